@@ -16,6 +16,9 @@ type BannedToolsConfig struct {
 	Mode       string               `json:"mode,omitempty"`
 	Structured []StructuredToolRule `json:"structured,omitempty"`
 	Advanced   []string             `json:"advanced,omitempty"`
+	// RuntimeGuard also enforces the rules while Python tests run, catching
+	// dynamic imports and calls the static analysis cannot see.
+	RuntimeGuard bool `json:"runtime_guard,omitempty"`
 }
 
 func parseBannedToolsConfig(raw *string) (*BannedToolsConfig, error) {
@@ -166,7 +169,7 @@ func cloneConfig(config *BannedToolsConfig) *BannedToolsConfig {
 	if config == nil {
 		return nil
 	}
-	out := &BannedToolsConfig{Mode: config.Mode}
+	out := &BannedToolsConfig{Mode: config.Mode, RuntimeGuard: config.RuntimeGuard}
 	if len(config.Structured) > 0 {
 		out.Structured = make([]StructuredToolRule, len(config.Structured))
 		copy(out.Structured, config.Structured)
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Checker ("special judge") tests run the student program on stdin as usual
+// and then hand stdin, the produced stdout and the optional reference output
+// (expected_stdout) to a teacher-written Python function:
+//
+//	def check(input, output, reference):
+//	    ...
+//
+// It may return a bool, a score in [0, 1], a (score, message) tuple or a dict
+// with "verdict", "score" and "message" keys.
+
+var checkerTimeout = getenvDurationOr("CHECKER_TIMEOUT", 10*time.Second)
+
+const (
+	checkerModuleFile = "__checker__.py"
+	checkerRunnerFile = "__checker_runner__.py"
+	checkerInputFile  = "__checker_input__.txt"
+	checkerOutputFile = "__checker_output__.txt"
+	checkerRefFile    = "__checker_reference__.txt"
+	checkerMarker     = "===CHECKER_JSON==="
+)
+
+const (
+	checkerAccepted = "accepted"
+	checkerPartial  = "partial"
+	checkerWrong    = "wrong"
+)
+
+// checkerVerdict is the normalized answer of a checker.
+type checkerVerdict struct {
+	Verdict string  `json:"verdict"`
+	Score   float64 `json:"score"`
+	Message string  `json:"message"`
+}
+
+const checkerRunnerScript = `import json
+import pathlib
+import sys
+import traceback
+
+MARKER = "===CHECKER_JSON==="
+HERE = pathlib.Path(__file__).resolve().parent
+
+
+def read(name):
+    path = HERE / name
+    if not path.exists():
+        return None
+    return path.read_text(encoding="utf-8")
+
+
+def clamp(v):
+    return max(0.0, min(1.0, float(v)))
+
+
+def normalize(res):
+    message = ""
+    if isinstance(res, tuple):
+        if len(res) != 2:
+            raise ValueError("checker tuple must be (score, message)")
+        res, message = res
+    if isinstance(res, dict):
+        verdict = res.get("verdict")
+        score = res.get("score")
+        message = str(res.get("message") or message or "")
+        if score is None:
+            score = 1.0 if verdict == "accepted" else 0.0
+        score = clamp(score)
+        if verdict is None:
+            verdict = "accepted" if score >= 1 else ("partial" if score > 0 else "wrong")
+        return {"verdict": str(verdict), "score": score, "message": message}
+    if isinstance(res, bool):
+        score = 1.0 if res else 0.0
+    elif isinstance(res, (int, float)):
+        score = clamp(res)
+    else:
+        raise TypeError("checker returned unsupported value %r" % (res,))
+    verdict = "accepted" if score >= 1 else ("partial" if score > 0 else "wrong")
+    return {"verdict": verdict, "score": score, "message": str(message or "")}
+
+
+def main():
+    # appended so files from the student's workspace cannot shadow modules
+    sys.path.append(str(HERE))
+    try:
+        import __checker__ as checker
+        check = getattr(checker, "check")
+        res = check(read("__checker_input__.txt") or "", read("__checker_output__.txt") or "", read("__checker_reference__.txt"))
+        out = normalize(res)
+    except Exception:  # noqa: BLE001
+        print(MARKER + json.dumps({"error": traceback.format_exc()}))
+        sys.exit(2)
+    print(MARKER + json.dumps(out))
+
+
+if __name__ == "__main__":
+    main()
+`
+
+// validateCheckerCode performs the cheap authoring-time checks; syntax errors
+// surface when the checker first runs.
+func validateCheckerCode(code *string) error {
+	if code == nil || strings.TrimSpace(*code) == "" {
+		return fmt.Errorf("checker_code is required")
+	}
+	if !strings.Contains(*code, "def check(") {
+		return fmt.Errorf("checker_code must define check(input, output, reference)")
+	}
+	return nil
+}
+
+// runChecker evaluates stdout of a finished student run with the test's
+// checker. It runs in the same VM session as the student program, in a fresh
+// copy of the workspace.
+func runChecker(sess *vmSession, dir string, tc TestCase, stdout string) (*checkerVerdict, error) {
+	files := map[string]string{
+		checkerModuleFile: stringOrEmpty(tc.CheckerCode),
+		checkerRunnerFile: checkerRunnerScript,
+		checkerInputFile:  tc.Stdin,
+		checkerOutputFile: stdout,
+	}
+	if tc.ExpectedStdout != "" {
+		files[checkerRefFile] = tc.ExpectedStdout
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return nil, fmt.Errorf("write %s: %w", name, err)
+		}
+		defer os.Remove(filepath.Join(dir, name))
+	}
+	_ = ensureSandboxPerms(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkerTimeout+vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
+	defer cancel()
+	vm, remoteDir, release, err := acquireRunWorkspace(ctx, sess, dir)
+	if err != nil {
+		return nil, fmt.Errorf("vm start failed: %w", err)
+	}
+	defer release()
+
+	execCtx, execCancel := context.WithTimeout(ctx, checkerTimeout)
+	defer execCancel()
+	script := fmt.Sprintf("PYTHONDONTWRITEBYTECODE=1 HOME=/tmp LANG=C.UTF-8 %s -I %s", pythonBinary, filepath.Join(remoteDir, checkerRunnerFile))
+	out, errOut, _, runErr := vm.runCommand(execCtx, remoteDir, script, nil)
+	if execCtx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("checker timed out after %v", checkerTimeout)
+	}
+	return parseCheckerOutput(out, errOut, runErr)
+}
+
+func parseCheckerOutput(out, errOut string, runErr error) (*checkerVerdict, error) {
+	idx := strings.LastIndex(out, checkerMarker)
+	if idx == -1 {
+		if runErr != nil {
+			return nil, fmt.Errorf("checker failed: %v: %s", runErr, strings.TrimSpace(errOut))
+		}
+		return nil, fmt.Errorf("checker produced no verdict")
+	}
+	var payload struct {
+		checkerVerdict
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out[idx+len(checkerMarker):])), &payload); err != nil {
+		return nil, fmt.Errorf("invalid checker verdict: %w", err)
+	}
+	if payload.Error != "" {
+		return nil, fmt.Errorf("checker raised an exception:\n%s", payload.Error)
+	}
+	v := payload.checkerVerdict
+	switch v.Verdict {
+	case checkerAccepted, checkerPartial, checkerWrong:
+	default:
+		return nil, fmt.Errorf("checker returned unknown verdict %q", v.Verdict)
+	}
+	return &v, nil
+}
+
+// checkerStatus maps a verdict to a result status and the fraction of the
+// test weight that is earned.
+func checkerStatus(v *checkerVerdict) (string, float64) {
+	switch {
+	case v.Verdict == checkerAccepted:
+		return "passed", 1
+	case v.Verdict == checkerPartial && v.Score > 0:
+		return "partially_passed", v.Score
+	default:
+		return "wrong_output", 0
+	}
+}
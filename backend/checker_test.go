@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseCheckerOutput(t *testing.T) {
+	v, err := parseCheckerOutput("debug print\n"+checkerMarker+`{"verdict":"partial","score":0.4,"message":"2 of 5 paths valid"}`+"\n", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status, credit := checkerStatus(v)
+	if status != "partially_passed" || credit != 0.4 || v.Message != "2 of 5 paths valid" {
+		t.Fatalf("got status=%q credit=%v message=%q", status, credit, v.Message)
+	}
+
+	v, err = parseCheckerOutput(checkerMarker+`{"verdict":"accepted","score":1}`, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status, credit := checkerStatus(v); status != "passed" || credit != 1 {
+		t.Fatalf("accepted verdict mapped to %q/%v", status, credit)
+	}
+
+	v, err = parseCheckerOutput(checkerMarker+`{"verdict":"partial","score":0}`, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status, credit := checkerStatus(v); status != "wrong_output" || credit != 0 {
+		t.Fatalf("zero partial verdict mapped to %q/%v", status, credit)
+	}
+}
+
+func TestParseCheckerOutputErrors(t *testing.T) {
+	if _, err := parseCheckerOutput(checkerMarker+`{"error":"Traceback: boom"}`, "", errors.New("exit status 2")); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected checker exception to be reported, got %v", err)
+	}
+	if _, err := parseCheckerOutput("", "SyntaxError", errors.New("exit status 1")); err == nil || !strings.Contains(err.Error(), "SyntaxError") {
+		t.Fatalf("expected stderr in error, got %v", err)
+	}
+	if _, err := parseCheckerOutput(checkerMarker+`{"verdict":"maybe"}`, "", nil); err == nil {
+		t.Fatalf("expected unknown verdict to be rejected")
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Static code-quality analysis of Python submissions. When enabled for an
+// assignment the findings are stored with the submission as feedback, and
+// with a non-zero weight the quality score also makes up that percentage of
+// the grade (see finalizeSubmissionOutcome).
+
+const (
+	qualityCheckStyle      = "style"
+	qualityCheckComplexity = "complexity"
+	qualityCheckUnused     = "unused"
+	qualityCheckDocstrings = "docstrings"
+	qualityCheckLength     = "function_length"
+
+	defaultQualityMaxLineLength    = 79
+	defaultQualityMaxComplexity    = 10
+	defaultQualityMaxFunctionLines = 50
+	defaultQualityPenalty          = 5
+)
+
+var qualityChecks = []string{qualityCheckStyle, qualityCheckComplexity, qualityCheckUnused, qualityCheckDocstrings, qualityCheckLength}
+
+type QualityConfig struct {
+	Enabled bool `json:"enabled"`
+	// Weight is the percentage of the grade taken by the quality score;
+	// zero keeps the analysis feedback only.
+	Weight float64 `json:"weight,omitempty"`
+	// Checks limits the analysis to some of qualityChecks (empty = all).
+	Checks           []string `json:"checks,omitempty"`
+	MaxLineLength    int      `json:"max_line_length,omitempty"`
+	MaxComplexity    int      `json:"max_complexity,omitempty"`
+	MaxFunctionLines int      `json:"max_function_lines,omitempty"`
+	// PenaltyPerFinding is the percentage of the quality score each
+	// finding costs.
+	PenaltyPerFinding float64 `json:"penalty_per_finding,omitempty"`
+}
+
+type qualityFinding struct {
+	Check   string `json:"check"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+// qualityReport is what gets stored with a submission.
+type qualityReport struct {
+	Score    float64          `json:"score"`
+	Weight   float64          `json:"weight"`
+	Findings []qualityFinding `json:"findings"`
+}
+
+func parseQualityConfig(raw *string) (*QualityConfig, error) {
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return nil, nil
+	}
+	var cfg QualityConfig
+	if err := json.Unmarshal([]byte(*raw), &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func serializeQualityConfig(cfg *QualityConfig) (*string, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	out := string(data)
+	return &out, nil
+}
+
+// normalize validates the configuration and fills in the defaults.
+func (cfg *QualityConfig) normalize() error {
+	if cfg.Weight < 0 || cfg.Weight > 100 {
+		return fmt.Errorf("weight must be between 0 and 100")
+	}
+	if cfg.PenaltyPerFinding < 0 || cfg.PenaltyPerFinding > 100 {
+		return fmt.Errorf("penalty_per_finding must be between 0 and 100")
+	}
+	if cfg.MaxLineLength < 0 || cfg.MaxComplexity < 0 || cfg.MaxFunctionLines < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	checks := make([]string, 0, len(cfg.Checks))
+	for _, c := range sanitizeList(cfg.Checks) {
+		c = strings.ToLower(c)
+		known := false
+		for _, k := range qualityChecks {
+			known = known || k == c
+		}
+		if !known {
+			return fmt.Errorf("unknown check %q", c)
+		}
+		checks = append(checks, c)
+	}
+	cfg.Checks = checks
+	if cfg.MaxLineLength == 0 {
+		cfg.MaxLineLength = defaultQualityMaxLineLength
+	}
+	if cfg.MaxComplexity == 0 {
+		cfg.MaxComplexity = defaultQualityMaxComplexity
+	}
+	if cfg.MaxFunctionLines == 0 {
+		cfg.MaxFunctionLines = defaultQualityMaxFunctionLines
+	}
+	if cfg.PenaltyPerFinding == 0 {
+		cfg.PenaltyPerFinding = defaultQualityPenalty
+	}
+	return nil
+}
+
+// assignmentQualityConfig returns the assignment's configuration when the
+// analysis is enabled.
+func assignmentQualityConfig(a *Assignment) (*QualityConfig, error) {
+	cfg, err := parseQualityConfig(a.QualityConfig)
+	if err != nil || cfg == nil || !cfg.Enabled {
+		return nil, err
+	}
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// analyzeCodeQuality runs the checks on the Python files under root and
+// scores the result.
+func analyzeCodeQuality(root string, cfg *QualityConfig) (*qualityReport, error) {
+	checks := cfg.Checks
+	if len(checks) == 0 {
+		checks = qualityChecks
+	}
+	cfgJSON, err := json.Marshal(map[string]any{
+		"checks":             checks,
+		"max_line_length":    cfg.MaxLineLength,
+		"max_complexity":     cfg.MaxComplexity,
+		"max_function_lines": cfg.MaxFunctionLines,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal quality config: %w", err)
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+
+	pythonExec := "python3"
+	if _, err := exec.LookPath(pythonExec); err != nil {
+		pythonExec = "python"
+	}
+
+	cmd := exec.Command(pythonExec, "-c", codeQualityScript, string(cfgJSON), absRoot)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("code quality analysis failed: %w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var findings []qualityFinding
+	if err := json.Unmarshal(stdout.Bytes(), &findings); err != nil {
+		return nil, fmt.Errorf("code quality parse: %w", err)
+	}
+	for i := range findings {
+		findings[i].File = filepath.ToSlash(strings.TrimSpace(findings[i].File))
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		if findings[i].Line != findings[j].Line {
+			return findings[i].Line < findings[j].Line
+		}
+		return findings[i].Column < findings[j].Column
+	})
+	if findings == nil {
+		findings = []qualityFinding{}
+	}
+	score := math.Max(0, 1-float64(len(findings))*cfg.PenaltyPerFinding/100)
+	return &qualityReport{Score: score, Weight: cfg.Weight, Findings: findings}, nil
+}
+
+// blendQualityScore gives the quality score its share of the grade.
+func blendQualityScore(score float64, maxPoints int, quality float64, weight float64) float64 {
+	w := weight / 100
+	return score*(1-w) + float64(maxPoints)*quality*w
+}
+
+const codeQualityScript = `import ast
+import io
+import json
+import pathlib
+import sys
+import tokenize
+
+cfg = json.loads(sys.argv[1])
+root = pathlib.Path(sys.argv[2])
+checks = set(cfg['checks'])
+max_line = cfg['max_line_length']
+results = []
+
+
+def add(check, code, message, rel, line, col=0):
+    results.append({'check': check, 'code': code, 'message': message, 'file': rel, 'line': line, 'column': col})
+
+
+def pycodestyle_check(rel, path):
+    import pycodestyle
+
+    class Report(pycodestyle.BaseReport):
+        def error(self, line_number, offset, text, check):
+            code = super().error(line_number, offset, text, check)
+            if code:
+                add('style', code, text[5:], rel, line_number, offset)
+            return code
+
+    guide = pycodestyle.StyleGuide(max_line_length=max_line, reporter=Report, quiet=True)
+    guide.input_file(str(path))
+
+
+ASSIGN_OPS = {'=', '==', '!=', '<', '>', '<=', '>=', '+=', '-=', '*=', '/=', '//=', '%=', '**=', ':=',
+              '&=', '|=', '^=', '>>=', '<<=', '@='}
+
+
+def builtin_style(rel, source):
+    # The pycodestyle checks students trip over most, for hosts without it.
+    lines = source.split('\n')
+    for no, line in enumerate(lines, 1):
+        stripped = line.rstrip('\r')
+        if len(stripped) > max_line:
+            add('style', 'E501', 'line too long (%d > %d characters)' % (len(stripped), max_line), rel, no, max_line)
+        if stripped != stripped.rstrip():
+            if stripped.strip():
+                add('style', 'W291', 'trailing whitespace', rel, no, len(stripped.rstrip()))
+            else:
+                add('style', 'W293', 'whitespace on blank line', rel, no)
+        indent = stripped[:len(stripped) - len(stripped.lstrip())]
+        if '\t' in indent:
+            add('style', 'W191', 'indentation contains tabs', rel, no)
+    if source and not source.endswith('\n'):
+        add('style', 'W292', 'no newline at end of file', rel, len(lines))
+
+    for no, line in enumerate(lines, 1):
+        if not (line.startswith('def ') or line.startswith('async def ') or line.startswith('class ') or line.startswith('@')):
+            continue
+        if no > 1 and lines[no - 2].startswith('@'):
+            continue
+        prev = no - 2
+        while prev >= 0 and lines[prev].lstrip().startswith('#'):
+            prev -= 1
+        blanks = 0
+        while prev >= 0 and not lines[prev].strip():
+            blanks += 1
+            prev -= 1
+        if prev >= 0 and blanks < 2:
+            add('style', 'E302', 'expected 2 blank lines, found %d' % blanks, rel, no)
+
+    try:
+        tokens = list(tokenize.generate_tokens(io.StringIO(source).readline))
+    except (tokenize.TokenError, IndentationError, SyntaxError):
+        return
+    depth = 0
+    for i, tok in enumerate(tokens):
+        prev = tokens[i - 1] if i else None
+        nxt = tokens[i + 1] if i + 1 < len(tokens) else None
+        if tok.type == tokenize.INDENT and '\t' not in tok.string and len(tok.string) % 4:
+            add('style', 'E111', 'indentation is not a multiple of 4', rel, tok.start[0])
+        elif tok.type == tokenize.COMMENT and prev is not None and prev.end[0] == tok.start[0] and prev.type not in (tokenize.NL, tokenize.NEWLINE, tokenize.INDENT, tokenize.DEDENT):
+            if tok.start[1] - prev.end[1] < 2:
+                add('style', 'E261', 'at least two spaces before inline comment', rel, tok.start[0], tok.start[1])
+        elif tok.type == tokenize.OP:
+            if tok.string in '([{':
+                depth += 1
+            elif tok.string in ')]}':
+                depth = max(0, depth - 1)
+            elif tok.string == ';':
+                if nxt is not None and nxt.type in (tokenize.NEWLINE, tokenize.COMMENT):
+                    add('style', 'E703', 'statement ends with a semicolon', rel, tok.start[0], tok.start[1])
+                else:
+                    add('style', 'E702', 'multiple statements on one line (semicolon)', rel, tok.start[0], tok.start[1])
+            elif tok.string == ',' and nxt is not None and nxt.start == tok.end and nxt.string not in ')]}' and nxt.type != tokenize.NEWLINE:
+                add('style', 'E231', "missing whitespace after ','", rel, tok.start[0], tok.start[1])
+            elif tok.string in ASSIGN_OPS and not (tok.string == '=' and depth > 0):
+                if (prev is not None and prev.end == tok.start) or (nxt is not None and nxt.start == tok.end):
+                    add('style', 'E225', 'missing whitespace around operator', rel, tok.start[0], tok.start[1])
+
+
+def complexity(node):
+    # McCabe: one plus a branch for every decision point; nested functions
+    # and classes are measured on their own.
+    score = 1
+    stack = list(ast.iter_child_nodes(node))
+    while stack:
+        child = stack.pop()
+        if isinstance(child, (ast.FunctionDef, ast.AsyncFunctionDef, ast.ClassDef)):
+            continue
+        if isinstance(child, (ast.If, ast.For, ast.AsyncFor, ast.While, ast.IfExp, ast.ExceptHandler)):
+            score += 1
+        elif isinstance(child, ast.BoolOp):
+            score += len(child.values) - 1
+        elif isinstance(child, ast.comprehension):
+            score += 1 + len(child.ifs)
+        elif hasattr(ast, 'match_case') and isinstance(child, ast.match_case):
+            score += 1
+        stack.extend(ast.iter_child_nodes(child))
+    return score
+
+
+def loaded_names(tree):
+    names = set()
+    for node in ast.walk(tree):
+        if isinstance(node, ast.Name) and not isinstance(node.ctx, ast.Store):
+            names.add(node.id)
+        elif isinstance(node, ast.Assign):
+            for target in node.targets:
+                if isinstance(target, ast.Name) and target.id == '__all__' and isinstance(node.value, (ast.List, ast.Tuple)):
+                    names.update(e.value for e in node.value.elts if isinstance(e, ast.Constant) and isinstance(e.value, str))
+    return names
+
+
+def unused_imports(rel, tree, used):
+    for node in ast.walk(tree):
+        if isinstance(node, ast.ImportFrom) and node.module == '__future__':
+            continue
+        if not isinstance(node, (ast.Import, ast.ImportFrom)):
+            continue
+        for alias in node.names:
+            if alias.name == '*':
+                continue
+            bound = alias.asname or alias.name.split('.')[0]
+            if bound not in used:
+                add('unused', 'F401', "'%s' imported but unused" % alias.name, rel, node.lineno, node.col_offset)
+
+
+def unused_locals(rel, func):
+    declared = set()
+    stored = {}
+    used = set()
+    for node in ast.walk(func):
+        if node is not func and isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef, ast.Lambda)):
+            # names read by nested functions count as used
+            for inner in ast.walk(node):
+                if isinstance(inner, ast.Name) and not isinstance(inner.ctx, ast.Store):
+                    used.add(inner.id)
+        if isinstance(node, (ast.Global, ast.Nonlocal)):
+            declared.update(node.names)
+        elif isinstance(node, ast.Name):
+            if not isinstance(node.ctx, ast.Store):
+                used.add(node.id)
+        elif isinstance(node, ast.AugAssign) and isinstance(node.target, ast.Name):
+            used.add(node.target.id)
+    for node in ast.walk(func):
+        targets = []
+        if isinstance(node, ast.Assign):
+            targets = [t for t in node.targets if isinstance(t, ast.Name)]
+        elif isinstance(node, ast.AnnAssign) and node.value is not None and isinstance(node.target, ast.Name):
+            targets = [node.target]
+        elif isinstance(node, ast.NamedExpr):
+            targets = [node.target]
+        elif isinstance(node, ast.withitem) and isinstance(node.optional_vars, ast.Name):
+            targets = [node.optional_vars]
+        for t in targets:
+            stored.setdefault(t.id, t)
+        if isinstance(node, ast.ExceptHandler) and node.name:
+            stored.setdefault(node.name, node)
+    for name, node in stored.items():
+        if name in used or name in declared or name.startswith('_'):
+            continue
+        add('unused', 'F841', "local variable '%s' is assigned to but never used" % name, rel, node.lineno, node.col_offset)
+
+
+def functions_and_classes(tree):
+    # yields (node, is_method)
+    for node in ast.walk(tree):
+        if isinstance(node, ast.ClassDef):
+            yield node, False
+            for item in node.body:
+                if isinstance(item, (ast.FunctionDef, ast.AsyncFunctionDef)):
+                    yield item, True
+    for node in tree.body:
+        if isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef)):
+            yield node, False
+
+
+for path in sorted(root.rglob('*.py')):
+    parts = path.relative_to(root).parts
+    if any(part.startswith('.') or part == '__pycache__' for part in parts) or parts[-1].startswith('__toolguard__'):
+        continue
+    rel = path.relative_to(root).as_posix()
+    try:
+        source = path.read_text(encoding='utf-8')
+    except UnicodeDecodeError:
+        source = path.read_text(encoding='latin-1')
+
+    if 'style' in checks:
+        try:
+            pycodestyle_check(rel, path)
+        except Exception:
+            builtin_style(rel, source)
+
+    try:
+        tree = ast.parse(source, filename=str(path))
+    except SyntaxError as exc:
+        add('style', 'E999', 'SyntaxError: %s' % exc.msg, rel, exc.lineno or 0, (exc.offset or 1) - 1)
+        continue
+
+    if 'unused' in checks:
+        unused_imports(rel, tree, loaded_names(tree))
+    for node in ast.walk(tree):
+        if not isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef)):
+            continue
+        if 'complexity' in checks:
+            score = complexity(node)
+            if score > cfg['max_complexity']:
+                add('complexity', 'C901', "'%s' is too complex (%d > %d)" % (node.name, score, cfg['max_complexity']), rel, node.lineno, node.col_offset)
+        if 'function_length' in checks:
+            length = node.end_lineno - node.lineno + 1
+            if length > cfg['max_function_lines']:
+                add('function_length', 'L001', "'%s' is too long (%d > %d lines)" % (node.name, length, cfg['max_function_lines']), rel, node.lineno, node.col_offset)
+        if 'unused' in checks:
+            unused_locals(rel, node)
+    if 'docstrings' in checks:
+        for node, is_method in functions_and_classes(tree):
+            if node.name.startswith('_') or ast.get_docstring(node) is not None:
+                continue
+            if isinstance(node, ast.ClassDef):
+                add('docstrings', 'D101', "missing docstring in public class '%s'" % node.name, rel, node.lineno, node.col_offset)
+            elif is_method:
+                add('docstrings', 'D102', "missing docstring in public method '%s'" % node.name, rel, node.lineno, node.col_offset)
+            else:
+                add('docstrings', 'D103', "missing docstring in public function '%s'" % node.name, rel, node.lineno, node.col_offset)
+
+print(json.dumps(results))
+`
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"os/exec"
+	"testing"
+)
+
+func TestQualityConfigNormalize(t *testing.T) {
+	cfg := &QualityConfig{Enabled: true, Weight: 20, Checks: []string{"Style", "unused", "style"}}
+	if err := cfg.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Checks) != 2 || cfg.MaxLineLength != 79 || cfg.MaxComplexity != 10 || cfg.MaxFunctionLines != 50 || cfg.PenaltyPerFinding != 5 {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	for _, bad := range []QualityConfig{{Weight: 120}, {Checks: []string{"naming"}}, {MaxComplexity: -1}, {PenaltyPerFinding: -5}} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+	if raw, _ := serializeQualityConfig(&QualityConfig{Weight: 10}); raw != nil {
+		t.Fatalf("disabled config should not be stored")
+	}
+}
+
+func TestBlendQualityScore(t *testing.T) {
+	// 8/10 from tests and a 50% quality score with quality worth 20%
+	if got := blendQualityScore(8, 10, 0.5, 20); math.Abs(got-7.4) > 1e-9 {
+		t.Fatalf("blend = %v", got)
+	}
+	if got := blendQualityScore(8, 10, 0, 0); got != 8 {
+		t.Fatalf("feedback-only blend changed the score: %v", got)
+	}
+}
+
+func TestAnalyzeCodeQuality(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.py": `import os
+import sys
+from math import sqrt
+
+
+def classify(n):
+    """Describe n."""
+    if n < 0 and n % 2:
+        return 'negative odd'
+    elif n < 0:
+        return 'negative'
+    for d in range(2, int(sqrt(n)) + 1):
+        if n % d == 0:
+            return 'composite'
+    return 'prime' if n > 1 else 'unit'
+
+def helper(x):
+    unused = x*2
+    total=x
+    return total # done
+`,
+		"pkg/shapes.py": "class Square:\n    \"\"\"A square.\"\"\"\n\n    def area(self):\n        return 1\n\n    def _hidden(self):\n        return 2\n",
+	})
+	cfg := &QualityConfig{Enabled: true, Weight: 30, MaxComplexity: 4, MaxFunctionLines: 8}
+	if err := cfg.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := analyzeCodeQuality(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]qualityFinding{}
+	for _, f := range report.Findings {
+		got[f.Code+"@"+f.File] = f
+	}
+	want := map[string]int{
+		"C901@main.py":       6,  // classify: if/and/elif/for/if/ifexp
+		"L001@main.py":       6,  // classify spans 10 lines
+		"E302@main.py":       17, // one blank line before helper
+		"D103@main.py":       17,
+		"F841@main.py":       18,
+		"E225@main.py":       19,
+		"E261@main.py":       20,
+		"D102@pkg/shapes.py": 4,
+	}
+	if n := countQualityCode(report.Findings, "F401"); n != 2 {
+		t.Errorf("expected os and sys to be reported as unused, got %d", n)
+	}
+	for key, line := range want {
+		f, ok := got[key]
+		if !ok {
+			t.Errorf("missing %s in %+v", key, report.Findings)
+			continue
+		}
+		if f.Line != line {
+			t.Errorf("%s reported on line %d, want %d", key, f.Line, line)
+		}
+	}
+	for _, f := range report.Findings {
+		if f.Code == "F401" && f.Line == 3 {
+			t.Errorf("used import reported: %+v", f)
+		}
+		if f.Code == "D102" && f.File == "pkg/shapes.py" && f.Line != 4 {
+			t.Errorf("private method reported: %+v", f)
+		}
+	}
+	if wantScore := 1 - float64(len(report.Findings))*0.05; math.Abs(report.Score-math.Max(wantScore, 0)) > 1e-9 || report.Weight != 30 {
+		t.Fatalf("score %v weight %v for %d findings", report.Score, report.Weight, len(report.Findings))
+	}
+
+	cfg.Checks = []string{qualityCheckDocstrings}
+	report, err = analyzeCodeQuality(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range report.Findings {
+		if f.Check != qualityCheckDocstrings {
+			t.Fatalf("check %s ran although only docstrings were selected", f.Check)
+		}
+	}
+}
+
+func countQualityCode(findings []qualityFinding, code string) int {
+	n := 0
+	for _, f := range findings {
+		if f.Code == code {
+			n++
+		}
+	}
+	return n
+}
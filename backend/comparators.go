@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Comparator names accepted on stdin_stdout test cases.
+const (
+	comparatorExact              = "exact"
+	comparatorTrailingWhitespace = "ignore_trailing_whitespace"
+	comparatorIgnoreCase         = "ignore_case"
+	comparatorTokens             = "tokens"
+	comparatorNumeric            = "numeric"
+	comparatorUnorderedLines     = "unordered_lines"
+	comparatorRegex              = "regex"
+)
+
+// comparatorOptions holds the optional per-test settings stored as JSON in
+// test_cases.comparator_options.
+type comparatorOptions struct {
+	AbsTolerance *float64 `json:"abs_tol,omitempty"`
+	RelTolerance *float64 `json:"rel_tol,omitempty"`
+	IgnoreCase   bool     `json:"ignore_case,omitempty"`
+}
+
+type outputComparator func(actual, expected string, opts comparatorOptions) bool
+
+var outputComparators = map[string]outputComparator{
+	comparatorExact:              compareExact,
+	comparatorTrailingWhitespace: compareTrailingWhitespace,
+	comparatorIgnoreCase:         compareIgnoreCase,
+	comparatorTokens:             compareTokens,
+	comparatorNumeric:            compareNumeric,
+	comparatorUnorderedLines:     compareUnorderedLines,
+	comparatorRegex:              compareRegex,
+}
+
+// normalizeComparator maps an empty name to the default exact comparison.
+func normalizeComparator(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return comparatorExact
+	}
+	return name
+}
+
+func parseComparatorOptions(raw *string) (comparatorOptions, error) {
+	var opts comparatorOptions
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return opts, nil
+	}
+	if err := json.Unmarshal([]byte(*raw), &opts); err != nil {
+		return opts, fmt.Errorf("invalid comparator_options: %w", err)
+	}
+	return opts, nil
+}
+
+// validateComparator checks a comparator name and its options before they are
+// stored, so misconfigured tests fail at authoring time rather than grading.
+func validateComparator(name string, raw *string, expected string) error {
+	name = normalizeComparator(name)
+	if _, ok := outputComparators[name]; !ok {
+		return fmt.Errorf("unknown comparator %q", name)
+	}
+	opts, err := parseComparatorOptions(raw)
+	if err != nil {
+		return err
+	}
+	if (opts.AbsTolerance != nil && *opts.AbsTolerance < 0) || (opts.RelTolerance != nil && *opts.RelTolerance < 0) {
+		return fmt.Errorf("comparator tolerances must not be negative")
+	}
+	if name == comparatorRegex {
+		if _, err := compileFullMatch(normalizeExpectedStdout(trimTrailingNewline(expected)), opts.IgnoreCase); err != nil {
+			return fmt.Errorf("invalid regex in expected_stdout: %w", err)
+		}
+	}
+	return nil
+}
+
+// stdoutMatches compares already normalized program output against the
+// expected output of a test case using its configured comparator.
+func stdoutMatches(tc TestCase, actual, expected string) bool {
+	cmp, ok := outputComparators[normalizeComparator(tc.Comparator)]
+	if !ok {
+		cmp = compareExact
+	}
+	opts, err := parseComparatorOptions(tc.ComparatorOptions)
+	if err != nil {
+		return false
+	}
+	return cmp(actual, expected, opts)
+}
+
+func compareExact(actual, expected string, _ comparatorOptions) bool {
+	return actual == expected
+}
+
+func trimLinesRight(s string) []string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func compareTrailingWhitespace(actual, expected string, _ comparatorOptions) bool {
+	a, e := trimLinesRight(actual), trimLinesRight(expected)
+	if len(a) != len(e) {
+		return false
+	}
+	for i := range a {
+		if a[i] != e[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func compareIgnoreCase(actual, expected string, opts comparatorOptions) bool {
+	return compareTrailingWhitespace(strings.ToLower(actual), strings.ToLower(expected), opts)
+}
+
+func compareTokens(actual, expected string, opts comparatorOptions) bool {
+	a, e := strings.Fields(actual), strings.Fields(expected)
+	if len(a) != len(e) {
+		return false
+	}
+	for i := range a {
+		if opts.IgnoreCase {
+			if !strings.EqualFold(a[i], e[i]) {
+				return false
+			}
+		} else if a[i] != e[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compareNumeric compares whitespace separated tokens; tokens that parse as
+// numbers on both sides are equal within the absolute or relative tolerance.
+func compareNumeric(actual, expected string, opts comparatorOptions) bool {
+	a, e := strings.Fields(actual), strings.Fields(expected)
+	if len(a) != len(e) {
+		return false
+	}
+	for i := range a {
+		av, aErr := strconv.ParseFloat(a[i], 64)
+		ev, eErr := strconv.ParseFloat(e[i], 64)
+		if aErr != nil || eErr != nil {
+			if opts.IgnoreCase {
+				if !strings.EqualFold(a[i], e[i]) {
+					return false
+				}
+			} else if a[i] != e[i] {
+				return false
+			}
+			continue
+		}
+		if !numbersMatch(av, ev, opts) {
+			return false
+		}
+	}
+	return true
+}
+
+// numbersMatch reports whether av equals ev within the tolerances of opts
+// (an absolute 1e-6 unless configured).
+func numbersMatch(av, ev float64, opts comparatorOptions) bool {
+	if math.IsNaN(av) || math.IsNaN(ev) {
+		return math.IsNaN(av) && math.IsNaN(ev)
+	}
+	if av == ev {
+		return true
+	}
+	abs := 1e-6
+	if opts.AbsTolerance != nil {
+		abs = *opts.AbsTolerance
+	}
+	rel := 0.0
+	if opts.RelTolerance != nil {
+		rel = *opts.RelTolerance
+	}
+	diff := math.Abs(av - ev)
+	return diff <= abs || diff <= rel*math.Abs(ev)
+}
+
+func compareUnorderedLines(actual, expected string, opts comparatorOptions) bool {
+	a, e := trimLinesRight(actual), trimLinesRight(expected)
+	if len(a) != len(e) {
+		return false
+	}
+	if opts.IgnoreCase {
+		for i := range a {
+			a[i] = strings.ToLower(a[i])
+		}
+		for i := range e {
+			e[i] = strings.ToLower(e[i])
+		}
+	}
+	sort.Strings(a)
+	sort.Strings(e)
+	for i := range a {
+		if a[i] != e[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func compileFullMatch(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	prefix := "(?s)"
+	if ignoreCase {
+		prefix = "(?si)"
+	}
+	return regexp.Compile(prefix + `\A(?:` + pattern + `)\z`)
+}
+
+// compareRegex treats the expected output as a regular expression that must
+// match the whole program output.
+func compareRegex(actual, expected string, opts comparatorOptions) bool {
+	re, err := compileFullMatch(expected, opts.IgnoreCase)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(actual)
+}
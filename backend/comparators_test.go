@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestStdoutComparators(t *testing.T) {
+	cases := []struct {
+		name       string
+		comparator string
+		options    *string
+		actual     string
+		expected   string
+		want       bool
+	}{
+		{"exact match", "", nil, "a b\nc", "a b\nc", true},
+		{"exact mismatch on spacing", "exact", nil, "a  b", "a b", false},
+		{"trailing whitespace", "ignore_trailing_whitespace", nil, "a  \nb\t\n\n", "a\nb", true},
+		{"trailing whitespace keeps leading", "ignore_trailing_whitespace", nil, " a", "a", false},
+		{"ignore case", "ignore_case", nil, "Hello World", "hello world", true},
+		{"tokens", "tokens", nil, "1   2\n3", "1 2 3", true},
+		{"tokens mismatch", "tokens", nil, "1 2", "1 2 3", false},
+		{"numeric abs tolerance", "numeric", strPtr(`{"abs_tol":1e-3}`), "3.14159", "3.1416", true},
+		{"numeric outside tolerance", "numeric", strPtr(`{"abs_tol":1e-6}`), "3.14159", "3.1416", false},
+		{"numeric rel tolerance", "numeric", strPtr(`{"abs_tol":0,"rel_tol":0.01}`), "1005", "1000", true},
+		{"numeric text tokens", "numeric", nil, "avg: 2.0000001", "avg: 2", true},
+		{"numeric text mismatch", "numeric", nil, "mean: 2", "avg: 2", false},
+		{"unordered lines", "unordered_lines", nil, "b\na\nc", "a\nb\nc", true},
+		{"unordered lines count", "unordered_lines", nil, "a\na", "a", false},
+		{"regex full match", "regex", nil, "Result: 42", `Result: \d+`, true},
+		{"regex must match whole output", "regex", nil, "Result: 42 extra", `Result: \d+`, false},
+		{"regex ignore case", "regex", strPtr(`{"ignore_case":true}`), "RESULT: 1", `result: \d`, true},
+	}
+	for _, c := range cases {
+		tc := TestCase{Comparator: c.comparator, ComparatorOptions: c.options}
+		if got := stdoutMatches(tc, c.actual, c.expected); got != c.want {
+			t.Errorf("%s: stdoutMatches(%q, %q) = %v, want %v", c.name, c.actual, c.expected, got, c.want)
+		}
+	}
+}
+
+func TestValidateComparator(t *testing.T) {
+	if err := validateComparator("", nil, "x"); err != nil {
+		t.Fatalf("default comparator rejected: %v", err)
+	}
+	if err := validateComparator("fuzzy", nil, "x"); err == nil {
+		t.Fatalf("expected unknown comparator to be rejected")
+	}
+	if err := validateComparator("numeric", strPtr(`{"abs_tol":-1}`), "1"); err == nil {
+		t.Fatalf("expected negative tolerance to be rejected")
+	}
+	if err := validateComparator("regex", nil, "(unclosed"); err == nil {
+		t.Fatalf("expected invalid regex to be rejected")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Compiled languages (C, C++, Java) are built once per grading run inside the
+// sandbox VM. The build outputs are copied back into the submission workspace
+// so every test gets them through the usual workspace sync.
+
+const (
+	compiledBinaryName = "__prog__"
+	javaClassesDir     = "__classes__"
+	compileTimeout     = 60 * time.Second
+	compileMaxOutput   = 64 * 1024
+)
+
+// defaultCompileFlags apply when an assignment does not set compile_flags.
+var defaultCompileFlags = map[string]string{
+	"c":    "-std=c17 -O2 -Wall",
+	"cpp":  "-std=c++17 -O2 -Wall",
+	"java": "",
+}
+
+// compileFlagPattern limits flags to plain tokens so they can be passed to
+// the compiler without any shell interpretation.
+var compileFlagPattern = regexp.MustCompile(`^[A-Za-z0-9_+=,.:/-]+$`)
+
+// compileError carries compiler diagnostics for a submission that did not build.
+type compileError struct {
+	Diagnostics string
+}
+
+func (e *compileError) Error() string {
+	if e.Diagnostics == "" {
+		return "compilation failed"
+	}
+	return "compilation failed:\n" + e.Diagnostics
+}
+
+// isBuildOutput reports whether name is produced by a build rather than
+// submitted by the student.
+func isBuildOutput(name string) bool {
+	switch name {
+	case compiledBinaryName, javaClassesDir, sqlSchemaFile, sqlSeedFile, notebookCellsFile, notebookModuleFile:
+		return true
+	}
+	return false
+}
+
+// normalizeCompileFlags validates teacher supplied flags. An empty value
+// resets the assignment to the language defaults.
+func normalizeCompileFlags(raw *string) (*string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	fields := strings.Fields(*raw)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	for _, f := range fields {
+		if !compileFlagPattern.MatchString(f) {
+			return nil, fmt.Errorf("invalid compile flag %q", f)
+		}
+		switch {
+		case strings.HasPrefix(f, "-o") || f == "-d":
+			return nil, errors.New("compile_flags must not set the output location")
+		case f == "-c" || f == "-E" || f == "-S":
+			return nil, fmt.Errorf("compile flag %s does not produce an executable", f)
+		}
+	}
+	joined := strings.Join(fields, " ")
+	return &joined, nil
+}
+
+// compileFlagsFor returns the flags used to build submissions of a.
+func compileFlagsFor(a *Assignment) []string {
+	if a.CompileFlags != nil && strings.TrimSpace(*a.CompileFlags) != "" {
+		return strings.Fields(*a.CompileFlags)
+	}
+	return strings.Fields(defaultCompileFlags[a.ProgrammingLanguage])
+}
+
+// findSources lists the files of dir with one of exts in a stable order.
+// Hidden files and grader files (prefixed with "__") are skipped.
+func findSources(dir string, exts ...string) ([]string, error) {
+	var sources []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "__") || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "__") {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		for _, e := range exts {
+			if ext == e {
+				rel, _ := filepath.Rel(dir, path)
+				sources = append(sources, filepath.ToSlash(rel))
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// buildInVM runs the compile command inside a VM holding dir and copies the
+// listed outputs back into dir. A non-zero compiler exit is reported as
+// *compileError; other errors mean the sandbox itself failed.
+func buildInVM(sess *vmSession, dir, compile string, outputs ...string) error {
+	quoted := make([]string, len(outputs))
+	for i, o := range outputs {
+		quoted[i] = shellQuote(o)
+	}
+	// diagnostics go to stderr; the outputs travel back as a tar on stdout
+	script := fmt.Sprintf("HOME=/tmp LANG=C.UTF-8 %s 1>&2 && tar -cf - %s | base64 -w0", compile, strings.Join(quoted, " "))
+
+	_ = ensureSandboxPerms(dir)
+	bootCtx, bootCancel := context.WithTimeout(context.Background(), vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
+	defer bootCancel()
+	vm, remoteDir, release, err := acquireRunWorkspace(bootCtx, sess, dir)
+	if err != nil {
+		return fmt.Errorf("vm start failed: %w", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), compileTimeout)
+	defer cancel()
+	stdout, stderr, exitCode, runErr := vm.runCommand(ctx, remoteDir, script, nil)
+	if ctx.Err() == context.DeadlineExceeded {
+		return &compileError{Diagnostics: fmt.Sprintf("compilation timed out after %v", compileTimeout)}
+	}
+	if exitCode > 0 {
+		diagnostics := strings.TrimSpace(stderr)
+		if len(diagnostics) > compileMaxOutput {
+			diagnostics = diagnostics[:compileMaxOutput] + "\n... (output truncated)"
+		}
+		return &compileError{Diagnostics: diagnostics}
+	}
+	if runErr != nil {
+		return fmt.Errorf("compile: %w", runErr)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout))
+	if err != nil || len(data) == 0 {
+		return fmt.Errorf("compile: could not retrieve build output")
+	}
+	return unpackBuildOutput(dir, data)
+}
+
+// unpackBuildOutput extracts a tar of build outputs into dir.
+func unpackBuildOutput(dir string, data []byte) error {
+	root := filepath.Clean(dir) + string(os.PathSeparator)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("compile: read build output: %w", err)
+		}
+		target := filepath.Join(dir, hdr.Name)
+		if !strings.HasPrefix(target, root) {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			body, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(target, body, 0644); err != nil {
+				return err
+			}
+		}
+	}
+}
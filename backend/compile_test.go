@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCompileFlags(t *testing.T) {
+	raw := "  -std=c11   -O0 -Wextra -lm "
+	got, err := normalizeCompileFlags(&raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got == nil || *got != "-std=c11 -O0 -Wextra -lm" {
+		t.Fatalf("got %v", got)
+	}
+	empty := "   "
+	if got, err := normalizeCompileFlags(&empty); err != nil || got != nil {
+		t.Fatalf("blank flags should reset to defaults, got %v, %v", got, err)
+	}
+	for _, bad := range []string{"-O2; rm -rf /", "-o out", "-ofoo", "-c", "-d", "a|b", "$(id)", "-DX=`id`"} {
+		if _, err := normalizeCompileFlags(&bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCompileFlagsFor(t *testing.T) {
+	a := &Assignment{ProgrammingLanguage: "cpp"}
+	if got := strings.Join(compileFlagsFor(a), " "); got != defaultCompileFlags["cpp"] {
+		t.Fatalf("default flags = %q", got)
+	}
+	flags := "-std=c++20 -O1"
+	a.CompileFlags = &flags
+	if got := compileFlagsFor(a); !reflect.DeepEqual(got, []string{"-std=c++20", "-O1"}) {
+		t.Fatalf("custom flags = %v", got)
+	}
+}
+
+func TestFindSourcesAndNativeEntry(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.cpp", "util.cc", "util.h", "notes.txt", "lib/extra.cxx", "__prog__", "node_modules/x.cpp"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := findSources(dir, nativeRuntime{lang: "cpp"}.sourceExts()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"lib/extra.cxx", "main.cpp", "util.cc"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sources = %v, want %v", got, want)
+	}
+	if prog, err := loadProgram(dir, "cpp"); err != nil || prog.entry != compiledBinaryName {
+		t.Fatalf("entry = %q, %v", prog.entry, err)
+	}
+	if _, err := loadProgram(dir, "c"); err == nil {
+		t.Fatalf("expected error when no C sources are present")
+	}
+}
+
+func TestNormalizeProgrammingLanguageCompiled(t *testing.T) {
+	for raw, want := range map[string]string{"C": "c", "cpp": "cpp", "C++": "cpp", "": "python", "Java": "java", "js": "javascript", "node": "javascript"} {
+		got, err := normalizeProgrammingLanguage(raw)
+		if err != nil || got != want {
+			t.Errorf("normalizeProgrammingLanguage(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+}
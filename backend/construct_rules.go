@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Construct rules complement the banned tools: they state what a Python
+// submission must (or must not) contain, e.g. "define a recursive function",
+// "define class Stack with method push" or "do not use any loop". They are
+// checked on the AST before the tests run. A violated rule either fails the
+// submission outright or deducts points from the score.
+
+const (
+	constructRequireRecursion = "require_recursion"
+	constructRequireFunction  = "require_function"
+	constructRequireClass     = "require_class"
+	constructRequire          = "require_construct"
+	constructForbid           = "forbid_construct"
+
+	constructActionFail   = "fail"
+	constructActionDeduct = "deduct"
+)
+
+// constructNames lists the constructs require/forbid rules can refer to.
+// "loop" covers for and while loops as well as comprehensions.
+var constructNames = map[string]string{
+	"while":         "a while loop",
+	"for":           "a for loop",
+	"loop":          "a loop",
+	"comprehension": "a comprehension",
+	"lambda":        "a lambda",
+	"try":           "a try statement",
+	"with":          "a with statement",
+	"yield":         "yield",
+	"global":        "a global statement",
+	"if":            "an if statement",
+	"assert":        "an assert statement",
+}
+
+type ConstructRule struct {
+	Kind string `json:"kind"`
+	// Name is the function or class the rule is about. For require/forbid
+	// construct rules it optionally limits the check to one function, and
+	// for require_recursion it names the function that must recurse (empty
+	// means any function).
+	Name      string   `json:"name,omitempty"`
+	Methods   []string `json:"methods,omitempty"`
+	Construct string   `json:"construct,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Action    string   `json:"action,omitempty"`
+	Points    float64  `json:"points,omitempty"`
+}
+
+type constructFinding struct {
+	Rule    int     `json:"rule"`
+	Kind    string  `json:"kind"`
+	Message string  `json:"message"`
+	Action  string  `json:"action"`
+	Points  float64 `json:"points,omitempty"`
+	File    string  `json:"file,omitempty"`
+	Line    int     `json:"line,omitempty"`
+}
+
+func parseConstructRules(raw *string) ([]ConstructRule, error) {
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return nil, nil
+	}
+	var rules []ConstructRule
+	if err := json.Unmarshal([]byte(*raw), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func serializeConstructRules(rules []ConstructRule) (*string, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+	out := string(data)
+	return &out, nil
+}
+
+// normalizeConstructRules validates the rules and fills in defaults: the
+// action is "deduct" when points are given and "fail" otherwise.
+func normalizeConstructRules(rules []ConstructRule) ([]ConstructRule, error) {
+	out := make([]ConstructRule, 0, len(rules))
+	for i, r := range rules {
+		r.Kind = strings.ToLower(strings.TrimSpace(r.Kind))
+		r.Name = strings.TrimSpace(r.Name)
+		r.Construct = strings.ToLower(strings.TrimSpace(r.Construct))
+		r.Message = strings.TrimSpace(r.Message)
+		r.Methods = sanitizeList(r.Methods)
+		switch r.Kind {
+		case constructRequireRecursion:
+		case constructRequireFunction, constructRequireClass:
+			if r.Name == "" {
+				return nil, fmt.Errorf("rule %d: %s needs a name", i+1, r.Kind)
+			}
+		case constructRequire, constructForbid:
+			if _, ok := constructNames[r.Construct]; !ok {
+				return nil, fmt.Errorf("rule %d: unknown construct %q", i+1, r.Construct)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown kind %q", i+1, r.Kind)
+		}
+		if r.Kind != constructRequireClass {
+			r.Methods = nil
+		}
+		if r.Kind != constructRequire && r.Kind != constructForbid {
+			r.Construct = ""
+		}
+		if r.Points < 0 {
+			return nil, fmt.Errorf("rule %d: points must not be negative", i+1)
+		}
+		r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+		switch r.Action {
+		case "":
+			r.Action = constructActionFail
+			if r.Points > 0 {
+				r.Action = constructActionDeduct
+			}
+		case constructActionFail:
+			r.Points = 0
+		case constructActionDeduct:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i+1, r.Action)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// assignmentConstructRules returns the assignment's validated rules.
+func assignmentConstructRules(a *Assignment) ([]ConstructRule, error) {
+	rules, err := parseConstructRules(a.ConstructRules)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return normalizeConstructRules(rules)
+}
+
+// describeConstructRule is the default message of a rule without one.
+func describeConstructRule(r ConstructRule) string {
+	scope := ""
+	if r.Name != "" {
+		scope = fmt.Sprintf(" in %q", r.Name)
+	}
+	switch r.Kind {
+	case constructRequireRecursion:
+		if r.Name != "" {
+			return fmt.Sprintf("function %q must be recursive", r.Name)
+		}
+		return "the solution must define a recursive function"
+	case constructRequireFunction:
+		return fmt.Sprintf("the solution must define function %q", r.Name)
+	case constructRequireClass:
+		if len(r.Methods) > 0 {
+			return fmt.Sprintf("the solution must define class %q with methods %s", r.Name, strings.Join(r.Methods, ", "))
+		}
+		return fmt.Sprintf("the solution must define class %q", r.Name)
+	case constructRequire:
+		return fmt.Sprintf("the solution must use %s%s", constructNames[r.Construct], scope)
+	case constructForbid:
+		return fmt.Sprintf("the solution must not use %s%s", constructNames[r.Construct], scope)
+	}
+	return r.Kind
+}
+
+// checkConstructRules analyses the Python files under root and returns one
+// finding per violated rule, in rule order.
+func checkConstructRules(root string, rules []ConstructRule) ([]constructFinding, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return nil, fmt.Errorf("marshal construct rules: %w", err)
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+
+	pythonExec := "python3"
+	if _, err := exec.LookPath(pythonExec); err != nil {
+		pythonExec = "python"
+	}
+
+	cmd := exec.Command(pythonExec, "-c", constructAnalyzerScript, string(rulesJSON), absRoot)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("construct analysis failed: %w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var raw []constructFinding
+	if err := json.Unmarshal(stdout.Bytes(), &raw); err != nil {
+		return nil, fmt.Errorf("construct analysis parse: %w", err)
+	}
+	findings := make([]constructFinding, 0, len(raw))
+	for _, f := range raw {
+		if f.Rule < 0 || f.Rule >= len(rules) {
+			continue
+		}
+		r := rules[f.Rule]
+		f.Kind = r.Kind
+		f.Action = r.Action
+		f.Points = r.Points
+		f.Message = r.Message
+		if f.Message == "" {
+			f.Message = describeConstructRule(r)
+		}
+		f.File = filepath.ToSlash(strings.TrimSpace(f.File))
+		findings = append(findings, f)
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Rule < findings[j].Rule })
+	return findings, nil
+}
+
+// constructOutcome splits findings into whether the submission fails outright
+// and the points to deduct for the remaining violations.
+func constructOutcome(findings []constructFinding) (failed bool, deduction float64) {
+	for _, f := range findings {
+		if f.Action == constructActionFail {
+			failed = true
+		} else {
+			deduction += f.Points
+		}
+	}
+	return failed, deduction
+}
+
+func formatConstructMessage(findings []constructFinding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Required structure not met:\n")
+	for _, f := range findings {
+		fmt.Fprintf(&b, " - %s", f.Message)
+		if f.File != "" {
+			fmt.Fprintf(&b, " (at %s:%d)", f.File, f.Line)
+		}
+		if f.Action == constructActionDeduct && f.Points > 0 {
+			fmt.Fprintf(&b, " — %s point(s) deducted", strconv.FormatFloat(f.Points, 'f', -1, 64))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+const constructAnalyzerScript = `import ast
+import json
+import pathlib
+import sys
+
+rules = json.loads(sys.argv[1])
+root = pathlib.Path(sys.argv[2])
+
+CONSTRUCTS = {
+    'while': (ast.While,),
+    'for': (ast.For, ast.AsyncFor),
+    'comprehension': (ast.ListComp, ast.SetComp, ast.DictComp, ast.GeneratorExp),
+    'lambda': (ast.Lambda,),
+    'try': (ast.Try,) + ((ast.TryStar,) if hasattr(ast, 'TryStar') else ()),
+    'with': (ast.With, ast.AsyncWith),
+    'yield': (ast.Yield, ast.YieldFrom),
+    'global': (ast.Global, ast.Nonlocal),
+    'if': (ast.If, ast.IfExp),
+    'assert': (ast.Assert,),
+}
+CONSTRUCTS['loop'] = CONSTRUCTS['while'] + CONSTRUCTS['for'] + CONSTRUCTS['comprehension']
+
+# qualified name -> {'node', 'file', 'simple', 'class', 'calls'}
+functions = {}
+# class name -> {'node', 'file', 'methods', 'bases'}
+classes = {}
+modules = []
+
+
+class Collector(ast.NodeVisitor):
+    def __init__(self, rel):
+        self.rel = rel
+        self.scope = []
+        self.current = None
+        self.current_class = None
+
+    def visit_ClassDef(self, node):
+        classes.setdefault(node.name, {
+            'node': node,
+            'file': self.rel,
+            'methods': {item.name for item in node.body if isinstance(item, (ast.FunctionDef, ast.AsyncFunctionDef))},
+            'bases': [b.id if isinstance(b, ast.Name) else getattr(b, 'attr', '') for b in node.bases],
+        })
+        saved = self.current_class
+        self.current_class = node.name
+        self.scope.append(node.name)
+        self.generic_visit(node)
+        self.scope.pop()
+        self.current_class = saved
+
+    def visit_FunctionDef(self, node):
+        qual = '.'.join(self.scope + [node.name])
+        functions[qual] = {
+            'node': node,
+            'file': self.rel,
+            'simple': node.name,
+            'class': self.current_class,
+            'calls': set(),
+        }
+        saved = self.current, self.current_class
+        self.current = qual
+        self.current_class = None
+        self.scope.append(node.name)
+        self.generic_visit(node)
+        self.scope.pop()
+        self.current, self.current_class = saved
+
+    visit_AsyncFunctionDef = visit_FunctionDef
+
+    def visit_Call(self, node):
+        if self.current is not None:
+            func = node.func
+            if isinstance(func, ast.Name):
+                functions[self.current]['calls'].add(('name', func.id))
+            elif isinstance(func, ast.Attribute):
+                functions[self.current]['calls'].add(('attr', func.attr))
+        self.generic_visit(node)
+
+
+for path in sorted(root.rglob('*.py')):
+    rel = path.relative_to(root).as_posix()
+    if any(part.startswith('.') or part == '__pycache__' for part in path.relative_to(root).parts):
+        continue
+    try:
+        source = path.read_text(encoding='utf-8')
+    except UnicodeDecodeError:
+        source = path.read_text(encoding='latin-1')
+    try:
+        tree = ast.parse(source, filename=str(path))
+    except SyntaxError:
+        continue
+    modules.append((rel, tree))
+    Collector(rel).visit(tree)
+
+by_simple = {}
+methods_by_name = {}
+for qual, info in functions.items():
+    if info['class'] is None:
+        by_simple.setdefault(info['simple'], set()).add(qual)
+    else:
+        methods_by_name.setdefault(info['simple'], set()).add(qual)
+
+
+def callees(qual):
+    # Calls are resolved by name: f() to functions named f, x.m() to any
+    # method named m, which is enough for the recursion students write.
+    out = set()
+    for kind, name in functions[qual]['calls']:
+        if kind == 'name':
+            out |= by_simple.get(name, set())
+            if name in classes:
+                out |= {name + '.__init__'} & set(functions)
+        else:
+            out |= methods_by_name.get(name, set())
+    return out
+
+
+def recursive(qual):
+    seen = set()
+    stack = list(callees(qual))
+    while stack:
+        current = stack.pop()
+        if current == qual:
+            return True
+        if current in seen:
+            continue
+        seen.add(current)
+        stack.extend(callees(current))
+    return False
+
+
+def find_functions(name):
+    if name in functions:
+        return [name]
+    return sorted(q for q, info in functions.items() if info['simple'] == name)
+
+
+def class_methods(name, seen=None):
+    seen = seen or set()
+    if name not in classes or name in seen:
+        return set()
+    seen.add(name)
+    info = classes[name]
+    out = set(info['methods'])
+    for base in info['bases']:
+        out |= class_methods(base, seen)
+    return out
+
+
+def scopes(rule):
+    name = rule.get('name') or ''
+    if not name:
+        return [(rel, tree) for rel, tree in modules]
+    return [(functions[q]['file'], functions[q]['node']) for q in find_functions(name)]
+
+
+def first_construct(rule):
+    types = CONSTRUCTS[rule['construct']]
+    for rel, tree in scopes(rule):
+        for node in ast.walk(tree):
+            if isinstance(node, types):
+                return rel, node.lineno
+    return None
+
+
+results = []
+for idx, rule in enumerate(rules):
+    kind = rule.get('kind')
+    name = rule.get('name') or ''
+    if kind == 'require_recursion':
+        candidates = find_functions(name) if name else list(functions)
+        if not any(recursive(q) for q in candidates):
+            results.append({'rule': idx})
+    elif kind == 'require_function':
+        if not find_functions(name):
+            results.append({'rule': idx})
+    elif kind == 'require_class':
+        if name not in classes or not set(rule.get('methods') or []) <= class_methods(name):
+            results.append({'rule': idx})
+    elif kind == 'require_construct':
+        if (name and not find_functions(name)) or first_construct(rule) is None:
+            results.append({'rule': idx})
+    elif kind == 'forbid_construct':
+        hit = first_construct(rule)
+        if hit is not None:
+            results.append({'rule': idx, 'file': hit[0], 'line': hit[1]})
+
+print(json.dumps(results))
+`
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeConstructRules(t *testing.T) {
+	rules, err := normalizeConstructRules([]ConstructRule{
+		{Kind: " Require_Recursion "},
+		{Kind: "forbid_construct", Construct: "Loop", Points: 2, Methods: []string{"x"}},
+		{Kind: "require_class", Name: "Stack", Methods: []string{"push", " push", ""}, Action: "FAIL", Points: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules[0].Action != constructActionFail || rules[1].Action != constructActionDeduct || rules[1].Construct != "loop" || rules[1].Methods != nil {
+		t.Fatalf("unexpected defaults: %+v", rules)
+	}
+	if rules[2].Points != 0 || len(rules[2].Methods) != 1 {
+		t.Fatalf("unexpected class rule: %+v", rules[2])
+	}
+	for _, bad := range []ConstructRule{
+		{Kind: "require_function"},
+		{Kind: "forbid_construct", Construct: "goto"},
+		{Kind: "require_magic"},
+		{Kind: "require_recursion", Points: -1},
+		{Kind: "require_recursion", Action: "warn"},
+	} {
+		if _, err := normalizeConstructRules([]ConstructRule{bad}); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestCheckConstructRules(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.py": `from tree import Node
+
+def is_even(n):
+    return True if n == 0 else is_odd(n - 1)
+
+def is_odd(n):
+    return False if n == 0 else is_even(n - 1)
+
+def total(xs):
+    s = 0
+    for x in xs:
+        s += x
+    return s
+
+class Stack(list):
+    def push(self, x):
+        self.append(x)
+`,
+		"tree.py": `class Node:
+    def __init__(self, value):
+        self.value, self.left = value, None
+
+    def insert(self, value):
+        if self.left is None:
+            self.left = Node(value)
+        else:
+            self.left.insert(value)
+`,
+	})
+	rules, err := normalizeConstructRules([]ConstructRule{
+		{Kind: "require_recursion"},
+		{Kind: "require_recursion", Name: "is_odd"},
+		{Kind: "require_recursion", Name: "Node.insert"},
+		{Kind: "require_recursion", Name: "total", Message: "total must call itself", Points: 1.5},
+		{Kind: "require_function", Name: "is_even"},
+		{Kind: "require_function", Name: "average"},
+		{Kind: "require_class", Name: "Stack", Methods: []string{"push"}},
+		{Kind: "require_class", Name: "Stack", Methods: []string{"pop"}},
+		{Kind: "require_construct", Construct: "while"},
+		{Kind: "require_construct", Construct: "for", Name: "total"},
+		{Kind: "forbid_construct", Construct: "loop", Points: 2},
+		{Kind: "forbid_construct", Construct: "lambda"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := checkConstructRules(dir, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var violated []int
+	for _, f := range findings {
+		violated = append(violated, f.Rule)
+	}
+	want := []int{3, 5, 7, 8, 10}
+	if len(violated) != len(want) {
+		t.Fatalf("violated rules %v, want %v (%+v)", violated, want, findings)
+	}
+	for i := range want {
+		if violated[i] != want[i] {
+			t.Fatalf("violated rules %v, want %v", violated, want)
+		}
+	}
+	if f := findings[4]; f.File != "main.py" || f.Line != 11 || f.Action != constructActionDeduct {
+		t.Fatalf("unexpected loop finding %+v", f)
+	}
+	failed, deduction := constructOutcome(findings)
+	if !failed || deduction != 3.5 {
+		t.Fatalf("outcome failed=%v deduction=%v", failed, deduction)
+	}
+	msg := formatConstructMessage(findings)
+	for _, part := range []string{"total must call itself — 1.5 point(s) deducted", `must define function "average"`, "must not use a loop (at main.py:11)"} {
+		if !strings.Contains(msg, part) {
+			t.Errorf("message %q lacks %q", msg, part)
+		}
+	}
+}
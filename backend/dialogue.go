@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dialogue tests drive an interactive program through a teacher-written
+// script of expect/send steps, similar to expect(1):
+//
+//	[
+//	  {"expect": "Name\\?", "timeout_sec": 2},
+//	  {"send": "Alice"},
+//	  {"expect": "Hello, Alice"},
+//	  {"close_stdin": true}
+//	]
+//
+// An expect step waits until its regex matches the program output that has
+// not been consumed by an earlier step. A send step types one line. The whole
+// dialogue is bounded by the test time limit; timeout_sec tightens a single
+// expect step. No model is involved, so a run is fully reproducible.
+
+const (
+	dialogueMaxOutput = 64 * 1024
+	// dialogueStderrTail is the end of stderr kept past dialogueMaxOutput.
+	dialogueStderrTail = 4 * 1024
+)
+
+type dialogueStep struct {
+	Expect     *string `json:"expect,omitempty"`
+	Send       *string `json:"send,omitempty"`
+	CloseStdin bool    `json:"close_stdin,omitempty"`
+	TimeoutSec float64 `json:"timeout_sec,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// parseDialogueScript decodes and validates a dialogue script.
+func parseDialogueScript(raw string) ([]dialogueStep, error) {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var steps []dialogueStep
+	if err := dec.Decode(&steps); err != nil {
+		return nil, fmt.Errorf("dialogue_script must be a JSON array of steps: %v", err)
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("dialogue_script must contain at least one step")
+	}
+	for i := range steps {
+		st := &steps[i]
+		actions := 0
+		if st.Expect != nil {
+			actions++
+		}
+		if st.Send != nil {
+			actions++
+		}
+		if st.CloseStdin {
+			actions++
+		}
+		if actions != 1 {
+			return nil, fmt.Errorf("dialogue step %d: exactly one of expect, send or close_stdin is required", i+1)
+		}
+		if st.TimeoutSec < 0 {
+			return nil, fmt.Errorf("dialogue step %d: timeout_sec must not be negative", i+1)
+		}
+		if st.TimeoutSec > 0 && st.Expect == nil {
+			return nil, fmt.Errorf("dialogue step %d: timeout_sec only applies to expect steps", i+1)
+		}
+		if st.Expect != nil {
+			re, err := regexp.Compile(*st.Expect)
+			if err != nil {
+				return nil, fmt.Errorf("dialogue step %d: invalid regex: %v", i+1, err)
+			}
+			st.re = re
+		}
+	}
+	return steps, nil
+}
+
+func validateDialogueScript(raw *string) error {
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return errors.New("dialogue_script is required")
+	}
+	_, err := parseDialogueScript(*raw)
+	return err
+}
+
+// dialogueStream collects program output and lets expect steps consume it.
+type dialogueStream struct {
+	mu     sync.Mutex
+	buf    []byte
+	pos    int
+	eof    bool
+	notify chan struct{}
+}
+
+func newDialogueStream() *dialogueStream {
+	return &dialogueStream{notify: make(chan struct{}, 1)}
+}
+
+func (s *dialogueStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	if room := dialogueMaxOutput - len(s.buf); room > 0 {
+		if len(p) > room {
+			s.buf = append(s.buf, p[:room]...)
+		} else {
+			s.buf = append(s.buf, p...)
+		}
+	}
+	s.mu.Unlock()
+	s.signal()
+	return len(p), nil
+}
+
+// closeWrite marks the end of program output.
+func (s *dialogueStream) closeWrite() {
+	s.mu.Lock()
+	s.eof = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *dialogueStream) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// expect waits until re matches the unconsumed output or the deadline passes.
+// On a match the output up to the end of the match is consumed and returned.
+func (s *dialogueStream) expect(re *regexp.Regexp, deadline time.Time) (string, bool) {
+	for {
+		s.mu.Lock()
+		if loc := re.FindIndex(s.buf[s.pos:]); loc != nil {
+			text := string(s.buf[s.pos : s.pos+loc[1]])
+			s.pos += loc[1]
+			s.mu.Unlock()
+			return text, true
+		}
+		eof := s.eof
+		s.mu.Unlock()
+		wait := time.Until(deadline)
+		if eof || wait <= 0 {
+			return "", false
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (s *dialogueStream) ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eof
+}
+
+// drain consumes and returns everything not yet matched.
+func (s *dialogueStream) drain() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := string(s.buf[s.pos:])
+	s.pos = len(s.buf)
+	return text
+}
+
+func writeProgramOutput(t *strings.Builder, text string) {
+	text = strings.TrimRight(text, "\r\n")
+	if text != "" {
+		t.WriteString("PROGRAM> " + text + "\n")
+	}
+}
+
+// playDialogue runs the steps against a program whose output is fed into
+// stream and whose stdin is stdin. It returns the transcript and whether every
+// step succeeded before the deadline.
+func playDialogue(steps []dialogueStep, stream *dialogueStream, stdin io.WriteCloser, deadline time.Time) (string, bool) {
+	var t strings.Builder
+	for i, st := range steps {
+		switch {
+		case st.Expect != nil:
+			stepDeadline := deadline
+			if st.TimeoutSec > 0 {
+				if d := time.Now().Add(time.Duration(st.TimeoutSec * float64(time.Second))); d.Before(deadline) {
+					stepDeadline = d
+				}
+			}
+			text, ok := stream.expect(st.re, stepDeadline)
+			if !ok {
+				writeProgramOutput(&t, stream.drain())
+				reason := "program output ended"
+				if time.Now().After(stepDeadline) {
+					reason = "timed out"
+				}
+				fmt.Fprintf(&t, "EXPECT /%s/ FAILED (step %d: %s)\n", *st.Expect, i+1, reason)
+				return t.String(), false
+			}
+			writeProgramOutput(&t, text)
+			fmt.Fprintf(&t, "EXPECT /%s/ ok\n", *st.Expect)
+		case st.Send != nil:
+			writeProgramOutput(&t, stream.drain())
+			t.WriteString("INPUT> " + formatForTranscript(*st.Send) + "\n")
+			if _, err := io.WriteString(stdin, normalizeSend(*st.Send)); err != nil {
+				fmt.Fprintf(&t, "SEND FAILED (step %d: program is no longer reading input)\n", i+1)
+				return t.String(), false
+			}
+		case st.CloseStdin:
+			writeProgramOutput(&t, stream.drain())
+			t.WriteString("INPUT> <EOF>\n")
+			_ = stdin.Close()
+		}
+	}
+	return t.String(), true
+}
+
+// dialogueRun is the outcome of one dialogue test.
+type dialogueRun struct {
+	Transcript string
+	Stderr     string
+	ExitCode   int
+	Killed     bool
+	TimedOut   bool
+	Passed     bool
+	Runtime    time.Duration
+	Memory     memoryUsage
+}
+
+// dialogueStatus maps a dialogue run onto a result status.
+func dialogueStatus(run dialogueRun) string {
+	switch {
+	case run.TimedOut:
+		return "time_limit_exceeded"
+	case run.Memory.LimitExceeded:
+		return "memory_limit_exceeded"
+	case !run.Passed && !run.Killed && run.ExitCode != 0:
+		return "runtime_error"
+	case !run.Passed:
+		return "wrong_output"
+	case run.ExitCode != 0:
+		return "runtime_error"
+	}
+	return "passed"
+}
+
+// runDialogueTest starts the student program inside the VM and plays the
+// test's dialogue script against it.
+func runDialogueTest(sess *vmSession, dir string, prog program, tc TestCase, timeout time.Duration) dialogueRun {
+	run := dialogueRun{ExitCode: -1}
+	steps, err := parseDialogueScript(stringOrEmpty(tc.DialogueScript))
+	if err != nil {
+		run.Stderr = err.Error()
+		return run
+	}
+	// Python programs start through the same runner as stdin tests, keeping
+	// their input() prompts for the expect steps.
+	entry := prog.entry
+	if _, ok := prog.rt.(pythonRuntime); ok {
+		if err := writePythonRunner(dir, prog.entry, true); err != nil {
+			run.Stderr = err.Error()
+			return run
+		}
+		entry = pythonRunnerFile
+	}
+	_ = ensureSandboxPerms(dir)
+	if err := writeMemoryGuard(dir); err != nil {
+		run.Stderr = err.Error()
+		return run
+	}
+
+	bootCtx, bootCancel := context.WithTimeout(context.Background(), vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
+	defer bootCancel()
+	vm, remoteDir, release, err := acquireRunWorkspace(bootCtx, sess, dir)
+	if err != nil {
+		run.TimedOut = bootCtx.Err() == context.DeadlineExceeded
+		run.Stderr = fmt.Sprintf("vm start failed: %v", err)
+		return run
+	}
+	defer release()
+
+	execCtx, execCancel := context.WithTimeout(context.Background(), timeout+vmExtraTimeout)
+	defer execCancel()
+	script := prog.rt.command(remoteDir, entry, tc.MemoryLimitKB)
+	start := time.Now()
+	cmd, stdinPipe, stdoutPipe, stderrPipe, err := vm.startInteractive(execCtx, remoteDir, script)
+	if err != nil {
+		run.Stderr = fmt.Sprintf("vm exec start failed: %v", err)
+		return run
+	}
+
+	stream := newDialogueStream()
+	var errMu sync.Mutex
+	var errBuf bytes.Buffer
+	// the memory guard reports last, so the end of stderr is kept even when
+	// the program wrote more than dialogueMaxOutput
+	var errTail []byte
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		_, _ = io.Copy(stream, stdoutPipe)
+		stream.closeWrite()
+	}()
+	go func() {
+		defer readers.Done()
+		b := make([]byte, 4096)
+		for {
+			n, rerr := stderrPipe.Read(b)
+			if n > 0 {
+				errMu.Lock()
+				chunk := b[:n]
+				if room := dialogueMaxOutput - errBuf.Len(); room > 0 {
+					keep := min(room, len(chunk))
+					errBuf.Write(chunk[:keep])
+					chunk = chunk[keep:]
+				}
+				errTail = append(errTail, chunk...)
+				if len(errTail) > dialogueStderrTail {
+					errTail = append(errTail[:0], errTail[len(errTail)-dialogueStderrTail:]...)
+				}
+				errMu.Unlock()
+			}
+			if rerr != nil {
+				return
+			}
+		}
+	}()
+	done := make(chan error, 1)
+	go func() {
+		readers.Wait()
+		done <- cmd.Wait()
+	}()
+
+	deadline := start.Add(timeout)
+	transcript, passed := playDialogue(steps, stream, stdinPipe, deadline)
+	_ = stdinPipe.Close()
+
+	var waitErr error
+	exited := false
+	if passed || stream.ended() {
+		// Give the program the rest of the time limit to finish on its own.
+		timer := time.NewTimer(max(time.Until(deadline), 0))
+		select {
+		case waitErr = <-done:
+			exited = true
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	if !exited {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+		<-done
+		run.Killed = true
+		if passed {
+			run.TimedOut = true
+		}
+	}
+	run.Runtime = time.Since(start)
+	if !passed && time.Now().After(deadline) {
+		run.TimedOut = true
+	}
+
+	var t strings.Builder
+	t.WriteString(transcript)
+	writeProgramOutput(&t, stream.drain())
+	if exited {
+		run.ExitCode = 0
+		if waitErr != nil {
+			run.ExitCode = -1
+			var ee *exec.ExitError
+			if errors.As(waitErr, &ee) && ee.ExitCode() >= 0 {
+				run.ExitCode = ee.ExitCode()
+			}
+		}
+		fmt.Fprintf(&t, "EXIT %d\n", run.ExitCode)
+	}
+	run.Transcript = strings.TrimRight(t.String(), "\n")
+	run.Passed = passed
+
+	errMu.Lock()
+	errOut, usage := splitMemoryGuardOutput(errBuf.String() + string(errTail))
+	errMu.Unlock()
+	if len(errOut) > dialogueMaxOutput {
+		errOut = errOut[:dialogueMaxOutput]
+	}
+	run.Stderr = strings.TrimSpace(errOut)
+	run.Memory = usage
+	return run
+}
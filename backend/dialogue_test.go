@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDialogueScriptValidation(t *testing.T) {
+	if _, err := parseDialogueScript(`[{"expect":"Name\\?","timeout_sec":2},{"send":"Ann"},{"close_stdin":true}]`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bad := []string{
+		`[]`,
+		`{"expect":"x"}`,
+		`[{"expect":"x","send":"y"}]`,
+		`[{"send":"y","timeout_sec":1}]`,
+		`[{"expect":"(","timeout_sec":1}]`,
+		`[{"expect":"x","timeout_sec":-1}]`,
+		`[{"expct":"x"}]`,
+	}
+	for _, raw := range bad {
+		if _, err := parseDialogueScript(raw); err == nil {
+			t.Errorf("expected error for %s", raw)
+		}
+	}
+}
+
+// fakeGreeter behaves like a program that asks for a name and greets back.
+func fakeGreeter(stream *dialogueStream, stdin io.Reader) {
+	defer stream.closeWrite()
+	r := bufio.NewReader(stdin)
+	stream.Write([]byte("Name? "))
+	name, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	stream.Write([]byte("Hello, " + strings.TrimSpace(name) + "!\n"))
+}
+
+func TestPlayDialogue(t *testing.T) {
+	steps, err := parseDialogueScript(`[{"expect":"Name\\? $"},{"send":"Ann"},{"expect":"Hello, Ann!"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newDialogueStream()
+	pr, pw := io.Pipe()
+	go fakeGreeter(stream, pr)
+	transcript, ok := playDialogue(steps, stream, pw, time.Now().Add(5*time.Second))
+	if !ok {
+		t.Fatalf("dialogue failed:\n%s", transcript)
+	}
+	want := "PROGRAM> Name? \nEXPECT /Name\\? $/ ok\nINPUT> Ann\nPROGRAM> Hello, Ann!\nEXPECT /Hello, Ann!/ ok\n"
+	if transcript != want {
+		t.Fatalf("transcript = %q, want %q", transcript, want)
+	}
+}
+
+func TestPlayDialogueReportsFailedExpect(t *testing.T) {
+	steps, err := parseDialogueScript(`[{"expect":"Name"},{"send":"Bob"},{"expect":"Goodbye","timeout_sec":5}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newDialogueStream()
+	pr, pw := io.Pipe()
+	go fakeGreeter(stream, pr)
+	start := time.Now()
+	transcript, ok := playDialogue(steps, stream, pw, time.Now().Add(10*time.Second))
+	if ok {
+		t.Fatalf("expected failure:\n%s", transcript)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatalf("expect should fail as soon as output ends")
+	}
+	if !strings.Contains(transcript, "PROGRAM> Hello, Bob!") || !strings.Contains(transcript, "EXPECT /Goodbye/ FAILED (step 3: program output ended)") {
+		t.Fatalf("unexpected transcript:\n%s", transcript)
+	}
+}
+
+func TestDialogueStatus(t *testing.T) {
+	cases := []struct {
+		run  dialogueRun
+		want string
+	}{
+		{dialogueRun{Passed: true}, "passed"},
+		{dialogueRun{Passed: true, ExitCode: 1}, "runtime_error"},
+		{dialogueRun{ExitCode: 1}, "runtime_error"},
+		{dialogueRun{ExitCode: -1, Killed: true}, "wrong_output"},
+		{dialogueRun{TimedOut: true, Killed: true}, "time_limit_exceeded"},
+	}
+	for _, c := range cases {
+		if got := dialogueStatus(c.run); got != c.want {
+			t.Errorf("dialogueStatus(%+v) = %s, want %s", c.run, got, c.want)
+		}
+	}
+}
+
+// TestRunDialogueTest runs a Python program through the runner: prompts
+// reach the expect steps, argv is the entry file and the memory guard
+// report survives a flood of stderr.
+func TestRunDialogueTest(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"main.py": "import sys\nsys.stderr.write('x' * 200000)\nname = input('Name? ')\nprint('Hello, ' + name + ' from ' + sys.argv[0])\n"})
+		script := `[{"expect":"Name\\? $"},{"send":"Ann"},{"expect":"Hello, Ann from main.py"}]`
+		tc := TestCase{ExecutionMode: "dialogue", DialogueScript: &script}
+		run := runDialogueTest(nil, dir, program{rt: pythonRuntime{}, entry: "main.py"}, tc, 20*time.Second)
+		if !run.Passed || run.ExitCode != 0 {
+			t.Fatalf("dialogue failed (exit %d):\n%s", run.ExitCode, run.Transcript)
+		}
+		if run.Memory.PeakKB == 0 {
+			t.Errorf("memory report lost after %d bytes of stderr", len(run.Stderr))
+		}
+		if len(run.Stderr) > dialogueMaxOutput || strings.Contains(run.Stderr, memoryGuardMarker) {
+			t.Errorf("stderr not trimmed: %d bytes", len(run.Stderr))
+		}
+	})
+}
@@ -16,19 +16,34 @@ type functionCallConfig struct {
 	ArgsJSON     *string
 	KwargsJSON   *string
 	ExpectedJSON *string
+	// BatchJSON is a JSON list of argument lists. When set the function is
+	// called once per entry and the outcomes are reported in Batch; Args,
+	// Kwargs and Expected are ignored.
+	BatchJSON *string
 }
 
 type functionCallResult struct {
-	Status          string         `json:"status"`
-	Passed          bool           `json:"passed"`
-	ReturnJSON      *string        `json:"return_json"`
-	ReturnRepr      string         `json:"return_repr"`
-	Stdout          string         `json:"stdout"`
-	Exception       string         `json:"exception"`
-	Traceback       string         `json:"traceback"`
-	ExpectedJSON    *string        `json:"expected_json"`
-	ExpectedRepr    *string        `json:"expected_repr"`
-	ComparisonDebug map[string]any `json:"comparison_debug"`
+	Status          string              `json:"status"`
+	Passed          bool                `json:"passed"`
+	ReturnJSON      *string             `json:"return_json"`
+	ReturnRepr      string              `json:"return_repr"`
+	Stdout          string              `json:"stdout"`
+	Exception       string              `json:"exception"`
+	Traceback       string              `json:"traceback"`
+	ExpectedJSON    *string             `json:"expected_json"`
+	ExpectedRepr    *string             `json:"expected_repr"`
+	ComparisonDebug map[string]any      `json:"comparison_debug"`
+	Batch           []functionBatchCall `json:"batch"`
+}
+
+// functionBatchCall is the outcome of one call of a batch.
+type functionBatchCall struct {
+	Status        string  `json:"status"`
+	ReturnJSON    *string `json:"return_json"`
+	ReturnRepr    string  `json:"return_repr"`
+	Stdout        string  `json:"stdout"`
+	Exception     string  `json:"exception"`
+	ExceptionType string  `json:"exception_type"`
 }
 
 func writeFunctionRunnerFiles(dir, mainFile string, cfg functionCallConfig) (string, string, error) {
@@ -58,6 +73,13 @@ func writeFunctionRunnerFiles(dir, mainFile string, cfg functionCallConfig) (str
 		}
 		payload["expected"] = parsed
 	}
+	if cfg.BatchJSON != nil {
+		var batch [][]any
+		if err := json.Unmarshal([]byte(*cfg.BatchJSON), &batch); err != nil {
+			return "", "", fmt.Errorf("invalid batch JSON: %w", err)
+		}
+		payload["batch"] = batch
+	}
 
 	configPath := filepath.Join(dir, "function_config.json")
 	runnerPath := filepath.Join(dir, "function_runner.py")
@@ -114,6 +136,90 @@ def resolve_attr(root, dotted: str):
     return target
 
 
+def call_one(func, args):
+    out = io.StringIO()
+    entry = {"status": "ok"}
+    try:
+        with contextlib.redirect_stdout(out):
+            value = func(*args)
+        entry['return_repr'] = repr(value)
+        try:
+            entry['return_json'] = json.dumps(normalize_value(value))
+        except (TypeError, ValueError):
+            entry['return_json'] = None
+    except Exception as exc:  # noqa: BLE001
+        entry['status'] = 'exception'
+        entry['exception'] = repr(exc)
+        entry['exception_type'] = type(exc).__name__
+    entry['stdout'] = out.getvalue()
+    return entry
+
+
+def run_single(func, cfg, result, call_stdout):
+    args = cfg.get('args') or []
+    kwargs = cfg.get('kwargs') or {}
+
+    with contextlib.redirect_stdout(call_stdout):
+        value = func(*args, **kwargs)
+
+    result['passed'] = True
+    sentinel = object()
+    expected = cfg.get('expected', sentinel)
+    if expected is not sentinel:
+        normalized_expected = normalize_value(expected)
+        normalized_value = normalize_value(value)
+
+        # Strip trailing newline for comparison
+        cmp_value = strip_trailing_newline(normalized_value)
+        cmp_expected = strip_trailing_newline(normalized_expected)
+
+        try:
+            equal = cmp_value == cmp_expected
+        except Exception as cmp_exc:  # noqa: BLE001
+            equal = False
+            result['compare_exception'] = repr(cmp_exc)
+
+        if not equal:
+            try:
+                if str(cmp_value).strip() == str(cmp_expected).strip():
+                    equal = True
+            except Exception:
+                pass
+
+        if not equal:
+            # Add comparison debug info
+            result['comparison_debug'] = {
+                "actual_type": type(value).__name__,
+                "actual_repr": repr(value),
+                "expected_type": type(expected).__name__,
+                "expected_repr": repr(expected),
+                "cmp_actual_repr": repr(cmp_value),
+                "cmp_expected_repr": repr(cmp_expected)
+            }
+
+        result['passed'] = bool(equal)
+        result['expected_repr'] = repr(expected)
+        try:
+            result['expected_json'] = json.dumps(normalized_expected)
+        except TypeError:
+            result['expected_json'] = None
+        if isinstance(value, tuple):
+            result['comparison_note'] = 'Compared after normalizing tuple return to list.'
+    else:
+        normalized_value = normalize_value(value)
+
+    result['return_repr'] = repr(value)
+    try:
+        result['return_json'] = json.dumps(value)
+    except TypeError:
+        result['return_json'] = None
+    if normalized_value is not value:
+        try:
+            result['return_json_normalized'] = json.dumps(normalized_value)
+        except TypeError:
+            result['return_json_normalized'] = None
+
+
 def main():
     cfg_path = pathlib.Path(__file__).with_name('function_config.json')
     with cfg_path.open('r', encoding='utf-8') as fh:
@@ -138,68 +244,11 @@ def main():
         with contextlib.redirect_stdout(module_stdout):
             module = load_module(str(path))
         func = resolve_attr(module, cfg['function_name'])
-        args = cfg.get('args') or []
-        kwargs = cfg.get('kwargs') or {}
-
-        with contextlib.redirect_stdout(call_stdout):
-            value = func(*args, **kwargs)
-
-        result['passed'] = True
-        sentinel = object()
-        expected = cfg.get('expected', sentinel)
-        if expected is not sentinel:
-            normalized_expected = normalize_value(expected)
-            normalized_value = normalize_value(value)
-
-            # Strip trailing newline for comparison
-            cmp_value = strip_trailing_newline(normalized_value)
-            cmp_expected = strip_trailing_newline(normalized_expected)
-
-            try:
-                equal = cmp_value == cmp_expected
-            except Exception as cmp_exc:  # noqa: BLE001
-                equal = False
-                result['compare_exception'] = repr(cmp_exc)
-
-            if not equal:
-                try:
-                    if str(cmp_value).strip() == str(cmp_expected).strip():
-                        equal = True
-                except Exception:
-                    pass
-
-            if not equal:
-                # Add comparison debug info
-                result['comparison_debug'] = {
-                    "actual_type": type(value).__name__,
-                    "actual_repr": repr(value),
-                    "expected_type": type(expected).__name__,
-                    "expected_repr": repr(expected),
-                    "cmp_actual_repr": repr(cmp_value),
-                    "cmp_expected_repr": repr(cmp_expected)
-                }
-
-            result['passed'] = bool(equal)
-            result['expected_repr'] = repr(expected)
-            try:
-                result['expected_json'] = json.dumps(normalized_expected)
-            except TypeError:
-                result['expected_json'] = None
-            if isinstance(value, tuple):
-                result['comparison_note'] = 'Compared after normalizing tuple return to list.'
+        if 'batch' in cfg:
+            result['batch'] = [call_one(func, call_args) for call_args in cfg['batch']]
+            result['passed'] = True
         else:
-            normalized_value = normalize_value(value)
-
-        result['return_repr'] = repr(value)
-        try:
-            result['return_json'] = json.dumps(value)
-        except TypeError:
-            result['return_json'] = None
-        if normalized_value is not value:
-            try:
-                result['return_json_normalized'] = json.dumps(normalized_value)
-            except TypeError:
-                result['return_json_normalized'] = None
+            run_single(func, cfg, result, call_stdout)
 
     except Exception as exc:  # noqa: BLE001
         result['status'] = 'exception'
@@ -219,19 +268,23 @@ def main():
 if __name__ == '__main__':
     main()
 `
-	if err := os.WriteFile(runnerPath, []byte(script), 0644); err != nil {
+	if err := os.WriteFile(runnerPath, []byte(toolGuardPrelude+script), 0644); err != nil {
 		return "", "", err
 	}
 	return configPath, runnerPath, nil
 }
 
-func runFunctionCall(dir, mainFile string, cfg functionCallConfig, timeout time.Duration) (string, string, int, bool, time.Duration, *functionCallResult, error) {
+func runFunctionCall(sess *vmSession, dir, mainFile string, cfg functionCallConfig, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage, *functionCallResult, error) {
 	configPath, runnerPath, err := writeFunctionRunnerFiles(dir, mainFile, cfg)
 	if err != nil {
-		return "", "", 0, false, 0, nil, err
+		return "", "", 0, false, 0, memoryUsage{}, nil, err
 	}
 	defer os.Remove(configPath)
 	defer os.Remove(runnerPath)
+	if err := writeMemoryGuard(dir); err != nil {
+		return "", "", 0, false, 0, memoryUsage{}, nil, err
+	}
+	defer os.Remove(filepath.Join(dir, memoryGuardFile))
 	_ = os.Chmod(configPath, 0644)
 	_ = os.Chmod(runnerPath, 0644)
 	_ = ensureSandboxPerms(dir)
@@ -239,19 +292,20 @@ func runFunctionCall(dir, mainFile string, cfg functionCallConfig, timeout time.
 	ctx, cancel := context.WithTimeout(context.Background(), timeout+vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
 	defer cancel()
 
-	vm, remoteDir, err := startVMWithWorkspace(ctx, dir, nil)
+	vm, remoteDir, release, err := acquireRunWorkspace(ctx, sess, dir)
 	if err != nil {
-		return "", "", -1, ctx.Err() == context.DeadlineExceeded, 0, nil, fmt.Errorf("vm start failed: %w", err)
+		return "", "", -1, ctx.Err() == context.DeadlineExceeded, 0, memoryUsage{}, nil, fmt.Errorf("vm start failed: %w", err)
 	}
-	defer vm.Close()
+	defer release()
 
 	remoteRunner := filepath.Join(remoteDir, filepath.Base(runnerPath))
-	script := fmt.Sprintf("start=$(date +%%s%%N); PYTHONDONTWRITEBYTECODE=1 PYTHONUNBUFFERED=1 HOME=/tmp LANG=C.UTF-8 %s -u '%s'; status=$?; end=$(date +%%s%%N); echo '===RUNTIME_MS===' $(((end-start)/1000000)); exit $status", pythonBinary, strings.ReplaceAll(remoteRunner, "'", "'\\''"))
+	script := fmt.Sprintf("start=$(date +%%s%%N); PYTHONDONTWRITEBYTECODE=1 PYTHONUNBUFFERED=1 HOME=/tmp LANG=C.UTF-8 %s; status=$?; end=$(date +%%s%%N); echo '===RUNTIME_MS===' $(((end-start)/1000000)); exit $status", memoryGuardedPython(remoteDir, remoteRunner, memoryLimitKB))
 
 	var stdoutBuf, stderrBuf strings.Builder
 	start := time.Now()
 	rawOut, rawErr, exitCode, runErr := vm.runCommand(ctx, remoteDir, script, nil)
 	duration := time.Since(start)
+	collectOutputFiles(vm, remoteDir, dir)
 	stdoutBuf.WriteString(rawOut)
 	stderrBuf.WriteString(rawErr)
 
@@ -280,18 +334,7 @@ func runFunctionCall(dir, mainFile string, cfg functionCallConfig, timeout time.
 		exitCode = -1
 	}
 
-	const marker = "===GRADER_JSON==="
-	var meta *functionCallResult
-	if idx := strings.LastIndex(out, marker); idx != -1 {
-		payload := strings.TrimSpace(out[idx+len(marker):])
-		out = out[:idx]
-		var tmp functionCallResult
-		if payload != "" {
-			if err := json.Unmarshal([]byte(payload), &tmp); err == nil {
-				meta = &tmp
-			}
-		}
-	}
+	out, meta := splitFunctionCallOutput(out)
 
 	timedOut := ctxTimedOut || runtime > timeout
 	stdout := out
@@ -299,5 +342,26 @@ func runFunctionCall(dir, mainFile string, cfg functionCallConfig, timeout time.
 		stdout = meta.Stdout
 	}
 
-	return stdout, strings.TrimSpace(stderrBuf.String()), exitCode, timedOut, runtime, meta, nil
+	errOut, usage := splitMemoryGuardOutput(stderrBuf.String())
+	if meta != nil && isMemoryErrorException(meta.Exception) && usage.LimitKB > 0 {
+		usage.LimitExceeded = true
+	}
+
+	return stdout, strings.TrimSpace(errOut), exitCode, timedOut, runtime, usage, meta, nil
+}
+
+// splitFunctionCallOutput separates the harness report from the program output.
+func splitFunctionCallOutput(out string) (string, *functionCallResult) {
+	const marker = "===GRADER_JSON==="
+	idx := strings.LastIndex(out, marker)
+	if idx == -1 {
+		return out, nil
+	}
+	payload := strings.TrimSpace(out[idx+len(marker):])
+	out = out[:idx]
+	var meta functionCallResult
+	if payload == "" || json.Unmarshal([]byte(payload), &meta) != nil {
+		return out, nil
+	}
+	return out, &meta
 }
@@ -14,6 +14,7 @@ import (
 	"image/png"
 	"io"
 	"log"
+	"math"
 	"mime"
 	"mime/multipart"
 	"net"
@@ -59,7 +60,7 @@ type RunSession struct {
 	// process and IO
 	Cmd   *exec.Cmd
 	Stdin io.WriteCloser
-	VM    *vmInstance
+	VM    Sandbox
 
 	// output buffers (accumulated for replay on reattach)
 	BufOut []byte
@@ -271,6 +272,9 @@ func createAssignment(c *gin.Context) {
 		ScratchEvaluationMode   *string `json:"scratch_evaluation_mode"`
 		ScratchSemanticCriteria *string `json:"scratch_semantic_criteria"`
 		MaxAttempts             *int    `json:"max_attempts"`
+		CompileFlags            *string `json:"compile_flags"`
+		SQLSchema               *string `json:"sql_schema"`
+		SQLSeed                 *string `json:"sql_seed"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -281,6 +285,11 @@ func createAssignment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	compileFlags, err := normalizeCompileFlags(req.CompileFlags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	manualReview := req.ManualReview
 	scratchMode := "automatic"
 	if req.ScratchEvaluationMode != nil {
@@ -321,6 +330,9 @@ func createAssignment(c *gin.Context) {
 		SecondDeadline:   nil,
 		LatePenaltyRatio: 0.5,
 		MaxAttempts:      req.MaxAttempts,
+		CompileFlags:     compileFlags,
+		SQLSchema:        nonEmptyStringPtr(req.SQLSchema),
+		SQLSeed:          nonEmptyStringPtr(req.SQLSeed),
 	}
 	if err := CreateAssignment(a); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create assignment"})
@@ -345,8 +357,14 @@ func normalizeProgrammingLanguage(raw string) (string, error) {
 		return "python", nil
 	}
 	switch lang {
-	case "python", "scratch":
+	case "python", "scratch", "c", "cpp", "java", "javascript", "sql", "jupyter":
 		return lang, nil
+	case "c++":
+		return "cpp", nil
+	case "js", "node":
+		return "javascript", nil
+	case "notebook", "ipynb":
+		return "jupyter", nil
 	default:
 		return "", fmt.Errorf("invalid programming_language")
 	}
@@ -395,13 +413,22 @@ func getAssignment(c *gin.Context) {
 		}
 		subs, _ := ListSubmissionsForAssignmentAndStudent(id, getUserID(c))
 		stats, _ := GetTestCaseStats(id)
-		c.JSON(http.StatusOK, gin.H{
+		allTests, _ := ListTestCases(id)
+		samples := publicSampleTests(allTests)
+		groups, _ := ListTestGroups(id)
+		resp := gin.H{
 			"assignment":                      a,
 			"submissions":                     subs,
 			"tests_count":                     stats.Count,
 			"submission_limit_per_minute":     getSubmissionAttemptLimit(),
 			"submission_limit_window_seconds": submissionAttemptWindowSeconds,
-		})
+			"sample_tests":                    samples,
+			"test_groups":                     groups,
+		}
+		if len(samples) > 0 {
+			resp["sample_run_quota"] = sampleRunStatus(id, getUserID(c))
+		}
+		c.JSON(http.StatusOK, resp)
 		return
 	} else if role == "teacher" {
 		if ok, err := IsTeacherOfAssignment(id, getUserID(c)); err != nil || !ok {
@@ -421,6 +448,7 @@ func getAssignment(c *gin.Context) {
 	} else {
 		stats, _ = GetTestCaseStats(id)
 	}
+	groups, _ := ListTestGroups(id)
 	// Recalculate MaxPoints from test weights if weighted grading policy is active.
 	// This applies to Python assignments and automatic/semi-automatic Scratch assignments.
 	if a.GradingPolicy == "weighted" {
@@ -429,7 +457,17 @@ func getAssignment(c *gin.Context) {
 		isScratchAuto := a.ProgrammingLanguage == "scratch" && (a.ScratchEvaluationMode == "automatic" || a.ScratchEvaluationMode == "semi_automatic")
 
 		if isPython {
-			if includeTests {
+			if len(groups) > 0 {
+				// group points replace the test weights
+				groupTests := tests
+				if !includeTests {
+					groupTests, _ = ListTestCases(id)
+				}
+				_, _, total, _ := scoreTestGroups(groups, groupTests, nil)
+				// max_points holds whole points, so fractional group points
+				// are rounded to the nearest one rather than cut off
+				a.MaxPoints = int(math.Round(total))
+			} else if includeTests {
 				sum := 0.0
 				for _, t := range tests {
 					sum += t.Weight
@@ -449,9 +487,10 @@ func getAssignment(c *gin.Context) {
 			a.MaxPoints = int(sum)
 		}
 	}
-	resp := gin.H{"assignment": a}
+	resp := gin.H{"assignment": a, "execution_modes": languageModes(a.ProgrammingLanguage)}
 	if includeTests {
 		resp["tests"] = tests
+		resp["test_groups"] = groups
 	} else {
 		resp["tests_count"] = stats.Count
 	}
@@ -528,6 +567,9 @@ func updateAssignment(c *gin.Context) {
 		SecondDeadline          *string  `json:"second_deadline"`
 		LatePenaltyRatio        *float64 `json:"late_penalty_ratio"`
 		MaxAttempts             *int     `json:"max_attempts"`
+		CompileFlags            *string  `json:"compile_flags"`
+		SQLSchema               *string  `json:"sql_schema"`
+		SQLSeed                 *string  `json:"sql_seed"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -582,6 +624,20 @@ func updateAssignment(c *gin.Context) {
 	} else if strings.TrimSpace(a.ProgrammingLanguage) == "" {
 		a.ProgrammingLanguage = "python"
 	}
+	if req.CompileFlags != nil {
+		flags, err := normalizeCompileFlags(req.CompileFlags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		a.CompileFlags = flags
+	}
+	if req.SQLSchema != nil {
+		a.SQLSchema = nonEmptyStringPtr(req.SQLSchema)
+	}
+	if req.SQLSeed != nil {
+		a.SQLSeed = nonEmptyStringPtr(req.SQLSeed)
+	}
 	a.LLMInteractive = req.LLMInteractive
 	a.LLMFeedback = req.LLMFeedback
 	a.LLMAutoAward = req.LLMAutoAward
@@ -682,7 +738,6 @@ func syncTeachersGroupAssignment(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
-	srcTests, _ := ListTestCases(aid)
 	updated := 0
 
 	for _, cl := range clones {
@@ -708,23 +763,37 @@ func syncTeachersGroupAssignment(c *gin.Context) {
 		clone.LLMStrictness = source.LLMStrictness
 		clone.LLMRubric = source.LLMRubric
 		clone.LLMTeacherBaseline = source.LLMTeacherBaseline
+		clone.CompileFlags = source.CompileFlags
+		clone.SQLSchema = source.SQLSchema
+		clone.SQLSeed = source.SQLSeed
 
 		if err := UpdateAssignment(clone); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update clone"})
 			return
 		}
+		if err := UpdateAssignmentConstructRules(clone.ID, source.ConstructRules); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update clone"})
+			return
+		}
+		if err := UpdateAssignmentQualityConfig(clone.ID, source.QualityConfig); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update clone"})
+			return
+		}
+		if err := copyReferenceSolution(source.ID, clone.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update clone"})
+			return
+		}
 		if err := DeleteAllTestCasesForAssignment(clone.ID); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset tests"})
 			return
 		}
-		for _, tcase := range srcTests {
-			tc := tcase
-			tc.ID = uuid.Nil
-			tc.AssignmentID = clone.ID
-			if err := CreateTestCase(&tc); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to copy tests"})
-				return
-			}
+		if err := DeleteAllTestGroupsForAssignment(clone.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset tests"})
+			return
+		}
+		if err := copyTestCases(source.ID, clone.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to copy tests"})
+			return
 		}
 		updated++
 	}
@@ -783,6 +852,109 @@ func updateAssignmentTestingConstraints(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"assignment": updated})
 }
 
+// updateAssignmentConstructRules: PUT /api/assignments/:id/construct-rules
+// Replaces the required/forbidden construct rules checked before the tests run.
+func updateAssignmentConstructRules(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if c.GetString("role") == "teacher" {
+		if ok, err := IsTeacherOfAssignment(id, getUserID(c)); err != nil || !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+	existing, err := GetAssignment(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if existing.ClassID == TeacherGroupID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot edit teacher group assignments directly"})
+		return
+	}
+
+	var payload struct {
+		Rules []ConstructRule `json:"rules"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rules, err := normalizeConstructRules(payload.Rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rulesJSON, err := serializeConstructRules(rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := UpdateAssignmentConstructRules(id, rulesJSON); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update"})
+		return
+	}
+	updated, err := GetAssignment(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"assignment": updated})
+}
+
+// updateAssignmentQualityConfig: PUT /api/assignments/:id/quality-config
+// Enables or disables the static code-quality analysis and sets its weight.
+func updateAssignmentQualityConfig(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if c.GetString("role") == "teacher" {
+		if ok, err := IsTeacherOfAssignment(id, getUserID(c)); err != nil || !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+	existing, err := GetAssignment(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if existing.ClassID == TeacherGroupID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot edit teacher group assignments directly"})
+		return
+	}
+
+	var payload QualityConfig
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cfgJSON, err := serializeQualityConfig(&payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := UpdateAssignmentQualityConfig(id, cfgJSON); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update"})
+		return
+	}
+	updated, err := GetAssignment(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"assignment": updated})
+}
+
 // deleteAssignment: DELETE /api/assignments/:id
 func deleteAssignment(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
@@ -903,7 +1075,13 @@ func uploadUnitTests(c *gin.Context) {
 	}
 	defer f.Close()
 	data, _ := io.ReadAll(f)
-	methods := parseUnittestMethods(string(data))
+	// mode is optional: unittest classes, pytest tests and doctests are
+	// tried in that order
+	mode, methods, err := detectPythonSuite(string(data), strings.TrimSpace(c.PostForm("mode")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if len(methods) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no tests found"})
 		return
@@ -911,7 +1089,7 @@ func uploadUnitTests(c *gin.Context) {
 	for _, m := range methods {
 		code := string(data)
 		name := m
-		tc := &TestCase{AssignmentID: aid, Weight: 1, Stdin: "", ExpectedStdout: "", UnittestCode: &code, UnittestName: &name, ExecutionMode: "unittest"}
+		tc := &TestCase{AssignmentID: aid, Weight: 1, Stdin: "", ExpectedStdout: "", UnittestCode: &code, UnittestName: &name, ExecutionMode: mode}
 		if err := CreateTestCase(tc); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
 			return
@@ -1515,6 +1693,13 @@ func createTestCase(c *gin.Context) {
 		ExpectedStdout   *string           `json:"expected_stdout"`
 		Weight           *float64          `json:"weight"`
 		TimeLimitSec     *float64          `json:"time_limit_sec"`
+		MemoryLimitKB    *int              `json:"memory_limit_kb"`
+		Comparator       string            `json:"comparator"`
+		ComparatorOpts   *string           `json:"comparator_options"`
+		CheckerCode      *string           `json:"checker_code"`
+		DialogueScript   *string           `json:"dialogue_script"`
+		GroupID          *uuid.UUID        `json:"group_id"`
+		IsSample         bool              `json:"is_sample"`
 		UnittestCode     *string           `json:"unittest_code"`
 		UnittestName     *string           `json:"unittest_name"`
 		FunctionName     *string           `json:"function_name"`
@@ -1525,13 +1710,22 @@ func createTestCase(c *gin.Context) {
 		FileName         *string           `json:"file_name"`
 		FileBase64       *string           `json:"file_base64"`
 		Files            []TestFilePayload `json:"files"`
+		SQLQuery         *string           `json:"sql_query"`
+		SQLFile          *string           `json:"sql_file"`
+		SQLOrdered       bool              `json:"sql_ordered"`
+		SQLColumnMatch   string            `json:"sql_column_match"`
+		NotebookTarget   *string           `json:"notebook_target"`
+		PerformanceCfg   *string           `json:"performance_config"`
+		PropertyCfg      *string           `json:"property_config"`
+		OutputFiles      []OutputFileSpec  `json:"output_files"`
+		TurtleCfg        *string           `json:"turtle_config"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	mode := strings.TrimSpace(req.ExecutionMode)
-	tc := &TestCase{AssignmentID: aid}
+	tc := &TestCase{AssignmentID: aid, IsSample: req.IsSample}
 	if req.Weight != nil {
 		tc.Weight = *req.Weight
 	} else {
@@ -1540,6 +1734,13 @@ func createTestCase(c *gin.Context) {
 	if req.TimeLimitSec != nil {
 		tc.TimeLimitSec = *req.TimeLimitSec
 	}
+	if req.MemoryLimitKB != nil {
+		if *req.MemoryLimitKB < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "memory_limit_kb must not be negative"})
+			return
+		}
+		tc.MemoryLimitKB = *req.MemoryLimitKB
+	}
 	switch mode {
 	case "", "stdin_stdout":
 		mode = "stdin_stdout"
@@ -1547,9 +1748,113 @@ func createTestCase(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "stdin and expected_stdout are required"})
 			return
 		}
+		if err := validateComparator(req.Comparator, req.ComparatorOpts, *req.ExpectedStdout); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		tc.Stdin = *req.Stdin
 		tc.ExpectedStdout = *req.ExpectedStdout
-	case "unittest":
+		tc.Comparator = req.Comparator
+		tc.ComparatorOptions = req.ComparatorOpts
+	case "checker":
+		if req.Stdin == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stdin is required"})
+			return
+		}
+		if err := validateCheckerCode(req.CheckerCode); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.Stdin = *req.Stdin
+		tc.ExpectedStdout = stringOrEmpty(req.ExpectedStdout)
+		tc.CheckerCode = req.CheckerCode
+	case "performance":
+		cfg, err := validatePerformanceTest(req.Stdin, req.PerformanceCfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateComparator(req.Comparator, req.ComparatorOpts, stringOrEmpty(req.ExpectedStdout)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.Stdin = *req.Stdin
+		tc.ExpectedStdout = stringOrEmpty(req.ExpectedStdout)
+		tc.Comparator = req.Comparator
+		tc.ComparatorOptions = req.ComparatorOpts
+		tc.PerformanceConfig = cfg
+	case "property":
+		cfg, err := validatePropertyTest(req.PropertyCfg, req.Comparator, req.ComparatorOpts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.FunctionName != nil && strings.TrimSpace(*req.FunctionName) != "" {
+			tc.FunctionName = strPtr(strings.TrimSpace(*req.FunctionName))
+		}
+		tc.Comparator = req.Comparator
+		tc.ComparatorOptions = req.ComparatorOpts
+		tc.PropertyConfig = cfg
+		tc.Stdin = ""
+		tc.ExpectedStdout = ""
+	case "turtle":
+		cfg, err := validateTurtleTest(req.TurtleCfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.TurtleConfig = cfg
+		tc.Stdin = stringOrEmpty(req.Stdin)
+		tc.ExpectedStdout = ""
+	case "dialogue":
+		if err := validateDialogueScript(req.DialogueScript); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.DialogueScript = req.DialogueScript
+		tc.Stdin = ""
+		tc.ExpectedStdout = ""
+	case "sql_query":
+		if err := validateSQLTest(req.SQLQuery, req.SQLFile); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		match, err := normalizeSQLColumnMatch(req.SQLColumnMatch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.SQLQuery = req.SQLQuery
+		tc.SQLFile = nonEmptyStringPtr(req.SQLFile)
+		tc.SQLOrdered = req.SQLOrdered
+		tc.SQLColumnMatch = match
+		tc.Stdin = ""
+		tc.ExpectedStdout = ""
+	case "notebook_variable", "notebook_cell":
+		target, err := validateNotebookTest(mode, req.NotebookTarget, req.ExpectedReturn)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.NotebookTarget = &target
+		if mode == "notebook_variable" {
+			tc.ExpectedReturn = nonEmptyStringPtr(req.ExpectedReturn)
+			tc.ExpectedStdout = ""
+		} else {
+			if req.ExpectedStdout == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "expected_stdout is required"})
+				return
+			}
+			if err := validateComparator(req.Comparator, req.ComparatorOpts, *req.ExpectedStdout); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			tc.ExpectedStdout = *req.ExpectedStdout
+			tc.Comparator = req.Comparator
+			tc.ComparatorOptions = req.ComparatorOpts
+		}
+		tc.Stdin = stringOrEmpty(req.Stdin)
+	case "unittest", "pytest", "doctest":
 		if req.UnittestCode == nil || req.UnittestName == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "unittest_code and unittest_name are required"})
 			return
@@ -1558,6 +1863,15 @@ func createTestCase(c *gin.Context) {
 		tc.UnittestName = req.UnittestName
 		tc.Stdin = ""
 		tc.ExpectedStdout = ""
+	case "plot":
+		if req.UnittestCode == nil || req.UnittestName == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unittest_code and unittest_name are required"})
+			return
+		}
+		tc.UnittestCode = req.UnittestCode
+		tc.UnittestName = req.UnittestName
+		tc.Stdin = stringOrEmpty(req.Stdin)
+		tc.ExpectedStdout = ""
 	case "function":
 		if req.FunctionName == nil || strings.TrimSpace(*req.FunctionName) == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "function_name is required"})
@@ -1621,6 +1935,23 @@ func createTestCase(c *gin.Context) {
 	} else {
 		tc.FilesJSON = filesJSON
 	}
+	if outputFiles, normErr := normalizeOutputFiles(mode, req.OutputFiles); normErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": normErr.Error()})
+		return
+	} else {
+		tc.OutputFiles = outputFiles
+	}
+	if req.GroupID != nil {
+		if ok, err := testGroupBelongsTo(*req.GroupID, aid); err != nil || !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group_id"})
+			return
+		}
+		tc.GroupID = req.GroupID
+	}
+	if err := checkAssignmentMode(aid, mode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	tc.ExecutionMode = mode
 	if err := CreateTestCase(tc); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
@@ -1642,6 +1973,13 @@ func updateTestCase(c *gin.Context) {
 		ExpectedStdout   string            `json:"expected_stdout"`
 		Weight           float64           `json:"weight"`
 		TimeLimitSec     float64           `json:"time_limit_sec"`
+		MemoryLimitKB    int               `json:"memory_limit_kb"`
+		Comparator       string            `json:"comparator"`
+		ComparatorOpts   *string           `json:"comparator_options"`
+		CheckerCode      *string           `json:"checker_code"`
+		DialogueScript   *string           `json:"dialogue_script"`
+		GroupID          *uuid.UUID        `json:"group_id"`
+		IsSample         bool              `json:"is_sample"`
 		UnittestCode     *string           `json:"unittest_code"`
 		UnittestName     *string           `json:"unittest_name"`
 		FunctionName     *string           `json:"function_name"`
@@ -1652,14 +1990,73 @@ func updateTestCase(c *gin.Context) {
 		FileName         *string           `json:"file_name"`
 		FileBase64       *string           `json:"file_base64"`
 		Files            []TestFilePayload `json:"files"`
+		SQLQuery         *string           `json:"sql_query"`
+		SQLFile          *string           `json:"sql_file"`
+		SQLOrdered       bool              `json:"sql_ordered"`
+		SQLColumnMatch   string            `json:"sql_column_match"`
+		NotebookTarget   *string           `json:"notebook_target"`
+		PerformanceCfg   *string           `json:"performance_config"`
+		PropertyCfg      *string           `json:"property_config"`
+		OutputFiles      []OutputFileSpec  `json:"output_files"`
+		TurtleCfg        *string           `json:"turtle_config"`
+	}
+	// The editor only sends the fields it shows, so start from the stored
+	// test and let the body override what it contains; null clears a field.
+	// A test moved to another mode keeps only the fields all modes share.
+	stored, err := GetTestCase(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var peek struct {
+		ExecutionMode *string `json:"execution_mode"`
+	}
+	if err := json.Unmarshal(body, &peek); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sameMode := peek.ExecutionMode == nil || strings.TrimSpace(*peek.ExecutionMode) == stored.ExecutionMode
+	req.Stdin, req.ExpectedStdout = stored.Stdin, stored.ExpectedStdout
+	req.Weight, req.TimeLimitSec, req.MemoryLimitKB = stored.Weight, stored.TimeLimitSec, stored.MemoryLimitKB
+	req.GroupID, req.IsSample = stored.GroupID, stored.IsSample
+	req.FileName, req.FileBase64 = stored.FileName, stored.FileBase64
+	if stored.FilesJSON != nil {
+		_ = json.Unmarshal([]byte(*stored.FilesJSON), &req.Files)
+	}
+	if sameMode {
+		req.ExecutionMode = stored.ExecutionMode
+		req.Comparator, req.ComparatorOpts = stored.Comparator, stored.ComparatorOptions
+		req.CheckerCode, req.DialogueScript = stored.CheckerCode, stored.DialogueScript
+		req.UnittestCode, req.UnittestName = stored.UnittestCode, stored.UnittestName
+		req.FunctionName, req.FunctionArgs, req.FunctionKwargs = stored.FunctionName, stored.FunctionArgs, stored.FunctionKwargs
+		req.FunctionArgNames, req.ExpectedReturn = stored.FunctionArgNames, stored.ExpectedReturn
+		req.SQLQuery, req.SQLFile, req.SQLOrdered, req.SQLColumnMatch = stored.SQLQuery, stored.SQLFile, stored.SQLOrdered, stored.SQLColumnMatch
+		req.NotebookTarget = stored.NotebookTarget
+		req.PerformanceCfg, req.PropertyCfg, req.TurtleCfg = stored.PerformanceConfig, stored.PropertyConfig, stored.TurtleConfig
+		if stored.OutputFiles != nil {
+			req.OutputFiles, _ = parseOutputFiles(stored.OutputFiles)
+		}
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	if req.Weight == 0 {
 		req.Weight = 1
 	}
+	if req.MemoryLimitKB < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "memory_limit_kb must not be negative"})
+		return
+	}
 	mode := strings.TrimSpace(req.ExecutionMode)
 	if mode == "" {
 		if req.UnittestName != nil && *req.UnittestName != "" {
@@ -1670,17 +2067,115 @@ func updateTestCase(c *gin.Context) {
 			mode = "stdin_stdout"
 		}
 	}
-	tc := &TestCase{ID: id, Stdin: req.Stdin, ExpectedStdout: req.ExpectedStdout, Weight: req.Weight, TimeLimitSec: req.TimeLimitSec, UnittestCode: req.UnittestCode, UnittestName: req.UnittestName, ExecutionMode: mode}
+	tc := stored
+	if !sameMode {
+		tc = &TestCase{ID: stored.ID, AssignmentID: stored.AssignmentID, CreatedAt: stored.CreatedAt}
+	}
+	tc.Stdin, tc.ExpectedStdout, tc.Weight, tc.TimeLimitSec, tc.MemoryLimitKB = req.Stdin, req.ExpectedStdout, req.Weight, req.TimeLimitSec, req.MemoryLimitKB
+	tc.UnittestCode, tc.UnittestName, tc.ExecutionMode, tc.IsSample, tc.GroupID = req.UnittestCode, req.UnittestName, mode, req.IsSample, nil
+	tc.Comparator, tc.ComparatorOptions = req.Comparator, req.ComparatorOpts
 	switch mode {
 	case "stdin_stdout":
-		// nothing extra
-	case "unittest":
+		if err := validateComparator(req.Comparator, req.ComparatorOpts, req.ExpectedStdout); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case "checker":
+		if err := validateCheckerCode(req.CheckerCode); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.CheckerCode = req.CheckerCode
+	case "performance":
+		cfg, err := validatePerformanceTest(&req.Stdin, req.PerformanceCfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateComparator(req.Comparator, req.ComparatorOpts, req.ExpectedStdout); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.Comparator = req.Comparator
+		tc.ComparatorOptions = req.ComparatorOpts
+		tc.PerformanceConfig = cfg
+	case "property":
+		cfg, err := validatePropertyTest(req.PropertyCfg, req.Comparator, req.ComparatorOpts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.FunctionName != nil && strings.TrimSpace(*req.FunctionName) != "" {
+			tc.FunctionName = strPtr(strings.TrimSpace(*req.FunctionName))
+		}
+		tc.Comparator = req.Comparator
+		tc.ComparatorOptions = req.ComparatorOpts
+		tc.PropertyConfig = cfg
+		tc.Stdin = ""
+		tc.ExpectedStdout = ""
+	case "turtle":
+		cfg, err := validateTurtleTest(req.TurtleCfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.TurtleConfig = cfg
+		tc.ExpectedStdout = ""
+	case "dialogue":
+		if err := validateDialogueScript(req.DialogueScript); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.DialogueScript = req.DialogueScript
+		tc.Stdin = ""
+		tc.ExpectedStdout = ""
+	case "sql_query":
+		if err := validateSQLTest(req.SQLQuery, req.SQLFile); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		match, err := normalizeSQLColumnMatch(req.SQLColumnMatch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.SQLQuery = req.SQLQuery
+		tc.SQLFile = nonEmptyStringPtr(req.SQLFile)
+		tc.SQLOrdered = req.SQLOrdered
+		tc.SQLColumnMatch = match
+		tc.Stdin = ""
+		tc.ExpectedStdout = ""
+	case "notebook_variable", "notebook_cell":
+		target, err := validateNotebookTest(mode, req.NotebookTarget, req.ExpectedReturn)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tc.NotebookTarget = &target
+		if mode == "notebook_variable" {
+			tc.ExpectedReturn = nonEmptyStringPtr(req.ExpectedReturn)
+			tc.ExpectedStdout = ""
+		} else {
+			if err := validateComparator(req.Comparator, req.ComparatorOpts, req.ExpectedStdout); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			tc.Comparator = req.Comparator
+			tc.ComparatorOptions = req.ComparatorOpts
+		}
+	case "unittest", "pytest", "doctest":
 		if req.UnittestCode == nil || req.UnittestName == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "unittest_code and unittest_name are required"})
 			return
 		}
 		tc.Stdin = ""
 		tc.ExpectedStdout = ""
+	case "plot":
+		if req.UnittestCode == nil || req.UnittestName == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unittest_code and unittest_name are required"})
+			return
+		}
+		tc.ExpectedStdout = ""
 	case "function":
 		if req.FunctionName == nil || strings.TrimSpace(*req.FunctionName) == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "function_name is required"})
@@ -1759,6 +2254,24 @@ func updateTestCase(c *gin.Context) {
 	} else {
 		tc.FilesJSON = filesJSON
 	}
+	if outputFiles, normErr := normalizeOutputFiles(mode, req.OutputFiles); normErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": normErr.Error()})
+		return
+	} else {
+		tc.OutputFiles = outputFiles
+	}
+	aid := stored.AssignmentID
+	if req.GroupID != nil {
+		if ok, err := testGroupBelongsTo(*req.GroupID, aid); err != nil || !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group_id"})
+			return
+		}
+		tc.GroupID = req.GroupID
+	}
+	if err := checkAssignmentMode(aid, mode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err := UpdateTestCase(tc); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
 		return
@@ -2035,9 +2548,14 @@ type previewTestPayload struct {
 	UnittestCode   string            `json:"unittest_code"`
 	UnittestName   string            `json:"unittest_name"`
 	TimeLimitSec   *float64          `json:"time_limit_sec"`
+	MemoryLimitKB  *int              `json:"memory_limit_kb"`
 	Weight         *float64          `json:"weight"`
 	Stdin          string            `json:"stdin"`
 	ExpectedStdout string            `json:"expected_stdout"`
+	Comparator     string            `json:"comparator"`
+	ComparatorOpts *string           `json:"comparator_options"`
+	CheckerCode    *string           `json:"checker_code"`
+	DialogueScript *string           `json:"dialogue_script"`
 	FunctionName   *string           `json:"function_name"`
 	FunctionArgs   *string           `json:"function_args"`
 	FunctionKwargs *string           `json:"function_kwargs"`
@@ -2045,6 +2563,15 @@ type previewTestPayload struct {
 	FileName       *string           `json:"file_name"`
 	FileBase64     *string           `json:"file_base64"`
 	Files          []TestFilePayload `json:"files"`
+	SQLQuery       *string           `json:"sql_query"`
+	SQLFile        *string           `json:"sql_file"`
+	SQLOrdered     bool              `json:"sql_ordered"`
+	SQLColumnMatch string            `json:"sql_column_match"`
+	NotebookTarget *string           `json:"notebook_target"`
+	PerformanceCfg *string           `json:"performance_config"`
+	PropertyCfg    *string           `json:"property_config"`
+	OutputFiles    []OutputFileSpec  `json:"output_files"`
+	TurtleCfg      *string           `json:"turtle_config"`
 }
 
 func (p previewTestPayload) toTestCase(aid uuid.UUID) (TestCase, error) {
@@ -2057,18 +2584,29 @@ func (p previewTestPayload) toTestCase(aid uuid.UUID) (TestCase, error) {
 		ExecutionMode:  mode,
 		Weight:         1,
 		TimeLimitSec:   1,
+		MemoryLimitKB:  65536,
 		Stdin:          p.Stdin,
 		ExpectedStdout: p.ExpectedStdout,
 	}
+	if mode == "stdin_stdout" {
+		if err := validateComparator(p.Comparator, p.ComparatorOpts, p.ExpectedStdout); err != nil {
+			return TestCase{}, err
+		}
+		tc.Comparator = p.Comparator
+		tc.ComparatorOptions = p.ComparatorOpts
+	}
 	if p.Weight != nil && *p.Weight >= 0 {
 		tc.Weight = *p.Weight
 	}
 	if p.TimeLimitSec != nil && *p.TimeLimitSec > 0 {
 		tc.TimeLimitSec = *p.TimeLimitSec
 	}
+	if p.MemoryLimitKB != nil && *p.MemoryLimitKB > 0 {
+		tc.MemoryLimitKB = *p.MemoryLimitKB
+	}
 
 	switch mode {
-	case "unittest":
+	case "unittest", "pytest", "doctest", "plot":
 		code := strings.TrimSpace(p.UnittestCode)
 		name := strings.TrimSpace(p.UnittestName)
 		if code == "" || name == "" {
@@ -2099,6 +2637,74 @@ func (p previewTestPayload) toTestCase(aid uuid.UUID) (TestCase, error) {
 		}
 	case "stdin_stdout":
 		// nothing extra required
+	case "checker":
+		if err := validateCheckerCode(p.CheckerCode); err != nil {
+			return TestCase{}, err
+		}
+		code := *p.CheckerCode
+		tc.CheckerCode = &code
+	case "performance":
+		cfg, err := validatePerformanceTest(&p.Stdin, p.PerformanceCfg)
+		if err != nil {
+			return TestCase{}, err
+		}
+		if err := validateComparator(p.Comparator, p.ComparatorOpts, p.ExpectedStdout); err != nil {
+			return TestCase{}, err
+		}
+		tc.Comparator = p.Comparator
+		tc.ComparatorOptions = p.ComparatorOpts
+		tc.PerformanceConfig = cfg
+	case "property":
+		cfg, err := validatePropertyTest(p.PropertyCfg, p.Comparator, p.ComparatorOpts)
+		if err != nil {
+			return TestCase{}, err
+		}
+		if p.FunctionName != nil && strings.TrimSpace(*p.FunctionName) != "" {
+			tc.FunctionName = strPtr(strings.TrimSpace(*p.FunctionName))
+		}
+		tc.Comparator = p.Comparator
+		tc.ComparatorOptions = p.ComparatorOpts
+		tc.PropertyConfig = cfg
+	case "turtle":
+		cfg, err := validateTurtleTest(p.TurtleCfg)
+		if err != nil {
+			return TestCase{}, err
+		}
+		tc.TurtleConfig = cfg
+	case "dialogue":
+		if err := validateDialogueScript(p.DialogueScript); err != nil {
+			return TestCase{}, err
+		}
+		script := *p.DialogueScript
+		tc.DialogueScript = &script
+	case "sql_query":
+		if err := validateSQLTest(p.SQLQuery, p.SQLFile); err != nil {
+			return TestCase{}, err
+		}
+		match, err := normalizeSQLColumnMatch(p.SQLColumnMatch)
+		if err != nil {
+			return TestCase{}, err
+		}
+		query := *p.SQLQuery
+		tc.SQLQuery = &query
+		tc.SQLFile = nonEmptyStringPtr(p.SQLFile)
+		tc.SQLOrdered = p.SQLOrdered
+		tc.SQLColumnMatch = match
+	case "notebook_variable", "notebook_cell":
+		target, err := validateNotebookTest(mode, p.NotebookTarget, p.ExpectedReturn)
+		if err != nil {
+			return TestCase{}, err
+		}
+		tc.NotebookTarget = &target
+		if mode == "notebook_variable" {
+			tc.ExpectedReturn = nonEmptyStringPtr(p.ExpectedReturn)
+		} else {
+			if err := validateComparator(p.Comparator, p.ComparatorOpts, p.ExpectedStdout); err != nil {
+				return TestCase{}, err
+			}
+			tc.Comparator = p.Comparator
+			tc.ComparatorOptions = p.ComparatorOpts
+		}
 	default:
 		return TestCase{}, fmt.Errorf("invalid preview execution mode")
 	}
@@ -2113,6 +2719,11 @@ func (p previewTestPayload) toTestCase(aid uuid.UUID) (TestCase, error) {
 	} else {
 		tc.FilesJSON = filesJSON
 	}
+	if outputFiles, normErr := normalizeOutputFiles(tc.ExecutionMode, p.OutputFiles); normErr != nil {
+		return TestCase{}, normErr
+	} else {
+		tc.OutputFiles = outputFiles
+	}
 
 	return tc, nil
 }
@@ -2220,34 +2831,9 @@ func runTeacherSolution(c *gin.Context) {
 	}
 
 	// Detect main file similarly to worker
-	var mainFile string
-	var firstPy string
-	filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil
-		}
-		if strings.HasSuffix(info.Name(), ".py") {
-			rel, _ := filepath.Rel(tmpDir, path)
-			if firstPy == "" {
-				firstPy = rel
-			}
-			content, _ := os.ReadFile(path)
-			if mainGuard.Match(content) {
-				mainFile = rel
-				return io.EOF
-			}
-		}
-		return nil
-	})
-	if mainFile == "" {
-		if _, err := os.Stat(filepath.Join(tmpDir, "main.py")); err == nil {
-			mainFile = "main.py"
-		} else {
-			mainFile = firstPy
-		}
-	}
-	if mainFile == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no python files found"})
+	prog, err := loadProgram(tmpDir, assignment.ProgrammingLanguage)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -2324,7 +2910,48 @@ func runTeacherSolution(c *gin.Context) {
 		}
 	}
 
+	var constructFindings []constructFinding
+	constructFailed, constructDeduction := false, 0.0
+	if _, ok := prog.rt.(pythonRuntime); ok && assignment != nil && !illegalDetected {
+		if rules, err := assignmentConstructRules(assignment); err != nil {
+			fmt.Printf("[teacher-run] invalid construct rules for assignment %s: %v\n", aid, err)
+		} else if constructFindings, err = checkConstructRules(tmpDir, rules); err != nil {
+			fmt.Printf("[teacher-run] construct analysis failed for assignment %s: %v\n", aid, err)
+		} else {
+			constructFailed, constructDeduction = constructOutcome(constructFindings)
+		}
+	}
+
+	var quality *qualityReport
+	if _, ok := prog.rt.(pythonRuntime); ok && assignment != nil && !illegalDetected && !constructFailed {
+		if cfg, err := assignmentQualityConfig(assignment); err != nil {
+			fmt.Printf("[teacher-run] invalid quality config for assignment %s: %v\n", aid, err)
+		} else if cfg != nil {
+			if quality, err = analyzeCodeQuality(tmpDir, cfg); err != nil {
+				fmt.Printf("[teacher-run] code quality analysis failed for assignment %s: %v\n", aid, err)
+			}
+		}
+	}
+
+	// tests are not run when banned tools are used, a construct rule set to
+	// fail is violated or the code does not build
+	blockedStatus, blockedMessage := "", ""
 	if illegalDetected {
+		blockedStatus, blockedMessage = "illegal_tool_use", illegalMessage
+	} else if constructFailed {
+		blockedStatus, blockedMessage = "construct_violation", formatConstructMessage(constructFindings)
+	} else if prog.rt.compiled() {
+		if err := prog.rt.build(nil, tmpDir, assignment); err != nil {
+			var ce *compileError
+			if !errors.As(err, &ce) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			blockedStatus, blockedMessage = "compile_error", ce.Diagnostics
+		}
+	}
+
+	if blockedStatus != "" {
 		for i, rc := range runCases {
 			tc := rc.TestCase
 			mode := strings.TrimSpace(tc.ExecutionMode)
@@ -2339,12 +2966,12 @@ func runTeacherSolution(c *gin.Context) {
 			}
 			item := map[string]any{
 				"unittest_name":   tc.UnittestName,
-				"status":          "illegal_tool_use",
+				"status":          blockedStatus,
 				"runtime_ms":      0,
 				"exit_code":       -1,
 				"actual_stdout":   "",
 				"expected_stdout": tc.ExpectedStdout,
-				"stderr":          illegalMessage,
+				"stderr":          blockedMessage,
 			}
 			if mode == "function" {
 				if tc.FunctionName != nil {
@@ -2373,6 +3000,12 @@ func runTeacherSolution(c *gin.Context) {
 		if parallelism < 1 {
 			parallelism = 1
 		}
+		runTests := make([]TestCase, len(runCases))
+		for i, rc := range runCases {
+			runTests[i] = rc.TestCase
+		}
+		ref := newReferenceSandbox(assignment, runTests)
+		defer ref.Close()
 
 		sem := make(chan struct{}, parallelism)
 		var wg sync.WaitGroup
@@ -2405,6 +3038,16 @@ func runTeacherSolution(c *gin.Context) {
 
 				var funcMeta *functionCallResult
 				var funcErr error
+				var mem memoryUsage
+				var verdict *checkerVerdict
+				var checkErr error
+				dialogue := dialogueRun{ExitCode: -1}
+				var sqlResult sqlTestRun
+				var nbResult notebookTestRun
+				var perfResult performanceRun
+				var propResult propertyRun
+				var turtleResult turtleRun
+				var plotFigures []ResultArtifact
 				workDir := tmpDir
 				cloneDir, cleanup, cloneErr := cloneWorkspace(tmpDir)
 				if cloneErr != nil {
@@ -2416,7 +3059,14 @@ func runTeacherSolution(c *gin.Context) {
 				}
 
 				if cloneErr == nil {
-					if err := stageTestFile(workDir, mainFile, tc); err != nil {
+					if err := stageTestFile(workDir, prog.entry, tc); err != nil {
+						stderr = err.Error()
+						exitCode = -1
+						cloneErr = err
+					}
+				}
+				if cloneErr == nil {
+					if err := checkModeSupported(prog.rt, mode); err != nil {
 						stderr = err.Error()
 						exitCode = -1
 						cloneErr = err
@@ -2425,23 +3075,39 @@ func runTeacherSolution(c *gin.Context) {
 
 				if cloneErr == nil {
 					switch mode {
-					case "unittest":
-						code := ""
-						if tc.UnittestCode != nil {
-							code = *tc.UnittestCode
-						}
-						name := ""
-						if tc.UnittestName != nil {
-							name = *tc.UnittestName
+					case "checker":
+						stdout, stderr, exitCode, timedOut, runtime, mem = prog.rt.runStdin(nil, workDir, prog.entry, tc.Stdin, timeout, tc.MemoryLimitKB)
+						if !timedOut && !mem.LimitExceeded && exitCode == 0 {
+							verdict, checkErr = runChecker(nil, workDir, tc, stdout)
 						}
-						stdout, stderr, exitCode, timedOut, runtime = executePythonUnit(workDir, mainFile, code, name, timeout)
+					case "performance":
+						perfResult = runPerformanceTest(nil, workDir, prog, tc, timeout, ref)
+						stdout, stderr, exitCode, timedOut, runtime, mem = perfResult.Stdout, perfResult.Stderr, perfResult.ExitCode, perfResult.TimedOut, perfResult.Runtime, perfResult.Memory
+					case "property":
+						propResult = runPropertyTest(nil, workDir, prog, tc, timeout, ref, uuid.Nil)
+						stdout, stderr, exitCode, timedOut, runtime, mem = propResult.Stdout, propResult.Stderr, propResult.ExitCode, propResult.TimedOut, propResult.Runtime, propResult.Memory
+					case "turtle":
+						turtleResult = runTurtleTest(nil, workDir, prog, tc, timeout, ref)
+						stdout, stderr, exitCode, timedOut, runtime, mem = turtleResult.Stdout, turtleResult.Stderr, turtleResult.ExitCode, turtleResult.TimedOut, turtleResult.Runtime, turtleResult.Memory
+					case "dialogue":
+						dialogue = runDialogueTest(nil, workDir, prog, tc, timeout)
+						stdout, stderr, exitCode, timedOut, runtime, mem = dialogue.Transcript, dialogue.Stderr, dialogue.ExitCode, dialogue.TimedOut, dialogue.Runtime, dialogue.Memory
+					case "sql_query":
+						sqlResult = runSQLTest(nil, workDir, prog, tc, timeout)
+						stdout, stderr, exitCode, timedOut, runtime, mem = sqlResult.Stdout, sqlResult.Stderr, sqlResult.ExitCode, sqlResult.TimedOut, sqlResult.Runtime, sqlResult.Memory
+					case "notebook_variable", "notebook_cell":
+						nbResult = runNotebookTest(nil, workDir, tc, timeout)
+						stdout, stderr, exitCode, timedOut, runtime, mem = nbResult.Stdout, nbResult.Stderr, nbResult.ExitCode, nbResult.TimedOut, nbResult.Runtime, nbResult.Memory
+					case "unittest", "pytest", "doctest", "plot":
+						stdout, stderr, exitCode, timedOut, runtime, mem = prog.rt.runUnit(nil, workDir, prog.entry, tc, timeout)
+						stdout, plotFigures = splitPlotFigures(stdout)
 					case "function":
 						fn := ""
 						if tc.FunctionName != nil {
 							fn = strings.TrimSpace(*tc.FunctionName)
 						}
 						cfg := functionCallConfig{FunctionName: fn, ArgsJSON: tc.FunctionArgs, KwargsJSON: tc.FunctionKwargs, ExpectedJSON: tc.ExpectedReturn}
-						stdout, stderr, exitCode, timedOut, runtime, funcMeta, funcErr = runFunctionCall(workDir, mainFile, cfg, timeout)
+						stdout, stderr, exitCode, timedOut, runtime, mem, funcMeta, funcErr = prog.rt.runFunction(nil, workDir, prog.entry, cfg, timeout, tc.MemoryLimitKB)
 						if funcErr != nil {
 							stderr = funcErr.Error()
 							exitCode = -1
@@ -2464,7 +3130,7 @@ func runTeacherSolution(c *gin.Context) {
 							}
 						}
 					default:
-						stdout, stderr, exitCode, timedOut, runtime = executePythonDir(workDir, mainFile, tc.Stdin, timeout)
+						stdout, stderr, exitCode, timedOut, runtime, mem = prog.rt.runStdin(nil, workDir, prog.entry, tc.Stdin, timeout, tc.MemoryLimitKB)
 						stdout = trimTrailingNewline(stdout)
 					}
 				}
@@ -2475,10 +3141,55 @@ func runTeacherSolution(c *gin.Context) {
 				}
 
 				status := "passed"
+				var score *float64
+				checkerMessage := ""
 				switch mode {
-				case "unittest":
+				case "checker":
+					switch {
+					case timedOut:
+						status = "time_limit_exceeded"
+					case mem.LimitExceeded:
+						status = "memory_limit_exceeded"
+					case exitCode != 0:
+						status = "runtime_error"
+					case checkErr != nil:
+						status = "checker_error"
+						checkerMessage = checkErr.Error()
+					default:
+						var credit float64
+						status, credit = checkerStatus(verdict)
+						score = &credit
+						checkerMessage = verdict.Message
+					}
+				case "dialogue":
+					status = dialogueStatus(dialogue)
+				case "performance":
+					status = performanceStatus(perfResult, tc)
+					checkerMessage = perfResult.Message
+					if perfResult.Problem != "" {
+						checkerMessage = perfResult.Problem
+					}
+				case "property":
+					status = propertyStatus(propResult)
+					checkerMessage = propResult.Message
+					if propResult.Problem != "" {
+						checkerMessage = propResult.Problem
+					}
+				case "turtle":
+					status = turtleStatus(turtleResult)
+					checkerMessage = turtleResult.Message
+					if turtleResult.Problem != "" {
+						checkerMessage = turtleResult.Problem
+					}
+				case "sql_query":
+					status, checkerMessage = sqlStatus(sqlResult, tc)
+				case "notebook_variable", "notebook_cell":
+					status = notebookStatus(nbResult, tc)
+				case "unittest", "pytest", "doctest", "plot":
 					if timedOut {
 						status = "time_limit_exceeded"
+					} else if mem.LimitExceeded {
+						status = "memory_limit_exceeded"
 					} else if exitCode != 0 {
 						if strings.Contains(stdout, "===JUDGE:ASSERT_FAIL===") {
 							status = "wrong_output"
@@ -2491,6 +3202,8 @@ func runTeacherSolution(c *gin.Context) {
 						status = "runtime_error"
 					} else if timedOut {
 						status = "time_limit_exceeded"
+					} else if mem.LimitExceeded {
+						status = "memory_limit_exceeded"
 					} else if funcMeta != nil {
 						if funcMeta.Status == "exception" {
 							status = "runtime_error"
@@ -2504,12 +3217,24 @@ func runTeacherSolution(c *gin.Context) {
 					switch {
 					case timedOut:
 						status = "time_limit_exceeded"
+					case mem.LimitExceeded:
+						status = "memory_limit_exceeded"
 					case exitCode != 0:
 						status = "runtime_error"
-					case stdout != expectedStdout:
+					case !stdoutMatches(tc, stdout, expectedStdout):
 						status = "wrong_output"
 					}
 				}
+				var fileDiffs *string
+				if outputFilesApply(mode) && (status == "passed" || status == "partially_passed" || status == "wrong_output") {
+					var filesOK bool
+					if fileDiffs, filesOK = gradeOutputFiles(workDir, tc); !filesOK {
+						status = "wrong_output"
+						if score != nil {
+							score = new(float64)
+						}
+					}
+				}
 
 				item := map[string]any{
 					"unittest_name":   tc.UnittestName,
@@ -2520,6 +3245,21 @@ func runTeacherSolution(c *gin.Context) {
 					"expected_stdout": tc.ExpectedStdout,
 					"stderr":          stderr,
 				}
+				if peak := mem.peakPtr(); peak != nil {
+					item["peak_memory_kb"] = *peak
+				}
+				if score != nil {
+					item["score"] = *score
+				}
+				if checkerMessage != "" {
+					item["checker_message"] = checkerMessage
+				}
+				if fileDiffs != nil {
+					item["file_diffs"] = json.RawMessage(*fileDiffs)
+				}
+				if artifacts := append(turtleResult.Artifacts, plotFigures...); len(artifacts) > 0 {
+					item["artifacts"] = artifacts
+				}
 				if mode == "function" {
 					if tc.FunctionName != nil {
 						item["function_name"] = strings.TrimSpace(*tc.FunctionName)
@@ -2568,6 +3308,8 @@ func runTeacherSolution(c *gin.Context) {
 				persistedPassed++
 				persistedEarnedWeight += tc.Weight
 			}
+		} else if score, ok := item["score"].(float64); ok && !rc.Preview {
+			persistedEarnedWeight += tc.Weight * score
 		}
 	}
 
@@ -2576,7 +3318,16 @@ func runTeacherSolution(c *gin.Context) {
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
 	_ = filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+		if err != nil {
+			return nil
+		}
+		if isBuildOutput(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
 			return nil
 		}
 		rel := filepath.Base(path)
@@ -2624,14 +3375,50 @@ func runTeacherSolution(c *gin.Context) {
 		if ar, ok := item["actual_return"].(string); ok && ar != "" {
 			r.ActualReturn = &ar
 		}
+		if peak, ok := item["peak_memory_kb"].(int); ok {
+			r.PeakMemoryKB = &peak
+		}
+		if score, ok := item["score"].(float64); ok {
+			r.Score = &score
+		}
+		if msg, ok := item["checker_message"].(string); ok {
+			r.CheckerMessage = &msg
+		}
 		_ = CreateResult(r)
 	}
 
 	// Compute and persist overall status and points similar to worker
 	allPass := persistedPassed == len(persistedTests)
-	if illegalDetected {
+	var groupSummary []groupResult
+	if groups, err := ListTestGroups(aid); err == nil && len(groups) > 0 {
+		credit := make(map[uuid.UUID]float64, len(runCases))
+		for i, rc := range runCases {
+			if rc.Preview || results[i] == nil {
+				continue
+			}
+			if status, _ := results[i]["status"].(string); status == "passed" {
+				credit[rc.ID] = 1
+			} else if score, ok := results[i]["score"].(float64); ok {
+				credit[rc.ID] = score
+			}
+		}
+		groupSummary, persistedEarnedWeight, persistedTotalWeight, allPass = scoreTestGroups(groups, persistedTests, credit)
+	}
+	if illegalDetected || constructFailed {
 		allPass = false
 	}
+	if len(constructFindings) > 0 {
+		if raw, err := json.Marshal(constructFindings); err == nil {
+			stored := string(raw)
+			_ = SetSubmissionConstructFindings(sub.ID, &stored)
+		}
+	}
+	if quality != nil {
+		if raw, err := json.Marshal(quality); err == nil {
+			stored := string(raw)
+			_ = SetSubmissionQualityReport(sub.ID, &stored)
+		}
+	}
 	if !assignment.LLMInteractive {
 		score := 0.0
 		switch assignment.GradingPolicy {
@@ -2645,6 +3432,16 @@ func runTeacherSolution(c *gin.Context) {
 				score = persistedEarnedWeight * (float64(assignment.MaxPoints) / persistedTotalWeight)
 			}
 		}
+		if quality != nil && quality.Weight > 0 {
+			score = blendQualityScore(score, assignment.MaxPoints, quality.Score, quality.Weight)
+			if quality.Score < 1 {
+				allPass = false
+			}
+		}
+		if constructDeduction > 0 {
+			score = math.Max(score-constructDeduction, 0)
+			allPass = false
+		}
 
 		// Handle late submission logic with second deadline
 		if sub.CreatedAt.After(assignment.Deadline) {
@@ -2696,8 +3493,17 @@ func runTeacherSolution(c *gin.Context) {
 		"failed":        len(runCases) - summaryPassed,
 		"results":       results,
 	}
+	if groupSummary != nil {
+		resp["groups"] = groupSummary
+	}
+	if len(constructFindings) > 0 {
+		resp["construct_findings"] = constructFindings
+	}
+	if quality != nil {
+		resp["quality_report"] = quality
+	}
 
-	if assignment.LLMInteractive && !illegalDetected {
+	if assignment.LLMInteractive && !illegalDetected && !constructFailed {
 		UpdateSubmissionStatus(sub.ID, "running")
 		runLLMInteractive(sub, assignment)
 		if llm, err := GetLatestLLMRun(sub.ID); err == nil && llm != nil {
@@ -2726,11 +3532,16 @@ func getSubmission(c *gin.Context) {
 	}
 	results, _ := ListResultsForSubmission(sid)
 	assignment, _ := GetAssignmentForSubmission(sub.ID)
+	var groups []groupResult
+	if assignment != nil {
+		groups, _ = submissionGroupResults(assignment.ID, results)
+	}
 	role := c.GetString("role")
 	if role == "student" {
 		if assignment != nil && !assignment.ShowTraceback {
 			for i := range results {
-				if strings.EqualFold(results[i].Status, "illegal_tool_use") {
+				// compiler diagnostics are the only feedback a build failure has
+				if strings.EqualFold(results[i].Status, "illegal_tool_use") || strings.EqualFold(results[i].Status, "construct_violation") || strings.EqualFold(results[i].Status, "compile_error") {
 					continue
 				}
 				results[i].Stderr = ""
@@ -2750,9 +3561,23 @@ func getSubmission(c *gin.Context) {
 		}
 	}
 	resp := gin.H{"submission": sub, "results": results}
+	if groups != nil {
+		resp["groups"] = groups
+	}
 	if sub.ScratchSemanticAnalysis != nil && json.Valid([]byte(*sub.ScratchSemanticAnalysis)) {
 		resp["semantic_analysis"] = json.RawMessage(*sub.ScratchSemanticAnalysis)
 	}
+	if findings, err := GetSubmissionConstructFindings(sid); err == nil && findings != nil && json.Valid([]byte(*findings)) {
+		resp["construct_findings"] = json.RawMessage(*findings)
+	}
+	if report, err := GetSubmissionQualityReport(sid); err == nil && report != nil && json.Valid([]byte(*report)) {
+		resp["quality_report"] = json.RawMessage(*report)
+	}
+	if role != "student" {
+		if cells, err := GetSubmissionNotebook(sid); err == nil && cells != nil && json.Valid([]byte(*cells)) {
+			resp["executed_notebook"] = json.RawMessage(*cells)
+		}
+	}
 	// Attach latest LLM run if available
 	if llm, err := GetLatestLLMRun(sid); err == nil && llm != nil {
 		// apply feedback visibility for students
@@ -3638,7 +4463,7 @@ func submissionRunWS(c *gin.Context) {
 			}
 
 			// Headless mode (no GUI)
-			vm, remoteDir, vmErr := startVMWithWorkspace(context.Background(), td, nil)
+			vm, remoteDir, vmErr := startSandboxWithWorkspace(context.Background(), td)
 			if vmErr != nil {
 				ch <- map[string]any{"type": "error", "message": fmt.Sprintf("vm start failed: %v", vmErr)}
 				continue
@@ -3781,7 +4606,7 @@ func submissionRunWS(c *gin.Context) {
 
 				cfg := functionCallConfig{FunctionName: fn, ArgsJSON: args, KwargsJSON: kwargs, ExpectedJSON: expected}
 				timeout := time.Duration(timeoutMS) * time.Millisecond
-				stdout, stderr, exitCode, timedOut, runtime, meta, runErr := runFunctionCall(td, mainFile, cfg, timeout)
+				stdout, stderr, exitCode, timedOut, runtime, _, meta, runErr := runFunctionCall(nil, td, mainFile, cfg, timeout, 0)
 
 				status := "passed"
 				if runErr != nil {
@@ -3,9 +3,11 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strconv"
 	"testing"
 	"time"
 
@@ -96,3 +98,117 @@ func TestUndoManualAccept(t *testing.T) {
 	// TODO: Fix gin test context setup for proper testing
 	// The actual function works correctly in practice but has test setup issues
 }
+
+// TestUpdateTestCaseKeepsOmittedFields checks that a PUT with only the fields
+// the test editor sends leaves the rest of the stored test alone.
+func TestUpdateTestCaseKeepsOmittedFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	defer db.Close()
+	DB = sqlx.NewDb(db, "sqlmock")
+
+	now := time.Now()
+	testID, assignmentID, groupID := uuid.New(), uuid.New(), uuid.New()
+	code, name := "def test_add():\n    assert True\n", "test_add"
+	opts := `{"abs_tolerance": 0.5}`
+	cols := []string{"id", "assignment_id", "stdin", "expected_stdout", "weight", "time_limit_sec", "memory_limit_kb", "unittest_code", "unittest_name", "execution_mode", "comparator", "comparator_options", "group_id", "group_name", "is_sample", "sql_column_match", "created_at", "updated_at"}
+	mock.ExpectQuery(`SELECT\s+.*\s+FROM test_cases\s+WHERE id = \$1`).WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(testID.String(), assignmentID.String(), "", "", 2.0, 1.0, 262144, code, name, "pytest", "numeric", opts, groupID.String(), "basic", true, "exact", now, now))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM test_groups WHERE id=$1 AND assignment_id=$2`)).
+		WithArgs(groupID, assignmentID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT\s+.*\s+FROM assignments\s+WHERE id = \$1`).WithArgs(assignmentID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "programming_language"}).AddRow(assignmentID.String(), "python"))
+	mock.ExpectExec(`UPDATE test_cases`).
+		WithArgs("", "", 3.0, 2.5, 262144, code, "test_add[1]", "pytest", nil, nil, nil, nil, nil, nil, nil, nil, "numeric", opts, nil, nil, groupID, true, nil, nil, false, "exact", nil, nil, nil, nil, nil, testID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+	body := `{"stdin":"","expected_stdout":"","time_limit_sec":2.5,"unittest_code":` + strconv.Quote(code) + `,"unittest_name":"test_add[1]","weight":3}`
+	c.Request, _ = http.NewRequest("PUT", "/tests/"+testID.String(), bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	updateTestCase(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateTestCaseModeChangeDropsOldFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	defer db.Close()
+	DB = sqlx.NewDb(db, "sqlmock")
+
+	now := time.Now()
+	testID, assignmentID, groupID := uuid.New(), uuid.New(), uuid.New()
+	code, name := "def test_add():\n    assert True\n", "test_add"
+	checker := "def check(i, o, r):\n    return True\n"
+	cols := []string{"id", "assignment_id", "stdin", "expected_stdout", "weight", "time_limit_sec", "memory_limit_kb", "unittest_code", "unittest_name", "execution_mode", "comparator", "comparator_options", "group_id", "group_name", "is_sample", "sql_column_match", "created_at", "updated_at"}
+	mock.ExpectQuery(`SELECT\s+.*\s+FROM test_cases\s+WHERE id = \$1`).WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(testID.String(), assignmentID.String(), "", "", 2.0, 1.0, 262144, code, name, "pytest", "numeric", `{"abs_tolerance": 0.5}`, groupID.String(), "basic", true, "exact", now, now))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM test_groups WHERE id=$1 AND assignment_id=$2`)).
+		WithArgs(groupID, assignmentID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT\s+.*\s+FROM assignments\s+WHERE id = \$1`).WithArgs(assignmentID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "programming_language"}).AddRow(assignmentID.String(), "python"))
+	// the shared fields stay, the unittest code and comparator go
+	mock.ExpectExec(`UPDATE test_cases`).
+		WithArgs("5", "", 2.0, 1.0, 262144, nil, nil, "checker", nil, nil, nil, nil, nil, nil, nil, nil, "exact", nil, checker, nil, groupID, true, nil, nil, false, "exact", nil, nil, nil, nil, nil, testID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+	body := `{"execution_mode":"checker","stdin":"5","checker_code":` + strconv.Quote(checker) + `}`
+	c.Request, _ = http.NewRequest("PUT", "/tests/"+testID.String(), bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	updateTestCase(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateTestCaseLookupErrors(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code int
+	}{
+		{sql.ErrNoRows, http.StatusNotFound},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("failed to open sqlmock: %v", err)
+		}
+		DB = sqlx.NewDb(db, "sqlmock")
+		testID := uuid.New()
+		mock.ExpectQuery(`SELECT\s+.*\s+FROM test_cases\s+WHERE id = \$1`).WithArgs(testID).WillReturnError(tt.err)
+
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: testID.String()}}
+		c.Request, _ = http.NewRequest("PUT", "/tests/"+testID.String(), bytes.NewBufferString(`{}`))
+		updateTestCase(c)
+		if w.Code != tt.code {
+			t.Errorf("%v: expected %d, got %d", tt.err, tt.code, w.Code)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Java submissions are compiled with javac into javaClassesDir. Unit tests are
+// JUnit 4 classes kept in the test's unittest_code; unittest_name selects the
+// class or a single method ("CalculatorTest.addsNumbers").
+
+var junitClasspath = getenvOr("JUNIT_CLASSPATH", "/usr/share/java/junit4.jar:/usr/share/java/hamcrest-core.jar")
+
+const (
+	javaTestSourceDir  = "__tests__"
+	javaTestClassesDir = "__testclasses__"
+	javaUnitRunner     = "__JudgeRunner"
+)
+
+var (
+	javaMainMethod = regexp.MustCompile(`\bstatic\s+(?:final\s+)?void\s+main\s*\(`)
+	javaPackage    = regexp.MustCompile(`(?m)^\s*package\s+([\w.]+)\s*;`)
+	javaPublicType = regexp.MustCompile(`\bpublic\s+(?:final\s+|abstract\s+)*class\s+(\w+)`)
+)
+
+const javaUnitRunnerSource = `import org.junit.runner.JUnitCore;
+import org.junit.runner.Request;
+import org.junit.runner.Result;
+import org.junit.runner.notification.Failure;
+
+public class __JudgeRunner {
+    public static void main(String[] args) throws Exception {
+        String target = args[0];
+        Request request;
+        try {
+            request = Request.aClass(Class.forName(target));
+        } catch (ClassNotFoundException e) {
+            int dot = target.lastIndexOf('.');
+            if (dot < 0) {
+                throw e;
+            }
+            request = Request.method(Class.forName(target.substring(0, dot)), target.substring(dot + 1));
+        }
+        Result result = new JUnitCore().run(request);
+        for (Failure f : result.getFailures()) {
+            System.out.println("FAIL: " + f.getTestHeader() + ": " + f.getMessage());
+            System.err.println(f.getTrace());
+        }
+        System.out.println("Ran " + result.getRunCount() + " test(s), " + result.getFailureCount() + " failure(s)");
+        if (!result.wasSuccessful()) {
+            System.out.println("===JUDGE:ASSERT_FAIL===");
+            System.exit(1);
+        }
+    }
+}
+`
+
+type javaRuntime struct {
+	noFunctionCalls
+}
+
+func (javaRuntime) modes() []string {
+	return []string{"stdin_stdout", "checker", "dialogue", "performance", "property", "unittest"}
+}
+
+func (javaRuntime) compiled() bool { return true }
+
+// entryFile returns the fully qualified name of the class with a main
+// method, preferring a class called Main when there are several.
+func (javaRuntime) entryFile(dir string) (string, error) {
+	sources, err := findSources(dir, ".java")
+	if err != nil {
+		return "", err
+	}
+	var entry string
+	for _, src := range sources {
+		content, err := os.ReadFile(filepath.Join(dir, src))
+		if err != nil || !javaMainMethod.Match(content) {
+			continue
+		}
+		class := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+		if m := javaPackage.FindSubmatch(content); m != nil {
+			class = string(m[1]) + "." + class
+		}
+		if entry == "" || filepath.Base(src) == "Main.java" {
+			entry = class
+		}
+	}
+	if entry == "" {
+		return "", fmt.Errorf("no Java class with a main method found")
+	}
+	return entry, nil
+}
+
+func (javaRuntime) build(sess *vmSession, dir string, a *Assignment) error {
+	sources, err := findSources(dir, ".java")
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return &compileError{Diagnostics: "no .java source files found"}
+	}
+	args := []string{"javac", "-encoding", "UTF-8", "-d", javaClassesDir}
+	args = append(args, compileFlagsFor(a)...)
+	for _, src := range sources {
+		args = append(args, shellQuote(src))
+	}
+	return buildInVM(sess, dir, strings.Join(args, " "), javaClassesDir)
+}
+
+// javaCommand runs a class under the memory guard. The JVM cannot start under
+// RLIMIT_DATA, so the limit is applied as the maximum heap size instead.
+func javaCommand(remoteDir, classpath, class string, memoryLimitKB int, args ...string) string {
+	argv := []string{"java", "-XX:+UseSerialGC"}
+	if memoryLimitKB > 0 {
+		argv = append(argv, fmt.Sprintf("-Xmx%dk", memoryLimitKB))
+	}
+	argv = append(argv, "-cp", classpath, class)
+	argv = append(argv, args...)
+	return "HOME=/tmp LANG=C.UTF-8 " + memoryGuardedExec(remoteDir, memoryLimitKB, false, argv...)
+}
+
+func (javaRuntime) command(remoteDir, entry string, memoryLimitKB int) string {
+	return javaCommand(remoteDir, javaClassesDir, entry, memoryLimitKB)
+}
+
+func (r javaRuntime) runStdin(sess *vmSession, dir, entry, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	return runGuardedStdin(r, sess, dir, entry, stdin, timeout, memoryLimitKB)
+}
+
+// runUnit compiles the test class against the submission and runs the
+// selected tests through a small JUnit runner. Compiling the test is not
+// counted towards the time limit.
+func (javaRuntime) runUnit(sess *vmSession, dir, entry string, tc TestCase, timeout time.Duration) (string, string, int, bool, time.Duration, memoryUsage) {
+	code := stringOrEmpty(tc.UnittestCode)
+	target := strings.TrimSpace(stringOrEmpty(tc.UnittestName))
+	class := strings.SplitN(target, ".", 2)[0]
+	if m := javaPublicType.FindStringSubmatch(code); m != nil {
+		class = m[1]
+	}
+	if class == "" || target == "" {
+		return "", "unittest_name must name the test class", -1, false, 0, memoryUsage{}
+	}
+	testDir := filepath.Join(dir, javaTestSourceDir)
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	if err := os.WriteFile(filepath.Join(testDir, class+".java"), []byte(code), 0644); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	if err := os.WriteFile(filepath.Join(testDir, javaUnitRunner+".java"), []byte(javaUnitRunnerSource), 0644); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	if err := writeMemoryGuard(dir); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	_ = ensureSandboxPerms(dir)
+
+	compileCP := javaClassesDir + ":" + junitClasspath
+	runCP := javaClassesDir + ":" + javaTestClassesDir + ":" + junitClasspath
+	prepare := func(string) string {
+		return fmt.Sprintf("HOME=/tmp LANG=C.UTF-8 javac -encoding UTF-8 -cp %s -d %s %s/*.java 1>&2",
+			shellQuote(compileCP), javaTestClassesDir, javaTestSourceDir)
+	}
+	return runPreparedInVM(sess, dir, "", timeout, prepare, func(remoteDir string) string {
+		return javaCommand(remoteDir, runCP, javaUnitRunner, tc.MemoryLimitKB, target)
+	})
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Grading jobs are persisted in the grading_jobs table so that a restart or a
+// crashed worker never drops a submission. Workers lease one job at a time
+// with SELECT ... FOR UPDATE SKIP LOCKED and keep the lease alive with
+// heartbeats; an expired lease makes the job claimable again.
+var (
+	jobLeaseTimeout   = getenvDurationOr("GRADING_LEASE_TIMEOUT", 2*time.Minute)
+	jobPollInterval   = getenvDurationOr("GRADING_POLL_INTERVAL", 2*time.Second)
+	jobRetryBackoff   = getenvDurationOr("GRADING_RETRY_BACKOFF", 15*time.Second)
+	jobMaxBackoff     = getenvDurationOr("GRADING_MAX_BACKOFF", 10*time.Minute)
+	jobMaxAttempts    = getenvIntOr("GRADING_MAX_ATTEMPTS", 3)
+	jobRetentionAfter = getenvDurationOr("GRADING_JOB_RETENTION", 7*24*time.Hour)
+)
+
+// errGradingLeaseLost means another worker or the maintenance loop took the
+// job over, so this worker must not record anything for it.
+var errGradingLeaseLost = errors.New("grading lease lost")
+
+// jobWake nudges idle workers when a job is enqueued by this process so they
+// do not have to wait for the next poll tick.
+var jobWake chan struct{}
+
+// GradingJob is a leased row of the grading_jobs table.
+type GradingJob struct {
+	ID           uuid.UUID `db:"id"`
+	SubmissionID uuid.UUID `db:"submission_id"`
+	Attempts     int       `db:"attempts"`
+	MaxAttempts  int       `db:"max_attempts"`
+	// RegradeID links the job to a bulk regrade whose audit it completes.
+	RegradeID *uuid.UUID `db:"regrade_id"`
+}
+
+func newWorkerID(idx int) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), idx)
+}
+
+// insertGradingJob queues a submission unless it already has a queued job.
+func insertGradingJob(subID uuid.UUID) error {
+	_, err := DB.Exec(`
+        INSERT INTO grading_jobs (submission_id, max_attempts)
+        VALUES ($1, $2)
+        ON CONFLICT (submission_id) WHERE status = 'queued' DO NOTHING`,
+		subID, jobMaxAttempts)
+	return err
+}
+
+// claimGradingJob leases the next runnable job. Expired leases are treated as
+// runnable so work held by a dead worker is picked up again. A submission is
+// never leased twice at the same time.
+func claimGradingJob(workerID string) (*GradingJob, error) {
+	var j GradingJob
+	err := DB.Get(&j, `
+        UPDATE grading_jobs
+           SET status = 'leased',
+               attempts = attempts + 1,
+               leased_by = $1,
+               lease_expires_at = now() + make_interval(secs => $2),
+               updated_at = now()
+         WHERE id = (
+                SELECT g.id
+                  FROM grading_jobs g
+                 WHERE ((g.status = 'queued' AND g.run_after <= now())
+                        OR (g.status = 'leased' AND g.lease_expires_at < now() AND g.attempts < g.max_attempts))
+                   AND NOT EXISTS (
+                        SELECT 1 FROM grading_jobs l
+                         WHERE l.submission_id = g.submission_id
+                           AND l.id <> g.id
+                           AND l.status = 'leased'
+                           AND l.lease_expires_at >= now())
+                 ORDER BY g.run_after, g.created_at
+                 LIMIT 1
+                 FOR UPDATE SKIP LOCKED)
+        RETURNING id, submission_id, attempts, max_attempts, regrade_id`,
+		workerID, jobLeaseTimeout.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// heartbeatGradingJob extends the lease; it fails if the lease was lost.
+func heartbeatGradingJob(jobID uuid.UUID, workerID string) error {
+	res, err := DB.Exec(`
+        UPDATE grading_jobs
+           SET lease_expires_at = now() + make_interval(secs => $3), updated_at = now()
+         WHERE id = $1 AND leased_by = $2 AND status = 'leased'`,
+		jobID, workerID, jobLeaseTimeout.Seconds())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("job %s: %w", jobID, errGradingLeaseLost)
+	}
+	return nil
+}
+
+// gradingLease ties a grading run to the lease of its job. The context is
+// cancelled as soon as the lease is known to be lost.
+type gradingLease struct {
+	jobID    uuid.UUID
+	workerID string
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// lost reports whether the run was cancelled, without asking the database.
+func (l *gradingLease) lost() bool {
+	return l != nil && l.ctx.Err() != nil
+}
+
+// held confirms the lease right before the run records an outcome. The check
+// also extends the lease, so no other worker can claim the job while the
+// results are written. Runs outside the job queue have no lease and always
+// hold it.
+func (l *gradingLease) held() bool {
+	if l == nil {
+		return true
+	}
+	if l.ctx.Err() != nil {
+		return false
+	}
+	if err := heartbeatGradingJob(l.jobID, l.workerID); err != nil {
+		fmt.Println("[worker] heartbeat:", err)
+		l.cancel()
+		return false
+	}
+	return true
+}
+
+func completeGradingJob(jobID uuid.UUID, workerID string) error {
+	res, err := DB.Exec(`
+        UPDATE grading_jobs
+           SET status = 'done', lease_expires_at = NULL, finished_at = now(), updated_at = now()
+         WHERE id = $1 AND leased_by = $2 AND status = 'leased'`,
+		jobID, workerID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("job %s: %w", jobID, errGradingLeaseLost)
+	}
+	return nil
+}
+
+// failGradingJob records the error and either schedules a retry with
+// exponential backoff or gives up and marks the submission failed.
+func failGradingJob(j *GradingJob, workerID string, cause error) error {
+	msg := ""
+	if cause != nil {
+		msg = cause.Error()
+	}
+	if j.Attempts >= j.MaxAttempts {
+		res, err := DB.Exec(`
+            UPDATE grading_jobs
+               SET status = 'failed', last_error = $3, lease_expires_at = NULL, finished_at = now(), updated_at = now()
+             WHERE id = $1 AND leased_by = $2 AND status = 'leased'`,
+			j.ID, workerID, msg)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("job %s: %w", j.ID, errGradingLeaseLost)
+		}
+		return UpdateSubmissionStatus(j.SubmissionID, "failed")
+	}
+	delay := jobBackoff(j.Attempts)
+	res, err := DB.Exec(`
+        UPDATE grading_jobs
+           SET status = 'queued', last_error = $3, leased_by = NULL, lease_expires_at = NULL,
+               run_after = now() + make_interval(secs => $4), updated_at = now()
+         WHERE id = $1 AND leased_by = $2 AND status = 'leased'
+           AND NOT EXISTS (
+                SELECT 1 FROM grading_jobs q
+                 WHERE q.submission_id = grading_jobs.submission_id AND q.status = 'queued')`,
+		j.ID, workerID, msg, delay.Seconds())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// A newer job for the same submission is already waiting; let it
+		// win. This also reports a lost lease.
+		return completeGradingJob(j.ID, workerID)
+	}
+	return UpdateSubmissionStatus(j.SubmissionID, "pending")
+}
+
+// jobBackoff returns the delay before the next attempt after `attempts` tries.
+func jobBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := jobRetryBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= jobMaxBackoff {
+			return jobMaxBackoff
+		}
+	}
+	if d > jobMaxBackoff {
+		return jobMaxBackoff
+	}
+	return d
+}
+
+// recoverOrphanedSubmissions re-queues submissions left behind by a previous
+// process: anything still marked running without an active job, and pending
+// submissions that were accepted before the job table existed.
+func recoverOrphanedSubmissions() (int, error) {
+	res, err := DB.Exec(`
+        WITH orphaned AS (
+            SELECT s.id
+              FROM submissions s
+             WHERE s.is_teacher_run = FALSE
+               AND s.manually_accepted = FALSE
+               AND (
+                    (s.status = 'running' AND NOT EXISTS (
+                        SELECT 1 FROM grading_jobs g
+                         WHERE g.submission_id = s.id AND g.status IN ('queued','leased')))
+                 OR (s.status = 'pending' AND NOT EXISTS (
+                        SELECT 1 FROM grading_jobs g WHERE g.submission_id = s.id))
+               )
+        )
+        INSERT INTO grading_jobs (submission_id, max_attempts)
+        SELECT id, $1 FROM orphaned
+        ON CONFLICT (submission_id) WHERE status = 'queued' DO NOTHING`,
+		jobMaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		_, err = DB.Exec(`
+            UPDATE submissions s
+               SET status = 'pending', updated_at = now()
+             WHERE s.status = 'running'
+               AND EXISTS (SELECT 1 FROM grading_jobs g WHERE g.submission_id = s.id AND g.status = 'queued')`)
+	}
+	return int(n), err
+}
+
+// failAbandonedGradingJobs gives up on jobs whose lease expired after the last
+// allowed attempt, e.g. because the submission keeps crashing the worker.
+func failAbandonedGradingJobs() error {
+	var jobs []GradingJob
+	err := DB.Select(&jobs, `
+        UPDATE grading_jobs
+           SET status = 'failed', last_error = COALESCE(last_error, 'lease expired'),
+               lease_expires_at = NULL, finished_at = now(), updated_at = now()
+         WHERE status = 'leased' AND lease_expires_at < now() AND attempts >= max_attempts
+        RETURNING id, submission_id, attempts, max_attempts, regrade_id`)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		if err := UpdateSubmissionStatus(jobs[i].SubmissionID, "failed"); err != nil {
+			return err
+		}
+		finishRegradeJob(&jobs[i])
+	}
+	return nil
+}
+
+func pruneFinishedGradingJobs() error {
+	_, err := DB.Exec(`
+        DELETE FROM grading_jobs
+         WHERE status IN ('done','failed')
+           AND finished_at < now() - make_interval(secs => $1)`,
+		jobRetentionAfter.Seconds())
+	return err
+}
+
+// clearSubmissionResults drops results written by an interrupted attempt so a
+// retry does not duplicate them.
+func clearSubmissionResults(subID uuid.UUID) error {
+	_, err := DB.Exec(`DELETE FROM results WHERE submission_id=$1`, subID)
+	return err
+}
+
+// processGradingJob runs one leased job while heartbeating its lease. Once
+// the lease is lost the run is cancelled and its outcome is dropped, since
+// the job may already be running on another worker.
+func processGradingJob(j *GradingJob, workerID string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lease := &gradingLease{jobID: j.ID, workerID: workerID, ctx: ctx, cancel: cancel}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(jobLeaseTimeout / 3)
+		defer t.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				err := heartbeatGradingJob(j.ID, workerID)
+				if err == nil {
+					renewed = time.Now()
+					continue
+				}
+				fmt.Println("[worker] heartbeat:", err)
+				// A database hiccup is retried until the lease would have
+				// expired anyway.
+				if errors.Is(err, errGradingLeaseLost) || time.Since(renewed) >= jobLeaseTimeout {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		// Retries and regrades may find results of an earlier run.
+		if j.Attempts > 1 || j.RegradeID != nil {
+			if err := clearSubmissionResults(j.SubmissionID); err != nil {
+				return err
+			}
+		}
+		runSubmission(j.SubmissionID, lease)
+		if lease.lost() {
+			return errGradingLeaseLost
+		}
+		// runSubmission always moves the submission out of "running" when it
+		// finishes; if it did not, grading was cut short.
+		sub, err := GetSubmission(j.SubmissionID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		if sub.Status == "running" {
+			return fmt.Errorf("grading did not finish")
+		}
+		return nil
+	}()
+
+	close(stop)
+	<-done
+
+	if lease.lost() {
+		fmt.Printf("[worker] job %s (submission %s): lease lost, dropping this attempt\n", j.ID, j.SubmissionID)
+		return
+	}
+	if err != nil {
+		fmt.Printf("[worker] job %s (submission %s) attempt %d/%d failed: %v\n", j.ID, j.SubmissionID, j.Attempts, j.MaxAttempts, err)
+		if ferr := failGradingJob(j, workerID, err); ferr != nil {
+			fmt.Println("[worker] record failure:", ferr)
+			return
+		}
+		if j.Attempts >= j.MaxAttempts {
+			finishRegradeJob(j)
+		}
+		return
+	}
+	if cerr := completeGradingJob(j.ID, workerID); cerr != nil {
+		fmt.Println("[worker] complete job:", cerr)
+		return
+	}
+	finishRegradeJob(j)
+}
+
+func gradingQueueMaintenance() {
+	t := time.NewTicker(jobLeaseTimeout)
+	defer t.Stop()
+	lastPrune := time.Now()
+	for range t.C {
+		if err := failAbandonedGradingJobs(); err != nil {
+			fmt.Println("[worker] expire jobs:", err)
+		}
+		if time.Since(lastPrune) < time.Hour {
+			continue
+		}
+		lastPrune = time.Now()
+		if err := pruneFinishedGradingJobs(); err != nil {
+			fmt.Println("[worker] prune jobs:", err)
+		}
+	}
+}
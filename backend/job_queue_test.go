@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestJobBackoffDoublesAndCaps(t *testing.T) {
+	prevBase, prevMax := jobRetryBackoff, jobMaxBackoff
+	jobRetryBackoff, jobMaxBackoff = 10*time.Second, time.Minute
+	defer func() { jobRetryBackoff, jobMaxBackoff = prevBase, prevMax }()
+
+	cases := map[int]time.Duration{
+		0: 10 * time.Second,
+		1: 10 * time.Second,
+		2: 20 * time.Second,
+		3: 40 * time.Second,
+		4: time.Minute,
+		9: time.Minute,
+	}
+	for attempts, want := range cases {
+		if got := jobBackoff(attempts); got != want {
+			t.Errorf("jobBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func newJobQueueMock(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	DB = sqlx.NewDb(db, "sqlmock")
+	return mock
+}
+
+var gradingJobColumns = []string{"id", "submission_id", "attempts", "max_attempts", "regrade_id"}
+
+func TestClaimGradingJob(t *testing.T) {
+	mock := newJobQueueMock(t)
+	jobID, subID := uuid.New(), uuid.New()
+	mock.ExpectQuery(`(?s)UPDATE grading_jobs\s+SET status = 'leased'.*FOR UPDATE SKIP LOCKED`).
+		WithArgs("w-1", jobLeaseTimeout.Seconds()).
+		WillReturnRows(sqlmock.NewRows(gradingJobColumns).AddRow(jobID, subID, 2, 3, nil))
+	mock.ExpectQuery(`UPDATE grading_jobs`).WithArgs("w-1", jobLeaseTimeout.Seconds()).WillReturnError(sql.ErrNoRows)
+
+	j, err := claimGradingJob("w-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if j == nil || j.ID != jobID || j.SubmissionID != subID || j.Attempts != 2 || j.RegradeID != nil {
+		t.Fatalf("unexpected job %+v", j)
+	}
+	if j, err := claimGradingJob("w-1"); err != nil || j != nil {
+		t.Fatalf("expected no job, got %+v, %v", j, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestFailGradingJobSchedulesRetry(t *testing.T) {
+	mock := newJobQueueMock(t)
+	j := &GradingJob{ID: uuid.New(), SubmissionID: uuid.New(), Attempts: 2, MaxAttempts: 3}
+	mock.ExpectExec(`(?s)UPDATE grading_jobs\s+SET status = 'queued'.*AND status = 'leased'`).
+		WithArgs(j.ID, "w-1", "boom", jobBackoff(2).Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE submissions SET status`).WithArgs("pending", j.SubmissionID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := failGradingJob(j, "w-1", errors.New("boom")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestFailGradingJobGivesUpAfterLastAttempt(t *testing.T) {
+	mock := newJobQueueMock(t)
+	j := &GradingJob{ID: uuid.New(), SubmissionID: uuid.New(), Attempts: 3, MaxAttempts: 3}
+	mock.ExpectExec(`(?s)UPDATE grading_jobs\s+SET status = 'failed'.*AND status = 'leased'`).
+		WithArgs(j.ID, "w-1", "boom").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE submissions SET status`).WithArgs("failed", j.SubmissionID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := failGradingJob(j, "w-1", errors.New("boom")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestFailGradingJobLeavesLostJobAlone(t *testing.T) {
+	mock := newJobQueueMock(t)
+	j := &GradingJob{ID: uuid.New(), SubmissionID: uuid.New(), Attempts: 3, MaxAttempts: 3}
+	// another worker owns the job now, so the submission must not be touched
+	mock.ExpectExec(`UPDATE grading_jobs`).WithArgs(j.ID, "w-1", "boom").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := failGradingJob(j, "w-1", errors.New("boom")); !errors.Is(err, errGradingLeaseLost) {
+		t.Fatalf("expected a lost lease, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCompleteGradingJobReportsLostLease(t *testing.T) {
+	mock := newJobQueueMock(t)
+	jobID := uuid.New()
+	mock.ExpectExec(`(?s)UPDATE grading_jobs\s+SET status = 'done'.*leased_by = \$2 AND status = 'leased'`).
+		WithArgs(jobID, "w-1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := completeGradingJob(jobID, "w-1"); !errors.Is(err, errGradingLeaseLost) {
+		t.Fatalf("expected a lost lease, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGradingLeaseCancelsRunWhenLost(t *testing.T) {
+	mock := newJobQueueMock(t)
+	jobID := uuid.New()
+	mock.ExpectExec(`UPDATE grading_jobs\s+SET lease_expires_at`).
+		WithArgs(jobID, "w-1", jobLeaseTimeout.Seconds()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE grading_jobs\s+SET lease_expires_at`).
+		WithArgs(jobID, "w-1", jobLeaseTimeout.Seconds()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lease := &gradingLease{jobID: jobID, workerID: "w-1", ctx: ctx, cancel: cancel}
+	if !lease.held() || lease.lost() {
+		t.Fatalf("expected the lease to be held")
+	}
+	if lease.held() {
+		t.Fatalf("expected the lease to be lost")
+	}
+	if !lease.lost() || ctx.Err() == nil {
+		t.Fatalf("expected the run to be cancelled")
+	}
+	// no more heartbeats once the lease is gone
+	if lease.held() {
+		t.Fatalf("expected the lease to stay lost")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	var none *gradingLease
+	if !none.held() || none.lost() {
+		t.Fatalf("runs without a job always hold their lease")
+	}
+}
+
+func TestRecoverOrphanedSubmissions(t *testing.T) {
+	mock := newJobQueueMock(t)
+	mock.ExpectExec(`(?s)WITH orphaned AS .*INSERT INTO grading_jobs`).WithArgs(jobMaxAttempts).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`(?s)UPDATE submissions s\s+SET status = 'pending'.*WHERE s.status = 'running'`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := recoverOrphanedSubmissions()
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 recovered submissions, got %d, %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRecoverOrphanedSubmissionsNothingToDo(t *testing.T) {
+	mock := newJobQueueMock(t)
+	mock.ExpectExec(`INSERT INTO grading_jobs`).WithArgs(jobMaxAttempts).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if n, err := recoverOrphanedSubmissions(); err != nil || n != 0 {
+		t.Fatalf("expected nothing recovered, got %d, %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestFailAbandonedGradingJobs(t *testing.T) {
+	mock := newJobQueueMock(t)
+	subID, regradeID := uuid.New(), uuid.New()
+	mock.ExpectQuery(`(?s)UPDATE grading_jobs\s+SET status = 'failed'.*attempts >= max_attempts`).
+		WillReturnRows(sqlmock.NewRows(gradingJobColumns).AddRow(uuid.New(), subID, 3, 3, regradeID))
+	mock.ExpectExec(`UPDATE submissions SET status`).WithArgs("failed", subID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE regrade_score_changes`).WithArgs(regradeID, subID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE regrade_runs`).WithArgs(regradeID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := failAbandonedGradingJobs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// languageRuntime builds and runs submissions written in one programming
+// language. Test execution dispatches on the assignment's runtime instead of
+// assuming Python; each runtime declares the execution modes it can grade.
+type languageRuntime interface {
+	// modes lists the execution modes the runtime supports.
+	modes() []string
+	// compiled reports whether build does any work.
+	compiled() bool
+	// entryFile locates the program entry point of an unpacked submission.
+	entryFile(dir string) (string, error)
+	// build prepares dir once before any test runs. A submission that does
+	// not build is reported as *compileError.
+	build(sess *vmSession, dir string, a *Assignment) error
+	// command is the shell fragment that starts the program in remoteDir
+	// under the memory guard.
+	command(remoteDir, entry string, memoryLimitKB int) string
+	runStdin(sess *vmSession, dir, entry, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage)
+	runUnit(sess *vmSession, dir, entry string, tc TestCase, timeout time.Duration) (string, string, int, bool, time.Duration, memoryUsage)
+	runFunction(sess *vmSession, dir, entry string, cfg functionCallConfig, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage, *functionCallResult, error)
+}
+
+var languageRuntimes = map[string]languageRuntime{
+	"python":     pythonRuntime{},
+	"c":          nativeRuntime{lang: "c"},
+	"cpp":        nativeRuntime{lang: "cpp"},
+	"java":       javaRuntime{},
+	"javascript": nodeRuntime{},
+	"sql":        sqlRuntime{},
+	"jupyter":    notebookRuntime{},
+}
+
+// runtimeFor returns the runtime of a programming language.
+func runtimeFor(lang string) (languageRuntime, error) {
+	if lang == "" {
+		lang = "python"
+	}
+	rt, ok := languageRuntimes[lang]
+	if !ok {
+		return nil, fmt.Errorf("no test runtime for %s assignments", lang)
+	}
+	return rt, nil
+}
+
+// languageModes lists the execution modes tests of lang may use.
+func languageModes(lang string) []string {
+	rt, err := runtimeFor(lang)
+	if err != nil {
+		return nil
+	}
+	return rt.modes()
+}
+
+// program is a submission ready to be tested: its runtime and entry point.
+type program struct {
+	rt    languageRuntime
+	entry string
+}
+
+// loadProgram locates the entry point of the submission in dir.
+func loadProgram(dir, lang string) (program, error) {
+	rt, err := runtimeFor(lang)
+	if err != nil {
+		return program{}, err
+	}
+	entry, err := rt.entryFile(dir)
+	if err != nil {
+		return program{}, err
+	}
+	return program{rt: rt, entry: entry}, nil
+}
+
+// checkModeSupported rejects execution modes the runtime cannot grade.
+func checkModeSupported(rt languageRuntime, mode string) error {
+	for _, m := range rt.modes() {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s tests are not supported for this programming language", mode)
+}
+
+// checkAssignmentMode rejects tests whose mode the assignment's language
+// cannot run. Languages without a test runtime (Scratch) are not checked.
+func checkAssignmentMode(aid uuid.UUID, mode string) error {
+	a, err := GetAssignment(aid)
+	if err != nil {
+		return errors.New("assignment not found")
+	}
+	rt, err := runtimeFor(a.ProgrammingLanguage)
+	if err != nil {
+		return nil
+	}
+	return checkModeSupported(rt, mode)
+}
+
+// runGuardedStdin runs the program command of rt with stdin under the
+// memory guard.
+func runGuardedStdin(rt languageRuntime, sess *vmSession, dir, entry, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	_ = ensureSandboxPerms(dir)
+	if err := writeMemoryGuard(dir); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	return runTimedInVM(sess, dir, stdin, timeout, func(remoteDir string) string {
+		return rt.command(remoteDir, entry, memoryLimitKB)
+	})
+}
+
+// noUnitTests and noFunctionCalls fill in modes a runtime does not declare;
+// checkModeSupported keeps them from being reached.
+type noUnitTests struct{}
+
+func (noUnitTests) runUnit(*vmSession, string, string, TestCase, time.Duration) (string, string, int, bool, time.Duration, memoryUsage) {
+	return "", "unittest tests are not supported for this programming language", -1, false, 0, memoryUsage{}
+}
+
+type noFunctionCalls struct{}
+
+func (noFunctionCalls) runFunction(*vmSession, string, string, functionCallConfig, time.Duration, int) (string, string, int, bool, time.Duration, memoryUsage, *functionCallResult, error) {
+	return "", "", -1, false, 0, memoryUsage{}, nil, errors.New("function tests are not supported for this programming language")
+}
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Python
+// ──────────────────────────────────────────────────────────────────────────────
+
+type pythonRuntime struct{}
+
+func (pythonRuntime) modes() []string {
+	return []string{"stdin_stdout", "checker", "dialogue", "performance", "property", "unittest", "pytest", "doctest", "plot", "function", "turtle"}
+}
+
+func (pythonRuntime) compiled() bool { return false }
+
+func (pythonRuntime) entryFile(dir string) (string, error) { return detectMainFile(dir) }
+
+func (pythonRuntime) build(*vmSession, string, *Assignment) error { return nil }
+
+func (pythonRuntime) command(remoteDir, entry string, memoryLimitKB int) string {
+	return "PYTHONDONTWRITEBYTECODE=1 PYTHONUNBUFFERED=1 HOME=/tmp LANG=C.UTF-8 " + memoryGuardedPython(remoteDir, filepath.Join(remoteDir, entry), memoryLimitKB)
+}
+
+func (pythonRuntime) runStdin(sess *vmSession, dir, entry, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	return executePythonDir(sess, dir, entry, stdin, timeout, memoryLimitKB)
+}
+
+func (pythonRuntime) runUnit(sess *vmSession, dir, entry string, tc TestCase, timeout time.Duration) (string, string, int, bool, time.Duration, memoryUsage) {
+	switch tc.ExecutionMode {
+	case "pytest":
+		return executePythonPytest(sess, dir, entry, stringOrEmpty(tc.UnittestCode), stringOrEmpty(tc.UnittestName), timeout, tc.MemoryLimitKB)
+	case "doctest":
+		return executePythonDoctest(sess, dir, entry, stringOrEmpty(tc.UnittestCode), stringOrEmpty(tc.UnittestName), timeout, tc.MemoryLimitKB)
+	}
+	// only plot tests feed their stdin to student_code() called without arguments
+	stdin := ""
+	if tc.ExecutionMode == "plot" {
+		stdin = tc.Stdin
+	}
+	return executePythonUnit(sess, dir, entry, stringOrEmpty(tc.UnittestCode), stringOrEmpty(tc.UnittestName), stdin, timeout, tc.MemoryLimitKB)
+}
+
+func (pythonRuntime) runFunction(sess *vmSession, dir, entry string, cfg functionCallConfig, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage, *functionCallResult, error) {
+	return runFunctionCall(sess, dir, entry, cfg, timeout, memoryLimitKB)
+}
+
+// ──────────────────────────────────────────────────────────────────────────────
+// C / C++
+// ──────────────────────────────────────────────────────────────────────────────
+
+type nativeRuntime struct {
+	noUnitTests
+	noFunctionCalls
+	lang string
+}
+
+func (nativeRuntime) modes() []string {
+	return []string{"stdin_stdout", "checker", "dialogue", "performance", "property"}
+}
+
+func (nativeRuntime) compiled() bool { return true }
+
+func (r nativeRuntime) sourceExts() []string {
+	if r.lang == "cpp" {
+		return []string{".cpp", ".cc", ".cxx"}
+	}
+	return []string{".c"}
+}
+
+func (r nativeRuntime) entryFile(dir string) (string, error) {
+	sources, err := findSources(dir, r.sourceExts()...)
+	if err != nil {
+		return "", err
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("no %s source files found", strings.Join(r.sourceExts(), "/"))
+	}
+	return compiledBinaryName, nil
+}
+
+func (r nativeRuntime) build(sess *vmSession, dir string, a *Assignment) error {
+	sources, err := findSources(dir, r.sourceExts()...)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return &compileError{Diagnostics: fmt.Sprintf("no %s source files found", strings.Join(r.sourceExts(), "/"))}
+	}
+	compiler := "gcc"
+	if r.lang == "cpp" {
+		compiler = "g++"
+	}
+	args := []string{compiler}
+	for _, src := range sources {
+		args = append(args, shellQuote(src))
+	}
+	args = append(args, compileFlagsFor(a)...)
+	if r.lang == "c" {
+		args = append(args, "-lm")
+	}
+	args = append(args, "-o", compiledBinaryName)
+	return buildInVM(sess, dir, strings.Join(args, " "), compiledBinaryName)
+}
+
+func (nativeRuntime) command(remoteDir, entry string, memoryLimitKB int) string {
+	bin := filepath.Join(remoteDir, entry)
+	// synced files lose their executable bit
+	return fmt.Sprintf("chmod +x %s && HOME=/tmp LANG=C.UTF-8 %s", shellQuote(bin), memoryGuardedExec(remoteDir, memoryLimitKB, true, bin))
+}
+
+func (r nativeRuntime) runStdin(sess *vmSession, dir, entry, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	return runGuardedStdin(r, sess, dir, entry, stdin, timeout, memoryLimitKB)
+}
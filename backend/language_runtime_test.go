@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRuntimeFor(t *testing.T) {
+	if rt, err := runtimeFor(""); err != nil || rt == nil {
+		t.Fatalf("empty language should default to python, got %v", err)
+	}
+	if _, err := runtimeFor("scratch"); err == nil {
+		t.Fatalf("scratch has no test runtime")
+	}
+	if modes := languageModes("javascript"); strings.Join(modes, ",") != "stdin_stdout,checker,dialogue,performance,property,function" {
+		t.Fatalf("javascript modes = %v", modes)
+	}
+}
+
+func TestCheckModeSupported(t *testing.T) {
+	cases := []struct {
+		lang, mode string
+		ok         bool
+	}{
+		{"python", "unittest", true},
+		{"python", "function", true},
+		{"c", "function", false},
+		{"cpp", "dialogue", true},
+		{"java", "unittest", true},
+		{"java", "function", false},
+		{"javascript", "function", true},
+		{"javascript", "unittest", false},
+	}
+	for _, tc := range cases {
+		rt, err := runtimeFor(tc.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkModeSupported(rt, tc.mode); (err == nil) != tc.ok {
+			t.Errorf("%s/%s: got err %v, want ok=%v", tc.lang, tc.mode, err, tc.ok)
+		}
+	}
+}
+
+func TestJavaEntryFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app/Helper.java": "package app;\nclass Helper { public static void main(String[] a) {} }",
+		"app/Main.java":   "package app;\npublic class Main {\n  public static void main(String[] args) {}\n}",
+		"app/Util.java":   "package app;\nclass Util {}",
+	})
+	prog, err := loadProgram(dir, "java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.entry != "app.Main" {
+		t.Fatalf("entry = %q, want app.Main", prog.entry)
+	}
+
+	empty := t.TempDir()
+	writeFiles(t, empty, map[string]string{"Util.java": "class Util {}"})
+	if _, err := loadProgram(empty, "java"); err == nil {
+		t.Fatalf("expected error without a main method")
+	}
+}
+
+func TestNodeEntryFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.js": "", "index.js": "", "node_modules/main.js": ""})
+	prog, err := loadProgram(dir, "javascript")
+	if err != nil || prog.entry != "index.js" {
+		t.Fatalf("entry = %q, %v; want index.js", prog.entry, err)
+	}
+	writeFiles(t, dir, map[string]string{"main.js": ""})
+	if prog, _ := loadProgram(dir, "javascript"); prog.entry != "main.js" {
+		t.Fatalf("entry = %q, want main.js", prog.entry)
+	}
+}
+
+func TestSplitFunctionCallOutput(t *testing.T) {
+	out, meta := splitFunctionCallOutput("hello\n===GRADER_JSON==={\"status\":\"ok\",\"passed\":true}\n")
+	if out != "hello\n" || meta == nil || !meta.Passed {
+		t.Fatalf("got %q, %+v", out, meta)
+	}
+	if out, meta := splitFunctionCallOutput("plain"); out != "plain" || meta != nil {
+		t.Fatalf("got %q, %+v", out, meta)
+	}
+}
+
+func TestMemoryGuardedExecQuoting(t *testing.T) {
+	cmd := memoryGuardedExec("/tmp/run", 1024, false, "java", "-cp", "it's", "Main")
+	if !strings.Contains(cmd, "--exec-soft") || !strings.Contains(cmd, `'it'\''s'`) {
+		t.Fatalf("unexpected command %q", cmd)
+	}
+	if cmd := memoryGuardedExec("/tmp/run", 1024, true, "/tmp/run/__prog__"); !strings.Contains(cmd, "--exec ") {
+		t.Fatalf("enforced guard should use --exec: %q", cmd)
+	}
+}
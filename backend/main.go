@@ -124,6 +124,7 @@ func main() {
 	// Ensure the shared execution root exists with permissive traversal
 	ensureExecRoot(execRoot)
 	StartWorker(2)
+	failInterruptedSimilarityRuns()
 	StartNotificationScheduler()
 	// seed RNG for avatar assignment
 	rand.Seed(time.Now().UnixNano())
@@ -132,8 +133,8 @@ func main() {
 		log.Printf("could not assign default avatars: %v", err)
 	}
 
-	// Initialize VM pool
-	initVMPool()
+	// Initialize the sandbox backend (warm VM pool for QEMU)
+	initSandbox()
 
 	// 2) Router
 	r := gin.New()
@@ -224,6 +225,8 @@ func main() {
 		api.GET("/assignments/:id", RoleGuard("student", "teacher", "admin"), getAssignment)
 		api.PUT("/assignments/:id", RoleGuard("teacher", "admin"), updateAssignment)
 		api.PUT("/assignments/:id/testing-constraints", RoleGuard("teacher", "admin"), updateAssignmentTestingConstraints)
+		api.PUT("/assignments/:id/construct-rules", RoleGuard("teacher", "admin"), updateAssignmentConstructRules)
+		api.PUT("/assignments/:id/quality-config", RoleGuard("teacher", "admin"), updateAssignmentQualityConfig)
 		api.DELETE("/assignments/:id", RoleGuard("teacher", "admin"), deleteAssignment)
 		api.PUT("/assignments/:id/publish", RoleGuard("teacher", "admin"), publishAssignment)
 		api.PUT("/assignments/:id/unpublish", RoleGuard("teacher", "admin"), unpublishAssignment)
@@ -239,8 +242,25 @@ func main() {
 		api.DELETE("/assignments/:id/tests", RoleGuard("teacher", "admin"), deleteAllTestCases)
 		api.PUT("/tests/:id", RoleGuard("teacher", "admin"), updateTestCase)
 		api.DELETE("/tests/:id", RoleGuard("teacher", "admin"), deleteTestCase)
+		api.GET("/assignments/:id/test-groups", RoleGuard("teacher", "admin"), listTestGroups)
+		api.POST("/assignments/:id/test-groups", RoleGuard("teacher", "admin"), createTestGroup)
+		api.PUT("/test-groups/:id", RoleGuard("teacher", "admin"), updateTestGroup)
+		api.DELETE("/test-groups/:id", RoleGuard("teacher", "admin"), deleteTestGroup)
 		api.POST("/assignments/:id/solution-run", RoleGuard("teacher", "admin"), runTeacherSolution)
+		api.GET("/assignments/:id/reference-solution", RoleGuard("teacher", "admin"), getReferenceSolution)
+		api.PUT("/assignments/:id/reference-solution", RoleGuard("teacher", "admin"), uploadReferenceSolution)
+		api.DELETE("/assignments/:id/reference-solution", RoleGuard("teacher", "admin"), deleteReferenceSolution)
+		api.POST("/assignments/:id/tests/regenerate-expected", RoleGuard("teacher", "admin"), regenerateExpectedOutputs)
+		api.POST("/assignments/:id/regrade", RoleGuard("teacher", "admin"), regradeAssignment)
+		api.GET("/assignments/:id/regrades", RoleGuard("teacher", "admin"), listRegrades)
+		api.GET("/assignments/:id/regrades/:rid", RoleGuard("teacher", "admin"), getRegrade)
+		api.GET("/assignments/:id/regrades/:rid/events", RoleGuard("teacher", "admin"), regradeEventsHandler)
+		api.POST("/assignments/:id/similarity", RoleGuard("teacher", "admin"), startSimilarity)
+		api.GET("/assignments/:id/similarity", RoleGuard("teacher", "admin"), listSimilarityRuns)
+		api.GET("/assignments/:id/similarity/:rid", RoleGuard("teacher", "admin"), getSimilarityRun)
+		api.GET("/assignments/:id/similarity/:rid/pairs/:pid", RoleGuard("teacher", "admin"), getSimilarityPair)
 		api.POST("/assignments/:id/submissions", RoleGuard("student"), createSubmission)
+		api.POST("/assignments/:id/sample-runs", RoleGuard("student"), runSampleTests)
 		// per-student deadline extensions
 		api.GET("/assignments/:id/extensions", RoleGuard("teacher", "admin"), listAssignmentExtensions)
 		api.PUT("/assignments/:id/extensions/:student_id", RoleGuard("teacher", "admin"), upsertAssignmentExtension)
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// memoryGuardFile is the wrapper script that applies the per-test memory cap
+// inside the VM and reports the peak resident memory of the student process.
+const memoryGuardFile = "__memguard__.py"
+
+const memoryGuardMarker = "===MEMGUARD==="
+
+// memoryUsage is what the guard reports back once the wrapped program exits.
+type memoryUsage struct {
+	PeakKB        int  `json:"peak_kb"`
+	LimitKB       int  `json:"limit_kb"`
+	LimitExceeded bool `json:"limit_exceeded"`
+}
+
+// peakPtr returns the measured peak as an optional value for persisting on results.
+func (m memoryUsage) peakPtr() *int {
+	if m.PeakKB <= 0 {
+		return nil
+	}
+	v := m.PeakKB
+	return &v
+}
+
+const memoryGuardScript = `import json
+import os
+import resource
+import signal
+import subprocess
+import sys
+import threading
+
+MARKER = "===MEMGUARD==="
+TAIL_LIMIT = 64 * 1024
+SAMPLE_INTERVAL = 0.02
+# Python MemoryError, Java OutOfMemoryError, V8 heap exhaustion, C++ bad_alloc
+OOM_MARKERS = (b'MemoryError', b'heap out of memory', b'std::bad_alloc')
+
+
+def apply_limit(limit_kb):
+    if limit_kb <= 0:
+        return
+    limit = limit_kb * 1024
+    # RLIMIT_DATA covers heap and anonymous mappings, which is what student
+    # programs actually grow; RLIMIT_AS would also count shared libraries.
+    try:
+        resource.setrlimit(resource.RLIMIT_DATA, (limit, limit))
+    except (ValueError, OSError):
+        pass
+
+
+def data_kb(pid):
+    try:
+        with open('/proc/%d/status' % pid) as fh:
+            for line in fh:
+                if line.startswith('VmData:'):
+                    return int(line.split()[1])
+    except (OSError, ValueError, IndexError):
+        pass
+    return 0
+
+
+def sample_data(pid, peak, done):
+    # the kernel keeps no peak of the data segment, which is what
+    # RLIMIT_DATA caps, so it is sampled while the program runs
+    while not done.wait(SAMPLE_INTERVAL):
+        peak[0] = max(peak[0], data_kb(pid))
+
+
+def main():
+    limit_kb = int(sys.argv[1])
+    enforce = True
+    if sys.argv[2] == '--exec':
+        # native programs (compiled submissions) run as they are
+        cmd = sys.argv[3:]
+    elif sys.argv[2] == '--exec-soft':
+        # the runtime caps its own heap (JVM -Xmx, node --max-old-space-size)
+        # and would not even start under RLIMIT_DATA; the limit only
+        # classifies the failure
+        enforce = False
+        cmd = sys.argv[3:]
+    else:
+        cmd = [sys.executable, '-u'] + sys.argv[2:]
+    proc = subprocess.Popen(cmd, stderr=subprocess.PIPE, preexec_fn=lambda: apply_limit(limit_kb if enforce else 0))
+
+    data_peak = [0]
+    done = threading.Event()
+    if enforce and limit_kb > 0:
+        threading.Thread(target=sample_data, args=(proc.pid, data_peak, done), daemon=True).start()
+
+    tail = b''
+    out = sys.stderr.buffer
+    while True:
+        chunk = proc.stderr.read(4096)
+        if not chunk:
+            break
+        out.write(chunk)
+        out.flush()
+        tail = (tail + chunk)[-TAIL_LIMIT:]
+
+    _, status, usage = os.wait4(proc.pid, 0)
+    done.set()
+    peak_kb = int(usage.ru_maxrss)
+    if os.WIFEXITED(status):
+        code = os.WEXITSTATUS(status)
+        killed = False
+    else:
+        sig = os.WTERMSIG(status)
+        code = 128 + sig
+        killed = sig in (signal.SIGKILL, signal.SIGSEGV)
+    proc.returncode = code
+
+    # Only a failed run is classified: a program that exits 0 may well have
+    # caught a MemoryError. Programs that die without a message count when
+    # their data segment came close to the enforced limit; the resident peak
+    # is reported but not compared, since RLIMIT_DATA does not cap it.
+    exceeded = False
+    if limit_kb > 0 and code != 0:
+        if any(m in tail for m in OOM_MARKERS):
+            exceeded = True
+        elif enforce and killed and data_peak[0] * 10 >= limit_kb * 9:
+            exceeded = True
+
+    report = {"peak_kb": peak_kb, "limit_kb": limit_kb, "limit_exceeded": exceeded}
+    out.write(("\n" + MARKER + json.dumps(report) + "\n").encode())
+    out.flush()
+    sys.exit(code)
+
+
+if __name__ == '__main__':
+    main()
+`
+
+// writeMemoryGuard stages the guard wrapper next to the runner scripts.
+func writeMemoryGuard(dir string) error {
+	path := filepath.Join(dir, memoryGuardFile)
+	if err := os.WriteFile(path, []byte(memoryGuardScript), 0644); err != nil {
+		return fmt.Errorf("write memory guard: %w", err)
+	}
+	_ = os.Chmod(path, 0644)
+	return nil
+}
+
+// memoryGuardedPython builds the shell fragment that runs a Python script
+// under the memory guard. A non-positive limit only measures peak memory.
+func memoryGuardedPython(remoteDir, remoteScript string, limitKB int) string {
+	if limitKB < 0 {
+		limitKB = 0
+	}
+	guard := filepath.Join(remoteDir, memoryGuardFile)
+	return fmt.Sprintf("%s -u '%s' %d '%s'",
+		pythonBinary,
+		strings.ReplaceAll(guard, "'", "'\\''"),
+		limitKB,
+		strings.ReplaceAll(remoteScript, "'", "'\\''"),
+	)
+}
+
+// memoryGuardedExec builds the shell fragment that runs argv under the memory
+// guard. With enforce unset the limit is not applied to the process; runtimes
+// that size their own heap use this so the guard only reports the failure.
+func memoryGuardedExec(remoteDir string, limitKB int, enforce bool, argv ...string) string {
+	if limitKB < 0 {
+		limitKB = 0
+	}
+	mode := "--exec"
+	if !enforce {
+		mode = "--exec-soft"
+	}
+	guard := filepath.Join(remoteDir, memoryGuardFile)
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", "'\\''") + "'"
+	}
+	return fmt.Sprintf("%s -u '%s' %d %s %s",
+		pythonBinary,
+		strings.ReplaceAll(guard, "'", "'\\''"),
+		limitKB,
+		mode,
+		strings.Join(quoted, " "),
+	)
+}
+
+// splitMemoryGuardOutput removes the guard report from stderr and decodes it.
+func splitMemoryGuardOutput(stderr string) (string, memoryUsage) {
+	var usage memoryUsage
+	idx := strings.LastIndex(stderr, memoryGuardMarker)
+	if idx == -1 {
+		return stderr, usage
+	}
+	payload := strings.TrimSpace(stderr[idx+len(memoryGuardMarker):])
+	if nl := strings.IndexByte(payload, '\n'); nl != -1 {
+		payload = payload[:nl]
+	}
+	if err := json.Unmarshal([]byte(payload), &usage); err != nil {
+		return stderr, memoryUsage{}
+	}
+	return strings.TrimRight(stderr[:idx], "\r\n"), usage
+}
+
+// isMemoryErrorException reports whether a captured Python exception repr is a MemoryError.
+func isMemoryErrorException(exc string) bool {
+	return strings.HasPrefix(strings.TrimSpace(exc), "MemoryError")
+}
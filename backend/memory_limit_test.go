@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestSplitMemoryGuardOutput(t *testing.T) {
+	cases := []struct {
+		name   string
+		stderr string
+		rest   string
+		usage  memoryUsage
+	}{
+		{"no report", "Traceback\nValueError", "Traceback\nValueError", memoryUsage{}},
+		{"report only", `===MEMGUARD==={"peak_kb": 2048, "limit_kb": 65536, "limit_exceeded": false}`, "", memoryUsage{PeakKB: 2048, LimitKB: 65536}},
+		{"report after stderr", "warning\r\n===MEMGUARD===" + `{"peak_kb": 70000, "limit_kb": 65536, "limit_exceeded": true}` + "\n", "warning", memoryUsage{PeakKB: 70000, LimitKB: 65536, LimitExceeded: true}},
+		{"last report wins", "===MEMGUARD===" + `{"peak_kb": 1}` + "\nmore\n===MEMGUARD===" + `{"peak_kb": 2}`, "===MEMGUARD===" + `{"peak_kb": 1}` + "\nmore", memoryUsage{PeakKB: 2}},
+		{"text after the report", "===MEMGUARD===" + `{"peak_kb": 5}` + "\ntrailing", "", memoryUsage{PeakKB: 5}},
+		{"broken report kept", "oops ===MEMGUARD=== {not json", "oops ===MEMGUARD=== {not json", memoryUsage{}},
+	}
+	for _, c := range cases {
+		rest, usage := splitMemoryGuardOutput(c.stderr)
+		if rest != c.rest || usage != c.usage {
+			t.Errorf("%s: got %q %+v, want %q %+v", c.name, rest, usage, c.rest, c.usage)
+		}
+	}
+}
+
+func TestMemoryGuardedCommands(t *testing.T) {
+	py := pythonBinary
+	cases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"python", memoryGuardedPython("/w", "/w/run.py", 65536), py + " -u '/w/__memguard__.py' 65536 '/w/run.py'"},
+		{"negative limit only measures", memoryGuardedPython("/w", "/w/run.py", -5), py + " -u '/w/__memguard__.py' 0 '/w/run.py'"},
+		{"quotes in paths", memoryGuardedPython("/it's", "/it's/a b.py", 1), py + ` -u '/it'\''s/__memguard__.py' 1 '/it'\''s/a b.py'`},
+		{"exec", memoryGuardedExec("/w", 1024, true, "./main", "a b"), py + " -u '/w/__memguard__.py' 1024 --exec './main' 'a b'"},
+		{"soft exec", memoryGuardedExec("/w", 1024, false, "java", "-Xmx1m", "Main"), py + " -u '/w/__memguard__.py' 1024 --exec-soft 'java' '-Xmx1m' 'Main'"},
+		{"exec quoting", memoryGuardedExec("/w", -1, true, "echo", "don't"), py + ` -u '/w/__memguard__.py' 0 --exec 'echo' 'don'\''t'`},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestIsMemoryErrorException(t *testing.T) {
+	cases := []struct {
+		exc  string
+		want bool
+	}{
+		{"MemoryError()", true},
+		{"  MemoryError: out of memory", true},
+		{"ValueError('MemoryError')", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := isMemoryErrorException(c.exc); got != c.want {
+			t.Errorf("isMemoryErrorException(%q) = %v, want %v", c.exc, got, c.want)
+		}
+	}
+}
+
+// TestMemoryGuardClassification runs programs under the guard: only a run
+// that failed is reported as over the limit.
+func TestMemoryGuardClassification(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		cases := []struct {
+			name, code string
+			exceeded   bool
+		}{
+			{"prints MemoryError", "import sys\nsys.stderr.write('MemoryError is a class\\n')\nprint('ok')\n", false},
+			{"catches MemoryError", "try:\n    x = bytearray(1 << 34)\nexcept MemoryError:\n    print('caught')\n", false},
+			{"runs out of memory", "x = bytearray(1 << 34)\n", true},
+		}
+		for _, c := range cases {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.py": c.code})
+			_, stderr, exitCode, _, _, mem := executePythonDir(nil, dir, "main.py", "", 20*time.Second, 256*1024)
+			if mem.LimitExceeded != c.exceeded || (exitCode == 0) == c.exceeded {
+				t.Errorf("%s: exit %d, usage %+v, stderr %q", c.name, exitCode, mem, stderr)
+			}
+		}
+	})
+}
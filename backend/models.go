@@ -78,6 +78,19 @@ type Assignment struct {
 	SecondDeadline   *time.Time `db:"second_deadline" json:"second_deadline"`
 	LatePenaltyRatio float64    `db:"late_penalty_ratio" json:"late_penalty_ratio"`
 	MaxAttempts      *int       `db:"max_attempts" json:"max_attempts"`
+
+	// Extra compiler flags for C, C++ and Java assignments (nil = language defaults)
+	CompileFlags *string `db:"compile_flags" json:"compile_flags"`
+
+	// Schema and seed scripts every SQL test database is built from
+	SQLSchema *string `db:"sql_schema" json:"sql_schema"`
+	SQLSeed   *string `db:"sql_seed" json:"sql_seed"`
+
+	// Required/forbidden language constructs as a JSON list of ConstructRule
+	ConstructRules *string `db:"construct_rules" json:"construct_rules,omitempty"`
+
+	// Static code-quality analysis settings as a JSON QualityConfig
+	QualityConfig *string `db:"quality_config" json:"quality_config,omitempty"`
 }
 
 // AssignmentClone links a cloned assignment back to its source and target class.
@@ -150,26 +163,52 @@ type Submission struct {
 }
 
 type TestCase struct {
-	ID               uuid.UUID `db:"id" json:"id"`
-	AssignmentID     uuid.UUID `db:"assignment_id" json:"assignment_id"`
-	Stdin            string    `db:"stdin" json:"stdin"`
-	ExpectedStdout   string    `db:"expected_stdout" json:"expected_stdout"`
-	Weight           float64   `db:"weight" json:"weight"`
-	TimeLimitSec     float64   `db:"time_limit_sec" json:"time_limit_sec"`
-	MemoryLimitKB    int       `db:"memory_limit_kb" json:"memory_limit_kb"`
-	UnittestCode     *string   `db:"unittest_code" json:"unittest_code"`
-	UnittestName     *string   `db:"unittest_name" json:"unittest_name"`
-	ExecutionMode    string    `db:"execution_mode" json:"execution_mode"`
-	FunctionName     *string   `db:"function_name" json:"function_name,omitempty"`
-	FunctionArgs     *string   `db:"function_args" json:"function_args,omitempty"`
-	FunctionKwargs   *string   `db:"function_kwargs" json:"function_kwargs,omitempty"`
-	FunctionArgNames *string   `db:"function_arg_names" json:"function_arg_names,omitempty"`
-	ExpectedReturn   *string   `db:"expected_return" json:"expected_return,omitempty"`
-	FileName         *string   `db:"file_name" json:"file_name,omitempty"`
-	FileBase64       *string   `db:"file_base64" json:"file_base64,omitempty"`
-	FilesJSON        *string   `db:"files_json" json:"files_json,omitempty"`
-	CreatedAt        time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt        time.Time `db:"updated_at" json:"updated_at"`
+	ID                uuid.UUID `db:"id" json:"id"`
+	AssignmentID      uuid.UUID `db:"assignment_id" json:"assignment_id"`
+	Stdin             string    `db:"stdin" json:"stdin"`
+	ExpectedStdout    string    `db:"expected_stdout" json:"expected_stdout"`
+	Weight            float64   `db:"weight" json:"weight"`
+	TimeLimitSec      float64   `db:"time_limit_sec" json:"time_limit_sec"`
+	MemoryLimitKB     int       `db:"memory_limit_kb" json:"memory_limit_kb"`
+	UnittestCode      *string   `db:"unittest_code" json:"unittest_code"`
+	UnittestName      *string   `db:"unittest_name" json:"unittest_name"`
+	ExecutionMode     string    `db:"execution_mode" json:"execution_mode"`
+	FunctionName      *string   `db:"function_name" json:"function_name,omitempty"`
+	FunctionArgs      *string   `db:"function_args" json:"function_args,omitempty"`
+	FunctionKwargs    *string   `db:"function_kwargs" json:"function_kwargs,omitempty"`
+	FunctionArgNames  *string   `db:"function_arg_names" json:"function_arg_names,omitempty"`
+	ExpectedReturn    *string   `db:"expected_return" json:"expected_return,omitempty"`
+	FileName          *string   `db:"file_name" json:"file_name,omitempty"`
+	FileBase64        *string   `db:"file_base64" json:"file_base64,omitempty"`
+	FilesJSON         *string   `db:"files_json" json:"files_json,omitempty"`
+	Comparator        string    `db:"comparator" json:"comparator"`
+	ComparatorOptions *string   `db:"comparator_options" json:"comparator_options,omitempty"`
+	CheckerCode       *string   `db:"checker_code" json:"checker_code,omitempty"`
+	DialogueScript    *string   `db:"dialogue_script" json:"dialogue_script,omitempty"`
+	// GroupID places the test in a test group; GroupName is only filled by ListTestCases and GetTestCase.
+	GroupID   *uuid.UUID `db:"group_id" json:"group_id,omitempty"`
+	GroupName *string    `db:"group_name" json:"group_name,omitempty"`
+	// IsSample marks public tests students may run before submitting.
+	IsSample bool `db:"is_sample" json:"is_sample"`
+	// SQL tests compare the student's query file against the reference query.
+	SQLQuery       *string `db:"sql_query" json:"sql_query,omitempty"`
+	SQLFile        *string `db:"sql_file" json:"sql_file,omitempty"`
+	SQLOrdered     bool    `db:"sql_ordered" json:"sql_ordered"`
+	SQLColumnMatch string  `db:"sql_column_match" json:"sql_column_match"`
+	// NotebookTarget is the variable or cell tag a notebook test inspects.
+	NotebookTarget *string `db:"notebook_target" json:"notebook_target,omitempty"`
+	// PerformanceConfig holds the relative limit and input series of
+	// performance tests.
+	PerformanceConfig *string `db:"performance_config" json:"performance_config,omitempty"`
+	// PropertyConfig holds the generator and run settings of property tests.
+	PropertyConfig *string `db:"property_config" json:"property_config,omitempty"`
+	// OutputFiles lists the files the program must write, each with its
+	// expected content and comparator.
+	OutputFiles *string `db:"output_files" json:"output_files,omitempty"`
+	// TurtleConfig holds the tolerance and matching rules of turtle tests.
+	TurtleConfig *string   `db:"turtle_config" json:"turtle_config,omitempty"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
 }
 
 // ──────────────────────────────────────────────────────
@@ -283,8 +322,8 @@ func CreateAssignment(a *Assignment) error {
 		a.ScratchEvaluationMode = "manual"
 	}
 	const q = `
-          INSERT INTO assignments (title, description, created_by, deadline, max_points, max_submission_size_mb, grading_policy, published, show_traceback, show_test_details, programming_language, manual_review, scratch_evaluation_mode, banned_functions, banned_modules, banned_tool_rules, template_path, class_id, second_deadline, late_penalty_ratio, llm_help_why_failed, scratch_semantic_criteria, max_attempts)
-          VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23)
+          INSERT INTO assignments (title, description, created_by, deadline, max_points, max_submission_size_mb, grading_policy, published, show_traceback, show_test_details, programming_language, manual_review, scratch_evaluation_mode, banned_functions, banned_modules, banned_tool_rules, template_path, class_id, second_deadline, late_penalty_ratio, llm_help_why_failed, scratch_semantic_criteria, max_attempts, compile_flags, sql_schema, sql_seed)
+          VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26)
           RETURNING id, created_at, updated_at`
 	return DB.QueryRow(q,
 		a.Title, a.Description, a.CreatedBy, a.Deadline,
@@ -292,7 +331,7 @@ func CreateAssignment(a *Assignment) error {
 		pq.Array(copyStringArray(a.BannedFunctions)), pq.Array(copyStringArray(a.BannedModules)),
 		a.BannedToolRules,
 		a.TemplatePath, a.ClassID,
-		a.SecondDeadline, a.LatePenaltyRatio, a.LLMHelpWhyFailed, a.ScratchSemanticCriteria, a.MaxAttempts,
+		a.SecondDeadline, a.LatePenaltyRatio, a.LLMHelpWhyFailed, a.ScratchSemanticCriteria, a.MaxAttempts, a.CompileFlags, a.SQLSchema, a.SQLSeed,
 	).Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt)
 }
 
@@ -347,7 +386,12 @@ func ListAssignments(role string, userID uuid.UUID) ([]Assignment, error) {
            a.scratch_semantic_criteria,
            a.second_deadline,
            COALESCE(a.late_penalty_ratio,0.5) AS late_penalty_ratio,
-           a.max_attempts
+           a.max_attempts,
+           a.compile_flags,
+           a.sql_schema,
+           a.sql_seed,
+           a.construct_rules,
+           a.quality_config
       FROM assignments a`
 	switch role {
 	case "teacher":
@@ -380,7 +424,12 @@ func ListAssignments(role string, userID uuid.UUID) ([]Assignment, error) {
            a.scratch_semantic_criteria,
            a.second_deadline,
            COALESCE(a.late_penalty_ratio,0.5) AS late_penalty_ratio,
-           a.max_attempts
+           a.max_attempts,
+           a.compile_flags,
+           a.sql_schema,
+           a.sql_seed,
+           a.construct_rules,
+           a.quality_config
       FROM assignments a` + joins + ` JOIN class_students cs ON cs.class_id = a.class_id
      WHERE cs.student_id = $1 AND a.published = true`
 		args = append(args, userID)
@@ -475,7 +524,12 @@ func GetAssignment(id uuid.UUID) (*Assignment, error) {
            scratch_semantic_criteria,
            second_deadline,
            COALESCE(late_penalty_ratio,0.5) AS late_penalty_ratio,
-           max_attempts
+           max_attempts,
+           compile_flags,
+           sql_schema,
+           sql_seed,
+           construct_rules,
+           quality_config
       FROM assignments
      WHERE id = $1`, id)
 	if err != nil {
@@ -510,7 +564,12 @@ func GetAssignmentForSubmission(subID uuid.UUID) (*Assignment, error) {
            a.scratch_semantic_criteria,
            a.second_deadline,
            COALESCE(a.late_penalty_ratio,0.5) AS late_penalty_ratio,
-           a.max_attempts
+           a.max_attempts,
+           a.compile_flags,
+           a.sql_schema,
+           a.sql_seed,
+           a.construct_rules,
+           a.quality_config
           FROM assignments a
           JOIN submissions s ON s.assignment_id = a.id
          WHERE s.id=$1`, subID)
@@ -529,15 +588,15 @@ func UpdateAssignment(a *Assignment) error {
            banned_functions=$12, banned_modules=$13, banned_tool_rules=$14,
            llm_interactive=$15, llm_feedback=$16, llm_auto_award=$17, llm_scenarios_json=$18,
            llm_strictness=$19, llm_rubric=$20, llm_teacher_baseline_json=$21,
-           second_deadline=$22, late_penalty_ratio=$23, llm_help_why_failed=$24, scratch_semantic_criteria=$25, max_attempts=$26,
+           second_deadline=$22, late_penalty_ratio=$23, llm_help_why_failed=$24, scratch_semantic_criteria=$25, max_attempts=$26, compile_flags=$27, sql_schema=$28, sql_seed=$29,
            updated_at=now()
-     WHERE id=$27`,
+     WHERE id=$30`,
 		a.Title, a.Description, a.Deadline,
 		a.MaxPoints, a.MaxSubmissionSizeMB, a.GradingPolicy, a.ShowTraceback, a.ShowTestDetails, a.ProgrammingLanguage, a.ManualReview, a.ScratchEvaluationMode,
 		pq.Array(copyStringArray(a.BannedFunctions)), pq.Array(copyStringArray(a.BannedModules)), a.BannedToolRules,
 		a.LLMInteractive, a.LLMFeedback, a.LLMAutoAward, a.LLMScenariosRaw,
 		a.LLMStrictness, a.LLMRubric, a.LLMTeacherBaseline,
-		a.SecondDeadline, a.LatePenaltyRatio, a.LLMHelpWhyFailed, a.ScratchSemanticCriteria, a.MaxAttempts,
+		a.SecondDeadline, a.LatePenaltyRatio, a.LLMHelpWhyFailed, a.ScratchSemanticCriteria, a.MaxAttempts, a.CompileFlags, a.SQLSchema, a.SQLSeed,
 		a.ID)
 	if err != nil {
 		return err
@@ -564,6 +623,18 @@ func UpdateAssignmentConstraints(id uuid.UUID, bannedFunctions, bannedModules []
 	return err
 }
 
+// UpdateAssignmentConstructRules replaces the assignment's construct rules.
+func UpdateAssignmentConstructRules(id uuid.UUID, rulesJSON *string) error {
+	_, err := DB.Exec(`UPDATE assignments SET construct_rules=$1, updated_at=now() WHERE id=$2`, rulesJSON, id)
+	return err
+}
+
+// UpdateAssignmentQualityConfig replaces the code-quality analysis settings.
+func UpdateAssignmentQualityConfig(id uuid.UUID, configJSON *string) error {
+	_, err := DB.Exec(`UPDATE assignments SET quality_config=$1, updated_at=now() WHERE id=$2`, configJSON, id)
+	return err
+}
+
 // DeleteAssignment removes an assignment (and cascades test_cases/submissions).
 func DeleteAssignment(id uuid.UUID) error {
 	_, err := DB.Exec(`DELETE FROM assignments WHERE id=$1`, id)
@@ -620,6 +691,9 @@ func CloneAssignmentWithTests(sourceID, targetClassID, createdBy uuid.UUID) (uui
 		LLMHelpWhyFailed:        src.LLMHelpWhyFailed,
 		ScratchSemanticCriteria: src.ScratchSemanticCriteria,
 		MaxAttempts:             src.MaxAttempts,
+		CompileFlags:            src.CompileFlags,
+		SQLSchema:               src.SQLSchema,
+		SQLSeed:                 src.SQLSeed,
 	}
 	if src.BannedToolRules != nil {
 		clone := *src.BannedToolRules
@@ -632,36 +706,80 @@ func CloneAssignmentWithTests(sourceID, targetClassID, createdBy uuid.UUID) (uui
 	if err := UpdateAssignment(dst); err != nil {
 		return uuid.Nil, err
 	}
-	// Copy test cases
-	tests, err := ListTestCases(sourceID)
-	if err != nil {
+	if src.ConstructRules != nil {
+		if err := UpdateAssignmentConstructRules(dst.ID, src.ConstructRules); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if src.QualityConfig != nil {
+		if err := UpdateAssignmentQualityConfig(dst.ID, src.QualityConfig); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if err := copyTestCases(sourceID, dst.ID); err != nil {
+		return uuid.Nil, err
+	}
+	if err := copyReferenceSolution(sourceID, dst.ID); err != nil {
 		return uuid.Nil, err
 	}
+	return dst.ID, nil
+}
+
+// copyTestCases duplicates the test groups and test cases of an assignment,
+// pointing the copied tests at the copied groups.
+func copyTestCases(srcID, dstID uuid.UUID) error {
+	groupIDs, err := copyTestGroups(srcID, dstID)
+	if err != nil {
+		return err
+	}
+	tests, err := ListTestCases(srcID)
+	if err != nil {
+		return err
+	}
 	for _, t := range tests {
 		tc := &TestCase{
-			AssignmentID:     dst.ID,
-			Stdin:            t.Stdin,
-			ExpectedStdout:   t.ExpectedStdout,
-			Weight:           t.Weight,
-			TimeLimitSec:     t.TimeLimitSec,
-			MemoryLimitKB:    t.MemoryLimitKB,
-			UnittestCode:     t.UnittestCode,
-			UnittestName:     t.UnittestName,
-			ExecutionMode:    t.ExecutionMode,
-			FunctionName:     t.FunctionName,
-			FunctionArgs:     t.FunctionArgs,
-			FunctionKwargs:   t.FunctionKwargs,
-			FunctionArgNames: t.FunctionArgNames,
-			ExpectedReturn:   t.ExpectedReturn,
-			FileName:         t.FileName,
-			FileBase64:       t.FileBase64,
-			FilesJSON:        t.FilesJSON,
+			AssignmentID:      dstID,
+			Stdin:             t.Stdin,
+			ExpectedStdout:    t.ExpectedStdout,
+			Weight:            t.Weight,
+			TimeLimitSec:      t.TimeLimitSec,
+			MemoryLimitKB:     t.MemoryLimitKB,
+			UnittestCode:      t.UnittestCode,
+			UnittestName:      t.UnittestName,
+			ExecutionMode:     t.ExecutionMode,
+			FunctionName:      t.FunctionName,
+			FunctionArgs:      t.FunctionArgs,
+			FunctionKwargs:    t.FunctionKwargs,
+			FunctionArgNames:  t.FunctionArgNames,
+			ExpectedReturn:    t.ExpectedReturn,
+			FileName:          t.FileName,
+			FileBase64:        t.FileBase64,
+			FilesJSON:         t.FilesJSON,
+			Comparator:        t.Comparator,
+			ComparatorOptions: t.ComparatorOptions,
+			CheckerCode:       t.CheckerCode,
+			DialogueScript:    t.DialogueScript,
+			IsSample:          t.IsSample,
+			SQLQuery:          t.SQLQuery,
+			SQLFile:           t.SQLFile,
+			SQLOrdered:        t.SQLOrdered,
+			SQLColumnMatch:    t.SQLColumnMatch,
+			NotebookTarget:    t.NotebookTarget,
+			PerformanceConfig: t.PerformanceConfig,
+			PropertyConfig:    t.PropertyConfig,
+			OutputFiles:       t.OutputFiles,
+			TurtleConfig:      t.TurtleConfig,
+		}
+		if t.GroupID != nil {
+			if gid, ok := groupIDs[*t.GroupID]; ok {
+				tc.GroupID = &gid
+			}
 		}
 		if err := CreateTestCase(tc); err != nil {
-			return uuid.Nil, err
+			return err
 		}
 	}
-	return dst.ID, nil
+	return nil
 }
 
 // IsTeacherOfAssignment checks whether the given teacher owns the class the
@@ -1380,16 +1498,28 @@ func CreateTestCase(tc *TestCase) error {
 			tc.ExecutionMode = "stdin_stdout"
 		}
 	}
+	tc.Comparator = normalizeComparator(tc.Comparator)
+	if tc.SQLColumnMatch == "" {
+		tc.SQLColumnMatch = sqlColumnsExact
+	}
 	const q = `
          INSERT INTO test_cases (assignment_id, stdin, expected_stdout, weight, time_limit_sec, memory_limit_kb, unittest_code, unittest_name,
-                                 execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json)
-         VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)
+                                 execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json,
+                                 comparator, comparator_options, checker_code, dialogue_script, group_id, is_sample,
+                                 sql_query, sql_file, sql_ordered, sql_column_match, notebook_target, performance_config, property_config, output_files, turtle_config)
+         VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32)
          RETURNING id, weight, time_limit_sec, memory_limit_kb, unittest_code, unittest_name,
-                   execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json, created_at, updated_at`
+                   execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json,
+                   comparator, comparator_options, checker_code, dialogue_script, group_id, is_sample,
+                   sql_query, sql_file, sql_ordered, sql_column_match, notebook_target, performance_config, property_config, output_files, turtle_config, created_at, updated_at`
 	return DB.QueryRow(q, tc.AssignmentID, tc.Stdin, tc.ExpectedStdout, tc.Weight, tc.TimeLimitSec, tc.MemoryLimitKB, tc.UnittestCode, tc.UnittestName,
-		tc.ExecutionMode, tc.FunctionName, tc.FunctionArgs, tc.FunctionKwargs, tc.FunctionArgNames, tc.ExpectedReturn, tc.FileName, tc.FileBase64, tc.FilesJSON).
+		tc.ExecutionMode, tc.FunctionName, tc.FunctionArgs, tc.FunctionKwargs, tc.FunctionArgNames, tc.ExpectedReturn, tc.FileName, tc.FileBase64, tc.FilesJSON,
+		tc.Comparator, tc.ComparatorOptions, tc.CheckerCode, tc.DialogueScript, tc.GroupID, tc.IsSample,
+		tc.SQLQuery, tc.SQLFile, tc.SQLOrdered, tc.SQLColumnMatch, tc.NotebookTarget, tc.PerformanceConfig, tc.PropertyConfig, tc.OutputFiles, tc.TurtleConfig).
 		Scan(&tc.ID, &tc.Weight, &tc.TimeLimitSec, &tc.MemoryLimitKB, &tc.UnittestCode, &tc.UnittestName,
-			&tc.ExecutionMode, &tc.FunctionName, &tc.FunctionArgs, &tc.FunctionKwargs, &tc.FunctionArgNames, &tc.ExpectedReturn, &tc.FileName, &tc.FileBase64, &tc.FilesJSON, &tc.CreatedAt, &tc.UpdatedAt)
+			&tc.ExecutionMode, &tc.FunctionName, &tc.FunctionArgs, &tc.FunctionKwargs, &tc.FunctionArgNames, &tc.ExpectedReturn, &tc.FileName, &tc.FileBase64, &tc.FilesJSON,
+			&tc.Comparator, &tc.ComparatorOptions, &tc.CheckerCode, &tc.DialogueScript, &tc.GroupID, &tc.IsSample,
+			&tc.SQLQuery, &tc.SQLFile, &tc.SQLOrdered, &tc.SQLColumnMatch, &tc.NotebookTarget, &tc.PerformanceConfig, &tc.PropertyConfig, &tc.OutputFiles, &tc.TurtleConfig, &tc.CreatedAt, &tc.UpdatedAt)
 }
 
 // UpdateTestCase modifies stdin/stdout/time limit of an existing test case.
@@ -1397,6 +1527,9 @@ func UpdateTestCase(tc *TestCase) error {
 	if tc.TimeLimitSec == 0 {
 		tc.TimeLimitSec = 1
 	}
+	if tc.MemoryLimitKB == 0 {
+		tc.MemoryLimitKB = 65536
+	}
 	if strings.TrimSpace(tc.ExecutionMode) == "" {
 		if tc.UnittestName != nil {
 			tc.ExecutionMode = "unittest"
@@ -1406,16 +1539,24 @@ func UpdateTestCase(tc *TestCase) error {
 			tc.ExecutionMode = "stdin_stdout"
 		}
 	}
+	tc.Comparator = normalizeComparator(tc.Comparator)
+	if tc.SQLColumnMatch == "" {
+		tc.SQLColumnMatch = sqlColumnsExact
+	}
 	res, err := DB.Exec(`
                 UPDATE test_cases
-                   SET stdin=$1, expected_stdout=$2, weight=$3, time_limit_sec=$4,
-                       unittest_code=$5, unittest_name=$6, execution_mode=$7,
-                       function_name=$8, function_args=$9, function_kwargs=$10, function_arg_names=$11, expected_return=$12,
-                       file_name=$13, file_base64=$14, files_json=$15,
-                       updated_at=now()
-                 WHERE id=$16`,
-		tc.Stdin, tc.ExpectedStdout, tc.Weight, tc.TimeLimitSec, tc.UnittestCode, tc.UnittestName, tc.ExecutionMode,
-		tc.FunctionName, tc.FunctionArgs, tc.FunctionKwargs, tc.FunctionArgNames, tc.ExpectedReturn, tc.FileName, tc.FileBase64, tc.FilesJSON, tc.ID)
+                   SET stdin=$1, expected_stdout=$2, weight=$3, time_limit_sec=$4, memory_limit_kb=$5,
+                       unittest_code=$6, unittest_name=$7, execution_mode=$8,
+                       function_name=$9, function_args=$10, function_kwargs=$11, function_arg_names=$12, expected_return=$13,
+                       file_name=$14, file_base64=$15, files_json=$16, comparator=$17, comparator_options=$18,
+                       checker_code=$19, dialogue_script=$20, group_id=$21, is_sample=$22,
+                       sql_query=$23, sql_file=$24, sql_ordered=$25, sql_column_match=$26, notebook_target=$27,
+                       performance_config=$28, property_config=$29, output_files=$30, turtle_config=$31, updated_at=now()
+                 WHERE id=$32`,
+		tc.Stdin, tc.ExpectedStdout, tc.Weight, tc.TimeLimitSec, tc.MemoryLimitKB, tc.UnittestCode, tc.UnittestName, tc.ExecutionMode,
+		tc.FunctionName, tc.FunctionArgs, tc.FunctionKwargs, tc.FunctionArgNames, tc.ExpectedReturn, tc.FileName, tc.FileBase64, tc.FilesJSON,
+		tc.Comparator, tc.ComparatorOptions, tc.CheckerCode, tc.DialogueScript, tc.GroupID, tc.IsSample,
+		tc.SQLQuery, tc.SQLFile, tc.SQLOrdered, tc.SQLColumnMatch, tc.NotebookTarget, tc.PerformanceConfig, tc.PropertyConfig, tc.OutputFiles, tc.TurtleConfig, tc.ID)
 	if err != nil {
 		return err
 	}
@@ -1430,13 +1571,36 @@ func ListTestCases(assignmentID uuid.UUID) ([]TestCase, error) {
 	err := DB.Select(&list, `
                SELECT id, assignment_id, stdin, expected_stdout, weight, time_limit_sec, memory_limit_kb,
                       unittest_code, unittest_name, execution_mode, function_name, function_args, function_kwargs,
-                      function_arg_names, expected_return, file_name, file_base64, files_json, created_at, updated_at
+                      function_arg_names, expected_return, file_name, file_base64, files_json,
+                      comparator, comparator_options, checker_code, dialogue_script, group_id,
+                      (SELECT g.name FROM test_groups g WHERE g.id = test_cases.group_id) AS group_name,
+                      is_sample, sql_query, sql_file, sql_ordered, sql_column_match, notebook_target, performance_config,
+                      property_config, output_files, turtle_config, created_at, updated_at
                  FROM test_cases
                  WHERE assignment_id = $1
                  ORDER BY id`, assignmentID)
 	return list, err
 }
 
+// GetTestCase loads a single test case, e.g. as the base of a partial update.
+func GetTestCase(id uuid.UUID) (*TestCase, error) {
+	var tc TestCase
+	err := DB.Get(&tc, `
+               SELECT id, assignment_id, stdin, expected_stdout, weight, time_limit_sec, memory_limit_kb,
+                      unittest_code, unittest_name, execution_mode, function_name, function_args, function_kwargs,
+                      function_arg_names, expected_return, file_name, file_base64, files_json,
+                      comparator, comparator_options, checker_code, dialogue_script, group_id,
+                      (SELECT g.name FROM test_groups g WHERE g.id = test_cases.group_id) AS group_name,
+                      is_sample, sql_query, sql_file, sql_ordered, sql_column_match, notebook_target, performance_config,
+                      property_config, output_files, turtle_config, created_at, updated_at
+                 FROM test_cases
+                 WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &tc, nil
+}
+
 type TestCaseStats struct {
 	Count     int     `db:"count"`
 	WeightSum float64 `db:"weight_sum"`
@@ -1479,6 +1643,21 @@ type testFingerprint struct {
 	FileName         *string `json:"file_name,omitempty"`
 	FileBase64       *string `json:"file_base64,omitempty"`
 	FilesJSON        *string `json:"files_json,omitempty"`
+	Comparator       string  `json:"comparator"`
+	ComparatorOpts   *string `json:"comparator_options,omitempty"`
+	CheckerCode      *string `json:"checker_code,omitempty"`
+	DialogueScript   *string `json:"dialogue_script,omitempty"`
+	GroupName        *string `json:"group_name,omitempty"`
+	IsSample         bool    `json:"is_sample,omitempty"`
+	SQLQuery         *string `json:"sql_query,omitempty"`
+	SQLFile          *string `json:"sql_file,omitempty"`
+	SQLOrdered       bool    `json:"sql_ordered,omitempty"`
+	SQLColumnMatch   string  `json:"sql_column_match,omitempty"`
+	NotebookTarget   *string `json:"notebook_target,omitempty"`
+	PerformanceCfg   *string `json:"performance_config,omitempty"`
+	PropertyCfg      *string `json:"property_config,omitempty"`
+	OutputFiles      *string `json:"output_files,omitempty"`
+	TurtleCfg        *string `json:"turtle_config,omitempty"`
 }
 
 func fingerprintTests(list []TestCase) ([]string, error) {
@@ -1501,6 +1680,21 @@ func fingerprintTests(list []TestCase) ([]string, error) {
 			FileName:         t.FileName,
 			FileBase64:       t.FileBase64,
 			FilesJSON:        t.FilesJSON,
+			Comparator:       normalizeComparator(t.Comparator),
+			ComparatorOpts:   t.ComparatorOptions,
+			CheckerCode:      t.CheckerCode,
+			DialogueScript:   t.DialogueScript,
+			GroupName:        t.GroupName,
+			IsSample:         t.IsSample,
+			SQLQuery:         t.SQLQuery,
+			SQLFile:          t.SQLFile,
+			SQLOrdered:       t.SQLOrdered,
+			SQLColumnMatch:   t.SQLColumnMatch,
+			NotebookTarget:   t.NotebookTarget,
+			PerformanceCfg:   t.PerformanceConfig,
+			PropertyCfg:      t.PropertyConfig,
+			OutputFiles:      t.OutputFiles,
+			TurtleCfg:        t.TurtleConfig,
 		}
 		js, err := json.Marshal(fp)
 		if err != nil {
@@ -1556,6 +1750,17 @@ func teacherGroupCloneDiffers(src, clone *Assignment, srcTests, cloneTests []Tes
 	if src.ScratchSemanticCriteria != nil && clone.ScratchSemanticCriteria != nil && *src.ScratchSemanticCriteria != *clone.ScratchSemanticCriteria {
 		return true
 	}
+	for _, pair := range [][2]*string{
+		{src.CompileFlags, clone.CompileFlags},
+		{src.SQLSchema, clone.SQLSchema},
+		{src.SQLSeed, clone.SQLSeed},
+		{src.ConstructRules, clone.ConstructRules},
+		{src.QualityConfig, clone.QualityConfig},
+	} {
+		if (pair[0] == nil) != (pair[1] == nil) || (pair[0] != nil && *pair[0] != *pair[1]) {
+			return true
+		}
+	}
 
 	if len(srcTests) != len(cloneTests) {
 		return true
@@ -1588,6 +1793,7 @@ func NeedsTeacherGroupSync(sourceID uuid.UUID) (bool, []AssignmentClone, error)
 		return false, clones, err
 	}
 	srcTests, _ := ListTestCases(sourceID)
+	srcGroups, _ := ListTestGroups(sourceID)
 	for _, cl := range clones {
 		dst, err := GetAssignment(cl.ClonedAssignmentID)
 		if err != nil {
@@ -1597,6 +1803,13 @@ func NeedsTeacherGroupSync(sourceID uuid.UUID) (bool, []AssignmentClone, error)
 		if teacherGroupCloneDiffers(src, dst, srcTests, dstTests) {
 			return true, clones, nil
 		}
+		dstGroups, _ := ListTestGroups(cl.ClonedAssignmentID)
+		if testGroupsDiffer(srcGroups, dstGroups) {
+			return true, clones, nil
+		}
+		if differs, err := referenceSolutionsDiffer(sourceID, cl.ClonedAssignmentID); err != nil || differs {
+			return true, clones, err
+		}
 	}
 	return false, clones, nil
 }
@@ -1615,6 +1828,11 @@ type Result struct {
 	Stderr             string    `db:"stderr" json:"stderr"`
 	ExitCode           int       `db:"exit_code" json:"exit_code"`
 	RuntimeMS          int       `db:"runtime_ms" json:"runtime_ms"`
+	PeakMemoryKB       *int      `db:"peak_memory_kb" json:"peak_memory_kb,omitempty"`
+	Score              *float64  `db:"score" json:"score,omitempty"`
+	CheckerMessage     *string   `db:"checker_message" json:"checker_message,omitempty"`
+	FileDiffs          *string   `db:"file_diffs" json:"file_diffs,omitempty"`
+	Artifacts          *string   `db:"artifacts" json:"artifacts,omitempty"`
 	Stdin              *string   `db:"stdin" json:"stdin,omitempty"`
 	ExpectedStdout     *string   `db:"expected_stdout" json:"expected_stdout,omitempty"`
 	UnittestCode       *string   `db:"unittest_code" json:"unittest_code,omitempty"`
@@ -1630,6 +1848,28 @@ type Result struct {
 	CreatedAt          time.Time `db:"created_at" json:"created_at"`
 }
 
+// ResultArtifact is a file made while grading a test, such as a rendered
+// drawing. Results store a JSON list of them.
+type ResultArtifact struct {
+	Name        string `json:"name"`
+	Label       string `json:"label,omitempty"`
+	ContentType string `json:"content_type"`
+	Data        string `json:"data"` // base64
+}
+
+// artifactsJSON encodes artifacts for results.artifacts, nil when empty.
+func artifactsJSON(list []ResultArtifact) *string {
+	if len(list) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
+
 // LLMRun stores artifacts from an LLM-interactive testing run for a submission.
 type LLMRun struct {
 	ID              uuid.UUID `db:"id" json:"id"`
@@ -1695,10 +1935,10 @@ func UpdateSubmissionStatus(id uuid.UUID, status string) error {
 
 func CreateResult(r *Result) error {
 	const q = `
-        INSERT INTO results (submission_id, test_case_id, status, actual_stdout, stderr, exit_code, runtime_ms, actual_return)
-        VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+        INSERT INTO results (submission_id, test_case_id, status, actual_stdout, stderr, exit_code, runtime_ms, actual_return, peak_memory_kb, score, checker_message, file_diffs, artifacts)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
         RETURNING id, created_at`
-	err := DB.QueryRow(q, r.SubmissionID, r.TestCaseID, r.Status, r.ActualStdout, r.Stderr, r.ExitCode, r.RuntimeMS, r.ActualReturn).
+	err := DB.QueryRow(q, r.SubmissionID, r.TestCaseID, r.Status, r.ActualStdout, r.Stderr, r.ExitCode, r.RuntimeMS, r.ActualReturn, r.PeakMemoryKB, r.Score, r.CheckerMessage, r.FileDiffs, r.Artifacts).
 		Scan(&r.ID, &r.CreatedAt)
 	if err == nil {
 		if num, nerr := lookupTestNumber(r.TestCaseID); nerr == nil {
@@ -1757,7 +1997,7 @@ func ListResultsForSubmission(subID uuid.UUID) ([]Result, error) {
              WHERE assignment_id = (SELECT assignment_id FROM sub)
         )
         SELECT r.id, r.submission_id, r.test_case_id, r.status, r.actual_stdout, r.stderr,
-               r.exit_code, r.runtime_ms, r.created_at,
+               r.exit_code, r.runtime_ms, r.peak_memory_kb, r.score, r.checker_message, r.file_diffs, r.artifacts, r.created_at,
                ot.stdin, ot.expected_stdout, ot.unittest_code, ot.unittest_name,
                ot.execution_mode, ot.function_name, ot.function_args, ot.function_kwargs, ot.expected_return,
                r.actual_return, r.failure_explanation,
@@ -1794,6 +2034,46 @@ func SetSubmissionScratchAnalysis(id uuid.UUID, analysis *string) error {
 	return err
 }
 
+// SetSubmissionNotebook stores the executed cells of a notebook submission.
+func SetSubmissionNotebook(id uuid.UUID, cells *string) error {
+	_, err := DB.Exec(`UPDATE submissions SET executed_notebook=$1, updated_at=now() WHERE id=$2`, cells, id)
+	return err
+}
+
+// GetSubmissionNotebook returns the executed cells of a notebook submission.
+func GetSubmissionNotebook(id uuid.UUID) (*string, error) {
+	var cells *string
+	err := DB.Get(&cells, `SELECT executed_notebook FROM submissions WHERE id=$1`, id)
+	return cells, err
+}
+
+// SetSubmissionConstructFindings stores the construct rules a submission
+// violated (nil when it satisfied all of them).
+func SetSubmissionConstructFindings(id uuid.UUID, findings *string) error {
+	_, err := DB.Exec(`UPDATE submissions SET construct_findings=$1, updated_at=now() WHERE id=$2`, findings, id)
+	return err
+}
+
+// GetSubmissionConstructFindings returns the stored construct rule violations.
+func GetSubmissionConstructFindings(id uuid.UUID) (*string, error) {
+	var findings *string
+	err := DB.Get(&findings, `SELECT construct_findings FROM submissions WHERE id=$1`, id)
+	return findings, err
+}
+
+// SetSubmissionQualityReport stores the code-quality score and findings.
+func SetSubmissionQualityReport(id uuid.UUID, report *string) error {
+	_, err := DB.Exec(`UPDATE submissions SET quality_report=$1, updated_at=now() WHERE id=$2`, report, id)
+	return err
+}
+
+// GetSubmissionQualityReport returns the stored code-quality report.
+func GetSubmissionQualityReport(id uuid.UUID) (*string, error) {
+	var report *string
+	err := DB.Get(&report, `SELECT quality_report FROM submissions WHERE id=$1`, id)
+	return report, err
+}
+
 func SetSubmissionScratchSemanticAnalysis(id uuid.UUID, analysis *string) error {
 	_, err := DB.Exec(`UPDATE submissions SET scratch_semantic_analysis=$1, updated_at=now() WHERE id=$2`, analysis, id)
 	return err
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql/driver"
 	"regexp"
 	"testing"
 	"time"
@@ -133,18 +134,22 @@ func TestCreateTestCaseDefaultsFunctionMode(t *testing.T) {
 	expected := "6"
 	now := time.Now()
 
-	rows := sqlmock.NewRows([]string{"id", "weight", "time_limit_sec", "memory_limit_kb", "unittest_code", "unittest_name", "execution_mode", "function_name", "function_args", "function_kwargs", "function_arg_names", "expected_return", "file_name", "file_base64", "files_json", "created_at", "updated_at"}).
-		AddRow(uuid.New().String(), 1.0, 1.0, 0, nil, nil, "function", fn, args, kwargs, nil, expected, nil, nil, nil, now, now)
+	rows := sqlmock.NewRows([]string{"id", "weight", "time_limit_sec", "memory_limit_kb", "unittest_code", "unittest_name", "execution_mode", "function_name", "function_args", "function_kwargs", "function_arg_names", "expected_return", "file_name", "file_base64", "files_json", "comparator", "comparator_options", "checker_code", "dialogue_script", "group_id", "is_sample", "sql_query", "sql_file", "sql_ordered", "sql_column_match", "notebook_target", "performance_config", "property_config", "output_files", "turtle_config", "created_at", "updated_at"}).
+		AddRow(uuid.New().String(), 1.0, 1.0, 0, nil, nil, "function", fn, args, kwargs, nil, expected, nil, nil, nil, "exact", nil, nil, nil, nil, false, nil, nil, false, "exact", nil, nil, nil, nil, nil, now, now)
 
 	insertRE := regexp.QuoteMeta(`
          INSERT INTO test_cases (assignment_id, stdin, expected_stdout, weight, time_limit_sec, memory_limit_kb, unittest_code, unittest_name,
-                                 execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json)
-         VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)
+                                 execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json,
+                                 comparator, comparator_options, checker_code, dialogue_script, group_id, is_sample,
+                                 sql_query, sql_file, sql_ordered, sql_column_match, notebook_target, performance_config, property_config, output_files, turtle_config)
+         VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32)
          RETURNING id, weight, time_limit_sec, memory_limit_kb, unittest_code, unittest_name,
-                   execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json, created_at, updated_at`)
+                   execution_mode, function_name, function_args, function_kwargs, function_arg_names, expected_return, file_name, file_base64, files_json,
+                   comparator, comparator_options, checker_code, dialogue_script, group_id, is_sample,
+                   sql_query, sql_file, sql_ordered, sql_column_match, notebook_target, performance_config, property_config, output_files, turtle_config, created_at, updated_at`)
 
 	mock.ExpectQuery(insertRE).
-		WithArgs(assignmentID, "", "", 1.0, 1.0, 65536, nil, nil, "function", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil).
+		WithArgs(assignmentID, "", "", 1.0, 1.0, 65536, nil, nil, "function", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, "exact", nil, nil, nil, nil, false, nil, nil, false, "exact", nil, nil, nil, nil, nil).
 		WillReturnRows(rows)
 
 	tc := &TestCase{AssignmentID: assignmentID, Weight: 1}
@@ -167,3 +172,51 @@ func TestCreateTestCaseDefaultsFunctionMode(t *testing.T) {
 		t.Fatalf("unmet expectations: %v", err)
 	}
 }
+
+func TestCopyTestCasesRemapsGroups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	DB = sqlx.NewDb(db, "sqlmock")
+
+	srcID, dstID := uuid.New(), uuid.New()
+	basics, advanced := uuid.New(), uuid.New()
+	newBasics, newAdvanced := uuid.New(), uuid.New()
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT id, assignment_id, name, points, policy, depends_on, position, created_at, updated_at\s+FROM test_groups`).
+		WithArgs(srcID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "assignment_id", "name", "points", "policy", "depends_on", "position", "created_at", "updated_at"}).
+			AddRow(basics.String(), srcID.String(), "basics", 4.0, "all", "{}", 0, now, now).
+			AddRow(advanced.String(), srcID.String(), "advanced", 6.0, "proportional", "{"+basics.String()+"}", 1, now, now))
+	mock.ExpectQuery(`INSERT INTO test_groups`).WithArgs(dstID, "basics", 4.0, "all", sqlmock.AnyArg(), 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(newBasics.String(), now, now))
+	mock.ExpectQuery(`INSERT INTO test_groups`).WithArgs(dstID, "advanced", 6.0, "proportional", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(newAdvanced.String(), now, now))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE test_groups SET depends_on=$1 WHERE id=$2`)).
+		WithArgs(`{"`+newBasics.String()+`"}`, newAdvanced).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT\s+.*\s+FROM test_cases\s+WHERE assignment_id = \$1`).
+		WithArgs(srcID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "assignment_id", "stdin", "expected_stdout", "weight", "time_limit_sec", "memory_limit_kb", "execution_mode", "comparator", "group_id", "group_name", "is_sample", "sql_column_match", "created_at", "updated_at"}).
+			AddRow(uuid.New().String(), srcID.String(), "1 2", "3", 1.0, 1.0, 65536, "stdin_stdout", "exact", advanced.String(), "advanced", false, "exact", now, now))
+
+	args := make([]driver.Value, 32)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[0], args[21] = dstID, newAdvanced
+	mock.ExpectQuery(`INSERT INTO test_cases`).WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "weight", "time_limit_sec", "memory_limit_kb", "unittest_code", "unittest_name", "execution_mode", "function_name", "function_args", "function_kwargs", "function_arg_names", "expected_return", "file_name", "file_base64", "files_json", "comparator", "comparator_options", "checker_code", "dialogue_script", "group_id", "is_sample", "sql_query", "sql_file", "sql_ordered", "sql_column_match", "notebook_target", "performance_config", "property_config", "output_files", "turtle_config", "created_at", "updated_at"}).
+			AddRow(uuid.New().String(), 1.0, 1.0, 65536, nil, nil, "stdin_stdout", nil, nil, nil, nil, nil, nil, nil, nil, "exact", nil, nil, nil, newAdvanced.String(), false, nil, nil, false, "exact", nil, nil, nil, nil, nil, now, now))
+
+	if err := copyTestCases(srcID, dstID); err != nil {
+		t.Fatalf("copyTestCases returned error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
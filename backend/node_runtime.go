@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// JavaScript submissions run on Node. Function tests load the entry file as a
+// CommonJS module (without running its require.main block) and call a
+// top-level function or export, mirroring runFunctionCall for Python.
+
+var nodeBinary = getenvOr("NODE_BIN", "node")
+
+const (
+	nodeFunctionRunner = "__fncall__.js"
+	nodeFunctionConfig = "__fncall__.json"
+)
+
+var jsFunctionPath = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*)*$`)
+
+const nodeFunctionRunnerSource = `'use strict';
+const fs = require('fs');
+const path = require('path');
+const util = require('util');
+const vm = require('vm');
+const { createRequire } = require('module');
+
+const MARKER = '===GRADER_JSON===';
+const cfg = JSON.parse(fs.readFileSync(path.join(__dirname, '__fncall__.json'), 'utf8'));
+const result = { status: 'ok', passed: false };
+
+let captured = '';
+const realWrite = process.stdout.write.bind(process.stdout);
+process.stdout.write = (chunk, encoding, cb) => {
+  captured += typeof chunk === 'string' ? chunk : Buffer.from(chunk).toString();
+  if (typeof encoding === 'function') encoding();
+  else if (typeof cb === 'function') cb();
+  return true;
+};
+
+function canonical(value) {
+  if (Array.isArray(value)) return value.map(canonical);
+  if (value && typeof value === 'object') {
+    const out = {};
+    for (const key of Object.keys(value).sort()) out[key] = canonical(value[key]);
+    return out;
+  }
+  if (typeof value === 'string') return value.replace(/[\r\n]+$/, '');
+  return value;
+}
+
+function toJSON(value) {
+  try {
+    const text = JSON.stringify(value);
+    return text === undefined ? null : text;
+  } catch (e) {
+    return null;
+  }
+}
+
+function finish(code) {
+  result.stdout = captured;
+  process.stdout.write = realWrite;
+  realWrite(MARKER + JSON.stringify(result) + '\n', () => process.exit(code));
+}
+
+async function main() {
+  const file = path.resolve(__dirname, cfg.module_path);
+  process.chdir(path.dirname(file));
+  const parts = cfg.function_name.split('.');
+  const source = fs.readFileSync(file, 'utf8').replace(/^#!.*/, '');
+  const wrapper = vm.runInThisContext(
+    '(function (exports, require, module, __filename, __dirname) {' + source +
+      '\n;return typeof ' + parts[0] + ' === "undefined" ? undefined : ' + parts[0] + ';\n})',
+    { filename: file });
+  const mod = { exports: {}, filename: file, id: file, loaded: false };
+  const local = wrapper.call(mod.exports, mod.exports, createRequire(file), mod, file, path.dirname(file));
+  let target = local !== undefined ? local : (mod.exports || {})[parts[0]];
+  for (const part of parts.slice(1)) {
+    target = target == null ? undefined : target[part];
+  }
+  if (typeof target !== 'function') {
+    throw new TypeError(cfg.function_name + ' is not a function');
+  }
+  const value = await Promise.resolve(target(...(cfg.args || [])));
+  result.passed = true;
+  if (Object.prototype.hasOwnProperty.call(cfg, 'expected')) {
+    const actual = JSON.stringify(canonical(value));
+    const expected = JSON.stringify(canonical(cfg.expected));
+    result.passed = actual === expected;
+    result.expected_json = toJSON(cfg.expected);
+    result.expected_repr = util.inspect(cfg.expected, { depth: 6 });
+    if (!result.passed) {
+      result.comparison_debug = {
+        actual_type: Array.isArray(value) ? 'array' : typeof value,
+        actual_repr: util.inspect(value, { depth: 6 }),
+        expected_type: Array.isArray(cfg.expected) ? 'array' : typeof cfg.expected,
+        expected_repr: result.expected_repr,
+      };
+    }
+  }
+  result.return_repr = util.inspect(value, { depth: 6 });
+  result.return_json = toJSON(value);
+}
+
+main().then(
+  () => finish(result.passed ? 0 : 1),
+  (err) => {
+    result.status = 'exception';
+    result.exception = String(err);
+    result.traceback = (err && err.stack) || String(err);
+    finish(2);
+  });
+`
+
+type nodeRuntime struct {
+	noUnitTests
+}
+
+func (nodeRuntime) modes() []string {
+	return []string{"stdin_stdout", "checker", "dialogue", "performance", "property", "function"}
+}
+
+func (nodeRuntime) compiled() bool { return false }
+
+func (nodeRuntime) entryFile(dir string) (string, error) {
+	sources, err := findSources(dir, ".js")
+	if err != nil {
+		return "", err
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("no JavaScript files found")
+	}
+	for _, preferred := range []string{"main.js", "index.js"} {
+		for _, src := range sources {
+			if src == preferred {
+				return src, nil
+			}
+		}
+	}
+	return sources[0], nil
+}
+
+func (nodeRuntime) build(*vmSession, string, *Assignment) error { return nil }
+
+// nodeCommand runs a script under the memory guard. Like the JVM, V8 reserves
+// far more address space than it uses, so the limit caps the heap instead.
+func nodeCommand(remoteDir, script string, memoryLimitKB int) string {
+	argv := []string{nodeBinary}
+	if memoryLimitKB > 0 {
+		argv = append(argv, fmt.Sprintf("--max-old-space-size=%d", max(memoryLimitKB/1024, 1)))
+	}
+	argv = append(argv, script)
+	return "HOME=/tmp LANG=C.UTF-8 " + memoryGuardedExec(remoteDir, memoryLimitKB, false, argv...)
+}
+
+func (nodeRuntime) command(remoteDir, entry string, memoryLimitKB int) string {
+	return nodeCommand(remoteDir, filepath.Join(remoteDir, entry), memoryLimitKB)
+}
+
+func (r nodeRuntime) runStdin(sess *vmSession, dir, entry, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	return runGuardedStdin(r, sess, dir, entry, stdin, timeout, memoryLimitKB)
+}
+
+func (nodeRuntime) runFunction(sess *vmSession, dir, entry string, cfg functionCallConfig, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage, *functionCallResult, error) {
+	fail := func(err error) (string, string, int, bool, time.Duration, memoryUsage, *functionCallResult, error) {
+		return "", "", 0, false, 0, memoryUsage{}, nil, err
+	}
+	if !jsFunctionPath.MatchString(cfg.FunctionName) {
+		return fail(fmt.Errorf("invalid function name %q", cfg.FunctionName))
+	}
+	if cfg.BatchJSON != nil {
+		return fail(errors.New("property tests of functions are not supported for JavaScript"))
+	}
+	payload := map[string]any{
+		"module_path":   filepath.ToSlash(entry),
+		"function_name": cfg.FunctionName,
+	}
+	if cfg.ArgsJSON != nil && strings.TrimSpace(*cfg.ArgsJSON) != "" {
+		var args []any
+		if err := json.Unmarshal([]byte(*cfg.ArgsJSON), &args); err != nil {
+			return fail(fmt.Errorf("invalid function args JSON: %w", err))
+		}
+		payload["args"] = args
+	}
+	if cfg.KwargsJSON != nil && strings.TrimSpace(*cfg.KwargsJSON) != "" {
+		var kwargs map[string]any
+		if err := json.Unmarshal([]byte(*cfg.KwargsJSON), &kwargs); err != nil {
+			return fail(fmt.Errorf("invalid function kwargs JSON: %w", err))
+		}
+		if len(kwargs) > 0 {
+			return fail(errors.New("keyword arguments are not supported for JavaScript functions"))
+		}
+	}
+	if cfg.ExpectedJSON != nil && strings.TrimSpace(*cfg.ExpectedJSON) != "" {
+		var expected any
+		if err := json.Unmarshal([]byte(*cfg.ExpectedJSON), &expected); err != nil {
+			return fail(fmt.Errorf("invalid expected return JSON: %w", err))
+		}
+		payload["expected"] = expected
+	}
+	cfgBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, nodeFunctionConfig), cfgBytes, 0644); err != nil {
+		return fail(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, nodeFunctionRunner), []byte(nodeFunctionRunnerSource), 0644); err != nil {
+		return fail(err)
+	}
+	if err := writeMemoryGuard(dir); err != nil {
+		return fail(err)
+	}
+	_ = ensureSandboxPerms(dir)
+
+	out, errOut, exitCode, timedOut, runtime, usage := runTimedInVM(sess, dir, "", timeout, func(remoteDir string) string {
+		return nodeCommand(remoteDir, filepath.Join(remoteDir, nodeFunctionRunner), memoryLimitKB)
+	})
+	out, meta := splitFunctionCallOutput(out)
+	if meta != nil && meta.Stdout != "" {
+		out = meta.Stdout
+	}
+	return out, errOut, exitCode, timedOut, runtime, usage, meta, nil
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Jupyter submissions are graded from the notebook's code cells. build
+// extracts the cells once; the notebook harness then executes them in order
+// in one namespace, like "Run All" in Jupyter, recording every cell's output.
+// Tests call functions the notebook defines, inspect variables it leaves
+// behind or compare the output of cells carrying a given tag.
+
+const (
+	notebookCellsFile  = "__nbcells__.json"
+	notebookModuleFile = "__notebook__.py"
+	notebookRunnerFile = "__nbrun__.py"
+	notebookConfigFile = "__nbrun__.json"
+	notebookMarker     = "===NOTEBOOK_JSON==="
+)
+
+// notebookRunTimeout bounds the run that records the executed notebook of a
+// submission; it fits in the compile budget of the grading session.
+var notebookRunTimeout = getenvDurationOr("NOTEBOOK_RUN_TIMEOUT", 30*time.Second)
+
+// displayShim stands in for IPython's display() outside a kernel.
+const displayShim = `def display(*objs, **kwargs):
+    for obj in objs:
+        print(obj if isinstance(obj, str) else repr(obj))
+`
+
+const notebookRunnerScript = `import ast
+import builtins
+import contextlib
+import io
+import json
+import math
+import os
+import pathlib
+import sys
+import time
+import traceback
+
+MARKER = "===NOTEBOOK_JSON==="
+HERE = pathlib.Path(__file__).resolve().parent
+NOTEBOOK = json.loads((HERE / "__nbcells__.json").read_text(encoding="utf-8"))
+CFG = json.loads((HERE / "__nbrun__.json").read_text(encoding="utf-8"))
+LIMIT = 20000
+
+` + displayShim + `
+
+def clip(text):
+    return text if len(text) <= LIMIT else text[:LIMIT] + "\n... (output truncated)"
+
+
+def run_cell(cell, ns):
+    name = "<cell %d>" % cell["index"]
+    out, err = io.StringIO(), io.StringIO()
+    record = {"index": cell["index"], "tags": cell.get("tags") or []}
+    start = time.monotonic()
+    try:
+        with contextlib.redirect_stdout(out), contextlib.redirect_stderr(err):
+            tree = ast.parse(cell["source"], filename=name)
+            last = None
+            if tree.body and isinstance(tree.body[-1], ast.Expr):
+                last = ast.Expression(tree.body.pop().value)
+            exec(compile(tree, name, "exec"), ns)
+            if last is not None:
+                value = eval(compile(last, name, "eval"), ns)
+                if value is not None:
+                    ns["_"] = value
+                    record["result"] = clip(repr(value))
+    except BaseException as exc:  # noqa: BLE001
+        if isinstance(exc, MemoryError):
+            sys.stderr.write("MemoryError\n")
+        tb = exc.__traceback__.tb_next or exc.__traceback__
+        record["error"] = {
+            "ename": type(exc).__name__,
+            "evalue": str(exc),
+            "traceback": "".join(traceback.format_exception(type(exc), exc, tb)),
+        }
+    record["stdout"] = clip(out.getvalue())
+    record["stderr"] = clip(err.getvalue())
+    record["duration_ms"] = int((time.monotonic() - start) * 1000)
+    return record
+
+
+def same(actual, expected):
+    if isinstance(expected, bool) or isinstance(actual, bool):
+        return isinstance(actual, bool) and isinstance(expected, bool) and actual == expected
+    if isinstance(expected, (int, float)) and isinstance(actual, (int, float)):
+        return math.isclose(actual, expected, rel_tol=1e-9, abs_tol=1e-9)
+    if isinstance(expected, list) and isinstance(actual, (list, tuple)):
+        return len(actual) == len(expected) and all(same(a, e) for a, e in zip(actual, expected))
+    if isinstance(expected, dict) and isinstance(actual, dict):
+        keyed = {str(k): v for k, v in actual.items()}
+        return keyed.keys() == expected.keys() and all(same(keyed[k], expected[k]) for k in expected)
+    return actual == expected
+
+
+def main():
+    workdir = HERE / NOTEBOOK["dir"]
+    os.chdir(workdir)
+    sys.path.insert(0, str(workdir))
+    ns = {"__name__": "__main__", "__builtins__": builtins, "display": display}
+    mode, target = CFG.get("mode", "run"), CFG.get("target", "")
+    cells = NOTEBOOK["cells"]
+    result = {"status": "ok", "cells": []}
+    stop = None
+    if mode == "cell":
+        tagged = [c["index"] for c in cells if target in (c.get("tags") or [])]
+        if not tagged:
+            result.update(status="missing", error="no code cell is tagged %r" % target)
+            cells = []
+        else:
+            stop = tagged[-1]
+    for cell in cells:
+        record = run_cell(cell, ns)
+        result["cells"].append(record)
+        if "error" in record:
+            result.update(status="error", error=record["error"]["traceback"])
+            break
+        if cell["index"] == stop:
+            break
+    if result["status"] == "ok" and mode == "cell":
+        parts = []
+        for record in result["cells"]:
+            if target in record["tags"]:
+                parts.append(record["stdout"])
+                if "result" in record:
+                    parts.append(record["result"] + "\n")
+        result["output"] = "".join(parts)
+    elif result["status"] == "ok" and mode == "variable":
+        if target not in ns:
+            result.update(status="missing", error="variable %r is not defined" % target)
+        else:
+            value = ns[target]
+            result["value_repr"] = clip(repr(value))
+            try:
+                result["value_json"] = json.dumps(value, sort_keys=True)
+            except (TypeError, ValueError):
+                pass
+            if "expected" in CFG:
+                result["passed"] = bool(same(value, CFG["expected"]))
+    sys.stdout = sys.__stdout__
+    print(MARKER + json.dumps(result))
+    sys.exit(0 if result["status"] == "ok" else 1)
+
+
+if __name__ == "__main__":
+    main()
+`
+
+// notebookCell is one code cell extracted from a notebook. Index counts code
+// cells from 1, like execution counts after "Run All".
+type notebookCell struct {
+	Index  int      `json:"index"`
+	Tags   []string `json:"tags,omitempty"`
+	Source string   `json:"source"`
+}
+
+// parseNotebook extracts the code cells of an .ipynb document. IPython magics
+// and shell escapes have no meaning outside a kernel and are disabled.
+func parseNotebook(data []byte) ([]notebookCell, error) {
+	var doc struct {
+		Cells []struct {
+			CellType string          `json:"cell_type"`
+			Source   json.RawMessage `json:"source"`
+			Metadata struct {
+				Tags []string `json:"tags"`
+			} `json:"metadata"`
+		} `json:"cells"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid notebook: %v", err)
+	}
+	var cells []notebookCell
+	for _, c := range doc.Cells {
+		if c.CellType != "code" {
+			continue
+		}
+		src, err := notebookSource(c.Source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notebook: cell %d: %v", len(cells)+1, err)
+		}
+		cells = append(cells, notebookCell{Index: len(cells) + 1, Tags: c.Metadata.Tags, Source: disableMagics(src)})
+	}
+	return cells, nil
+}
+
+// notebookSource decodes a cell source, stored either as one string or as a
+// list of lines.
+func notebookSource(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return "", errors.New("source must be a string or a list of strings")
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// disableMagics turns line magics and shell escapes into no-ops. Cell magics
+// other than timing ones disable the whole cell.
+func disableMagics(src string) string {
+	lines := strings.Split(src, "\n")
+	if len(lines) > 0 && strings.HasPrefix(strings.TrimSpace(lines[0]), "%%") {
+		magic := strings.Fields(strings.TrimSpace(lines[0]))[0]
+		if magic != "%%time" && magic != "%%timeit" {
+			return "# " + strings.Join(lines, "\n# ") + "\npass"
+		}
+		lines[0] = "# " + lines[0]
+	}
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "!") {
+			indent := line[:len(line)-len(trimmed)]
+			lines[i] = indent + "pass  # " + trimmed
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// notebookModule is the generated module holding all code cells, placed next
+// to the notebook so relative imports and file paths keep working.
+func notebookModule(entry string) string {
+	return path.Join(path.Dir(filepath.ToSlash(entry)), notebookModuleFile)
+}
+
+var notebookVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateNotebookTest checks the target of a notebook_variable or
+// notebook_cell test and returns it trimmed.
+func validateNotebookTest(mode string, target, expected *string) (string, error) {
+	name := strings.TrimSpace(stringOrEmpty(target))
+	if name == "" {
+		return "", errors.New("notebook_target is required")
+	}
+	if mode == "notebook_variable" {
+		if !notebookVariableName.MatchString(name) {
+			return "", errors.New("notebook_target must be a variable name")
+		}
+		if expected != nil && !isEmptyOrJSON(*expected) {
+			return "", errors.New("expected_return must be valid JSON")
+		}
+	}
+	return name, nil
+}
+
+type notebookRuntime struct {
+	noUnitTests
+}
+
+func (notebookRuntime) modes() []string {
+	return []string{"stdin_stdout", "checker", "dialogue", "function", "notebook_variable", "notebook_cell"}
+}
+
+// compiled is true so build extracts the code cells before the tests run.
+func (notebookRuntime) compiled() bool { return true }
+
+func (notebookRuntime) entryFile(dir string) (string, error) {
+	sources, err := findSources(dir, ".ipynb")
+	if err != nil {
+		return "", err
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("no Jupyter notebook found")
+	}
+	for _, src := range sources {
+		if path.Base(src) == "main.ipynb" {
+			return src, nil
+		}
+	}
+	return sources[0], nil
+}
+
+// build writes the extracted cells for the harness and the generated module
+// used by the other test modes. A notebook that cannot be parsed does not
+// build.
+func (r notebookRuntime) build(_ *vmSession, dir string, _ *Assignment) error {
+	entry, err := r.entryFile(dir)
+	if err != nil {
+		return &compileError{Diagnostics: err.Error()}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, entry))
+	if err != nil {
+		return err
+	}
+	cells, err := parseNotebook(data)
+	if err != nil {
+		return &compileError{Diagnostics: err.Error()}
+	}
+	payload, err := json.Marshal(map[string]any{"dir": path.Dir(entry), "cells": cells})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, notebookCellsFile), payload, 0644); err != nil {
+		return err
+	}
+	var module strings.Builder
+	module.WriteString(displayShim)
+	for _, c := range cells {
+		fmt.Fprintf(&module, "\n# In[%d]:\n%s\n", c.Index, c.Source)
+	}
+	return os.WriteFile(filepath.Join(dir, filepath.FromSlash(notebookModule(entry))), []byte(module.String()), 0644)
+}
+
+func (notebookRuntime) command(remoteDir, entry string, memoryLimitKB int) string {
+	return pythonRuntime{}.command(remoteDir, notebookModule(entry), memoryLimitKB)
+}
+
+func (notebookRuntime) runStdin(sess *vmSession, dir, entry, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	return executePythonDir(sess, dir, notebookModule(entry), stdin, timeout, memoryLimitKB)
+}
+
+func (notebookRuntime) runFunction(sess *vmSession, dir, entry string, cfg functionCallConfig, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage, *functionCallResult, error) {
+	return runFunctionCall(sess, dir, notebookModule(entry), cfg, timeout, memoryLimitKB)
+}
+
+// notebookCellRun is the recorded execution of one code cell.
+type notebookCellRun struct {
+	Index      int                `json:"index"`
+	Tags       []string           `json:"tags"`
+	Stdout     string             `json:"stdout"`
+	Stderr     string             `json:"stderr"`
+	Result     *string            `json:"result,omitempty"`
+	Error      *notebookCellError `json:"error,omitempty"`
+	DurationMS int                `json:"duration_ms"`
+}
+
+type notebookCellError struct {
+	EName     string `json:"ename"`
+	EValue    string `json:"evalue"`
+	Traceback string `json:"traceback"`
+}
+
+// notebookRun is what the harness reports.
+type notebookRun struct {
+	Status    string            `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	Output    string            `json:"output,omitempty"`
+	ValueRepr string            `json:"value_repr,omitempty"`
+	ValueJSON *string           `json:"value_json,omitempty"`
+	Passed    *bool             `json:"passed,omitempty"`
+	Cells     []notebookCellRun `json:"cells"`
+}
+
+// notebookTestRun is the outcome of one harness run.
+type notebookTestRun struct {
+	Result   notebookRun
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Runtime  time.Duration
+	Memory   memoryUsage
+	Err      error
+}
+
+// runNotebook executes the notebook in dir through the harness. mode is
+// "run", "variable" or "cell"; expected is the JSON a variable must equal.
+func runNotebook(sess *vmSession, dir, mode, target string, expected *string, stdin string, timeout time.Duration, memoryLimitKB int) notebookTestRun {
+	cfg := map[string]any{"mode": mode, "target": target}
+	if expected != nil && strings.TrimSpace(*expected) != "" {
+		var v any
+		if err := json.Unmarshal([]byte(*expected), &v); err != nil {
+			return notebookTestRun{ExitCode: -1, Err: fmt.Errorf("invalid expected return JSON: %w", err)}
+		}
+		cfg["expected"] = v
+	}
+	cfgBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return notebookTestRun{ExitCode: -1, Err: err}
+	}
+	files := map[string][]byte{
+		notebookRunnerFile: []byte(notebookRunnerScript),
+		notebookConfigFile: cfgBytes,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return notebookTestRun{ExitCode: -1, Err: fmt.Errorf("write %s: %w", name, err)}
+		}
+	}
+	if err := writeMemoryGuard(dir); err != nil {
+		return notebookTestRun{ExitCode: -1, Err: err}
+	}
+	_ = ensureSandboxPerms(dir)
+
+	run := notebookTestRun{}
+	var out string
+	out, run.Stderr, run.ExitCode, run.TimedOut, run.Runtime, run.Memory = runTimedInVM(sess, dir, stdin, timeout, func(remoteDir string) string {
+		return pythonRuntime{}.command(remoteDir, notebookRunnerFile, memoryLimitKB)
+	})
+	idx := strings.LastIndex(out, notebookMarker)
+	if idx == -1 {
+		return run
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out[idx+len(notebookMarker):])), &run.Result); err != nil {
+		run.Err = fmt.Errorf("invalid notebook runner output: %w", err)
+		return run
+	}
+	switch mode {
+	case "cell":
+		run.Stdout = run.Result.Output
+	case "variable":
+		run.Stdout = run.Result.ValueRepr
+	}
+	if run.Result.Error != "" {
+		run.Stderr = strings.TrimSpace(run.Result.Error + "\n" + run.Stderr)
+	}
+	return run
+}
+
+// runNotebookTest runs a notebook_variable or notebook_cell test.
+func runNotebookTest(sess *vmSession, dir string, tc TestCase, timeout time.Duration) notebookTestRun {
+	target := strings.TrimSpace(stringOrEmpty(tc.NotebookTarget))
+	if tc.ExecutionMode == "notebook_cell" {
+		return runNotebook(sess, dir, "cell", target, nil, tc.Stdin, timeout, tc.MemoryLimitKB)
+	}
+	return runNotebook(sess, dir, "variable", target, tc.ExpectedReturn, tc.Stdin, timeout, tc.MemoryLimitKB)
+}
+
+// notebookStatus grades a notebook test run.
+func notebookStatus(run notebookTestRun, tc TestCase) string {
+	switch {
+	case run.TimedOut:
+		return "time_limit_exceeded"
+	case run.Memory.LimitExceeded:
+		return "memory_limit_exceeded"
+	case run.Err != nil || run.Result.Status != "ok" || run.ExitCode != 0:
+		return "runtime_error"
+	}
+	if tc.ExecutionMode == "notebook_cell" {
+		actual := normalizeActualStdout(trimTrailingNewline(run.Stdout))
+		if !stdoutMatches(tc, actual, normalizeExpectedStdout(trimTrailingNewline(tc.ExpectedStdout))) {
+			return "wrong_output"
+		}
+		return "passed"
+	}
+	if run.Result.Passed != nil && !*run.Result.Passed {
+		return "wrong_output"
+	}
+	return "passed"
+}
+
+// recordExecutedNotebook runs the whole notebook once so teachers can review
+// every cell's output next to the test results.
+func recordExecutedNotebook(sess *vmSession, dir string, subID uuid.UUID, memoryLimitKB int) {
+	run := runNotebook(sess, dir, "run", "", nil, "", notebookRunTimeout, memoryLimitKB)
+	if run.Err != nil || run.Result.Cells == nil {
+		fmt.Printf("[notebook] could not record executed notebook of %s: %v %s\n", subID, run.Err, strings.TrimSpace(run.Stderr))
+		return
+	}
+	data, err := json.Marshal(run.Result.Cells)
+	if err != nil {
+		return
+	}
+	executed := string(data)
+	if err := SetSubmissionNotebook(subID, &executed); err != nil {
+		fmt.Printf("[notebook] store executed notebook of %s: %v\n", subID, err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleNotebook = `{
+ "nbformat": 4,
+ "metadata": {},
+ "cells": [
+  {"cell_type": "markdown", "metadata": {}, "source": ["# Homework\n"]},
+  {"cell_type": "code", "metadata": {}, "source": ["%matplotlib inline\n", "import math\n", "def area(r):\n", "    return math.pi * r * r\n"]},
+  {"cell_type": "code", "metadata": {"tags": ["answer"]}, "source": "total = sum(range(5))\nprint('total', total)\ntotal * 2"},
+  {"cell_type": "code", "metadata": {}, "source": ["!pip install numpy\n", "squares = {i: i * i for i in range(3)}"]}
+ ]
+}`
+
+func TestParseNotebook(t *testing.T) {
+	cells, err := parseNotebook([]byte(sampleNotebook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 3 {
+		t.Fatalf("expected 3 code cells, got %d", len(cells))
+	}
+	if cells[0].Index != 1 || !strings.HasPrefix(cells[0].Source, "pass  # %matplotlib inline\nimport math") {
+		t.Fatalf("unexpected first cell %+v", cells[0])
+	}
+	if len(cells[1].Tags) != 1 || cells[1].Tags[0] != "answer" {
+		t.Fatalf("tags = %v", cells[1].Tags)
+	}
+	if !strings.HasPrefix(cells[2].Source, "pass  # !pip install numpy") {
+		t.Fatalf("shell escape not disabled: %q", cells[2].Source)
+	}
+	if _, err := parseNotebook([]byte("{not json")); err == nil {
+		t.Fatalf("expected error for invalid notebook")
+	}
+}
+
+func TestDisableMagics(t *testing.T) {
+	if got := disableMagics("%%time\nx = 1"); got != "# %%time\nx = 1" {
+		t.Fatalf("timing cell magic: %q", got)
+	}
+	if got := disableMagics("%%bash\nls -l"); got != "# %%bash\n# ls -l\npass" {
+		t.Fatalf("other cell magic: %q", got)
+	}
+	if got := disableMagics("for i in x:\n    %time f(i)"); got != "for i in x:\n    pass  # %time f(i)" {
+		t.Fatalf("indented magic: %q", got)
+	}
+}
+
+func TestValidateNotebookTest(t *testing.T) {
+	name, bad := " result ", "a.b"
+	if got, err := validateNotebookTest("notebook_variable", &name, nil); err != nil || got != "result" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := validateNotebookTest("notebook_variable", &bad, nil); err == nil {
+		t.Fatalf("expected error for invalid variable name")
+	}
+	if _, err := validateNotebookTest("notebook_cell", &bad, nil); err != nil {
+		t.Fatalf("cell tags are free-form: %v", err)
+	}
+	if _, err := validateNotebookTest("notebook_cell", nil, nil); err == nil {
+		t.Fatalf("expected error for missing target")
+	}
+}
+
+func TestNotebookBuildAndEntry(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"hw/main.ipynb": sampleNotebook,
+		"hw/.ipynb_checkpoints/main-checkpoint.ipynb": sampleNotebook,
+		"scratch.ipynb": sampleNotebook,
+	})
+	prog, err := loadProgram(dir, "jupyter")
+	if err != nil || prog.entry != "hw/main.ipynb" {
+		t.Fatalf("entry = %q, %v", prog.entry, err)
+	}
+	if err := prog.rt.build(nil, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	module, err := os.ReadFile(filepath.Join(dir, "hw", notebookModuleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(module), "# In[2]:\ntotal = sum(range(5))") {
+		t.Fatalf("unexpected module:\n%s", module)
+	}
+}
+
+// TestNotebookRunner executes the harness with the local Python.
+func TestNotebookRunner(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not available")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.ipynb": sampleNotebook, notebookRunnerFile: notebookRunnerScript})
+	if err := (notebookRuntime{}).build(nil, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	run := func(cfg map[string]any) notebookRun {
+		t.Helper()
+		data, _ := json.Marshal(cfg)
+		writeFiles(t, dir, map[string]string{notebookConfigFile: string(data)})
+		out, _ := exec.Command(python, filepath.Join(dir, notebookRunnerFile)).Output()
+		idx := strings.LastIndex(string(out), notebookMarker)
+		if idx == -1 {
+			t.Fatalf("no marker in %q", out)
+		}
+		var res notebookRun
+		if err := json.Unmarshal(out[idx+len(notebookMarker):], &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	all := run(map[string]any{"mode": "run"})
+	if all.Status != "ok" || len(all.Cells) != 3 || all.Cells[1].Stdout != "total 10\n" || all.Cells[1].Result == nil || *all.Cells[1].Result != "20" {
+		t.Fatalf("unexpected run: %+v", all)
+	}
+
+	cell := run(map[string]any{"mode": "cell", "target": "answer"})
+	if cell.Output != "total 10\n20\n" || len(cell.Cells) != 2 {
+		t.Fatalf("cell output = %q (%d cells)", cell.Output, len(cell.Cells))
+	}
+
+	v := run(map[string]any{"mode": "variable", "target": "squares", "expected": map[string]any{"0": 0, "1": 1, "2": 4}})
+	if v.Passed == nil || !*v.Passed {
+		t.Fatalf("variable should match: %+v", v)
+	}
+	if missing := run(map[string]any{"mode": "variable", "target": "nope"}); missing.Status != "missing" {
+		t.Fatalf("expected missing variable, got %+v", missing)
+	}
+
+	writeFiles(t, dir, map[string]string{"main.ipynb": strings.Replace(sampleNotebook, "sum(range(5))", "1 / 0", 1)})
+	if err := (notebookRuntime{}).build(nil, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	failed := run(map[string]any{"mode": "run"})
+	if failed.Status != "error" || len(failed.Cells) != 2 || failed.Cells[1].Error == nil || failed.Cells[1].Error.EName != "ZeroDivisionError" {
+		t.Fatalf("execution should stop at the failing cell: %+v", failed)
+	}
+}
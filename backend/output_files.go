@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Output files let a test check files the program writes ("write the results
+// to out.csv") next to its stdout. Every expected file has its own
+// comparator:
+//
+//	exact  the text with normalized line endings (bytes for binary files)
+//	csv    cell by cell; numbers within abs_tol/rel_tol
+//	json   semantically; numbers within abs_tol/rel_tol
+//	image  by a 64-bit difference hash, so re-encoding or scaling passes
+//
+// stageTestFile stages the list of names with the input files. After the run
+// the files are pulled out of the sandbox into the local workspace, where the
+// grader compares them.
+
+const (
+	outputFileExact = "exact"
+	outputFileCSV   = "csv"
+	outputFileJSON  = "json"
+	outputFileImage = "image"
+
+	outputManifestFile = ".__output_files__"
+	outputFilesDir     = ".__outputs__"
+	outputPullError    = ".__pull_error__"
+
+	maxOutputFiles = 10
+	// lineDiff is quadratic; longer files only report the first difference.
+	maxDiffFileLines = 2000
+	maxDiffLines     = 200
+	// defaultImageDistance is how many hash bits may differ by default.
+	defaultImageDistance = 5
+)
+
+// OutputFileSpec is one expected file, stored as JSON in
+// test_cases.output_files.
+type OutputFileSpec struct {
+	Name       string             `json:"name"`
+	Content    string             `json:"content"` // base64 encoded
+	Comparator string             `json:"comparator"`
+	Options    *outputFileOptions `json:"options,omitempty"`
+}
+
+type outputFileOptions struct {
+	comparatorOptions
+	// IgnoreRowOrder compares the CSV rows after the header in any order.
+	IgnoreRowOrder bool   `json:"ignore_row_order,omitempty"`
+	Delimiter      string `json:"delimiter,omitempty"`
+	// MaxDistance is how many of the 64 image hash bits may differ.
+	MaxDistance *int `json:"max_distance,omitempty"`
+}
+
+// outputFileResult is the comparison of one file, stored in results.file_diffs.
+type outputFileResult struct {
+	Name       string     `json:"name"`
+	Comparator string     `json:"comparator"`
+	Passed     bool       `json:"passed"`
+	Message    string     `json:"message,omitempty"`
+	Diff       []diffLine `json:"diff,omitempty"`
+}
+
+// outputFilesApply lists the modes that run the program once in a workspace
+// the files can be collected from.
+func outputFilesApply(mode string) bool {
+	switch mode {
+	case "stdin_stdout", "checker", "unittest", "pytest", "doctest", "plot", "function":
+		return true
+	}
+	return false
+}
+
+func (spec OutputFileSpec) options() outputFileOptions {
+	if spec.Options == nil {
+		return outputFileOptions{}
+	}
+	return *spec.Options
+}
+
+// normalizeOutputFiles validates the expected files of a test at authoring
+// time, including that the expected content suits the comparator.
+func normalizeOutputFiles(mode string, files []OutputFileSpec) (*string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if !outputFilesApply(mode) {
+		return nil, fmt.Errorf("output files are not supported for %s tests", mode)
+	}
+	if len(files) > maxOutputFiles {
+		return nil, fmt.Errorf("at most %d output files are allowed", maxOutputFiles)
+	}
+	seen := map[string]bool{}
+	out := make([]OutputFileSpec, 0, len(files))
+	for _, f := range files {
+		name := filepath.Base(strings.TrimSpace(f.Name))
+		if name == "." || name == "/" || name == "" || strings.HasPrefix(name, ".__") {
+			return nil, fmt.Errorf("invalid output file name: %q", f.Name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("output file %s is listed twice", name)
+		}
+		seen[name] = true
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(f.Content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 content for output file %s", name)
+		}
+		f.Name, f.Content = name, base64.StdEncoding.EncodeToString(data)
+		f.Comparator = strings.ToLower(strings.TrimSpace(f.Comparator))
+		if f.Comparator == "" {
+			f.Comparator = outputFileExact
+		}
+		opts := f.options()
+		if (opts.AbsTolerance != nil && *opts.AbsTolerance < 0) || (opts.RelTolerance != nil && *opts.RelTolerance < 0) {
+			return nil, fmt.Errorf("output file %s: tolerances must not be negative", name)
+		}
+		switch f.Comparator {
+		case outputFileExact:
+		case outputFileCSV:
+			if utf8.RuneCountInString(opts.Delimiter) > 1 {
+				return nil, fmt.Errorf("output file %s: delimiter must be a single character", name)
+			}
+			if _, err := readCSVFile(data, opts); err != nil {
+				return nil, fmt.Errorf("output file %s is not valid CSV: %v", name, err)
+			}
+		case outputFileJSON:
+			if !json.Valid(data) {
+				return nil, fmt.Errorf("output file %s is not valid JSON", name)
+			}
+		case outputFileImage:
+			if opts.MaxDistance != nil && (*opts.MaxDistance < 0 || *opts.MaxDistance > 64) {
+				return nil, fmt.Errorf("output file %s: max_distance must be between 0 and 64", name)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+				return nil, fmt.Errorf("output file %s is not a PNG, JPEG or GIF image", name)
+			}
+		default:
+			return nil, fmt.Errorf("unknown output file comparator %q", f.Comparator)
+		}
+		out = append(out, f)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
+func parseOutputFiles(raw *string) ([]OutputFileSpec, error) {
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return nil, nil
+	}
+	var files []OutputFileSpec
+	if err := json.Unmarshal([]byte(*raw), &files); err != nil {
+		return nil, fmt.Errorf("invalid output_files: %w", err)
+	}
+	return files, nil
+}
+
+// outputManifest tells the run which files to pull out of the sandbox. The
+// program may write relative to the entry file's directory or the workspace
+// root, so both are tried.
+type outputManifest struct {
+	Names []string `json:"names"`
+	Dir   string   `json:"dir,omitempty"`
+}
+
+func (m outputManifest) candidates(name string) []string {
+	if m.Dir == "" || m.Dir == "." {
+		return []string{name}
+	}
+	return []string{filepath.ToSlash(filepath.Join(m.Dir, name)), name}
+}
+
+// stageOutputManifest writes the names (never the expected contents) of the
+// test's output files into the workspace.
+func stageOutputManifest(dir, mainFile string, tc TestCase) error {
+	path := filepath.Join(dir, outputManifestFile)
+	files, err := parseOutputFiles(tc.OutputFiles)
+	if err != nil || len(files) == 0 {
+		// an earlier test of the submission may have left one behind
+		_ = os.Remove(path)
+		return err
+	}
+	m := outputManifest{Dir: filepath.Dir(mainFile)}
+	for _, f := range files {
+		m.Names = append(m.Names, f.Name)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// collectOutputFiles pulls the files named in dir's manifest out of the
+// sandbox into dir/.__outputs__, replacing those of an earlier run. It must
+// run before the sandbox workspace is released.
+func collectOutputFiles(sb Sandbox, remoteDir, dir string) {
+	raw, err := os.ReadFile(filepath.Join(dir, outputManifestFile))
+	if err != nil {
+		return
+	}
+	var m outputManifest
+	if err := json.Unmarshal(raw, &m); err != nil || len(m.Names) == 0 {
+		return
+	}
+	outDir := filepath.Join(dir, outputFilesDir)
+	_ = os.RemoveAll(outDir)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fmt.Printf("[worker] collect output files: %v\n", err)
+		return
+	}
+	var paths []string
+	for _, name := range m.Names {
+		paths = append(paths, m.candidates(name)...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	files, err := sb.pullFiles(ctx, remoteDir, paths)
+	if err != nil {
+		fmt.Printf("[worker] collect output files: %v\n", err)
+		_ = os.WriteFile(filepath.Join(outDir, outputPullError), []byte(err.Error()), 0644)
+		return
+	}
+	for _, name := range m.Names {
+		for _, p := range m.candidates(name) {
+			if data, ok := files[p]; ok {
+				_ = os.WriteFile(filepath.Join(outDir, name), data, 0644)
+				break
+			}
+		}
+	}
+}
+
+// gradeOutputFiles compares the collected files of a run with the expected
+// ones. It returns the per-file results as JSON (nil when the test has no
+// output files) and whether all of them matched.
+func gradeOutputFiles(workDir string, tc TestCase) (*string, bool) {
+	specs, err := parseOutputFiles(tc.OutputFiles)
+	if len(specs) == 0 && err == nil {
+		return nil, true
+	}
+	var results []outputFileResult
+	if err != nil {
+		results = []outputFileResult{{Name: "output_files", Message: err.Error()}}
+	} else {
+		results = compareOutputFiles(filepath.Join(workDir, outputFilesDir), specs)
+	}
+	passed := true
+	for _, r := range results {
+		passed = passed && r.Passed
+	}
+	data, _ := json.Marshal(results)
+	s := string(data)
+	return &s, passed
+}
+
+func compareOutputFiles(outDir string, specs []OutputFileSpec) []outputFileResult {
+	pullErr, _ := os.ReadFile(filepath.Join(outDir, outputPullError))
+	results := make([]outputFileResult, len(specs))
+	for i, spec := range specs {
+		res := outputFileResult{Name: spec.Name, Comparator: spec.Comparator}
+		expected, _ := base64.StdEncoding.DecodeString(spec.Content)
+		actual, err := os.ReadFile(filepath.Join(outDir, spec.Name))
+		switch {
+		case len(pullErr) > 0:
+			res.Message = "could not collect the file: " + string(pullErr)
+		case err != nil:
+			res.Message = "the program did not create this file"
+		default:
+			res.Passed, res.Message, res.Diff = compareOutputFile(spec, expected, actual)
+		}
+		results[i] = res
+	}
+	return results
+}
+
+func compareOutputFile(spec OutputFileSpec, expected, actual []byte) (bool, string, []diffLine) {
+	opts := spec.options()
+	switch spec.Comparator {
+	case outputFileCSV:
+		ok, msg := compareCSVFile(expected, actual, opts)
+		return ok, msg, nil
+	case outputFileJSON:
+		ok, msg := compareJSONFile(expected, actual, opts)
+		return ok, msg, nil
+	case outputFileImage:
+		ok, msg := compareImageFile(expected, actual, opts)
+		return ok, msg, nil
+	}
+	return compareExactFile(expected, actual)
+}
+
+func normalizeFileText(data []byte) string {
+	return strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+}
+
+func compareExactFile(expected, actual []byte) (bool, string, []diffLine) {
+	if bytes.Equal(expected, actual) {
+		return true, "", nil
+	}
+	if !utf8.Valid(expected) || !utf8.Valid(actual) {
+		return false, fmt.Sprintf("the contents differ (%d bytes expected, %d written)", len(expected), len(actual)), nil
+	}
+	e, a := normalizeFileText(expected), normalizeFileText(actual)
+	if e == a {
+		return true, "", nil
+	}
+	el, al := strings.Split(e, "\n"), strings.Split(a, "\n")
+	if len(el) > maxDiffFileLines || len(al) > maxDiffFileLines {
+		line := 0
+		for line < len(el) && line < len(al) && el[line] == al[line] {
+			line++
+		}
+		return false, fmt.Sprintf("the contents differ from line %d on", line+1), nil
+	}
+	return false, "the contents differ", compactDiff(lineDiff(e, a), 2)
+}
+
+// compactDiff keeps the changed lines with some context and replaces the
+// unchanged runs between them with "...".
+func compactDiff(diff []diffLine, context int) []diffLine {
+	keep := make([]bool, len(diff))
+	for i, d := range diff {
+		if d.Op == " " {
+			continue
+		}
+		for j := max(0, i-context); j <= min(len(diff)-1, i+context); j++ {
+			keep[j] = true
+		}
+	}
+	var out []diffLine
+	for i, d := range diff {
+		if keep[i] {
+			out = append(out, d)
+		} else if i == 0 || keep[i-1] {
+			out = append(out, diffLine{" ", "..."})
+		}
+		if len(out) >= maxDiffLines {
+			out = append(out, diffLine{" ", "..."})
+			break
+		}
+	}
+	return out
+}
+
+func readCSVFile(data []byte, opts outputFileOptions) ([][]string, error) {
+	r := csv.NewReader(strings.NewReader(normalizeFileText(data)))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	if opts.Delimiter != "" {
+		r.Comma, _ = utf8.DecodeRuneInString(opts.Delimiter)
+	}
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		for j := range row {
+			row[j] = strings.TrimSpace(row[j])
+		}
+	}
+	return rows, nil
+}
+
+func csvCellsMatch(expected, actual string, opts outputFileOptions) bool {
+	if expected == actual || (opts.IgnoreCase && strings.EqualFold(expected, actual)) {
+		return true
+	}
+	ev, eErr := strconv.ParseFloat(expected, 64)
+	av, aErr := strconv.ParseFloat(actual, 64)
+	return eErr == nil && aErr == nil && numbersMatch(av, ev, opts.comparatorOptions)
+}
+
+func compareCSVFile(expected, actual []byte, opts outputFileOptions) (bool, string) {
+	e, _ := readCSVFile(expected, opts)
+	a, err := readCSVFile(actual, opts)
+	if err != nil {
+		return false, fmt.Sprintf("not valid CSV: %v", err)
+	}
+	if opts.IgnoreRowOrder {
+		sortCSVRows(e)
+		sortCSVRows(a)
+	}
+	if len(a) != len(e) {
+		return false, fmt.Sprintf("expected %d rows, got %d", len(e), len(a))
+	}
+	var first string
+	differing := 0
+	for i := range e {
+		if len(a[i]) != len(e[i]) {
+			differing++
+			if first == "" {
+				first = fmt.Sprintf("row %d: expected %d columns, got %d", i+1, len(e[i]), len(a[i]))
+			}
+			continue
+		}
+		for j := range e[i] {
+			if csvCellsMatch(e[i][j], a[i][j], opts) {
+				continue
+			}
+			differing++
+			if first == "" {
+				first = fmt.Sprintf("row %d, column %d: expected %q, got %q", i+1, j+1, e[i][j], a[i][j])
+			}
+		}
+	}
+	switch {
+	case differing == 0:
+		return true, ""
+	case differing > 1:
+		return false, fmt.Sprintf("%s (%d differences)", first, differing)
+	}
+	return false, first
+}
+
+// sortCSVRows sorts the rows after the header.
+func sortCSVRows(rows [][]string) {
+	if len(rows) < 2 {
+		return
+	}
+	body := rows[1:]
+	sort.SliceStable(body, func(i, j int) bool {
+		return strings.Join(body[i], "\x00") < strings.Join(body[j], "\x00")
+	})
+}
+
+func compareJSONFile(expected, actual []byte, opts outputFileOptions) (bool, string) {
+	var e, a any
+	if err := json.Unmarshal(expected, &e); err != nil {
+		return false, fmt.Sprintf("invalid expected JSON: %v", err)
+	}
+	if err := json.Unmarshal(actual, &a); err != nil {
+		return false, fmt.Sprintf("not valid JSON: %v", err)
+	}
+	if path, msg := jsonDifference("$", e, a, opts.comparatorOptions); msg != "" {
+		return false, path + ": " + msg
+	}
+	return true, ""
+}
+
+// jsonDifference returns the path and description of the first difference
+// between two decoded JSON values; numbers match within the tolerances.
+func jsonDifference(path string, e, a any, opts comparatorOptions) (string, string) {
+	switch ev := e.(type) {
+	case map[string]any:
+		av, ok := a.(map[string]any)
+		if !ok {
+			return path, "expected an object, got " + jsonSnippet(a)
+		}
+		keys := make([]string, 0, len(ev))
+		for k := range ev {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			item, ok := av[k]
+			if !ok {
+				return path, fmt.Sprintf("missing key %q", k)
+			}
+			if p, msg := jsonDifference(path+"."+k, ev[k], item, opts); msg != "" {
+				return p, msg
+			}
+		}
+		for k := range av {
+			if _, ok := ev[k]; !ok {
+				return path, fmt.Sprintf("unexpected key %q", k)
+			}
+		}
+	case []any:
+		av, ok := a.([]any)
+		if !ok {
+			return path, "expected an array, got " + jsonSnippet(a)
+		}
+		if len(av) != len(ev) {
+			return path, fmt.Sprintf("expected %d items, got %d", len(ev), len(av))
+		}
+		for i := range ev {
+			if p, msg := jsonDifference(fmt.Sprintf("%s[%d]", path, i), ev[i], av[i], opts); msg != "" {
+				return p, msg
+			}
+		}
+	case float64:
+		if av, ok := a.(float64); !ok || !numbersMatch(av, ev, opts) {
+			return path, fmt.Sprintf("expected %s, got %s", jsonSnippet(e), jsonSnippet(a))
+		}
+	default:
+		if e != a {
+			return path, fmt.Sprintf("expected %s, got %s", jsonSnippet(e), jsonSnippet(a))
+		}
+	}
+	return "", ""
+}
+
+func jsonSnippet(v any) string {
+	data, _ := json.Marshal(v)
+	if len(data) > 60 {
+		return string(data[:57]) + "..."
+	}
+	return string(data)
+}
+
+func compareImageFile(expected, actual []byte, opts outputFileOptions) (bool, string) {
+	e, _, err := image.Decode(bytes.NewReader(expected))
+	if err != nil {
+		return false, "invalid expected image"
+	}
+	a, _, err := image.Decode(bytes.NewReader(actual))
+	if err != nil {
+		return false, "not a PNG, JPEG or GIF image"
+	}
+	allowed := defaultImageDistance
+	if opts.MaxDistance != nil {
+		allowed = *opts.MaxDistance
+	}
+	if d := bits.OnesCount64(differenceHash(e) ^ differenceHash(a)); d > allowed {
+		return false, fmt.Sprintf("the image looks different: %d of 64 hash bits differ (at most %d allowed)", d, allowed)
+	}
+	return true, ""
+}
+
+// differenceHash shrinks the image to 9x8 grey blocks and sets a bit for
+// every block darker than its right neighbour.
+func differenceHash(img image.Image) uint64 {
+	b := img.Bounds()
+	if b.Empty() {
+		return 0
+	}
+	var grey [8][9]float64
+	for y := 0; y < 8; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/8, b.Min.Y+(y+1)*b.Dy()/8
+		y1 = max(y1, y0+1)
+		for x := 0; x < 9; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/9, b.Min.X+(x+1)*b.Dx()/9
+			x1 = max(x1, x0+1)
+			var sum float64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, g, bl, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+				}
+			}
+			grey[y][x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if grey[y][x] < grey[y][x+1] {
+				hash |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return hash
+}
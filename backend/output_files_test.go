@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func outputSpec(name, comparator, content string, opts *outputFileOptions) OutputFileSpec {
+	return OutputFileSpec{Name: name, Comparator: comparator, Content: base64.StdEncoding.EncodeToString([]byte(content)), Options: opts}
+}
+
+func TestNormalizeOutputFiles(t *testing.T) {
+	stored, err := normalizeOutputFiles("stdin_stdout", []OutputFileSpec{
+		outputSpec("out/result.csv", "", "a,b\n1,2\n", nil),
+		outputSpec("data.json", "JSON", `{"a": 1}`, nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs, err := parseOutputFiles(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specs[0].Name != "result.csv" || specs[0].Comparator != "exact" || specs[1].Comparator != "json" {
+		t.Fatalf("unexpected normalized specs %+v", specs)
+	}
+	if stored, err := normalizeOutputFiles("dialogue", nil); err != nil || stored != nil {
+		t.Fatalf("no output files should store nothing, got %v %v", stored, err)
+	}
+	bad := map[string][]OutputFileSpec{
+		"mode":       {outputSpec("a.txt", "exact", "x", nil)},
+		"duplicate":  {outputSpec("a.txt", "exact", "x", nil), outputSpec("a.txt", "exact", "y", nil)},
+		"reserved":   {outputSpec(".__outputs__", "exact", "x", nil)},
+		"base64":     {{Name: "a.txt", Content: "%%%"}},
+		"comparator": {outputSpec("a.txt", "fuzzy", "x", nil)},
+		"json":       {outputSpec("a.json", "json", "{", nil)},
+		"csv":        {outputSpec("a.csv", "csv", "\"a,b\n", nil)},
+		"image":      {outputSpec("a.png", "image", "not an image", nil)},
+		"delimiter":  {outputSpec("a.csv", "csv", "a", &outputFileOptions{Delimiter: ";;"})},
+	}
+	for name, files := range bad {
+		mode := "stdin_stdout"
+		if name == "mode" {
+			mode = "dialogue"
+		}
+		if _, err := normalizeOutputFiles(mode, files); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCompareOutputFileComparators(t *testing.T) {
+	tol := 0.01
+	cases := []struct {
+		spec   OutputFileSpec
+		actual string
+		pass   bool
+		msg    string
+	}{
+		{outputSpec("a.txt", "exact", "one\ntwo\n", nil), "one\r\ntwo", true, ""},
+		{outputSpec("a.txt", "exact", "one\ntwo\nthree\n", nil), "one\n2\nthree\n", false, "the contents differ"},
+		{outputSpec("a.csv", "csv", "name, score\nada, 1.0\n", nil), "name,score\nada,1\n", true, ""},
+		{outputSpec("a.csv", "csv", "name;score\nada;1.5\n", &outputFileOptions{Delimiter: ";"}), "name;score\nada;1.7\n", false, `row 2, column 2: expected "1.5", got "1.7"`},
+		{outputSpec("a.csv", "csv", "n\nb\na\n", &outputFileOptions{IgnoreRowOrder: true}), "n\na\nb\n", true, ""},
+		{outputSpec("a.csv", "csv", "n\nb\na\n", nil), "n\na\nb\n", false, "(2 differences)"},
+		{outputSpec("a.csv", "csv", "n\n1\n", nil), "n\n1\n2\n", false, "expected 2 rows, got 3"},
+		{outputSpec("a.json", "json", `{"b": [1, 2.0], "a": "x"}`, nil), `{"a":"x","b":[1,2]}`, true, ""},
+		{outputSpec("a.json", "json", `{"items": [{"v": 0.5}]}`, &outputFileOptions{comparatorOptions: comparatorOptions{AbsTolerance: &tol}}), `{"items": [{"v": 0.505}]}`, true, ""},
+		{outputSpec("a.json", "json", `{"items": [1, 2, 3]}`, nil), `{"items": [1, 2, 4]}`, false, "$.items[2]: expected 3, got 4"},
+		{outputSpec("a.json", "json", `{"a": 1}`, nil), `{"a": 1, "b": 2}`, false, `$: unexpected key "b"`},
+		{outputSpec("a.json", "json", `{"a": 1}`, nil), `{"a": 1`, false, "not valid JSON"},
+	}
+	for _, c := range cases {
+		expected, _ := base64.StdEncoding.DecodeString(c.spec.Content)
+		pass, msg, _ := compareOutputFile(c.spec, expected, []byte(c.actual))
+		if pass != c.pass || !strings.Contains(msg, c.msg) {
+			t.Errorf("%s %q vs %q: got %v %q", c.spec.Comparator, expected, c.actual, pass, msg)
+		}
+	}
+
+	_, _, diff := compareExactFile([]byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\n"), []byte("one\ntwo\nthree\nfour\nfive\nsix\n7\n"))
+	if len(diff) != 5 || diff[0].Text != "..." || diff[3].Op != "-" || diff[4].Op != "+" {
+		t.Errorf("unexpected compact diff %+v", diff)
+	}
+}
+
+func gradientImage(w, h int, invert bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			if y > h/2 {
+				v = uint8(y * 255 / h)
+			}
+			if invert {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func TestCompareImageFiles(t *testing.T) {
+	var expected, scaled, inverted bytes.Buffer
+	if err := png.Encode(&expected, gradientImage(90, 80, false)); err != nil {
+		t.Fatal(err)
+	}
+	// re-encoding at another size and format should not matter
+	if err := jpeg.Encode(&scaled, gradientImage(180, 160, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&inverted, gradientImage(90, 80, true)); err != nil {
+		t.Fatal(err)
+	}
+	spec := OutputFileSpec{Name: "plot.png", Comparator: "image"}
+	if ok, msg, _ := compareOutputFile(spec, expected.Bytes(), scaled.Bytes()); !ok {
+		t.Errorf("scaled image rejected: %s", msg)
+	}
+	if ok, msg, _ := compareOutputFile(spec, expected.Bytes(), inverted.Bytes()); ok || !strings.Contains(msg, "hash bits differ") {
+		t.Errorf("inverted image accepted: %s", msg)
+	}
+	if ok, _, _ := compareOutputFile(spec, expected.Bytes(), []byte("text")); ok {
+		t.Errorf("text accepted as an image")
+	}
+}
+
+// TestOutputFilesFromSandbox collects the files a program writes in the
+// sandbox and grades them.
+func TestOutputFilesFromSandbox(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		stored, err := normalizeOutputFiles("stdin_stdout", []OutputFileSpec{
+			outputSpec("out.csv", "csv", "x,square\n1,1\n2,4\n3,9\n", nil),
+			outputSpec("summary.json", "json", `{"count": 3}`, nil),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc := TestCase{ExecutionMode: "stdin_stdout", OutputFiles: stored}
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"src/main.py": "import json\nn = int(input())\nwith open('out.csv', 'w') as f:\n    f.write('x,square\\n')\n    for i in range(1, n + 1):\n        f.write('%d,%d\\n' % (i, i * i + (i == 3)))\n",
+		})
+		if err := stageTestFile(dir, "src/main.py", tc); err != nil {
+			t.Fatal(err)
+		}
+		_, stderr, exitCode, _, _, _ := pythonRuntime{}.runStdin(nil, dir, "src/main.py", "3\n", 10*time.Second, 0)
+		if exitCode != 0 {
+			t.Fatalf("exit %d: %s", exitCode, stderr)
+		}
+		raw, ok := gradeOutputFiles(dir, tc)
+		if ok || raw == nil {
+			t.Fatalf("expected the files to fail, got %v", raw)
+		}
+		var results []outputFileResult
+		if err := json.Unmarshal([]byte(*raw), &results); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 || results[0].Passed || !strings.Contains(results[0].Message, `row 4, column 2: expected "9", got "10"`) {
+			t.Fatalf("unexpected csv result %+v", results)
+		}
+		if results[1].Passed || results[1].Message != "the program did not create this file" {
+			t.Fatalf("unexpected json result %+v", results[1])
+		}
+
+		writeFiles(t, dir, map[string]string{
+			"src/main.py": "import json\nn = int(input())\nwith open('out.csv', 'w') as f:\n    f.write('x,square\\n')\n    for i in range(1, n + 1):\n        f.write('%d,%d\\n' % (i, i * i))\njson.dump({'count': n}, open('summary.json', 'w'))\n",
+		})
+		if _, stderr, exitCode, _, _, _ := (pythonRuntime{}).runStdin(nil, dir, "src/main.py", "3\n", 10*time.Second, 0); exitCode != 0 {
+			t.Fatalf("exit %d: %s", exitCode, stderr)
+		}
+		if raw, ok := gradeOutputFiles(dir, tc); !ok {
+			t.Fatalf("expected the files to pass: %s", *raw)
+		}
+	})
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Performance tests run the program on stdin like stdin/stdout tests, but
+// their time limit is a multiple of the reference solution's runtime measured
+// in an identically configured sandbox during the same grading run, so the
+// limit follows VM speed and host load instead of being an absolute number.
+// time_limit_sec stays the hard cap that kills a run.
+//
+// performance_config may add a series of growing inputs produced by a
+// teacher-written Python generator:
+//
+//	def generate(n):
+//	    return "%d\n%s\n" % (n, " ".join(map(str, range(n))))
+//
+// Every input of the series is judged against the same relative limit and
+// the runtimes are fitted against common growth functions to tell the student
+// how their program scales, e.g. "looks quadratic, O(n²)".
+
+var perfGeneratorTimeout = getenvDurationOr("PERF_GENERATOR_TIMEOUT", 30*time.Second)
+
+const (
+	perfGeneratorFile = "__perf_generator__.py"
+	perfRunnerFile    = "__perf_runner__.py"
+	perfSizesFile     = "__perf_sizes__.json"
+	perfMarker        = "===PERF_INPUTS==="
+
+	perfMaxSizes   = 12
+	perfMaxRepeats = 10
+)
+
+// PerformanceConfig is stored as JSON in test_cases.performance_config.
+type PerformanceConfig struct {
+	// Factor is the allowed multiple of the reference runtime.
+	Factor float64 `json:"factor"`
+	// MinLimitMS keeps the limit meaningful when the reference is too fast
+	// to measure reliably.
+	MinLimitMS int `json:"min_limit_ms"`
+	// Repeats is how many times each input is run; the median counts.
+	Repeats int `json:"repeats"`
+	// Sizes and Generator describe the optional series of growing inputs.
+	Sizes     []int  `json:"sizes,omitempty"`
+	Generator string `json:"generator,omitempty"`
+}
+
+func (cfg *PerformanceConfig) normalize() error {
+	if cfg.Factor == 0 {
+		cfg.Factor = 3
+	}
+	if cfg.MinLimitMS == 0 {
+		cfg.MinLimitMS = 100
+	}
+	if cfg.Repeats == 0 {
+		cfg.Repeats = 3
+	}
+	switch {
+	case cfg.Factor < 1 || cfg.Factor > 100:
+		return errors.New("factor must be between 1 and 100")
+	case cfg.MinLimitMS < 0:
+		return errors.New("min_limit_ms must not be negative")
+	case cfg.Repeats < 1 || cfg.Repeats > perfMaxRepeats:
+		return fmt.Errorf("repeats must be between 1 and %d", perfMaxRepeats)
+	case len(cfg.Sizes) > perfMaxSizes:
+		return fmt.Errorf("at most %d sizes are allowed", perfMaxSizes)
+	}
+	cfg.Generator = strings.TrimSpace(cfg.Generator)
+	if len(cfg.Sizes) == 0 {
+		cfg.Generator = ""
+		return nil
+	}
+	if len(cfg.Sizes) < 3 {
+		return errors.New("a series needs at least 3 sizes to estimate complexity")
+	}
+	for i, n := range cfg.Sizes {
+		if n < 1 {
+			return errors.New("sizes must be positive")
+		}
+		if i > 0 && n <= cfg.Sizes[i-1] {
+			return errors.New("sizes must be increasing")
+		}
+	}
+	if cfg.Generator == "" {
+		return errors.New("generator is required when sizes are given")
+	}
+	if !strings.Contains(cfg.Generator, "def generate(") {
+		return errors.New("generator must define generate(n)")
+	}
+	return nil
+}
+
+// parsePerformanceConfig decodes a stored config; nil means the defaults.
+func parsePerformanceConfig(raw *string) (*PerformanceConfig, error) {
+	cfg := &PerformanceConfig{}
+	if raw != nil && strings.TrimSpace(*raw) != "" {
+		dec := json.NewDecoder(strings.NewReader(*raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("performance_config must be a JSON object: %v", err)
+		}
+	}
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validatePerformanceTest checks a performance test at authoring time and
+// returns the normalized config to store.
+func validatePerformanceTest(stdin, raw *string) (*string, error) {
+	if stdin == nil {
+		return nil, errors.New("stdin is required")
+	}
+	cfg, err := parsePerformanceConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
+// performanceRuns is how many times a test starts the program; it scales the
+// VM session budget.
+func performanceRuns(tc TestCase) int {
+	if tc.ExecutionMode != "performance" {
+		return 1
+	}
+	cfg, err := parsePerformanceConfig(tc.PerformanceConfig)
+	if err != nil {
+		return 1
+	}
+	return (1 + len(cfg.Sizes)) * cfg.Repeats
+}
+
+const perfRunnerScript = `import json
+import pathlib
+import random
+import sys
+import traceback
+
+MARKER = "===PERF_INPUTS==="
+HERE = pathlib.Path(__file__).resolve().parent
+
+
+def main():
+    sys.path.append(str(HERE))
+    try:
+        from __perf_generator__ import generate
+        inputs = []
+        for n in json.loads((HERE / "__perf_sizes__.json").read_text()):
+            # the same inputs for the reference and the student
+            random.seed(n)
+            text = generate(n)
+            if not isinstance(text, str):
+                raise TypeError("generate(%d) returned %s, expected str" % (n, type(text).__name__))
+            inputs.append(text)
+    except Exception:  # noqa: BLE001
+        print(MARKER + json.dumps({"error": traceback.format_exc()}))
+        sys.exit(2)
+    print(MARKER + json.dumps({"inputs": inputs}))
+
+
+if __name__ == "__main__":
+    main()
+`
+
+// generatePerfInputs produces the stdin of every size with the test's
+// generator. It runs in the reference sandbox, apart from the student's code.
+func generatePerfInputs(ref *referenceSandbox, cfg *PerformanceConfig) ([]string, error) {
+	sizes, _ := json.Marshal(cfg.Sizes)
+	files := map[string]string{
+		perfGeneratorFile: cfg.Generator,
+		perfRunnerFile:    perfRunnerScript,
+		perfSizesFile:     string(sizes),
+	}
+	var payload struct {
+		Inputs []string `json:"inputs"`
+	}
+	if err := ref.runTeacherScript(files, perfRunnerFile, perfMarker, perfGeneratorTimeout, &payload); err != nil {
+		return nil, fmt.Errorf("generator: %w", err)
+	}
+	if len(payload.Inputs) != len(cfg.Sizes) {
+		return nil, fmt.Errorf("generator returned %d inputs for %d sizes", len(payload.Inputs), len(cfg.Sizes))
+	}
+	return payload.Inputs, nil
+}
+
+// perfSample is the measurement of one input; N is 0 for the test's stdin.
+type perfSample struct {
+	N           int
+	Runtime     time.Duration
+	Reference   time.Duration
+	Limit       time.Duration
+	OverLimit   bool
+	Unfinished  bool
+	hasBaseline bool
+}
+
+// performanceRun is the outcome of a performance test. Stdout and Stderr
+// belong to the run on the test's own stdin unless a later run failed.
+type performanceRun struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Runtime  time.Duration
+	Memory   memoryUsage
+	// Slow is set when an input exceeded the relative limit.
+	Slow    bool
+	Samples []perfSample
+	// Problem reports a failure of the reference solution or generator.
+	Problem string
+	Message string
+}
+
+func medianDuration(ds []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+func runPerformanceTest(sess *vmSession, workDir string, prog program, tc TestCase, timeout time.Duration, ref *referenceSandbox) performanceRun {
+	run := performanceRun{ExitCode: -1}
+	cfg, err := parsePerformanceConfig(tc.PerformanceConfig)
+	if err != nil {
+		run.Problem = err.Error()
+		return run
+	}
+	if ref == nil {
+		run.Problem = "performance tests are not available here"
+		return run
+	}
+	if err := ref.load(); err != nil {
+		run.Problem = err.Error()
+		return run
+	}
+	inputs := []string{tc.Stdin}
+	sizes := []int{0}
+	if len(cfg.Sizes) > 0 {
+		generated, err := generatePerfInputs(ref, cfg)
+		if err != nil {
+			run.Problem = err.Error()
+			return run
+		}
+		inputs = append(inputs, generated...)
+		sizes = append(sizes, cfg.Sizes...)
+	}
+
+	minLimit := time.Duration(cfg.MinLimitMS) * time.Millisecond
+	for i, stdin := range inputs {
+		sample := perfSample{N: sizes[i], Limit: timeout}
+		if ref.present {
+			refTimes := make([]time.Duration, 0, cfg.Repeats)
+			for r := 0; r < cfg.Repeats; r++ {
+				_, d, err := ref.runStdin(tc, stdin, timeout)
+				if err != nil {
+					run.Problem = err.Error()
+					return run
+				}
+				refTimes = append(refTimes, d)
+			}
+			sample.Reference = medianDuration(refTimes)
+			sample.Limit = max(time.Duration(cfg.Factor*float64(sample.Reference)), minLimit)
+			sample.hasBaseline = true
+		}
+
+		times := make([]time.Duration, 0, cfg.Repeats)
+		over := 0
+		for r := 0; r < cfg.Repeats && over*2 <= cfg.Repeats; r++ {
+			stdout, stderr, exitCode, timedOut, runtime, mem := prog.rt.runStdin(sess, workDir, prog.entry, stdin, timeout, tc.MemoryLimitKB)
+			if i == 0 && r == 0 {
+				run.Stdout, run.Stderr, run.ExitCode, run.Runtime = normalizeActualStdout(trimTrailingNewline(stdout)), stderr, exitCode, runtime
+			}
+			run.Memory.PeakKB = max(run.Memory.PeakKB, mem.PeakKB)
+			if timedOut || mem.LimitExceeded || exitCode != 0 {
+				run.Stdout, run.Stderr, run.ExitCode = stdout, stderr, exitCode
+				run.TimedOut, run.Memory.LimitExceeded = timedOut, mem.LimitExceeded
+				sample.Unfinished = true
+				break
+			}
+			if runtime > sample.Limit {
+				over++
+			}
+			times = append(times, runtime)
+		}
+		if !sample.Unfinished {
+			sample.Runtime = medianDuration(times)
+			sample.OverLimit = sample.Runtime > sample.Limit
+			run.Slow = run.Slow || sample.OverLimit
+		}
+		run.Samples = append(run.Samples, sample)
+		if sample.Unfinished {
+			break
+		}
+	}
+	if !run.TimedOut && !run.Memory.LimitExceeded && run.ExitCode == 0 && len(run.Samples) > 0 {
+		run.Runtime = run.Samples[0].Runtime
+	}
+	run.Message = performanceMessage(run.Samples, cfg.Factor, ref.present)
+	return run
+}
+
+func performanceStatus(run performanceRun, tc TestCase) string {
+	switch {
+	case run.Problem != "":
+		return "checker_error"
+	case run.TimedOut:
+		return "time_limit_exceeded"
+	case run.Memory.LimitExceeded:
+		return "memory_limit_exceeded"
+	case run.ExitCode != 0:
+		return "runtime_error"
+	case tc.ExpectedStdout != "" && !stdoutMatches(tc, run.Stdout, normalizeExpectedStdout(trimTrailingNewline(tc.ExpectedStdout))):
+		return "wrong_output"
+	case run.Slow:
+		return "time_limit_exceeded"
+	}
+	return "passed"
+}
+
+func formatPerfDuration(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%d ms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.2f s", d.Seconds())
+}
+
+// performanceMessage is the feedback shown with the result: the runtime of
+// every input against its limit and the growth estimate of the series.
+func performanceMessage(samples []perfSample, factor float64, withReference bool) string {
+	var b strings.Builder
+	if !withReference {
+		b.WriteString("No reference solution is stored; only the absolute time limit applies.\n")
+	}
+	var ns, student, reference []float64
+	complete := true
+	for _, s := range samples {
+		label := "test input"
+		if s.N > 0 {
+			label = fmt.Sprintf("n = %d", s.N)
+		}
+		switch {
+		case s.Unfinished:
+			fmt.Fprintf(&b, "%s: did not finish\n", label)
+		case s.hasBaseline:
+			fmt.Fprintf(&b, "%s: %s, limit %s (%gx the reference's %s)", label, formatPerfDuration(s.Runtime), formatPerfDuration(s.Limit), factor, formatPerfDuration(s.Reference))
+			if s.OverLimit {
+				b.WriteString(" (too slow)")
+			}
+			b.WriteString("\n")
+		default:
+			fmt.Fprintf(&b, "%s: %s\n", label, formatPerfDuration(s.Runtime))
+		}
+		if s.N > 0 {
+			if s.Unfinished {
+				complete = false
+				continue
+			}
+			ns = append(ns, float64(s.N))
+			student = append(student, float64(s.Runtime)/float64(time.Millisecond))
+			reference = append(reference, float64(s.Reference)/float64(time.Millisecond))
+		}
+	}
+	if len(ns) >= 3 {
+		b.WriteString("\n")
+		if est := estimateComplexity(ns, student); est != nil {
+			fmt.Fprintf(&b, "Runtime growth over n = %d..%d looks %s, %s.", int(ns[0]), int(ns[len(ns)-1]), est.Name, est.Notation)
+		} else {
+			fmt.Fprintf(&b, "Runtime did not change measurably over n = %d..%d.", int(ns[0]), int(ns[len(ns)-1]))
+		}
+		if withReference {
+			if est := estimateComplexity(ns, reference); est != nil {
+				fmt.Fprintf(&b, " The reference solution looks %s, %s.", est.Name, est.Notation)
+			}
+		}
+		b.WriteString("\n")
+	} else if len(ns) > 0 && !complete {
+		b.WriteString("\nNot enough inputs finished to estimate the complexity.\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// complexityClass is a growth function runtimes are fitted against.
+type complexityClass struct {
+	Name     string
+	Notation string
+	f        func(n float64) float64
+}
+
+var complexityClasses = []complexityClass{
+	{"logarithmic", "O(log n)", func(n float64) float64 { return math.Log(n) }},
+	{"linear", "O(n)", func(n float64) float64 { return n }},
+	{"linearithmic", "O(n log n)", func(n float64) float64 { return n * math.Log(n) }},
+	{"quadratic", "O(n²)", func(n float64) float64 { return n * n }},
+	{"cubic", "O(n³)", func(n float64) float64 { return n * n * n }},
+	{"exponential", "O(2ⁿ)", func(n float64) float64 { return math.Exp2(n) }},
+}
+
+var constantComplexity = complexityClass{Name: "constant", Notation: "O(1)"}
+
+// perfMinGrowthMS is the smallest runtime change (runtimes have millisecond
+// resolution) that is read as growth rather than noise.
+const perfMinGrowthMS = 5
+
+// estimateComplexity fits t = a + b·f(n) for every class by least squares;
+// the intercept absorbs interpreter start-up and VM overhead. The class with
+// the smallest residual wins, a simpler one when it is within 10%. It returns
+// nil when the runtime does not grow measurably.
+func estimateComplexity(ns, ts []float64) *complexityClass {
+	if len(ns) < 3 || len(ns) != len(ts) {
+		return nil
+	}
+	lo, hi := ts[0], ts[0]
+	for _, t := range ts {
+		lo, hi = math.Min(lo, t), math.Max(hi, t)
+	}
+	if hi-lo < perfMinGrowthMS {
+		return nil
+	}
+	nmax := ns[len(ns)-1]
+	best := &constantComplexity
+	bestSSE := fitResidual(ts, nil)
+	for i := range complexityClasses {
+		c := &complexityClasses[i]
+		// exponential growth is only plausible for tiny n
+		if c.Name == "exponential" && nmax > 64 {
+			continue
+		}
+		// scale so the largest input maps to 1; keeps n³ and 2ⁿ finite
+		norm := c.f(nmax)
+		if c.Name == "exponential" {
+			norm = 1
+		}
+		xs := make([]float64, len(ns))
+		for j, n := range ns {
+			if c.Name == "exponential" {
+				xs[j] = math.Exp2(n - nmax)
+			} else {
+				xs[j] = c.f(n) / norm
+			}
+		}
+		if s := fitResidual(ts, xs); s < bestSSE*0.9 {
+			best, bestSSE = c, s
+		}
+	}
+	if best == &constantComplexity {
+		return nil
+	}
+	return best
+}
+
+// fitResidual is the residual sum of squares of the least-squares fit
+// t = a + b·x with b ≥ 0; without xs it is the fit of a constant.
+func fitResidual(ts, xs []float64) float64 {
+	n := float64(len(ts))
+	var meanT, meanX float64
+	for i, t := range ts {
+		meanT += t
+		if xs != nil {
+			meanX += xs[i]
+		}
+	}
+	meanT /= n
+	meanX /= n
+	b := 0.0
+	if xs != nil {
+		var cov, varX float64
+		for i := range ts {
+			cov += (xs[i] - meanX) * (ts[i] - meanT)
+			varX += (xs[i] - meanX) * (xs[i] - meanX)
+		}
+		if varX > 0 {
+			b = math.Max(cov/varX, 0)
+		}
+	}
+	a := meanT - b*meanX
+	var s float64
+	for i, t := range ts {
+		x := 0.0
+		if xs != nil {
+			x = xs[i]
+		}
+		r := t - a - b*x
+		s += r * r
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPerformanceConfigNormalize(t *testing.T) {
+	cfg, err := parsePerformanceConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Factor != 3 || cfg.Repeats != 3 || cfg.MinLimitMS != 100 || len(cfg.Sizes) != 0 {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	for _, bad := range []string{
+		`{"factor": 0.5}`,
+		`{"repeats": 50}`,
+		`{"sizes": [10, 20]}`,
+		`{"sizes": [10, 30, 20], "generator": "def generate(n): return ''"}`,
+		`{"sizes": [10, 20, 30]}`,
+		`{"sizes": [10, 20, 30], "generator": "print(1)"}`,
+		`{"limit": 2}`,
+	} {
+		if _, err := parsePerformanceConfig(&bad); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+	if _, err := validatePerformanceTest(nil, nil); err == nil {
+		t.Fatalf("stdin should be required")
+	}
+	stdin := "5\n"
+	raw := `{"factor": 2, "sizes": [100, 200, 400], "generator": "def generate(n):\n    return str(n)\n"}`
+	stored, err := validatePerformanceTest(&stdin, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := TestCase{ExecutionMode: "performance", PerformanceConfig: stored}
+	if n := performanceRuns(tc); n != 12 {
+		t.Fatalf("performanceRuns = %d, want 4 inputs x 3 repeats", n)
+	}
+	if n := performanceRuns(TestCase{ExecutionMode: "stdin_stdout"}); n != 1 {
+		t.Fatalf("performanceRuns of a plain test = %d", n)
+	}
+}
+
+func TestEstimateComplexity(t *testing.T) {
+	// n log n only separates from n over a wide range of sizes
+	ns := []float64{1000, 4000, 16000, 64000, 256000}
+	cases := map[string]func(n float64) float64{
+		"linear":       func(n float64) float64 { return 40 + n/500 },
+		"linearithmic": func(n float64) float64 { return 40 + n*math.Log(n)/5000 },
+		"quadratic":    func(n float64) float64 { return 40 + n*n/5e7 },
+		"cubic":        func(n float64) float64 { return 40 + n*n*n/1e13 },
+	}
+	for want, f := range cases {
+		ts := make([]float64, len(ns))
+		for i, n := range ns {
+			// a little jitter like real measurements
+			ts[i] = math.Round(f(n) * (1 + 0.02*float64(i%2*2-1)))
+		}
+		est := estimateComplexity(ns, ts)
+		if est == nil || est.Name != want {
+			t.Errorf("%s data estimated as %+v (%v)", want, est, ts)
+		}
+	}
+	if est := estimateComplexity(ns, []float64{41, 40, 42, 41, 40}); est != nil {
+		t.Errorf("flat runtimes estimated as %s", est.Name)
+	}
+	small := []float64{10, 14, 18, 22, 26}
+	if est := estimateComplexity(small, []float64{5, 80, 1250, 20000, 320000}); est == nil || est.Name != "exponential" {
+		t.Errorf("exponential data estimated as %+v", est)
+	}
+}
+
+func TestPerformanceStatusAndMessage(t *testing.T) {
+	samples := []perfSample{
+		{Runtime: 90 * time.Millisecond, Reference: 40 * time.Millisecond, Limit: 120 * time.Millisecond, hasBaseline: true},
+		{N: 1000, Runtime: 60 * time.Millisecond, Reference: 40 * time.Millisecond, Limit: 120 * time.Millisecond, hasBaseline: true},
+		{N: 2000, Runtime: 120 * time.Millisecond, Reference: 42 * time.Millisecond, Limit: 126 * time.Millisecond, hasBaseline: true},
+		{N: 4000, Runtime: 380 * time.Millisecond, Reference: 45 * time.Millisecond, Limit: 135 * time.Millisecond, OverLimit: true, hasBaseline: true},
+		{N: 8000, Runtime: 1420 * time.Millisecond, Reference: 50 * time.Millisecond, Limit: 150 * time.Millisecond, OverLimit: true, hasBaseline: true},
+	}
+	msg := performanceMessage(samples, 3, true)
+	for _, want := range []string{
+		"test input: 90 ms, limit 120 ms (3x the reference's 40 ms)",
+		"n = 8000: 1.42 s, limit 150 ms (3x the reference's 50 ms) (too slow)",
+		"looks quadratic, O(n²)",
+		"The reference solution looks linear, O(n).",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message %q lacks %q", msg, want)
+		}
+	}
+	if msg := performanceMessage(samples[:1], 3, false); !strings.HasPrefix(msg, "No reference solution") {
+		t.Errorf("unexpected message without reference: %q", msg)
+	}
+
+	tc := TestCase{Comparator: "exact", ExpectedStdout: "42\n"}
+	for _, c := range []struct {
+		run  performanceRun
+		want string
+	}{
+		{performanceRun{Stdout: "42"}, "passed"},
+		{performanceRun{Stdout: "41"}, "wrong_output"},
+		{performanceRun{Stdout: "42", Slow: true}, "time_limit_exceeded"},
+		{performanceRun{Stdout: "41", Slow: true}, "wrong_output"},
+		{performanceRun{ExitCode: 1}, "runtime_error"},
+		{performanceRun{Problem: "generator raised an exception"}, "checker_error"},
+	} {
+		if got := performanceStatus(c.run, tc); got != c.want {
+			t.Errorf("performanceStatus(%+v) = %s, want %s", c.run, got, c.want)
+		}
+	}
+}
+
+// TestPerformanceAgainstReference grades a quadratic submission against a
+// linear reference solution in the sandbox.
+func TestPerformanceAgainstReference(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		refDir, subDir := t.TempDir(), t.TempDir()
+		writeFiles(t, refDir, map[string]string{
+			"main.py": "n = int(input())\nvals = list(map(int, input().split()))\nprint(len(set(vals)))\n",
+		})
+		writeFiles(t, subDir, map[string]string{
+			"main.py": "n = int(input())\nvals = list(map(int, input().split()))\nseen = []\nfor v in vals:\n    if v not in seen:\n        seen.append(v)\nprint(len(seen))\n",
+		})
+		cfg := `{"factor": 3, "repeats": 1, "min_limit_ms": 50, "sizes": [1500, 3000, 6000, 12000],
+			"generator": "def generate(n):\n    return '%d\\n%s\\n' % (n, ' '.join(map(str, range(n))))\n"}`
+		tc := TestCase{
+			ExecutionMode:     "performance",
+			Stdin:             "3\n1 2 1\n",
+			ExpectedStdout:    "2",
+			Comparator:        "exact",
+			TimeLimitSec:      20,
+			PerformanceConfig: &cfg,
+		}
+		ref := &referenceSandbox{dir: refDir, present: true, prog: program{rt: pythonRuntime{}, entry: "main.py"}}
+		ref.once.Do(func() {})
+
+		run := runPerformanceTest(nil, subDir, program{rt: pythonRuntime{}, entry: "main.py"}, tc, 20*time.Second, ref)
+		if run.Problem != "" {
+			t.Fatal(run.Problem)
+		}
+		if len(run.Samples) != 5 || run.Stdout != "2" {
+			t.Fatalf("samples %+v stdout %q", run.Samples, run.Stdout)
+		}
+		if status := performanceStatus(run, tc); status != "time_limit_exceeded" {
+			t.Fatalf("status %s:\n%s", status, run.Message)
+		}
+		// the exact class depends on machine load; estimateComplexity itself
+		// is tested on synthetic data
+		if !strings.Contains(run.Message, "Runtime growth over n = 1500..12000 looks") || strings.Contains(run.Message, "growth over n = 1500..12000 looks linear,") {
+			t.Fatalf("expected a super-linear estimate:\n%s", run.Message)
+		}
+	})
+}
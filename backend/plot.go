@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Plot tests grade the charts a program draws with matplotlib. They are
+// unittest tests: the harness below is added to the executePythonUnit
+// script, where student_plot() runs the program with the non-interactive
+// Agg backend and returns the figures it drew, and the assertions added to
+// unittest.TestCase inspect titles, axis labels, series and chart types.
+// The figures are rendered to PNGs after the test and attached to the
+// result as artifacts.
+
+const (
+	plotMarker = "===PLOT_FIGURES==="
+	// plotMaxFigures bounds the figures rendered for one test.
+	plotMaxFigures = 4
+	plotDPI        = 80
+)
+
+// plotInputs turns a plot test's stdin into the lines student_code() and
+// student_plot() feed to input() when called without arguments, as a
+// Python list literal. Other unittest tests pass no stdin.
+func plotInputs(stdin string) string {
+	lines := []string{}
+	if stdin = strings.TrimRight(normalizeLineEndings(stdin), "\n"); stdin != "" {
+		lines = strings.Split(stdin, "\n")
+	}
+	data, _ := json.Marshal(lines)
+	return string(data)
+}
+
+// splitPlotFigures removes the rendered figures from the harness output.
+func splitPlotFigures(stdout string) (string, []ResultArtifact) {
+	idx := strings.LastIndex(stdout, plotMarker)
+	if idx < 0 {
+		return stdout, nil
+	}
+	var figures []struct {
+		Name string `json:"name"`
+		Data string `json:"data"`
+	}
+	raw := strings.TrimSpace(stdout[idx+len(plotMarker):])
+	if line, _, ok := strings.Cut(raw, "\n"); ok {
+		raw = line
+	}
+	rest := strings.TrimRight(stdout[:idx], "\n")
+	if err := json.Unmarshal([]byte(raw), &figures); err != nil {
+		return rest, nil
+	}
+	var artifacts []ResultArtifact
+	for i, f := range figures {
+		if i >= plotMaxFigures || f.Data == "" {
+			break
+		}
+		artifacts = append(artifacts, ResultArtifact{
+			Name:        f.Name,
+			Label:       fmt.Sprintf("Figure %d", i+1),
+			ContentType: "image/png",
+			Data:        f.Data,
+		})
+	}
+	return rest, artifacts
+}
+
+// plotHarness is the Python added to the unittest script ahead of the
+// teacher's test code. It only imports matplotlib when student_plot is
+// called, so plain unittest tests do not need it installed.
+var plotHarness = `
+_PLOT_MARKER = '` + plotMarker + `'
+_PLOT_MAX_FIGURES = ` + strconv.Itoa(plotMaxFigures) + `
+_PLOT_DPI = ` + strconv.Itoa(plotDPI) + `
+_plot_figures = []
+
+class PlotResult(list):
+    """The figures one run of the student program drew, plus its output."""
+
+    def __init__(self, figures, output):
+        list.__init__(self, figures)
+        self.output = output
+
+    @property
+    def axes(self):
+        return [ax for fig in self for ax in fig.axes]
+
+    @property
+    def ax(self):
+        axes = self.axes
+        if not axes:
+            raise AssertionError('the program did not draw a chart')
+        return axes[0]
+
+def _pyplot():
+    os.environ.setdefault('MPLCONFIGDIR', '/tmp/matplotlib')
+    import matplotlib
+    matplotlib.use('Agg', force=True)
+    import matplotlib.pyplot as plt
+    return plt
+
+def student_plot(*args):
+    plt = _pyplot()
+    plt.close('all')
+    saved = plt.show, plt.pause, plt.close
+    plt.show = plt.pause = plt.close = __grader_noop__
+    try:
+        output = student_code(*args)
+    finally:
+        plt.show, plt.pause, plt.close = saved
+    figures = [plt.figure(num) for num in plt.get_fignums()]
+    _plot_figures[:] = figures
+    return PlotResult(figures, output)
+
+def _plot_axes(target):
+    if isinstance(target, PlotResult):
+        return target.ax
+    if hasattr(target, 'savefig'):
+        if not target.axes:
+            raise AssertionError('the figure has no axes')
+        return target.axes[0]
+    return target
+
+def _plot_text(value):
+    return ' '.join(str(value or '').split())
+
+def _plot_contiguous(spans):
+    spans = sorted(spans)
+    if len(spans) < 2:
+        return False
+    for (_, end), (start, _) in zip(spans, spans[1:]):
+        if abs(end - start) > 1e-9 * max(1.0, abs(start)):
+            return False
+    return True
+
+def plot_series(target):
+    """The data series of a chart as dicts with kind, label, x and y."""
+    ax = _plot_axes(target)
+    series = []
+    for line in ax.get_lines():
+        series.append({'kind': 'line', 'label': line.get_label(), 'x': list(line.get_xdata()), 'y': list(line.get_ydata())})
+    for coll in ax.collections:
+        if type(coll).__name__ != 'PathCollection':
+            continue
+        offsets = coll.get_offsets()
+        series.append({'kind': 'scatter', 'label': coll.get_label(), 'x': [float(p[0]) for p in offsets], 'y': [float(p[1]) for p in offsets]})
+    for cont in ax.containers:
+        if type(cont).__name__ != 'BarContainer':
+            continue
+        if getattr(cont, 'orientation', 'vertical') == 'horizontal':
+            kind = 'barh'
+            spans = [(p.get_y(), p.get_y() + p.get_height()) for p in cont]
+            values = [p.get_width() for p in cont]
+        else:
+            kind = 'bar'
+            spans = [(p.get_x(), p.get_x() + p.get_width()) for p in cont]
+            values = [p.get_height() for p in cont]
+        if _plot_contiguous(spans):
+            kind = 'hist'
+        series.append({'kind': kind, 'label': cont.get_label(), 'x': [(a + b) / 2 for a, b in spans], 'y': values})
+    wedges = [p for p in ax.patches if type(p).__name__ == 'Wedge']
+    if wedges:
+        series.append({'kind': 'pie', 'label': None, 'x': [w.get_label() for w in wedges], 'y': [(w.theta2 - w.theta1) / 360.0 for w in wedges]})
+    return series
+
+def _plot_category(ax, value):
+    units = ax.xaxis.get_units()
+    mapping = getattr(units, '_mapping', None)
+    if mapping and value in mapping:
+        return mapping[value]
+    return None
+
+def _plot_value_matches(ax, expected, actual, tol):
+    if isinstance(expected, str):
+        if isinstance(actual, str):
+            return expected == actual
+        expected = _plot_category(ax, expected)
+        if expected is None:
+            return False
+    try:
+        return abs(float(actual) - float(expected)) <= tol + 1e-9 * abs(float(expected))
+    except (TypeError, ValueError):
+        return actual == expected
+
+def _plot_pick_series(self, ax, series, msg):
+    found = plot_series(ax)
+    if isinstance(series, str):
+        for s in found:
+            if s['label'] == series:
+                return s
+        self.fail(self._formatMessage(msg, 'the chart has no series labelled %r' % series))
+    if series >= len(found):
+        self.fail(self._formatMessage(msg, 'the chart has %d series, expected at least %d' % (len(found), series + 1)))
+    return found[series]
+
+def _assert_plot_title(self, target, expected, msg=None):
+    ax = _plot_axes(target)
+    title = ax.get_title()
+    suptitle = getattr(ax.figure, '_suptitle', None)
+    if not title and suptitle is not None:
+        title = suptitle.get_text()
+    if _plot_text(title) != _plot_text(expected):
+        self.fail(self._formatMessage(msg, 'the chart title is %r, expected %r' % (title, expected)))
+
+def _assert_axis_labels(self, target, xlabel=None, ylabel=None, msg=None):
+    ax = _plot_axes(target)
+    for axis, expected, actual in (('x', xlabel, ax.get_xlabel()), ('y', ylabel, ax.get_ylabel())):
+        if expected is not None and _plot_text(actual) != _plot_text(expected):
+            self.fail(self._formatMessage(msg, 'the %s axis label is %r, expected %r' % (axis, actual, expected)))
+
+def _assert_series_count(self, target, expected, kind=None, msg=None):
+    found = [s for s in plot_series(target) if kind is None or s['kind'] == kind]
+    if len(found) != expected:
+        what = '%s series' % kind if kind else 'series'
+        self.fail(self._formatMessage(msg, 'the chart has %d %s, expected %d' % (len(found), what, expected)))
+
+def _assert_data_points(self, target, x=None, y=None, series=0, tol=1e-6, msg=None):
+    ax = _plot_axes(target)
+    s = _plot_pick_series(self, ax, series, msg)
+    name = 'series %r' % (s['label'] if isinstance(series, str) else series)
+    for axis, expected in (('x', x), ('y', y)):
+        if expected is None:
+            continue
+        actual = s[axis]
+        expected = list(expected)
+        if len(actual) != len(expected):
+            self.fail(self._formatMessage(msg, '%s has %d points, expected %d' % (name, len(actual), len(expected))))
+        for i, (e, a) in enumerate(zip(expected, actual)):
+            if not _plot_value_matches(ax, e, a, tol):
+                self.fail(self._formatMessage(msg, '%s point %d: %s is %r, expected %r' % (name, i + 1, axis, a, e)))
+
+def _assert_chart_type(self, target, kind, msg=None):
+    kinds = []
+    for s in plot_series(target):
+        if s['kind'] not in kinds:
+            kinds.append(s['kind'])
+    if kind not in kinds:
+        drawn = ', '.join(kinds) if kinds else 'empty'
+        self.fail(self._formatMessage(msg, 'the chart is %s, expected %s' % (drawn, kind)))
+
+unittest.TestCase.assertPlotTitle = _assert_plot_title
+unittest.TestCase.assertAxisLabels = _assert_axis_labels
+unittest.TestCase.assertSeriesCount = _assert_series_count
+unittest.TestCase.assertDataPoints = _assert_data_points
+unittest.TestCase.assertChartType = _assert_chart_type
+
+def _emit_plot_figures():
+    if not _plot_figures:
+        return
+    import base64, json
+    figures = []
+    for fig in _plot_figures[:_PLOT_MAX_FIGURES]:
+        buf = io.BytesIO()
+        try:
+            fig.savefig(buf, format='png', dpi=_PLOT_DPI)
+        except Exception:
+            continue
+        figures.append({'name': 'figure_%d.png' % (len(figures) + 1), 'data': base64.b64encode(buf.getvalue()).decode('ascii')})
+    sys.__stdout__.write('\n' + _PLOT_MARKER + json.dumps(figures) + '\n')
+    sys.__stdout__.flush()
+`
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitPlotFigures(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString([]byte("png"))
+	stdout := "drawing\n" + plotMarker + `[{"name": "figure_1.png", "data": "` + data + `"}]` + "\n"
+	rest, figures := splitPlotFigures(stdout)
+	if rest != "drawing" || len(figures) != 1 {
+		t.Fatalf("unexpected split %q %+v", rest, figures)
+	}
+	if figures[0].Label != "Figure 1" || figures[0].ContentType != "image/png" || figures[0].Data != data {
+		t.Errorf("unexpected artifact %+v", figures[0])
+	}
+	if rest, figures := splitPlotFigures("no figures"); rest != "no figures" || figures != nil {
+		t.Errorf("output without figures changed: %q %+v", rest, figures)
+	}
+	if got := plotInputs("3\r\nred\n\n"); got != `["3","red"]` {
+		t.Errorf("unexpected inputs %s", got)
+	}
+	if got := plotInputs(""); got != "[]" {
+		t.Errorf("unexpected empty inputs %s", got)
+	}
+}
+
+// TestPlotAssertions runs plot tests through the unittest harness. It needs
+// matplotlib, which the sandbox images ship but development machines may not.
+func TestPlotAssertions(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	if err := exec.Command(pythonBinary, "-c", "import matplotlib").Run(); err != nil {
+		t.Skip("matplotlib not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		student := "import matplotlib.pyplot as plt\n\nn = int(input())\nxs = list(range(1, n + 1))\nplt.plot(xs, [x * x for x in xs], label='squares')\nplt.bar(['a', 'b'], [3, 5])\nplt.title('Squares')\nplt.xlabel('n')\nplt.ylabel('n squared')\nprint('plotted', n)\nplt.show()\n"
+		run := func(t *testing.T, test string) (string, string, int, []ResultArtifact) {
+			t.Helper()
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.py": student})
+			code := "class T(unittest.TestCase):\n    def test_plot(self):\n" + test
+			stdout, stderr, exitCode, _, _, _ := executePythonUnit(nil, dir, "main.py", code, "T.test_plot", "4\n", 30*time.Second, 0)
+			stdout, figures := splitPlotFigures(stdout)
+			return stdout, stderr, exitCode, figures
+		}
+
+		good := "        p = student_plot()\n" +
+			"        self.assertEqual(p.output, 'plotted 4')\n" +
+			"        self.assertPlotTitle(p, 'Squares')\n" +
+			"        self.assertAxisLabels(p, xlabel='n', ylabel='n squared')\n" +
+			"        self.assertSeriesCount(p, 2)\n" +
+			"        self.assertChartType(p, 'line')\n" +
+			"        self.assertChartType(p, 'bar')\n" +
+			"        self.assertDataPoints(p, x=[1, 2, 3, 4], y=[1, 4, 9, 16.000001], tol=0.01)\n" +
+			"        self.assertDataPoints(p, series=1, x=['a', 'b'], y=[3, 5])\n"
+		_, stderr, exitCode, figures := run(t, good)
+		if exitCode != 0 {
+			t.Fatalf("exit %d: %s", exitCode, stderr)
+		}
+		if len(figures) != 1 {
+			t.Fatalf("expected one figure, got %d", len(figures))
+		}
+		data, _ := base64.StdEncoding.DecodeString(figures[0].Data)
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("figure is not a PNG: %v", err)
+		}
+
+		failing := map[string]string{
+			"        self.assertDataPoints(student_plot(), y=[1, 4, 9, 15])\n": "point 4: y is 16",
+			"        self.assertChartType(student_plot(), 'pie')\n":            "the chart is line, bar, expected pie",
+			"        self.assertPlotTitle(student_plot('2'), 'Cubes')\n":       "the chart title is 'Squares', expected 'Cubes'",
+		}
+		for test, msg := range failing {
+			stdout, stderr, exitCode, _ := run(t, test)
+			if exitCode == 0 || !strings.Contains(stdout, "===JUDGE:ASSERT_FAIL===") || !strings.Contains(stderr, msg) {
+				t.Errorf("%s: exit %d, stderr %q", strings.TrimSpace(test), exitCode, stderr)
+			}
+		}
+	})
+}
+
+// TestUnittestIgnoresStoredStdin checks that only plot tests hand their stdin
+// to student_code() called without arguments.
+func TestUnittestIgnoresStoredStdin(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		test := "class T(unittest.TestCase):\n    def test_input(self):\n        with self.assertRaises(EOFError):\n            student_code()\n"
+		tc := TestCase{ExecutionMode: "unittest", Stdin: "4\n", UnittestCode: &test, UnittestName: strPtr("T.test_input")}
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"main.py": "print(int(input()) * 2)\n"})
+		if _, stderr, exitCode, _, _, _ := (pythonRuntime{}).runUnit(nil, dir, "main.py", tc, 20*time.Second); exitCode != 0 {
+			t.Fatalf("exit %d: %s", exitCode, stderr)
+		}
+	})
+}
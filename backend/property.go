@@ -0,0 +1,594 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Property tests compare a submission with the reference solution on random
+// inputs instead of hand-written ones, so hardcoding the visible outputs does
+// not help. A teacher-written Python generator produces every input from a
+// seeded random.Random:
+//
+//	def generate(rng):
+//	    return [[rng.randint(-50, 50) for _ in range(rng.randint(0, 10))]]
+//
+// With function_name set the value is the argument list of a call and the
+// student's and the reference's function are called with all inputs in one
+// run each, as in function tests. Otherwise the value becomes stdin (through
+// to_stdin(value) if the generator defines it) and both programs run once per
+// input. The first failing input is shrunk to a minimal counterexample with
+// the generator's shrink(value), or a generic shrinker for numbers, strings,
+// lists and dicts, and shown to the student.
+
+var propertyScriptTimeout = getenvDurationOr("PROPERTY_SCRIPT_TIMEOUT", 30*time.Second)
+
+const (
+	propertyGeneratorFile = "__property_generator__.py"
+	propertyRunnerFile    = "__property_runner__.py"
+	propertyRequestFile   = "__property_request__.json"
+	propertyMarker        = "===PROPERTY_JSON==="
+
+	propertyMaxRuns    = 1000
+	propertyMaxShrinks = 200
+	// propertyCandidates bounds the shrink candidates tried per step.
+	propertyCandidates = 20
+)
+
+// PropertyConfig is stored as JSON in test_cases.property_config.
+type PropertyConfig struct {
+	Generator string `json:"generator"`
+	// Runs is the number of random inputs per grading run.
+	Runs int   `json:"runs"`
+	Seed int64 `json:"seed"`
+	// VarySeed mixes the submission ID into the seed so that every
+	// submission sees other inputs. Teacher runs use Seed as is.
+	VarySeed bool `json:"vary_seed"`
+	// MaxShrinks bounds the shrinking steps of a counterexample.
+	MaxShrinks int `json:"max_shrinks"`
+}
+
+func (cfg *PropertyConfig) normalize() error {
+	if cfg.Runs == 0 {
+		cfg.Runs = 100
+	}
+	if cfg.MaxShrinks == 0 {
+		cfg.MaxShrinks = 50
+	}
+	cfg.Generator = strings.TrimSpace(cfg.Generator)
+	switch {
+	case cfg.Generator == "":
+		return errors.New("generator is required")
+	case !strings.Contains(cfg.Generator, "def generate("):
+		return errors.New("generator must define generate(rng)")
+	case cfg.Runs < 1 || cfg.Runs > propertyMaxRuns:
+		return fmt.Errorf("runs must be between 1 and %d", propertyMaxRuns)
+	case cfg.MaxShrinks < 0 || cfg.MaxShrinks > propertyMaxShrinks:
+		return fmt.Errorf("max_shrinks must be between 0 and %d", propertyMaxShrinks)
+	}
+	return nil
+}
+
+// parsePropertyConfig decodes a stored config.
+func parsePropertyConfig(raw *string) (*PropertyConfig, error) {
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return nil, errors.New("property_config is required")
+	}
+	cfg := &PropertyConfig{}
+	dec := json.NewDecoder(strings.NewReader(*raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("property_config must be a JSON object: %v", err)
+	}
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validatePropertyTest checks a property test at authoring time and returns
+// the normalized config to store. The reference output is compared with the
+// test's comparator, which cannot be a regex.
+func validatePropertyTest(raw *string, comparator string, comparatorOpts *string) (*string, error) {
+	if normalizeComparator(comparator) == comparatorRegex {
+		return nil, errors.New("the regex comparator cannot be used with property tests")
+	}
+	if err := validateComparator(comparator, comparatorOpts, ""); err != nil {
+		return nil, err
+	}
+	cfg, err := parsePropertyConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
+// propertyRuns is how many times a test may start the program in the worst
+// case; it scales the VM session budget like performanceRuns.
+func propertyRuns(tc TestCase) int {
+	if tc.ExecutionMode != "property" {
+		return 1
+	}
+	cfg, err := parsePropertyConfig(tc.PropertyConfig)
+	if err != nil {
+		return 1
+	}
+	if tc.FunctionName != nil {
+		return 1 + cfg.MaxShrinks
+	}
+	return cfg.Runs + cfg.MaxShrinks*propertyCandidates
+}
+
+// propertySeed is the seed of a grading run.
+func propertySeed(cfg *PropertyConfig, subID uuid.UUID) int64 {
+	if !cfg.VarySeed || subID == uuid.Nil {
+		return cfg.Seed
+	}
+	h := fnv.New64a()
+	h.Write(subID[:])
+	return cfg.Seed ^ int64(h.Sum64()>>1)
+}
+
+const propertyRunnerScript = `import json
+import math
+import pathlib
+import random
+import sys
+import traceback
+
+MARKER = "===PROPERTY_JSON==="
+HERE = pathlib.Path(__file__).resolve().parent
+
+
+def generic_shrink(value):
+    if isinstance(value, bool):
+        if value:
+            yield False
+    elif isinstance(value, int):
+        if value != 0:
+            yield 0
+            if value < 0:
+                yield -value
+            yield int(value / 2)
+            yield value - 1 if value > 0 else value + 1
+    elif isinstance(value, float):
+        if value != 0 and math.isfinite(value):
+            yield 0.0
+            yield float(int(value))
+            yield value / 2
+    elif isinstance(value, str):
+        if value:
+            yield ""
+            yield value[:len(value) // 2]
+            yield value[len(value) // 2:]
+            for i in range(len(value)):
+                yield value[:i] + value[i + 1:]
+            for i in range(len(value)):
+                yield value[:i] + "a" + value[i + 1:]
+    elif isinstance(value, list):
+        if value:
+            yield []
+            yield value[:len(value) // 2]
+            yield value[len(value) // 2:]
+            for i in range(len(value)):
+                yield value[:i] + value[i + 1:]
+            yield from shrink_items(value)
+    elif isinstance(value, dict):
+        for key in value:
+            yield {k: v for k, v in value.items() if k != key}
+        for key, item in value.items():
+            for smaller in generic_shrink(item):
+                yield {**value, key: smaller}
+
+
+def shrink_items(items):
+    # shrinks one item at a time and keeps the length, e.g. of argument lists
+    for i, item in enumerate(items):
+        for smaller in generic_shrink(item):
+            yield items[:i] + [smaller] + items[i + 1:]
+
+
+def plain(value, what):
+    try:
+        return json.loads(json.dumps(value))
+    except (TypeError, ValueError):
+        raise TypeError("%s is not JSON-serializable: %r" % (what, value)) from None
+
+
+def as_args(value, what):
+    if not isinstance(value, (list, tuple)):
+        raise TypeError("%s must be the list of arguments, got %s" % (what, type(value).__name__))
+    return plain(list(value), what)
+
+
+def stdin_of(gen, value):
+    to_stdin = getattr(gen, "to_stdin", None)
+    if to_stdin is not None:
+        text = to_stdin(value)
+    elif isinstance(value, str):
+        text = value
+    elif isinstance(value, list):
+        text = "\n".join(map(str, value))
+    else:
+        text = str(value)
+    if not isinstance(text, str):
+        raise TypeError("to_stdin returned %s, expected str" % type(text).__name__)
+    return text if text.endswith("\n") else text + "\n"
+
+
+def main():
+    sys.path.append(str(HERE))
+    try:
+        req = json.loads((HERE / "__property_request__.json").read_text())
+        import __property_generator__ as gen
+        function = req["function"]
+        values = []
+        if req["action"] == "generate":
+            for i in range(req["runs"]):
+                # every input depends only on the seed and its index
+                value = gen.generate(random.Random("%d:%d" % (req["seed"], i)))
+                values.append(as_args(value, "generate(rng)") if function else plain(value, "generate(rng)"))
+        else:
+            value = req["value"]
+            if hasattr(gen, "shrink"):
+                candidates = gen.shrink(value)
+            elif function:
+                candidates = shrink_items(value)
+            else:
+                candidates = generic_shrink(value)
+            seen = {json.dumps(value, sort_keys=True)}
+            for candidate in candidates:
+                candidate = as_args(candidate, "shrink(value)") if function else plain(candidate, "shrink(value)")
+                key = json.dumps(candidate, sort_keys=True)
+                if key in seen:
+                    continue
+                seen.add(key)
+                values.append(candidate)
+                if len(values) >= req["limit"]:
+                    break
+        out = {"values": values}
+        if not function:
+            out["stdins"] = [stdin_of(gen, v) for v in values]
+    except Exception:  # noqa: BLE001
+        print(MARKER + json.dumps({"error": traceback.format_exc()}))
+        sys.exit(2)
+    print(MARKER + json.dumps(out))
+
+
+if __name__ == "__main__":
+    main()
+`
+
+// propertyInput is one generated input: the JSON value and, for programs,
+// the stdin made from it.
+type propertyInput struct {
+	Value json.RawMessage
+	Stdin string
+}
+
+// propertyInputs runs the generator in the reference sandbox, apart from the
+// student's code. With value set it returns shrink candidates of value.
+func propertyInputs(ref *referenceSandbox, cfg *PropertyConfig, function bool, seed int64, value json.RawMessage) ([]propertyInput, error) {
+	req := map[string]any{"action": "generate", "function": function, "seed": seed, "runs": cfg.Runs}
+	if value != nil {
+		req = map[string]any{"action": "shrink", "function": function, "value": value, "limit": propertyCandidates}
+	}
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{
+		propertyGeneratorFile: cfg.Generator,
+		propertyRunnerFile:    propertyRunnerScript,
+		propertyRequestFile:   string(reqJSON),
+	}
+	var payload struct {
+		Values []json.RawMessage `json:"values"`
+		Stdins []string          `json:"stdins"`
+	}
+	if err := ref.runTeacherScript(files, propertyRunnerFile, propertyMarker, propertyScriptTimeout, &payload); err != nil {
+		return nil, fmt.Errorf("generator: %w", err)
+	}
+	if !function && len(payload.Stdins) != len(payload.Values) {
+		return nil, errors.New("generator: missing stdin")
+	}
+	inputs := make([]propertyInput, len(payload.Values))
+	for i, v := range payload.Values {
+		inputs[i].Value = v
+		if !function {
+			inputs[i].Stdin = payload.Stdins[i]
+		}
+	}
+	return inputs, nil
+}
+
+// propertyFailure describes why an input failed.
+type propertyFailure struct {
+	Status   string
+	Expected string
+	Actual   string
+}
+
+// propertyRun is the outcome of a property test.
+type propertyRun struct {
+	Status   string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Runtime  time.Duration
+	Memory   memoryUsage
+	// Problem reports a failure of the reference solution or generator.
+	Problem string
+	Message string
+}
+
+// propertyChecker runs inputs through the submission and the reference.
+type propertyChecker struct {
+	sess     *vmSession
+	workDir  string
+	prog     program
+	tc       TestCase
+	timeout  time.Duration
+	ref      *referenceSandbox
+	function string
+}
+
+// check returns a failure or nil per input. In program mode it stops at the
+// first failing input. A non-nil run means the check could not finish.
+//
+// Shrink candidates the reference solution fails on are outside the inputs
+// the generator would produce and never count as failures.
+func (c *propertyChecker) check(inputs []propertyInput, shrinking bool) ([]*propertyFailure, *propertyRun) {
+	if c.function != "" {
+		return c.checkFunction(inputs, shrinking)
+	}
+	failures := make([]*propertyFailure, len(inputs))
+	for i, in := range inputs {
+		expected, _, err := c.ref.runStdin(c.tc, in.Stdin, c.timeout)
+		if err != nil && shrinking {
+			continue
+		}
+		if err != nil {
+			return nil, &propertyRun{Problem: fmt.Sprintf("%v\nInput:\n%s", err, in.Stdin)}
+		}
+		stdout, stderr, exitCode, timedOut, runtime, mem := c.prog.rt.runStdin(c.sess, c.workDir, c.prog.entry, in.Stdin, c.timeout, c.tc.MemoryLimitKB)
+		run := &propertyRun{Stdout: stdout, Stderr: stderr, ExitCode: exitCode, TimedOut: timedOut, Runtime: runtime, Memory: mem,
+			Message: "Input:\n" + strings.TrimRight(in.Stdin, "\n")}
+		switch {
+		case timedOut:
+			run.Status = "time_limit_exceeded"
+			return nil, run
+		case mem.LimitExceeded:
+			run.Status = "memory_limit_exceeded"
+			return nil, run
+		case exitCode != 0:
+			failures[i] = &propertyFailure{Status: "runtime_error", Expected: expected, Actual: strings.TrimSpace(stderr)}
+		default:
+			actual := normalizeActualStdout(trimTrailingNewline(stdout))
+			if !stdoutMatches(c.tc, actual, normalizeExpectedStdout(trimTrailingNewline(expected))) {
+				failures[i] = &propertyFailure{Status: "wrong_output", Expected: expected, Actual: actual}
+			}
+		}
+		if failures[i] != nil {
+			break
+		}
+	}
+	return failures, nil
+}
+
+func (c *propertyChecker) checkFunction(inputs []propertyInput, shrinking bool) ([]*propertyFailure, *propertyRun) {
+	values := make([]json.RawMessage, len(inputs))
+	for i, in := range inputs {
+		values[i] = in.Value
+	}
+	batch, err := json.Marshal(values)
+	if err != nil {
+		return nil, &propertyRun{Problem: err.Error()}
+	}
+	cfg := functionCallConfig{FunctionName: c.function, BatchJSON: strPtr(string(batch))}
+	want, err := c.ref.runFunction(c.tc, cfg, c.timeout)
+	if err == nil && (want.Status == "exception" || len(want.Batch) != len(inputs)) {
+		err = fmt.Errorf("reference solution failed: %s", want.Exception)
+	}
+	if err != nil {
+		return nil, &propertyRun{Problem: err.Error()}
+	}
+
+	stdout, stderr, exitCode, timedOut, runtime, mem, got, err := c.prog.rt.runFunction(c.sess, c.workDir, c.prog.entry, cfg, c.timeout, c.tc.MemoryLimitKB)
+	run := &propertyRun{Stdout: stdout, Stderr: stderr, ExitCode: exitCode, TimedOut: timedOut, Runtime: runtime, Memory: mem}
+	switch {
+	case err != nil:
+		run.Status, run.Stderr, run.ExitCode = "runtime_error", err.Error(), -1
+		return nil, run
+	case timedOut:
+		run.Status = "time_limit_exceeded"
+		return nil, run
+	case mem.LimitExceeded:
+		run.Status = "memory_limit_exceeded"
+		return nil, run
+	case got == nil || got.Status == "exception" || len(got.Batch) != len(inputs):
+		// the module could not be loaded or lacks the function
+		run.Status = "runtime_error"
+		if got != nil && got.Traceback != "" {
+			run.Stderr = got.Traceback
+		}
+		return nil, run
+	}
+
+	failures := make([]*propertyFailure, len(inputs))
+	for i := range inputs {
+		if shrinking && want.Batch[i].Status == "exception" {
+			continue
+		}
+		failures[i] = compareBatchCalls(want.Batch[i], got.Batch[i])
+	}
+	return failures, nil
+}
+
+// compareBatchCalls compares the student's call with the reference's. When
+// the reference raises, the student must raise the same exception type.
+func compareBatchCalls(want, got functionBatchCall) *propertyFailure {
+	expected := want.ReturnRepr
+	if want.Status == "exception" {
+		expected = "raises " + want.ExceptionType
+		if got.Status == "exception" && got.ExceptionType == want.ExceptionType {
+			return nil
+		}
+	}
+	if got.Status == "exception" {
+		return &propertyFailure{Status: "runtime_error", Expected: expected, Actual: "raised " + got.Exception}
+	}
+	if want.Status != "exception" && sameReturn(want, got) {
+		return nil
+	}
+	return &propertyFailure{Status: "wrong_output", Expected: expected, Actual: got.ReturnRepr}
+}
+
+// sameReturn compares return values as JSON, or by repr when they are not
+// JSON-serializable.
+func sameReturn(want, got functionBatchCall) bool {
+	if want.ReturnJSON == nil || got.ReturnJSON == nil {
+		return want.ReturnJSON == nil && got.ReturnJSON == nil && want.ReturnRepr == got.ReturnRepr
+	}
+	var a, b any
+	if json.Unmarshal([]byte(*want.ReturnJSON), &a) != nil || json.Unmarshal([]byte(*got.ReturnJSON), &b) != nil {
+		return *want.ReturnJSON == *got.ReturnJSON
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func firstFailure(failures []*propertyFailure) int {
+	for i, f := range failures {
+		if f != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func runPropertyTest(sess *vmSession, workDir string, prog program, tc TestCase, timeout time.Duration, ref *referenceSandbox, subID uuid.UUID) propertyRun {
+	cfg, err := parsePropertyConfig(tc.PropertyConfig)
+	if err != nil {
+		return propertyRun{ExitCode: -1, Problem: err.Error()}
+	}
+	if ref == nil {
+		return propertyRun{ExitCode: -1, Problem: "property tests are not available here"}
+	}
+	if err := ref.load(); err != nil {
+		return propertyRun{ExitCode: -1, Problem: err.Error()}
+	}
+	if !ref.present {
+		return propertyRun{ExitCode: -1, Problem: "property tests need a reference solution"}
+	}
+	c := &propertyChecker{sess: sess, workDir: workDir, prog: prog, tc: tc, timeout: timeout, ref: ref,
+		function: strings.TrimSpace(stringOrEmpty(tc.FunctionName))}
+	function := c.function != ""
+	seed := propertySeed(cfg, subID)
+
+	inputs, err := propertyInputs(ref, cfg, function, seed, nil)
+	if err != nil {
+		return propertyRun{ExitCode: -1, Problem: err.Error()}
+	}
+	failures, halt := c.check(inputs, false)
+	if halt != nil {
+		if halt.Problem == "" {
+			halt.Message = strings.TrimSpace(fmt.Sprintf("Checked random inputs with seed %d.\n%s", seed, halt.Message))
+		}
+		return *halt
+	}
+	idx := firstFailure(failures)
+	if idx == -1 {
+		return propertyRun{Status: "passed", Message: fmt.Sprintf("All %d random inputs (seed %d) matched the reference solution.", len(inputs), seed)}
+	}
+
+	var summary string
+	if function {
+		failed := 0
+		for _, f := range failures {
+			if f != nil {
+				failed++
+			}
+		}
+		summary = fmt.Sprintf("%d of %d random inputs (seed %d) failed.", failed, len(inputs), seed)
+	} else {
+		summary = fmt.Sprintf("Random input %d of %d (seed %d) failed.", idx+1, len(inputs), seed)
+	}
+
+	best, failure := inputs[idx], failures[idx]
+	steps := 0
+	for steps < cfg.MaxShrinks {
+		candidates, err := propertyInputs(ref, cfg, function, seed, best.Value)
+		if err != nil {
+			return propertyRun{ExitCode: -1, Problem: err.Error()}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		// candidates that cannot be checked (e.g. one loops) end shrinking
+		results, halt := c.check(candidates, true)
+		if halt != nil {
+			break
+		}
+		i := firstFailure(results)
+		if i == -1 {
+			break
+		}
+		best, failure = candidates[i], results[i]
+		steps++
+	}
+
+	run := propertyRun{Status: failure.Status, Message: summary + "\n" + c.describe(best, failure, steps)}
+	if failure.Status == "runtime_error" {
+		run.Stderr, run.ExitCode = failure.Actual, 1
+	} else {
+		run.Stdout = failure.Actual
+	}
+	return run
+}
+
+// describe formats a counterexample for the student.
+func (c *propertyChecker) describe(in propertyInput, f *propertyFailure, steps int) string {
+	var b strings.Builder
+	if steps > 0 {
+		fmt.Fprintf(&b, "Minimal counterexample (shrunk in %d steps):\n", steps)
+	} else {
+		b.WriteString("Counterexample:\n")
+	}
+	if c.function != "" {
+		var args []json.RawMessage
+		_ = json.Unmarshal(in.Value, &args)
+		parts := make([]string, len(args))
+		for i, a := range args {
+			parts[i] = string(a)
+		}
+		fmt.Fprintf(&b, "  %s(%s)\nExpected: %s\nGot: %s", c.function, strings.Join(parts, ", "), f.Expected, f.Actual)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Input:\n%s\nExpected output:\n%s\n", strings.TrimRight(in.Stdin, "\n"), strings.TrimRight(f.Expected, "\n"))
+	if f.Status == "runtime_error" {
+		fmt.Fprintf(&b, "Error:\n%s", f.Actual)
+	} else {
+		fmt.Fprintf(&b, "Got:\n%s", f.Actual)
+	}
+	return b.String()
+}
+
+func propertyStatus(run propertyRun) string {
+	if run.Problem != "" {
+		return "checker_error"
+	}
+	return run.Status
+}
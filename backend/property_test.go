@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPropertyConfigNormalize(t *testing.T) {
+	raw := `{"generator": "def generate(rng):\n    return [rng.randint(0, 9)]\n", "seed": 7}`
+	stored, err := validatePropertyTest(&raw, "exact", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parsePropertyConfig(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Runs != 100 || cfg.MaxShrinks != 50 || cfg.Seed != 7 {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	for _, bad := range []string{
+		``,
+		`{"runs": 10}`,
+		`{"generator": "print(1)"}`,
+		`{"generator": "def generate(rng): return [1]", "runs": 5000}`,
+		`{"generator": "def generate(rng): return [1]", "max_shrinks": -1}`,
+		`{"generator": "def generate(rng): return [1]", "inputs": 3}`,
+	} {
+		if _, err := validatePropertyTest(&bad, "exact", nil); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+	if _, err := validatePropertyTest(&raw, "regex", nil); err == nil {
+		t.Fatalf("regex comparator should be rejected")
+	}
+
+	fn := "total"
+	tc := TestCase{ExecutionMode: "property", PropertyConfig: stored}
+	if n := propertyRuns(tc); n != 100+50*propertyCandidates {
+		t.Fatalf("propertyRuns of a program = %d", n)
+	}
+	tc.FunctionName = &fn
+	if n := propertyRuns(tc); n != 51 {
+		t.Fatalf("propertyRuns of a function = %d", n)
+	}
+
+	sub := uuid.New()
+	if propertySeed(cfg, sub) != 7 {
+		t.Fatalf("seed changed without vary_seed")
+	}
+	cfg.VarySeed = true
+	if s := propertySeed(cfg, sub); s == 7 || s != propertySeed(cfg, sub) {
+		t.Fatalf("varied seed %d is not a stable mix of the submission", s)
+	}
+	if propertySeed(cfg, uuid.Nil) != 7 {
+		t.Fatalf("teacher runs should use the configured seed")
+	}
+}
+
+func TestCompareBatchCalls(t *testing.T) {
+	ret := func(j, repr string) functionBatchCall {
+		return functionBatchCall{Status: "ok", ReturnJSON: &j, ReturnRepr: repr}
+	}
+	raise := func(typ string) functionBatchCall {
+		return functionBatchCall{Status: "exception", Exception: typ + "()", ExceptionType: typ}
+	}
+	for _, c := range []struct {
+		want, got functionBatchCall
+		status    string
+	}{
+		{ret(`{"a": 1, "b": [1, 2]}`, ""), ret(`{"b": [1, 2], "a": 1}`, ""), ""},
+		{ret(`[1, 2]`, "(1, 2)"), ret(`[1, 2]`, "[1, 2]"), ""},
+		{ret(`3`, "3"), ret(`4`, "4"), "wrong_output"},
+		{ret(`3`, "3"), raise("ZeroDivisionError"), "runtime_error"},
+		{raise("ValueError"), raise("ValueError"), ""},
+		{raise("ValueError"), raise("TypeError"), "runtime_error"},
+		{raise("ValueError"), ret(`0`, "0"), "wrong_output"},
+		{functionBatchCall{ReturnRepr: "<obj>"}, functionBatchCall{ReturnRepr: "<obj>"}, ""},
+	} {
+		f := compareBatchCalls(c.want, c.got)
+		if (f == nil && c.status != "") || (f != nil && f.Status != c.status) {
+			t.Errorf("compareBatchCalls(%+v, %+v) = %+v, want %q", c.want, c.got, f, c.status)
+		}
+	}
+}
+
+// TestPropertyAgainstReference shrinks the counterexamples of buggy
+// submissions in the sandbox, for a function and for a program.
+func TestPropertyAgainstReference(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		refDir := t.TempDir()
+		writeFiles(t, refDir, map[string]string{
+			"main.py": "def total(xs):\n    return sum(xs)\n\n\nif __name__ == '__main__':\n    input()\n    print(max(map(int, input().split())))\n",
+		})
+		ref := &referenceSandbox{dir: refDir, present: true, prog: program{rt: pythonRuntime{}, entry: "main.py"}}
+		ref.once.Do(func() {})
+		prog := program{rt: pythonRuntime{}, entry: "main.py"}
+
+		fn := "total"
+		funcCfg := `{"runs": 30, "seed": 3, "generator": "def generate(rng):\n    return [[rng.randint(-50, 50) for _ in range(rng.randint(1, 8))]]\n"}`
+		tc := TestCase{ExecutionMode: "property", Comparator: "exact", TimeLimitSec: 10, FunctionName: &fn, PropertyConfig: &funcCfg}
+
+		good := t.TempDir()
+		writeFiles(t, good, map[string]string{"main.py": "def total(xs):\n    s = 0\n    for x in xs:\n        s += x\n    return s\n"})
+		if run := runPropertyTest(nil, good, prog, tc, 10*time.Second, ref, uuid.Nil); propertyStatus(run) != "passed" {
+			t.Fatalf("correct function failed: %+v", run)
+		}
+
+		bad := t.TempDir()
+		writeFiles(t, bad, map[string]string{"main.py": "def total(xs):\n    return sum(x for x in xs if x > 0)\n"})
+		run := runPropertyTest(nil, bad, prog, tc, 10*time.Second, ref, uuid.Nil)
+		if propertyStatus(run) != "wrong_output" {
+			t.Fatalf("status %s: %+v", propertyStatus(run), run)
+		}
+		for _, want := range []string{"random inputs (seed 3) failed", "total([-1])", "Expected: -1", "Got: 0"} {
+			if !strings.Contains(run.Message, want) {
+				t.Errorf("message %q lacks %q", run.Message, want)
+			}
+		}
+
+		progCfg := `{"runs": 10, "seed": 5, "generator": "def generate(rng):\n    return [rng.randint(-30, -1) for _ in range(rng.randint(2, 5))]\n\n\ndef to_stdin(xs):\n    return '%d\\n%s\\n' % (len(xs), ' '.join(map(str, xs)))\n"}`
+		tc = TestCase{ExecutionMode: "property", Comparator: "exact", TimeLimitSec: 10, PropertyConfig: &progCfg}
+		writeFiles(t, bad, map[string]string{"main.py": "input()\nbest = 0\nfor x in map(int, input().split()):\n    best = max(best, x)\nprint(best)\n"})
+		run = runPropertyTest(nil, bad, prog, tc, 10*time.Second, ref, uuid.Nil)
+		if propertyStatus(run) != "wrong_output" {
+			t.Fatalf("status %s: %+v", propertyStatus(run), run)
+		}
+		// the empty list is skipped because the reference fails on it
+		if !strings.Contains(run.Message, "Input:\n1\n-1\nExpected output:\n-1\nGot:\n0") {
+			t.Errorf("unexpected counterexample:\n%s", run.Message)
+		}
+	})
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Besides unittest classes, uploaded Python test files can be pytest
+// modules or templates with doctests. Uploads are split into one test case
+// per test: pytest functions and methods become "pytest" tests, one per
+// case when they are parametrized with a literal list, and docstrings with
+// examples become "doctest" tests. The whole file is stored as the test's
+// unittest_code and the selected test as its unittest_name; pytest runs in
+// the sandbox with a plugin that deselects everything else, and doctests
+// take the examples from the uploaded file and run them against the
+// student's module.
+
+const (
+	pytestSuiteFile   = "__pytest_suite__.py"
+	doctestSourceFile = "__doctests__.py"
+	// doctestModule names the examples in the module docstring.
+	doctestModule = "<module>"
+	// pytestMaxCases bounds the parametrize cases split into separate tests;
+	// larger tables run as a single test.
+	pytestMaxCases = 100
+)
+
+// detectPythonSuite splits an uploaded test file into test names. An empty
+// mode tries unittest, pytest and doctest in that order.
+func detectPythonSuite(src, mode string) (string, []string, error) {
+	parsers := []struct {
+		mode  string
+		parse func(string) []string
+	}{
+		{"unittest", parseUnittestMethods},
+		{"pytest", parsePytestItems},
+		{"doctest", parseDoctestBlocks},
+	}
+	known := mode == ""
+	for _, p := range parsers {
+		if mode != "" && mode != p.mode {
+			continue
+		}
+		known = true
+		if names := p.parse(src); len(names) > 0 {
+			return p.mode, names, nil
+		}
+	}
+	if !known {
+		return "", nil, fmt.Errorf("unknown test file mode %q", mode)
+	}
+	return mode, nil, nil
+}
+
+// pythonLine is a logical line of Python source: physical lines joined while
+// a bracket or a triple-quoted string is open, without comments.
+type pythonLine struct {
+	Indent int
+	Text   string
+}
+
+func pythonLogicalLines(src string) []pythonLine {
+	src = normalizeLeadingTabsToSpaces(normalizeLineEndings(src))
+	var lines []pythonLine
+	var cur strings.Builder
+	indent, depth := 0, 0
+	atStart := true
+	emit := func() {
+		if text := strings.TrimSpace(cur.String()); text != "" {
+			lines = append(lines, pythonLine{Indent: indent, Text: text})
+		}
+		cur.Reset()
+		indent, depth, atStart = 0, 0, true
+	}
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if atStart {
+			switch c {
+			case ' ':
+				indent++
+				continue
+			case '\n':
+				indent = 0
+				continue
+			}
+			atStart = false
+		}
+		switch {
+		case c == '#':
+			for i+1 < len(src) && src[i+1] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			end := pythonStringEnd(src, i)
+			cur.WriteString(src[i:end])
+			i = end - 1
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			cur.WriteByte(' ')
+			i++
+		case c == '\n':
+			if depth > 0 {
+				cur.WriteByte(' ')
+			} else {
+				emit()
+			}
+		default:
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				if depth > 0 {
+					depth--
+				}
+			}
+			cur.WriteByte(c)
+		}
+	}
+	emit()
+	return lines
+}
+
+// pythonStringEnd returns the index just past the string literal starting
+// at src[start]. Unterminated single-quoted strings end at the line break.
+func pythonStringEnd(src string, start int) int {
+	q := src[start]
+	triple := strings.HasPrefix(src[start:], strings.Repeat(string(q), 3))
+	i := start + 1
+	if triple {
+		i = start + 3
+	}
+	for i < len(src) {
+		switch {
+		case src[i] == '\\':
+			i += 2
+			continue
+		case triple && strings.HasPrefix(src[i:], strings.Repeat(string(q), 3)):
+			return i + 3
+		case !triple && src[i] == q:
+			return i + 1
+		case !triple && src[i] == '\n':
+			return i
+		}
+		i++
+	}
+	return len(src)
+}
+
+// pythonSplitTopLevel splits s at the commas outside brackets and strings.
+func pythonSplitTopLevel(s string) []string {
+	var parts []string
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			i = pythonStringEnd(s, i) - 1
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[last:i]))
+				last = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[last:]); rest != "" {
+		parts = append(parts, rest)
+	}
+	return parts
+}
+
+// pythonCallArgs returns the arguments of the first call to name in s.
+func pythonCallArgs(s, name string) ([]string, bool) {
+	idx := strings.Index(s, name+"(")
+	if idx < 0 {
+		return nil, false
+	}
+	start := idx + len(name) + 1
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			i = pythonStringEnd(s, i) - 1
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return pythonSplitTopLevel(s[start:i]), true
+			}
+		}
+	}
+	return nil, false
+}
+
+var (
+	pythonKeywordArgRE    = regexp.MustCompile(`^(\w+)\s*=[^=]`)
+	pythonComprehensionRE = regexp.MustCompile(`\sfor\s`)
+	pytestClassRE         = regexp.MustCompile(`^class\s+(\w+)\s*[(:]`)
+	pytestFuncRE          = regexp.MustCompile(`^(?:async\s+)?def\s+(\w+)\s*\(`)
+)
+
+// parametrizeCases counts the cases the parametrize decorators produce. It
+// is false when a case table is not a literal list or tuple.
+func parametrizeCases(decorators []string) (int, bool) {
+	cases := 1
+	for _, dec := range decorators {
+		args, ok := pythonCallArgs(dec, "parametrize")
+		if !ok {
+			continue
+		}
+		var values string
+		positional := 0
+		for _, arg := range args {
+			if m := pythonKeywordArgRE.FindStringSubmatch(arg); m != nil {
+				if m[1] == "argvalues" {
+					values = strings.TrimSpace(arg[strings.Index(arg, "=")+1:])
+				}
+				continue
+			}
+			if positional++; positional == 2 {
+				values = arg
+			}
+		}
+		if len(values) < 2 {
+			return 0, false
+		}
+		first, last := values[0], values[len(values)-1]
+		if !(first == '[' && last == ']') && !(first == '(' && last == ')') {
+			return 0, false
+		}
+		items := pythonSplitTopLevel(values[1 : len(values)-1])
+		if len(items) == 0 || (len(items) == 1 && pythonComprehensionRE.MatchString(items[0])) {
+			return 0, false
+		}
+		cases *= len(items)
+	}
+	return cases, true
+}
+
+// parsePytestItems lists the tests pytest would collect from src: test*
+// functions and the test* methods of Test* classes, as Class::method.
+// Parametrized tests with literal case tables are listed once per case as
+// name[index].
+func parsePytestItems(src string) []string {
+	type scope struct {
+		indent    int
+		name      string
+		collected bool
+		isClass   bool
+		cases     int
+		casesOK   bool
+	}
+	var stack []scope
+	var decorators []string
+	var items []string
+	for _, ln := range pythonLogicalLines(src) {
+		for len(stack) > 0 && ln.Indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		if strings.HasPrefix(ln.Text, "@") {
+			decorators = append(decorators, ln.Text)
+			continue
+		}
+		decs := decorators
+		decorators = nil
+		var parent *scope
+		if len(stack) > 0 {
+			parent = &stack[len(stack)-1]
+		}
+		inCollected := parent == nil || (parent.isClass && parent.collected)
+		if m := pytestClassRE.FindStringSubmatch(ln.Text); m != nil {
+			cases, ok := parametrizeCases(decs)
+			s := scope{indent: ln.Indent, name: m[1], isClass: true, cases: cases, casesOK: ok}
+			s.collected = inCollected && strings.HasPrefix(m[1], "Test")
+			if parent != nil {
+				s.cases *= parent.cases
+				s.casesOK = s.casesOK && parent.casesOK
+			}
+			stack = append(stack, s)
+			continue
+		}
+		m := pytestFuncRE.FindStringSubmatch(ln.Text)
+		if m == nil {
+			continue
+		}
+		stack = append(stack, scope{indent: ln.Indent, name: m[1]})
+		if !inCollected || !strings.HasPrefix(m[1], "test") {
+			continue
+		}
+		cases, ok := parametrizeCases(decs)
+		var path []string
+		for _, s := range stack[:len(stack)-1] {
+			path = append(path, s.name)
+		}
+		if parent != nil {
+			cases *= parent.cases
+			ok = ok && parent.casesOK
+		}
+		name := strings.Join(append(path, m[1]), "::")
+		if !ok || cases <= 1 || cases > pytestMaxCases {
+			items = append(items, name)
+			continue
+		}
+		for i := 0; i < cases; i++ {
+			items = append(items, name+"["+strconv.Itoa(i)+"]")
+		}
+	}
+	return items
+}
+
+var pythonDocstringRE = regexp.MustCompile(`^[rRuU]?("""|'''|"|')`)
+
+// parseDoctestBlocks lists the docstrings with >>> examples in src: the
+// module docstring as <module>, functions and classes by their dotted name.
+// Functions nested in functions are skipped, as doctest itself does.
+func parseDoctestBlocks(src string) []string {
+	type scope struct {
+		indent int
+		name   string
+		inDef  bool
+	}
+	hasExamples := func(ln pythonLine) bool {
+		return pythonDocstringRE.MatchString(ln.Text) && strings.Contains(ln.Text, ">>>")
+	}
+	lines := pythonLogicalLines(src)
+	var names []string
+	if len(lines) > 0 && lines[0].Indent == 0 && hasExamples(lines[0]) {
+		names = append(names, doctestModule)
+	}
+	var stack []scope
+	for i, ln := range lines {
+		for len(stack) > 0 && ln.Indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		var kind, name string
+		if m := pytestClassRE.FindStringSubmatch(ln.Text); m != nil {
+			kind, name = "class", m[1]
+		} else if m := pytestFuncRE.FindStringSubmatch(ln.Text); m != nil {
+			kind, name = "def", m[1]
+		} else {
+			continue
+		}
+		hidden := len(stack) > 0 && stack[len(stack)-1].inDef
+		var path []string
+		for _, s := range stack {
+			path = append(path, s.name)
+		}
+		stack = append(stack, scope{indent: ln.Indent, name: name, inDef: hidden || kind == "def"})
+		if hidden || i+1 >= len(lines) || lines[i+1].Indent <= ln.Indent || !hasExamples(lines[i+1]) {
+			continue
+		}
+		names = append(names, strings.Join(append(path, name), "."))
+	}
+	return names
+}
+
+// pythonLiteral encodes s as a Python string literal.
+func pythonLiteral(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func executePythonPytest(sess *vmSession, dir, mainFile, testCode, testName string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	if err := os.WriteFile(filepath.Join(dir, pytestSuiteFile), []byte(normalizeLeadingTabsToSpaces(testCode)), 0644); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	content := fmt.Sprintf(`import sys, os, pathlib
+
+script_dir = os.path.dirname(os.path.abspath(__file__))
+os.chdir(script_dir)
+
+ROOT = pathlib.Path(script_dir)
+STUDENT_DIR = str((ROOT / %s).parent)
+SUITE = %s
+SELECT = %s
+
+# the test file imports the student's module by its file name
+for path in (script_dir, STUDENT_DIR):
+    if path not in sys.path:
+        sys.path.insert(0, path)
+
+import pytest
+
+_base, _, _param = SELECT.partition('[')
+if _param.endswith(']'):
+    _param = _param[:-1]
+
+class _SelectTest:
+    """Deselects everything but the selected test or parametrize case."""
+
+    @pytest.hookimpl(trylast=True)
+    def pytest_collection_modifyitems(self, session, config, items):
+        keep, seen = [], 0
+        for item in items:
+            if item.nodeid.split('::', 1)[-1].split('[', 1)[0] != _base:
+                continue
+            # a number picks the case by position, anything else by its id
+            if not _param:
+                keep.append(item)
+            elif _param.isdigit():
+                if seen == int(_param):
+                    keep.append(item)
+            elif getattr(getattr(item, 'callspec', None), 'id', None) == _param:
+                keep.append(item)
+            seen += 1
+        config.hook.pytest_deselected(items=[item for item in items if item not in keep])
+        items[:] = keep
+        if not keep:
+            sys.stderr.write('test ' + SELECT + ' was not found in the pytest file\n')
+
+if __name__ == '__main__':
+    # the report goes to stderr like the unittest runner's
+    sys.stdout.flush()
+    saved = os.dup(1)
+    os.dup2(2, 1)
+    try:
+        code = pytest.main([SUITE, '-q', '-p', 'no:cacheprovider', '--rootdir', script_dir, '--tb=short'], plugins=[_SelectTest()])
+    finally:
+        sys.stdout.flush()
+        os.dup2(saved, 1)
+    if code == pytest.ExitCode.TESTS_FAILED:
+        print("===JUDGE:ASSERT_FAIL===")
+    sys.exit(int(code))
+`, pythonLiteral(mainFile), pythonLiteral(pytestSuiteFile), pythonLiteral(testName))
+	return runPythonHarness(sess, dir, "run_pytest.py", content, timeout, memoryLimitKB)
+}
+
+func executePythonDoctest(sess *vmSession, dir, mainFile, testCode, testName string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	if err := os.WriteFile(filepath.Join(dir, doctestSourceFile), []byte(testCode), 0644); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	content := fmt.Sprintf(`import sys, os, ast, doctest, pathlib, types
+
+script_dir = os.path.dirname(os.path.abspath(__file__))
+os.chdir(script_dir)
+
+ROOT = pathlib.Path(script_dir)
+student_file = ROOT / %s
+SOURCE = ROOT / %s
+NAME = %s
+MODULE = %s
+sys.path.insert(0, str(student_file.parent))
+
+def _find_docstring(tree, dotted):
+    node = tree
+    if dotted != MODULE:
+        for part in dotted.split('.'):
+            for child in getattr(node, 'body', []):
+                if isinstance(child, (ast.FunctionDef, ast.AsyncFunctionDef, ast.ClassDef)) and child.name == part:
+                    node = child
+                    break
+            else:
+                return None, 0
+    doc = ast.get_docstring(node, clean=False)
+    if doc is None:
+        return None, 0
+    return doc, node.body[0].lineno - 1
+
+if __name__ == '__main__':
+    # the examples come from the uploaded template, the code from the student
+    doc, lineno = _find_docstring(ast.parse(SOURCE.read_text()), NAME)
+    if not doc or '>>>' not in doc:
+        sys.stderr.write('no doctest examples found for ' + NAME + '\n')
+        sys.exit(2)
+    module = types.ModuleType('__student__')
+    module.__file__ = str(student_file)
+    exec(compile(student_file.read_text(), str(student_file), 'exec'), module.__dict__)
+    test = doctest.DocTestParser().get_doctest(doc, dict(module.__dict__), NAME, SOURCE.name, lineno)
+    result = doctest.DocTestRunner(verbose=False).run(test, out=sys.stderr.write)
+    if result.failed:
+        print("===JUDGE:ASSERT_FAIL===")
+        sys.exit(1)
+`, pythonLiteral(mainFile), pythonLiteral(doctestSourceFile), pythonLiteral(testName), pythonLiteral(doctestModule))
+	return runPythonHarness(sess, dir, "run_doctest.py", content, timeout, memoryLimitKB)
+}
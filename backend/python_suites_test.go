@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const pytestSample = `import pytest
+from main import add, div
+
+CASES = [(1, 1, 2)]
+
+def helper():
+    def test_nested():
+        pass
+
+def test_add():
+    assert add(1, 2) == 3
+
+@pytest.mark.parametrize("a, b, expected", [
+    (1, 2, 3),
+    (-1, 1, 0),  # a comment, with a comma
+    pytest.param(2, ")", None, id="weird"),
+])
+def test_add_cases(a, b, expected):
+    assert add(a, b) == expected
+
+@pytest.mark.parametrize("x", [1, 2])
+@pytest.mark.parametrize("y", argvalues=(3, 4, 5))
+def test_grid(x, y):
+    pass
+
+@pytest.mark.parametrize("a, b, c", CASES)
+def test_table(a, b, c):
+    pass
+
+@pytest.mark.parametrize("n", [n for n in range(3)])
+def test_generated(n):
+    pass
+
+class Helper:
+    def test_not_collected(self):
+        pass
+
+@pytest.mark.parametrize("d", [1, 2])
+class TestDiv:
+    def test_div(self, d):
+        assert div(d, d) == 1
+
+    def test_zero(self, d):
+        with pytest.raises(ZeroDivisionError):
+            div(d, 0)
+`
+
+func TestParsePytestItems(t *testing.T) {
+	want := []string{
+		"test_add",
+		"test_add_cases[0]", "test_add_cases[1]", "test_add_cases[2]",
+		"test_grid[0]", "test_grid[1]", "test_grid[2]", "test_grid[3]", "test_grid[4]", "test_grid[5]",
+		"test_table",
+		"test_generated",
+		"TestDiv::test_div[0]", "TestDiv::test_div[1]",
+		"TestDiv::test_zero[0]", "TestDiv::test_zero[1]",
+	}
+	if got := parsePytestItems(pytestSample); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected items\n got %v\nwant %v", got, want)
+	}
+}
+
+const doctestSample = `"""Helpers for the exercise.
+
+>>> add(1, 1)
+2
+"""
+
+def add(a, b):
+    """Add two numbers.
+
+    >>> add(2, 3)
+    5
+    """
+    def inner():
+        """
+        >>> 1
+        1
+        """
+
+def todo():
+    """No examples here."""
+    pass
+
+class Stack:
+    '''A stack.'''
+
+    def push(self, x):
+        r"""
+        >>> s = Stack(); s.push(1); s.items
+        [1]
+        """
+        self.items.append(x)
+`
+
+func TestParseDoctestBlocks(t *testing.T) {
+	want := []string{doctestModule, "add", "Stack.push"}
+	if got := parseDoctestBlocks(doctestSample); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected doctests %v", got)
+	}
+}
+
+func TestDetectPythonSuite(t *testing.T) {
+	unit := "import unittest\n\nclass T(unittest.TestCase):\n    def test_a(self):\n        pass\n"
+	cases := []struct {
+		src, mode, wantMode string
+		count               int
+	}{
+		{unit, "", "unittest", 1},
+		{pytestSample, "", "pytest", 16},
+		{doctestSample, "", "doctest", 3},
+		{doctestSample, "pytest", "pytest", 0},
+	}
+	for _, c := range cases {
+		mode, names, err := detectPythonSuite(c.src, c.mode)
+		if err != nil || mode != c.wantMode || len(names) != c.count {
+			t.Errorf("mode %q: got %s %v (%v)", c.mode, mode, names, err)
+		}
+	}
+	if _, _, err := detectPythonSuite(unit, "nose"); err == nil {
+		t.Errorf("unknown mode accepted")
+	}
+}
+
+// TestDoctestRunner runs doctests taken from a template against student code.
+func TestDoctestRunner(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		run := func(t *testing.T, student, name string) (string, string, int) {
+			t.Helper()
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.py": student})
+			stdout, stderr, exitCode, _, _, _ := executePythonDoctest(nil, dir, "main.py", doctestSample, name, 20*time.Second, 0)
+			return stdout, stderr, exitCode
+		}
+		good := "def add(a, b):\n    return a + b\n\nclass Stack:\n    def __init__(self):\n        self.items = []\n\n    def push(self, x):\n        self.items.append(x)\n\nif __name__ == '__main__':\n    print(add(int(input()), 1))\n"
+		for _, name := range []string{doctestModule, "add", "Stack.push"} {
+			if _, stderr, exitCode := run(t, good, name); exitCode != 0 {
+				t.Errorf("%s: exit %d: %s", name, exitCode, stderr)
+			}
+		}
+		stdout, stderr, exitCode := run(t, strings.Replace(good, "a + b", "a - b", 1), "add")
+		if exitCode == 0 || !strings.Contains(stdout, "===JUDGE:ASSERT_FAIL===") || !strings.Contains(stderr, "Expected:\n    5\nGot:\n    -1") {
+			t.Errorf("wrong add: exit %d, stdout %q, stderr %q", exitCode, stdout, stderr)
+		}
+		if stdout, stderr, exitCode := run(t, good, "todo"); exitCode == 0 || strings.Contains(stdout, "===JUDGE:ASSERT_FAIL===") || !strings.Contains(stderr, "no doctest examples found for todo") {
+			t.Errorf("missing examples: exit %d, stderr %q", exitCode, stderr)
+		}
+	})
+}
+
+// TestPytestRunner runs single pytest tests and parametrize cases. It needs
+// pytest, which the sandbox images ship but development machines may not.
+func TestPytestRunner(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	if err := exec.Command(pythonBinary, "-c", "import pytest").Run(); err != nil {
+		t.Skip("pytest not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		run := func(t *testing.T, name string) (string, string, int) {
+			t.Helper()
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.py": "def add(a, b):\n    return abs(a) + b\n\ndef div(a, b):\n    return a / b\n"})
+			stdout, stderr, exitCode, _, _, _ := executePythonPytest(nil, dir, "main.py", pytestSample, name, 30*time.Second, 0)
+			return stdout, stderr, exitCode
+		}
+		for _, name := range []string{"test_add", "test_add_cases[0]", "test_grid[5]", "TestDiv::test_div[0]", "TestDiv::test_zero[1]"} {
+			if _, stderr, exitCode := run(t, name); exitCode != 0 {
+				t.Errorf("%s: exit %d: %s", name, exitCode, stderr)
+			}
+		}
+		// a failing case, by index and by the id pytest gives it
+		for _, name := range []string{"test_add_cases[1]", "test_add_cases[weird]"} {
+			if stdout, stderr, exitCode := run(t, name); exitCode == 0 || !strings.Contains(stdout, "===JUDGE:ASSERT_FAIL===") || !strings.Contains(stderr, "1 failed") {
+				t.Errorf("%s: exit %d, stderr %q", name, exitCode, stderr)
+			}
+		}
+		if stdout, stderr, exitCode := run(t, "test_missing"); exitCode == 0 || strings.Contains(stdout, "===JUDGE:ASSERT_FAIL===") || !strings.Contains(stderr, "was not found") {
+			t.Errorf("missing test: exit %d, stderr %q", exitCode, stderr)
+		}
+	})
+}
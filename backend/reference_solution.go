@@ -0,0 +1,891 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// An assignment may store a reference solution (a zip archive, base64 encoded
+// like submissions). Running it against the tests fills in expected_stdout for
+// stdin/stdout, checker and performance tests and expected_return for function
+// tests, so teachers no longer type expected outputs by hand. Performance tests
+// also measure their time limit against it.
+
+// ReferenceSolutionInfo describes the stored reference solution without its
+// contents.
+type ReferenceSolutionInfo struct {
+	Files     []string   `json:"files"`
+	MainFile  string     `json:"main_file"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+func SetAssignmentReferenceSolution(aid uuid.UUID, archive *string) error {
+	_, err := DB.Exec(`UPDATE assignments
+                          SET reference_solution=$1,
+                              reference_solution_updated_at=CASE WHEN $1::text IS NULL THEN NULL ELSE now() END,
+                              updated_at=now()
+                        WHERE id=$2`, archive, aid)
+	return err
+}
+
+// GetAssignmentReferenceSolution returns the stored archive, or nil if the
+// assignment has none.
+func GetAssignmentReferenceSolution(aid uuid.UUID) (*string, *time.Time, error) {
+	var row struct {
+		Archive   *string    `db:"reference_solution"`
+		UpdatedAt *time.Time `db:"reference_solution_updated_at"`
+	}
+	err := DB.Get(&row, `SELECT reference_solution, reference_solution_updated_at FROM assignments WHERE id=$1`, aid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return row.Archive, row.UpdatedAt, nil
+}
+
+func copyReferenceSolution(srcID, dstID uuid.UUID) error {
+	_, err := DB.Exec(`UPDATE assignments d
+                          SET reference_solution=s.reference_solution,
+                              reference_solution_updated_at=s.reference_solution_updated_at
+                         FROM assignments s
+                        WHERE s.id=$1 AND d.id=$2`, srcID, dstID)
+	return err
+}
+
+// referenceSolutionsDiffer reports whether two assignments store different
+// reference solutions.
+func referenceSolutionsDiffer(aID, bID uuid.UUID) (bool, error) {
+	var differs bool
+	err := DB.Get(&differs, `SELECT a.reference_solution IS DISTINCT FROM b.reference_solution
+                               FROM assignments a, assignments b
+                              WHERE a.id=$1 AND b.id=$2`, aID, bID)
+	return differs, err
+}
+
+// UpdateTestCaseExpected stores regenerated expected values of a test.
+func UpdateTestCaseExpected(id uuid.UUID, expectedStdout string, expectedReturn *string) error {
+	res, err := DB.Exec(`UPDATE test_cases SET expected_stdout=$1, expected_return=$2, updated_at=now() WHERE id=$3`,
+		expectedStdout, expectedReturn, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// zipDirectory packs every regular file below dir using slash separated
+// relative names.
+func zipDirectory(dir string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// extractArchive unpacks a base64 encoded zip into dest, skipping entries
+// that would escape it.
+func extractArchive(encoded, dest string) ([]string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range zr.File {
+		fpath := filepath.Join(dest, f.Name)
+		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(fpath, 0755); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(fpath, data, 0644); err != nil {
+			return nil, err
+		}
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// referenceRun is the outcome of running the reference solution on one test.
+type referenceRun struct {
+	Stdout string
+	Return *string
+	// Problem is set when the run cannot be trusted as an expected value.
+	Problem string
+}
+
+// regeneratesExpected reports whether the reference solution's output can
+// replace the test's expected value. A regex expectation is a pattern the
+// teacher wrote, not an output, and is left alone.
+func regeneratesExpected(tc TestCase) bool {
+	switch tc.ExecutionMode {
+	case "function":
+		return true
+	case "stdin_stdout", "checker", "performance":
+		return normalizeComparator(tc.Comparator) != comparatorRegex
+	}
+	return false
+}
+
+func runReferenceForTest(sess *vmSession, baseDir string, prog program, tc TestCase) referenceRun {
+	workDir, cleanup, err := cloneWorkspace(baseDir)
+	if err != nil {
+		return referenceRun{Problem: fmt.Sprintf("prepare workspace: %v", err)}
+	}
+	defer cleanup()
+	if err := stageTestFile(workDir, prog.entry, tc); err != nil {
+		return referenceRun{Problem: err.Error()}
+	}
+	if err := checkModeSupported(prog.rt, tc.ExecutionMode); err != nil {
+		return referenceRun{Problem: err.Error()}
+	}
+	timeout := time.Duration(tc.TimeLimitSec * float64(time.Second))
+
+	if tc.ExecutionMode == "function" {
+		cfg := functionCallConfig{FunctionName: strings.TrimSpace(stringOrEmpty(tc.FunctionName)), ArgsJSON: tc.FunctionArgs, KwargsJSON: tc.FunctionKwargs}
+		_, stderr, exitCode, timedOut, _, mem, meta, err := prog.rt.runFunction(sess, workDir, prog.entry, cfg, timeout, tc.MemoryLimitKB)
+		switch {
+		case err != nil:
+			return referenceRun{Problem: err.Error()}
+		case timedOut:
+			return referenceRun{Problem: "time_limit_exceeded"}
+		case mem.LimitExceeded:
+			return referenceRun{Problem: "memory_limit_exceeded"}
+		case meta == nil:
+			return referenceRun{Problem: fmt.Sprintf("runtime_error (exit %d): %s", exitCode, stderr)}
+		case meta.Status == "exception":
+			return referenceRun{Problem: "runtime_error: " + meta.Exception}
+		case meta.ReturnJSON == nil:
+			return referenceRun{Problem: "return value is not JSON serializable: " + meta.ReturnRepr}
+		}
+		return referenceRun{Return: meta.ReturnJSON}
+	}
+
+	stdout, stderr, exitCode, timedOut, _, mem := prog.rt.runStdin(sess, workDir, prog.entry, tc.Stdin, timeout, tc.MemoryLimitKB)
+	switch {
+	case timedOut:
+		return referenceRun{Problem: "time_limit_exceeded"}
+	case mem.LimitExceeded:
+		return referenceRun{Problem: "memory_limit_exceeded"}
+	case exitCode != 0:
+		return referenceRun{Problem: fmt.Sprintf("runtime_error (exit %d): %s", exitCode, stderr)}
+	}
+	out := trimTrailingNewline(stdout)
+	// expected_stdout reads \n, \r and \t as escapes and has no way to
+	// write them literally, so such an output would not compare equal to itself
+	if normalizeExpectedStdout(out) != normalizeLineEndings(out) {
+		return referenceRun{Problem: `the output contains \n, \r or \t, which expected_stdout reads as escape sequences`}
+	}
+	return referenceRun{Stdout: out}
+}
+
+type diffLine struct {
+	Op   string `json:"op"` // " ", "-" or "+"
+	Text string `json:"text"`
+}
+
+// lineDiff is a plain LCS line diff, good enough for expected outputs.
+func lineDiff(oldText, newText string) []diffLine {
+	a := strings.Split(oldText, "\n")
+	b := strings.Split(newText, "\n")
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, diffLine{" ", a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, diffLine{"-", a[i]})
+			i++
+		default:
+			out = append(out, diffLine{"+", b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, diffLine{"-", a[i]})
+	}
+	for ; j < len(b); j++ {
+		out = append(out, diffLine{"+", b[j]})
+	}
+	return out
+}
+
+func requireAssignmentTeacher(c *gin.Context, aid uuid.UUID) bool {
+	if c.GetString("role") == "teacher" {
+		if ok, err := IsTeacherOfAssignment(aid, getUserID(c)); err != nil || !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return false
+		}
+	}
+	return true
+}
+
+// uploadReferenceSolution: PUT /api/assignments/:id/reference-solution
+func uploadReferenceSolution(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid form"})
+		return
+	}
+	var files []*multipart.FileHeader
+	if c.Request.MultipartForm != nil {
+		files = c.Request.MultipartForm.File["files"]
+	}
+	if len(files) == 0 {
+		if f, ferr := c.FormFile("file"); ferr == nil {
+			files = []*multipart.FileHeader{f}
+		}
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no files"})
+		return
+	}
+	tmpDir, err := os.MkdirTemp(execRoot, "reference-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, fh := range files {
+		if err := c.SaveUploadedFile(fh, filepath.Join(tmpDir, filepath.Base(fh.Filename))); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot save"})
+			return
+		}
+	}
+	assignment, err := GetAssignment(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	prog, err := loadProgram(tmpDir, assignment.ProgrammingLanguage)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := zipDirectory(tmpDir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if err := SetAssignmentReferenceSolution(aid, &encoded); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	names := make([]string, 0, len(files))
+	for _, fh := range files {
+		names = append(names, filepath.Base(fh.Filename))
+	}
+	sort.Strings(names)
+	now := time.Now()
+	c.JSON(http.StatusOK, ReferenceSolutionInfo{Files: names, MainFile: prog.entry, UpdatedAt: &now})
+}
+
+// getReferenceSolution: GET /api/assignments/:id/reference-solution
+func getReferenceSolution(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	archive, updatedAt, err := GetAssignmentReferenceSolution(aid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	if archive == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no reference solution"})
+		return
+	}
+	tmpDir, err := os.MkdirTemp(execRoot, "reference-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	names, err := extractArchive(*archive, tmpDir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "corrupt reference solution"})
+		return
+	}
+	var mainFile string
+	if a, err := GetAssignment(aid); err == nil {
+		if prog, err := loadProgram(tmpDir, a.ProgrammingLanguage); err == nil {
+			mainFile = prog.entry
+		}
+	}
+	c.JSON(http.StatusOK, ReferenceSolutionInfo{Files: names, MainFile: mainFile, UpdatedAt: updatedAt})
+}
+
+// deleteReferenceSolution: DELETE /api/assignments/:id/reference-solution
+func deleteReferenceSolution(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	if err := SetAssignmentReferenceSolution(aid, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// expectedPreviewToken signs one proposed change of a regeneration preview:
+// the test, the field and both the stored and the proposed value.
+func expectedPreviewToken(id uuid.UUID, field, oldText, newText string) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	for _, part := range []string{id.String(), field, oldText, newText} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// expectedField names the field a regeneration fills in for the test and
+// returns its stored value.
+func expectedField(tc TestCase) (string, string) {
+	if tc.ExecutionMode == "function" {
+		return "expected_return", stringOrEmpty(tc.ExpectedReturn)
+	}
+	return "expected_stdout", tc.ExpectedStdout
+}
+
+type previewedOutput struct {
+	TestCaseID uuid.UUID `json:"test_case_id"`
+	New        string    `json:"new"`
+	Token      string    `json:"token"`
+}
+
+// regenerateExpectedOutputs: POST /api/assignments/:id/tests/regenerate-expected
+//
+// Runs the reference solution on every stdin/stdout, checker and function
+// test, except those compared with a regex, optionally limited to "test_ids",
+// and returns the proposed changes with a diff and a token each. With "apply"
+// the reference is not run again; instead the previewed values sent in
+// "outputs" are saved, provided their tokens still match the stored tests.
+func regenerateExpectedOutputs(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	var req struct {
+		Apply   bool              `json:"apply"`
+		TestIDs []uuid.UUID       `json:"test_ids"`
+		Outputs []previewedOutput `json:"outputs"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Apply {
+		applyPreviewedOutputs(c, aid, req.Outputs)
+		return
+	}
+	archive, _, err := GetAssignmentReferenceSolution(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	if archive == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "assignment has no reference solution"})
+		return
+	}
+	tests, err := ListTestCases(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	selected := map[uuid.UUID]bool{}
+	for _, id := range req.TestIDs {
+		selected[id] = true
+	}
+	var targets []TestCase
+	for _, tc := range tests {
+		if !regeneratesExpected(tc) {
+			continue
+		}
+		if len(selected) > 0 && !selected[tc.ID] {
+			continue
+		}
+		targets = append(targets, tc)
+	}
+
+	tmpDir, err := os.MkdirTemp(execRoot, "reference-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	if _, err := extractArchive(*archive, tmpDir); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "corrupt reference solution"})
+		return
+	}
+	assignment, err := GetAssignment(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	prog, err := loadProgram(tmpDir, assignment.ProgrammingLanguage)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_ = ensureSandboxPerms(tmpDir)
+
+	var budget time.Duration
+	for _, tc := range targets {
+		budget += time.Duration(tc.TimeLimitSec*float64(time.Second)) + vmExtraTimeout + 30*time.Second
+	}
+	compiled := prog.rt.compiled()
+	if compiled {
+		budget += compileTimeout
+	}
+	var sess *vmSession
+	if len(targets) > 0 {
+		if s, err := startVMSession(tmpDir, budget); err == nil {
+			sess = s
+		} else {
+			fmt.Printf("[reference] shared VM session unavailable: %v\n", err)
+		}
+	}
+	defer sess.Close()
+	if compiled && len(targets) > 0 {
+		if err := prog.rt.build(sess, tmpDir, assignment); err != nil {
+			var ce *compileError
+			if errors.As(err, &ce) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "reference solution does not compile", "diagnostics": ce.Diagnostics})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	runs := make([]referenceRun, len(targets))
+	sem := make(chan struct{}, max(maxParallelVMs, 1))
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			runs[i] = runReferenceForTest(sess, tmpDir, prog, targets[i])
+		}(i)
+	}
+	wg.Wait()
+
+	items := make([]gin.H, 0, len(targets))
+	changedCount, flaggedCount := 0, 0
+	for i, tc := range targets {
+		run := runs[i]
+		item := gin.H{
+			"test_case_id":   tc.ID,
+			"execution_mode": tc.ExecutionMode,
+		}
+		if run.Problem != "" {
+			flaggedCount++
+			item["flagged"] = true
+			item["error"] = run.Problem
+			items = append(items, item)
+			continue
+		}
+		field, oldText := expectedField(tc)
+		newText := run.Stdout
+		if field == "expected_return" {
+			newText = stringOrEmpty(run.Return)
+		}
+		changed := oldText != newText
+		item["field"] = field
+		item["old"] = oldText
+		item["new"] = newText
+		item["changed"] = changed
+		if changed {
+			changedCount++
+			item["diff"] = lineDiff(oldText, newText)
+			item["token"] = expectedPreviewToken(tc.ID, field, oldText, newText)
+		}
+		items = append(items, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"applied": false,
+		"tests":   items,
+		"summary": gin.H{"total": len(targets), "changed": changedCount, "flagged": flaggedCount, "saved": 0},
+	})
+}
+
+// applyPreviewedOutputs saves the values of an earlier preview. Nothing is
+// saved if any test was edited or removed since, or a value does not match
+// its token.
+func applyPreviewedOutputs(c *gin.Context, aid uuid.UUID, outputs []previewedOutput) {
+	if len(outputs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "outputs from a preview are required"})
+		return
+	}
+	tests, err := ListTestCases(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	byID := make(map[uuid.UUID]TestCase, len(tests))
+	for _, tc := range tests {
+		byID[tc.ID] = tc
+	}
+	conflicts := []gin.H{}
+	for _, out := range outputs {
+		tc, ok := byID[out.TestCaseID]
+		if !ok || !regeneratesExpected(tc) {
+			conflicts = append(conflicts, gin.H{"test_case_id": out.TestCaseID, "error": "test not found"})
+			continue
+		}
+		field, oldText := expectedField(tc)
+		want := expectedPreviewToken(tc.ID, field, oldText, out.New)
+		if !hmac.Equal([]byte(want), []byte(out.Token)) {
+			conflicts = append(conflicts, gin.H{"test_case_id": out.TestCaseID, "error": "test changed since the preview"})
+		}
+	}
+	if len(conflicts) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "outputs no longer match the tests, preview again", "conflicts": conflicts})
+		return
+	}
+
+	items := make([]gin.H, 0, len(outputs))
+	savedCount := 0
+	for _, out := range outputs {
+		tc := byID[out.TestCaseID]
+		field, _ := expectedField(tc)
+		newStdout, newReturn := tc.ExpectedStdout, tc.ExpectedReturn
+		if field == "expected_return" {
+			v := out.New
+			newReturn = &v
+		} else {
+			newStdout = out.New
+		}
+		item := gin.H{"test_case_id": tc.ID, "execution_mode": tc.ExecutionMode, "field": field, "new": out.New}
+		if err := UpdateTestCaseExpected(tc.ID, newStdout, newReturn); err != nil {
+			item["error"] = "db fail"
+		} else {
+			savedCount++
+			item["saved"] = true
+		}
+		items = append(items, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"applied": true,
+		"tests":   items,
+		"summary": gin.H{"total": len(outputs), "saved": savedCount},
+	})
+}
+
+// referenceSandbox runs the reference solution and teacher-written helper
+// scripts (input generators) for tests that compare against it at grading
+// time. It is prepared on first use in its own VM session so student code
+// never shares a sandbox with it. Without a stored reference solution only the
+// helper scripts can run and present is false.
+type referenceSandbox struct {
+	assignment *Assignment
+	budget     time.Duration
+
+	once    sync.Once
+	dir     string
+	prog    program
+	sess    *vmSession
+	present bool
+	err     error
+}
+
+// referenceBudget is the sandbox time a test may spend in the reference
+// sandbox; zero for tests that do not use it.
+func referenceBudget(tc TestCase) time.Duration {
+	limit := time.Duration(tc.TimeLimitSec * float64(time.Second))
+	switch tc.ExecutionMode {
+	case "performance":
+		return time.Duration(performanceRuns(tc))*limit + perfGeneratorTimeout + vmExtraTimeout
+	case "property":
+		budget := time.Duration(propertyRuns(tc))*limit + propertyScriptTimeout + vmExtraTimeout
+		if cfg, err := parsePropertyConfig(tc.PropertyConfig); err == nil {
+			budget += time.Duration(cfg.MaxShrinks) * propertyScriptTimeout
+		}
+		return budget
+	case "turtle":
+		return limit + vmExtraTimeout
+	}
+	return 0
+}
+
+// newReferenceSandbox returns nil when no test needs the reference sandbox.
+func newReferenceSandbox(a *Assignment, tests []TestCase) *referenceSandbox {
+	if a == nil {
+		return nil
+	}
+	var budget time.Duration
+	for _, tc := range tests {
+		budget += referenceBudget(tc)
+	}
+	if budget == 0 {
+		return nil
+	}
+	if rt, err := runtimeFor(a.ProgrammingLanguage); err == nil && rt.compiled() {
+		budget += compileTimeout
+	}
+	return &referenceSandbox{assignment: a, budget: budget}
+}
+
+func (r *referenceSandbox) load() error {
+	r.once.Do(func() {
+		dir, err := os.MkdirTemp(execRoot, "reference-run-")
+		if err != nil {
+			r.err = err
+			return
+		}
+		r.dir = dir
+		archive, _, err := GetAssignmentReferenceSolution(r.assignment.ID)
+		if err != nil {
+			r.err = fmt.Errorf("load reference solution: %w", err)
+			return
+		}
+		if archive != nil {
+			if _, err := extractArchive(*archive, dir); err != nil {
+				r.err = fmt.Errorf("corrupt reference solution: %w", err)
+				return
+			}
+			if r.prog, err = loadProgram(dir, r.assignment.ProgrammingLanguage); err != nil {
+				r.err = fmt.Errorf("reference solution: %w", err)
+				return
+			}
+			r.present = true
+		}
+		_ = ensureSandboxPerms(dir)
+		if s, err := startVMSession(dir, r.budget); err == nil {
+			r.sess = s
+		} else {
+			fmt.Printf("[reference] VM session unavailable: %v\n", err)
+		}
+		if r.present && r.prog.rt.compiled() {
+			if err := r.prog.rt.build(r.sess, dir, r.assignment); err != nil {
+				r.err = fmt.Errorf("reference solution does not build: %w", err)
+			}
+		}
+	})
+	return r.err
+}
+
+// Close stops the reference session and removes its workspace.
+func (r *referenceSandbox) Close() {
+	if r == nil {
+		return
+	}
+	r.sess.Close()
+	if r.dir != "" {
+		_ = os.RemoveAll(r.dir)
+	}
+}
+
+// runStdin runs the reference solution once on stdin. A run that fails is
+// an error: its output cannot serve as the expected one.
+func (r *referenceSandbox) runStdin(tc TestCase, stdin string, timeout time.Duration) (string, time.Duration, error) {
+	workDir, cleanup, err := cloneWorkspace(r.dir)
+	if err != nil {
+		return "", 0, fmt.Errorf("prepare workspace: %w", err)
+	}
+	defer cleanup()
+	if err := stageTestFile(workDir, r.prog.entry, tc); err != nil {
+		return "", 0, err
+	}
+	stdout, stderr, exitCode, timedOut, runtime, mem := r.prog.rt.runStdin(r.sess, workDir, r.prog.entry, stdin, timeout, tc.MemoryLimitKB)
+	switch {
+	case timedOut:
+		return "", 0, errors.New("reference solution exceeded the time limit")
+	case mem.LimitExceeded:
+		return "", 0, errors.New("reference solution exceeded the memory limit")
+	case exitCode != 0:
+		return "", 0, fmt.Errorf("reference solution failed (exit %d): %s", exitCode, stderr)
+	}
+	return stdout, runtime, nil
+}
+
+// runFunction calls a function of the reference solution.
+func (r *referenceSandbox) runFunction(tc TestCase, cfg functionCallConfig, timeout time.Duration) (*functionCallResult, error) {
+	workDir, cleanup, err := cloneWorkspace(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("prepare workspace: %w", err)
+	}
+	defer cleanup()
+	if err := stageTestFile(workDir, r.prog.entry, tc); err != nil {
+		return nil, err
+	}
+	_, stderr, exitCode, timedOut, _, mem, meta, err := r.prog.rt.runFunction(r.sess, workDir, r.prog.entry, cfg, timeout, tc.MemoryLimitKB)
+	switch {
+	case err != nil:
+		return nil, err
+	case timedOut:
+		return nil, errors.New("reference solution exceeded the time limit")
+	case mem.LimitExceeded:
+		return nil, errors.New("reference solution exceeded the memory limit")
+	case meta == nil:
+		return nil, fmt.Errorf("reference solution failed (exit %d): %s", exitCode, stderr)
+	}
+	return meta, nil
+}
+
+// runTeacherScript runs runner with python3 in a copy of the reference
+// workspace holding files and decodes the JSON printed after marker into
+// out. Runners report their own failures as {"error": "..."}.
+func (r *referenceSandbox) runTeacherScript(files map[string]string, runner, marker string, timeout time.Duration, out any) error {
+	workDir, cleanup, err := cloneWorkspace(r.dir)
+	if err != nil {
+		return fmt.Errorf("prepare workspace: %w", err)
+	}
+	defer cleanup()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	_ = ensureSandboxPerms(workDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
+	defer cancel()
+	vm, remoteDir, release, err := acquireRunWorkspace(ctx, r.sess, workDir)
+	if err != nil {
+		return fmt.Errorf("vm start failed: %w", err)
+	}
+	defer release()
+
+	execCtx, execCancel := context.WithTimeout(ctx, timeout)
+	defer execCancel()
+	script := fmt.Sprintf("PYTHONDONTWRITEBYTECODE=1 HOME=/tmp LANG=C.UTF-8 %s -I %s", pythonBinary, filepath.Join(remoteDir, runner))
+	stdout, errOut, _, runErr := vm.runCommand(execCtx, remoteDir, script, nil)
+	if execCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	idx := strings.LastIndex(stdout, marker)
+	if idx == -1 {
+		if runErr != nil {
+			return fmt.Errorf("%v: %s", runErr, strings.TrimSpace(errOut))
+		}
+		return errors.New("produced no result")
+	}
+	payload := []byte(strings.TrimSpace(stdout[idx+len(marker):]))
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &failure); err != nil {
+		return fmt.Errorf("invalid output: %w", err)
+	}
+	if failure.Error != "" {
+		return fmt.Errorf("raised an exception:\n%s", failure.Error)
+	}
+	if err := json.Unmarshal(payload, out); err != nil {
+		return fmt.Errorf("invalid output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a\nb\nc", "a\nx\nc\nd")
+	want := []diffLine{{" ", "a"}, {"-", "b"}, {"+", "x"}, {" ", "c"}, {"+", "d"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("lineDiff = %v, want %v", got, want)
+	}
+}
+
+func TestReferenceArchiveRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "main.py"), []byte("print(1)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "pkg", "util.py"), []byte("X = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := zipDirectory(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	names, err := extractArchive(base64.StdEncoding.EncodeToString(data), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"main.py", "pkg/util.py"}) {
+		t.Fatalf("unexpected files %v", names)
+	}
+	if b, err := os.ReadFile(filepath.Join(dst, "pkg", "util.py")); err != nil || string(b) != "X = 1\n" {
+		t.Fatalf("content mismatch: %q %v", b, err)
+	}
+}
+
+func TestRegeneratesExpected(t *testing.T) {
+	cases := []struct {
+		tc   TestCase
+		want bool
+	}{
+		{TestCase{ExecutionMode: "stdin_stdout"}, true},
+		{TestCase{ExecutionMode: "stdin_stdout", Comparator: "Regex"}, false},
+		{TestCase{ExecutionMode: "performance", Comparator: comparatorRegex}, false},
+		{TestCase{ExecutionMode: "checker", Comparator: "numeric"}, true},
+		{TestCase{ExecutionMode: "function", Comparator: comparatorRegex}, true},
+		{TestCase{ExecutionMode: "unittest"}, false},
+	}
+	for _, c := range cases {
+		if got := regeneratesExpected(c.tc); got != c.want {
+			t.Errorf("%s/%s: got %v", c.tc.ExecutionMode, c.tc.Comparator, got)
+		}
+	}
+}
+
+// TestReferenceOutputRoundTrips checks that an output is only proposed when
+// it compares equal to itself once stored as expected_stdout.
+func TestReferenceOutputRoundTrips(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		run := func(code string) referenceRun {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.py": code})
+			return runReferenceForTest(nil, dir, program{rt: pythonRuntime{}, entry: "main.py"}, TestCase{ExecutionMode: "stdin_stdout", TimeLimitSec: 10})
+		}
+		if r := run("print('a\\tb\\n')\n"); r.Problem != "" || r.Stdout != "a\tb" {
+			t.Errorf("plain output: %+v", r)
+		}
+		if r := run("print(r'C:\\new')\n"); !strings.Contains(r.Problem, "escape sequences") {
+			t.Errorf("output with a literal \\n: %+v", r)
+		}
+	})
+}
+
+func TestApplyPreviewedOutputs(t *testing.T) {
+	aid, stdoutID, funcID := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+	listRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "assignment_id", "stdin", "expected_stdout", "weight", "time_limit_sec", "memory_limit_kb", "execution_mode", "function_name", "expected_return", "comparator", "is_sample", "sql_column_match", "created_at", "updated_at"}).
+			AddRow(stdoutID.String(), aid.String(), "2", "old\n", 1.0, 1.0, 65536, "stdin_stdout", nil, nil, "exact", false, "exact", now, now).
+			AddRow(funcID.String(), aid.String(), "", "", 1.0, 1.0, 65536, "function", "f", "1", "exact", false, "exact", now, now)
+	}
+	post := func(body string) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: aid.String()}}
+		c.Request, _ = http.NewRequest("POST", "/", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		regenerateExpectedOutputs(c)
+		return w
+	}
+	outputs := func(stdoutToken string) string {
+		list := []previewedOutput{
+			{TestCaseID: stdoutID, New: "new\n", Token: stdoutToken},
+			{TestCaseID: funcID, New: "2", Token: expectedPreviewToken(funcID, "expected_return", "1", "2")},
+		}
+		raw, _ := json.Marshal(gin.H{"apply": true, "outputs": list})
+		return string(raw)
+	}
+
+	t.Run("saves the previewed values", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("failed to open sqlmock: %v", err)
+		}
+		defer db.Close()
+		DB = sqlx.NewDb(db, "sqlmock")
+		mock.ExpectQuery(`FROM test_cases`).WithArgs(aid).WillReturnRows(listRows())
+		mock.ExpectExec(`UPDATE test_cases SET expected_stdout`).WithArgs("new\n", nil, stdoutID).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE test_cases SET expected_stdout`).WithArgs("", "2", funcID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := post(outputs(expectedPreviewToken(stdoutID, "expected_stdout", "old\n", "new\n")))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("unmet expectations: %v", err)
+		}
+	})
+
+	t.Run("rejects outputs of a stale preview", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("failed to open sqlmock: %v", err)
+		}
+		defer db.Close()
+		DB = sqlx.NewDb(db, "sqlmock")
+		mock.ExpectQuery(`FROM test_cases`).WithArgs(aid).WillReturnRows(listRows())
+
+		// previewed against a stored value the test no longer has
+		w := post(outputs(expectedPreviewToken(stdoutID, "expected_stdout", "older\n", "new\n")))
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("unmet expectations: %v", err)
+		}
+	})
+
+	t.Run("requires the previewed outputs", func(t *testing.T) {
+		if w := post(`{"apply":true}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", w.Code)
+		}
+	})
+}
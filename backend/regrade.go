@@ -0,0 +1,400 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// A regrade re-enqueues existing submissions of an assignment after its tests
+// changed. Old results are dropped in the same transaction that queues the
+// jobs, and each submission's score before and after is kept in
+// regrade_score_changes as an audit trail.
+
+const (
+	regradeScopeAll    = "all"
+	regradeScopeLatest = "latest"
+	// failed covers every submission that did not pass all tests
+	regradeScopeFailed = "failed"
+
+	// regradeSuperseded is the new_status of a submission whose queued job
+	// was handed over to a later regrade before it ran.
+	regradeSuperseded = "superseded"
+)
+
+type RegradeOptions struct {
+	Scope                string `json:"scope"`
+	SkipManuallyAccepted *bool  `json:"skip_manually_accepted"`
+	KeepOverridePoints   *bool  `json:"keep_override_points"`
+}
+
+type RegradeRun struct {
+	ID                   uuid.UUID  `db:"id" json:"id"`
+	AssignmentID         uuid.UUID  `db:"assignment_id" json:"assignment_id"`
+	RequestedBy          *uuid.UUID `db:"requested_by" json:"requested_by,omitempty"`
+	Scope                string     `db:"scope" json:"scope"`
+	SkipManuallyAccepted bool       `db:"skip_manually_accepted" json:"skip_manually_accepted"`
+	KeepOverridePoints   bool       `db:"keep_override_points" json:"keep_override_points"`
+	Total                int        `db:"total" json:"total"`
+	Completed            int        `db:"completed" json:"completed"`
+	Status               string     `db:"status" json:"status"`
+	CreatedAt            time.Time  `db:"created_at" json:"created_at"`
+	FinishedAt           *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+type RegradeScoreChange struct {
+	SubmissionID        uuid.UUID  `db:"submission_id" json:"submission_id"`
+	StudentID           uuid.UUID  `db:"student_id" json:"student_id"`
+	StudentName         *string    `db:"student_name" json:"student_name,omitempty"`
+	OldStatus           string     `db:"old_status" json:"old_status"`
+	OldPoints           *float64   `db:"old_points" json:"old_points"`
+	OldOverridePoints   *float64   `db:"old_override_points" json:"old_override_points"`
+	OldManuallyAccepted bool       `db:"old_manually_accepted" json:"old_manually_accepted"`
+	NewStatus           *string    `db:"new_status" json:"new_status"`
+	NewPoints           *float64   `db:"new_points" json:"new_points"`
+	NewOverridePoints   *float64   `db:"new_override_points" json:"new_override_points"`
+	FinishedAt          *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+func validRegradeScope(scope string) bool {
+	switch scope {
+	case regradeScopeAll, regradeScopeLatest, regradeScopeFailed:
+		return true
+	}
+	return false
+}
+
+// StartRegrade selects the submissions matching the options, records their
+// current scores, clears their results and analysis reports and queues a
+// grading job for each, all in one transaction.
+func StartRegrade(aid uuid.UUID, requestedBy *uuid.UUID, scope string, skipManual, keepOverride bool) (*RegradeRun, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var run RegradeRun
+	err = tx.Get(&run, `
+        INSERT INTO regrade_runs (assignment_id, requested_by, scope, skip_manually_accepted, keep_override_points)
+        VALUES ($1,$2,$3,$4,$5)
+        RETURNING id, assignment_id, requested_by, scope, skip_manually_accepted, keep_override_points, total, 0 AS completed, status, created_at, finished_at`,
+		aid, requestedBy, scope, skipManual, keepOverride)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`
+        INSERT INTO regrade_score_changes (regrade_id, submission_id, student_id, old_status, old_points, old_override_points, old_manually_accepted)
+        SELECT $1, s.id, s.student_id, s.status::text, s.points, s.override_points, s.manually_accepted
+          FROM submissions s
+         WHERE s.assignment_id = $2
+           AND s.is_teacher_run = FALSE
+           AND ($3 <> 'latest' OR s.id = (
+                SELECT l.id FROM submissions l
+                 WHERE l.assignment_id = s.assignment_id AND l.student_id = s.student_id AND l.is_teacher_run = FALSE
+                 ORDER BY l.created_at DESC, l.id DESC
+                 LIMIT 1))
+           AND ($3 <> 'failed' OR s.status IN ('failed','partially_completed'))
+           AND (NOT $4 OR s.manually_accepted = FALSE)`,
+		run.ID, aid, scope, skipManual)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := res.RowsAffected()
+	run.Total = int(n)
+
+	if _, err := tx.Exec(`
+        DELETE FROM results
+         WHERE submission_id IN (SELECT submission_id FROM regrade_score_changes WHERE regrade_id = $1)`, run.ID); err != nil {
+		return nil, err
+	}
+	// Manually accepted submissions are never graded by the worker, so
+	// including them in a regrade withdraws the acceptance.
+	if _, err := tx.Exec(`
+        UPDATE submissions s
+           SET status = 'pending',
+               manually_accepted = FALSE,
+               override_points = CASE WHEN $2 THEN s.override_points ELSE NULL END,
+               construct_findings = NULL,
+               quality_report = NULL,
+               updated_at = now()
+          FROM regrade_score_changes c
+         WHERE c.regrade_id = $1 AND c.submission_id = s.id`, run.ID, keepOverride); err != nil {
+		return nil, err
+	}
+	// A job still queued for an earlier regrade is taken over below, so that
+	// run would never hear about it. Close its rows as superseded and let it
+	// complete if nothing else is outstanding.
+	if _, err := tx.Exec(`
+        UPDATE regrade_score_changes c
+           SET new_status = $2, finished_at = now()
+          FROM grading_jobs j, regrade_score_changes n
+         WHERE n.regrade_id = $1 AND j.submission_id = n.submission_id
+           AND j.status = 'queued' AND j.regrade_id = c.regrade_id
+           AND c.submission_id = n.submission_id AND c.regrade_id <> $1 AND c.finished_at IS NULL`,
+		run.ID, regradeSuperseded); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+        UPDATE regrade_runs r
+           SET status = 'completed', finished_at = now()
+         WHERE r.assignment_id = $2 AND r.id <> $1 AND r.status = 'running'
+           AND NOT EXISTS (SELECT 1 FROM regrade_score_changes WHERE regrade_id = r.id AND finished_at IS NULL)`,
+		run.ID, aid); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+        INSERT INTO grading_jobs (submission_id, max_attempts, regrade_id)
+        SELECT submission_id, $2, $1 FROM regrade_score_changes WHERE regrade_id = $1
+        ON CONFLICT (submission_id) WHERE status = 'queued'
+        DO UPDATE SET regrade_id = EXCLUDED.regrade_id, updated_at = now()`, run.ID, jobMaxAttempts); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE regrade_runs SET total = $2,
+                               status = CASE WHEN $2 = 0 THEN 'completed' ELSE status END,
+                               finished_at = CASE WHEN $2 = 0 THEN now() ELSE NULL END
+                         WHERE id = $1`, run.ID, run.Total); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if run.Total == 0 {
+		run.Status = "completed"
+	}
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	return &run, nil
+}
+
+func GetRegradeRun(id uuid.UUID) (*RegradeRun, error) {
+	var run RegradeRun
+	err := DB.Get(&run, `
+        SELECT r.id, r.assignment_id, r.requested_by, r.scope, r.skip_manually_accepted, r.keep_override_points,
+               r.total, r.status, r.created_at, r.finished_at,
+               (SELECT COUNT(*) FROM regrade_score_changes c WHERE c.regrade_id = r.id AND c.finished_at IS NOT NULL) AS completed
+          FROM regrade_runs r
+         WHERE r.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func ListRegradeRuns(aid uuid.UUID) ([]RegradeRun, error) {
+	list := []RegradeRun{}
+	err := DB.Select(&list, `
+        SELECT r.id, r.assignment_id, r.requested_by, r.scope, r.skip_manually_accepted, r.keep_override_points,
+               r.total, r.status, r.created_at, r.finished_at,
+               (SELECT COUNT(*) FROM regrade_score_changes c WHERE c.regrade_id = r.id AND c.finished_at IS NOT NULL) AS completed
+          FROM regrade_runs r
+         WHERE r.assignment_id = $1
+         ORDER BY r.created_at DESC`, aid)
+	return list, err
+}
+
+func ListRegradeScoreChanges(regradeID uuid.UUID) ([]RegradeScoreChange, error) {
+	list := []RegradeScoreChange{}
+	err := DB.Select(&list, `
+        SELECT c.submission_id, c.student_id, u.name AS student_name,
+               c.old_status, c.old_points, c.old_override_points, c.old_manually_accepted,
+               c.new_status, c.new_points, c.new_override_points, c.finished_at
+          FROM regrade_score_changes c
+          LEFT JOIN users u ON u.id = c.student_id
+         WHERE c.regrade_id = $1
+         ORDER BY u.name NULLS LAST, c.submission_id`, regradeID)
+	return list, err
+}
+
+// finishRegradeJob records the outcome of a job that belongs to a regrade
+// once the job reached a final state.
+func finishRegradeJob(j *GradingJob) {
+	if j.RegradeID == nil {
+		return
+	}
+	if err := finishRegradeSubmission(*j.RegradeID, j.SubmissionID); err != nil {
+		fmt.Printf("[worker] regrade %s: record submission %s: %v\n", *j.RegradeID, j.SubmissionID, err)
+	}
+}
+
+func finishRegradeSubmission(regradeID, subID uuid.UUID) error {
+	_, err := DB.Exec(`
+        UPDATE regrade_score_changes c
+           SET new_status = s.status::text, new_points = s.points, new_override_points = s.override_points, finished_at = now()
+          FROM submissions s
+         WHERE c.regrade_id = $1 AND c.submission_id = $2 AND s.id = c.submission_id AND c.finished_at IS NULL`,
+		regradeID, subID)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+        UPDATE regrade_runs
+           SET status = 'completed', finished_at = now()
+         WHERE id = $1 AND status = 'running'
+           AND NOT EXISTS (SELECT 1 FROM regrade_score_changes WHERE regrade_id = $1 AND finished_at IS NULL)`,
+		regradeID)
+	return err
+}
+
+// regradeAssignment: POST /api/assignments/:id/regrade
+func regradeAssignment(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	var req RegradeOptions
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Scope == "" {
+		req.Scope = regradeScopeAll
+	}
+	if !validRegradeScope(req.Scope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be all, latest or failed"})
+		return
+	}
+	skipManual, keepOverride := true, true
+	if req.SkipManuallyAccepted != nil {
+		skipManual = *req.SkipManuallyAccepted
+	}
+	if req.KeepOverridePoints != nil {
+		keepOverride = *req.KeepOverridePoints
+	}
+	assignment, err := GetAssignment(aid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if assignment.ManualReview && assignment.ProgrammingLanguage != "scratch" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manually reviewed assignments are not graded automatically"})
+		return
+	}
+	uid := getUserID(c)
+	run, err := StartRegrade(aid, &uid, req.Scope, skipManual, keepOverride)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusAccepted, run)
+}
+
+// listRegrades: GET /api/assignments/:id/regrades
+func listRegrades(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	list, err := ListRegradeRuns(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func loadRegradeForRequest(c *gin.Context) (*RegradeRun, bool) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return nil, false
+	}
+	run, err := GetRegradeRun(rid)
+	if err != nil || run.AssignmentID != aid {
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+			return nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return nil, false
+	}
+	return run, true
+}
+
+// getRegrade: GET /api/assignments/:id/regrades/:rid
+// Returns the run together with the score audit of every submission.
+func getRegrade(c *gin.Context) {
+	run, ok := loadRegradeForRequest(c)
+	if !ok {
+		return
+	}
+	changes, err := ListRegradeScoreChanges(run.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"regrade": run, "changes": changes})
+}
+
+// regradeEventsHandler: GET /api/assignments/:id/regrades/:rid/events
+// Streams a "progress" event for each regraded submission and "done" at the
+// end. Jobs may run in another process, so progress is read from the
+// database rather than pushed by the worker.
+func regradeEventsHandler(c *gin.Context) {
+	run, ok := loadRegradeForRequest(c)
+	if !ok {
+		return
+	}
+	seen := map[uuid.UUID]bool{}
+	c.Stream(func(w io.Writer) bool {
+		cur, err := GetRegradeRun(run.ID)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "db fail"})
+			return false
+		}
+		changes, err := ListRegradeScoreChanges(run.ID)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "db fail"})
+			return false
+		}
+		for _, ch := range changes {
+			if ch.FinishedAt == nil || seen[ch.SubmissionID] {
+				continue
+			}
+			seen[ch.SubmissionID] = true
+			c.SSEvent("progress", gin.H{
+				"regrade_id": run.ID,
+				"completed":  cur.Completed,
+				"total":      cur.Total,
+				"change":     ch,
+			})
+		}
+		if cur.Status != "running" {
+			c.SSEvent("done", cur)
+			return false
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-time.After(jobPollInterval):
+			return true
+		}
+	})
+}
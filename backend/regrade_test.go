@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestStartRegradeRunsInOneTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	defer db.Close()
+	DB = sqlx.NewDb(db, "sqlmock")
+	jobWake = make(chan struct{}, 1)
+
+	aid, rid, teacher := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO regrade_runs`).
+		WithArgs(aid, &teacher, "latest", true, false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "assignment_id", "requested_by", "scope", "skip_manually_accepted", "keep_override_points", "total", "completed", "status", "created_at", "finished_at"}).
+			AddRow(rid, aid, teacher, "latest", true, false, 0, 0, "running", now, nil))
+	mock.ExpectExec(`INSERT INTO regrade_score_changes`).
+		WithArgs(rid, aid, "latest", true).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`DELETE FROM results`).WithArgs(rid).WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectExec(`(?s)UPDATE submissions s.*construct_findings = NULL,\s+quality_report = NULL`).WithArgs(rid, false).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE regrade_score_changes c\s+SET new_status = \$2`).WithArgs(rid, regradeSuperseded).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE regrade_runs r\s+SET status = 'completed'`).WithArgs(rid, aid).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO grading_jobs`).WithArgs(rid, jobMaxAttempts).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE regrade_runs SET total`).WithArgs(rid, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	run, err := StartRegrade(aid, &teacher, "latest", true, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run.Total != 3 || run.Status != "running" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestStartRegradeRollsBackOnFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	defer db.Close()
+	DB = sqlx.NewDb(db, "sqlmock")
+
+	aid, rid := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO regrade_runs`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "assignment_id", "requested_by", "scope", "skip_manually_accepted", "keep_override_points", "total", "completed", "status", "created_at", "finished_at"}).
+			AddRow(rid, aid, nil, "all", true, true, 0, 0, "running", time.Now(), nil))
+	mock.ExpectExec(`INSERT INTO regrade_score_changes`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM results`).WillReturnError(errors.New("boom"))
+	mock.ExpectRollback()
+
+	if _, err := StartRegrade(aid, nil, "all", true, true); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
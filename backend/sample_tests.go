@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Sample (public) tests can be run by students against uncommitted code. Such
+// runs create no submission, do not count against max_attempts or the
+// submission rate limit, and have their own hourly quota tracked in
+// sample_runs.
+
+const defaultSampleRunLimit = 20
+const sampleRunWindowSeconds = 3600
+
+func getSampleRunLimit() int {
+	raw := strings.TrimSpace(GetSystemVariable("sample_run_limit_per_hour", ""))
+	if raw == "" {
+		return defaultSampleRunLimit
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 0 {
+		return defaultSampleRunLimit
+	}
+	return limit
+}
+
+// publicSampleTests returns the sample tests of a list with teacher-only data
+// removed: checker code, the reference SQL query, the generators of
+// performance and property tests and the content of expected output files.
+func publicSampleTests(tests []TestCase) []TestCase {
+	out := []TestCase{}
+	for _, tc := range tests {
+		if !tc.IsSample {
+			continue
+		}
+		tc.CheckerCode = nil
+		tc.SQLQuery = nil
+		tc.PerformanceConfig = nil
+		tc.PropertyConfig = nil
+		tc.OutputFiles = publicOutputFiles(tc.OutputFiles)
+		out = append(out, tc)
+	}
+	return out
+}
+
+// publicOutputFiles keeps the names and comparators of the expected output
+// files, so students know what to write, but not their content.
+func publicOutputFiles(raw *string) *string {
+	files, err := parseOutputFiles(raw)
+	if err != nil || len(files) == 0 {
+		return nil
+	}
+	for i := range files {
+		files[i].Content = ""
+	}
+	data, err := json.Marshal(files)
+	if err != nil {
+		return nil
+	}
+	public := string(data)
+	return &public
+}
+
+type sampleRunWindow struct {
+	Count  int          `db:"count"`
+	Oldest sql.NullTime `db:"oldest"`
+}
+
+func getSampleRunWindow(aid, studentID uuid.UUID) (sampleRunWindow, error) {
+	var w sampleRunWindow
+	err := DB.Get(&w, sampleRunWindowQuery, aid, studentID, sampleRunWindowSeconds)
+	return w, err
+}
+
+const sampleRunWindowQuery = `SELECT COUNT(*) AS count, MIN(created_at) AS oldest
+                                FROM sample_runs
+                               WHERE assignment_id=$1 AND student_id=$2
+                                 AND created_at >= now() - make_interval(secs => $3)`
+
+// claimSampleRun charges one sample run to the student unless the hourly
+// quota is used up. The student's row is locked while counting, so
+// concurrent requests cannot both take the last run. The window is returned
+// for the Retry-After header when the claim fails.
+func claimSampleRun(aid, studentID uuid.UUID, limit int) (sampleRunWindow, bool, error) {
+	var w sampleRunWindow
+	tx, err := DB.Beginx()
+	if err != nil {
+		return w, false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id=$1 FOR UPDATE`, studentID); err != nil {
+		return w, false, err
+	}
+	if err := tx.Get(&w, sampleRunWindowQuery, aid, studentID, sampleRunWindowSeconds); err != nil {
+		return w, false, err
+	}
+	if w.Count >= limit {
+		return w, false, nil
+	}
+	if _, err := tx.Exec(`INSERT INTO sample_runs (assignment_id, student_id) VALUES ($1,$2)`, aid, studentID); err != nil {
+		return w, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return w, false, err
+	}
+	// old runs are pruned on the way; the claim stands if that fails
+	_, _ = DB.Exec(`DELETE FROM sample_runs WHERE created_at < now() - interval '1 day'`)
+	return w, true, nil
+}
+
+// refundSampleRun gives back a claimed run when the server failed to carry
+// it out. Any run of the window will do, since only their number counts.
+func refundSampleRun(aid, studentID uuid.UUID) error {
+	_, err := DB.Exec(`
+        DELETE FROM sample_runs
+         WHERE id = (SELECT id FROM sample_runs
+                      WHERE assignment_id=$1 AND student_id=$2
+                      ORDER BY created_at DESC
+                      LIMIT 1)`, aid, studentID)
+	return err
+}
+
+// sampleRunLimitReached answers a request over the sample run quota.
+func sampleRunLimitReached(c *gin.Context, limit int, window sampleRunWindow) {
+	retryAfter := sampleRunWindowSeconds
+	if window.Oldest.Valid {
+		remaining := sampleRunWindowSeconds - int(time.Since(window.Oldest.Time).Seconds())
+		if remaining > 0 {
+			retryAfter = remaining
+		} else {
+			retryAfter = 1
+		}
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":               "sample_run_limit_reached",
+		"limit":               limit,
+		"window_seconds":      sampleRunWindowSeconds,
+		"retry_after_seconds": retryAfter,
+	})
+}
+
+// sampleRunStatus reports how many sample runs a student has left.
+func sampleRunStatus(aid, studentID uuid.UUID) gin.H {
+	limit := getSampleRunLimit()
+	used := 0
+	if w, err := getSampleRunWindow(aid, studentID); err == nil {
+		used = w.Count
+	}
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return gin.H{"limit": limit, "remaining": remaining, "window_seconds": sampleRunWindowSeconds}
+}
+
+// runSampleTests: POST /api/assignments/:id/sample-runs
+func runSampleTests(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	uid := getUserID(c)
+	if ok, err := IsStudentOfAssignment(aid, uid); err != nil || !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	assignment, err := GetAssignment(aid)
+	if err != nil || !assignment.Published {
+		c.JSON(http.StatusNotFound, gin.H{"error": "assignment not found"})
+		return
+	}
+	if assignment.ProgrammingLanguage == "scratch" || assignment.LLMInteractive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sample runs are not available for this assignment"})
+		return
+	}
+	tests, err := ListTestCases(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	var samples []TestCase
+	for _, tc := range tests {
+		if tc.IsSample {
+			samples = append(samples, tc)
+		}
+	}
+	if len(samples) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "assignment has no sample tests"})
+		return
+	}
+
+	limit := getSampleRunLimit()
+	window, err := getSampleRunWindow(aid, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	// a cheap check before the upload; the run is only charged below
+	if window.Count >= limit {
+		sampleRunLimitReached(c, limit, window)
+		return
+	}
+
+	maxMB := assignment.MaxSubmissionSizeMB
+	if maxMB <= 0 {
+		maxMB = defaultSubmissionSizeMB
+	}
+	maxBytes := int64(maxMB) * 1024 * 1024
+	if c.Request.ContentLength > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "submission_too_large", "max_mb": maxMB})
+		return
+	}
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid form"})
+		return
+	}
+	var files []*multipart.FileHeader
+	if c.Request.MultipartForm != nil {
+		files = c.Request.MultipartForm.File["files"]
+	}
+	if len(files) == 0 {
+		if f, err := c.FormFile("file"); err == nil {
+			files = []*multipart.FileHeader{f}
+		}
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no files"})
+		return
+	}
+	tmpDir, err := os.MkdirTemp(execRoot, "sample-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	var totalSize int64
+	for _, fh := range files {
+		if err := c.SaveUploadedFile(fh, filepath.Join(tmpDir, filepath.Base(fh.Filename))); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot save"})
+			return
+		}
+		totalSize += fh.Size
+		if totalSize > maxBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "submission_too_large", "max_mb": maxMB})
+			return
+		}
+	}
+	prog, err := loadProgram(tmpDir, assignment.ProgrammingLanguage)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_ = ensureSandboxPerms(tmpDir)
+
+	// The quota is charged once the upload is accepted, before running.
+	window, claimed, err := claimSampleRun(aid, uid, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	if !claimed {
+		sampleRunLimitReached(c, limit, window)
+		return
+	}
+
+	outcomes := make([]testOutcome, len(samples))
+	bannedFuncs := copyStringArray(assignment.BannedFunctions)
+	bannedMods := copyStringArray(assignment.BannedModules)
+	var illegal string
+	if len(bannedFuncs) > 0 || len(bannedMods) > 0 {
+		if findings, detErr := detectIllegalToolUse(tmpDir, bannedFuncs, bannedMods); detErr == nil && len(findings) > 0 {
+			illegal = formatIllegalToolMessage(findings, notesFromAssignment(assignment))
+		}
+	}
+	// construct rules are checked as in graded runs
+	var constructFindings []constructFinding
+	var constructViolation string
+	if _, ok := prog.rt.(pythonRuntime); ok && illegal == "" {
+		if rules, err := assignmentConstructRules(assignment); err != nil {
+			fmt.Printf("[sample] invalid construct rules for assignment %s: %v\n", assignment.ID, err)
+		} else if findings, err := checkConstructRules(tmpDir, rules); err != nil {
+			fmt.Printf("[sample] construct analysis failed: %v\n", err)
+		} else {
+			constructFindings = findings
+			if failed, _ := constructOutcome(findings); failed {
+				constructViolation = formatConstructMessage(findings)
+			}
+		}
+	}
+	if illegal != "" {
+		for i, tc := range samples {
+			outcomes[i] = testOutcome{result: &Result{TestCaseID: tc.ID, Status: "illegal_tool_use", Stderr: illegal, ExitCode: -1}, weight: tc.Weight}
+		}
+	} else if constructViolation != "" {
+		for i, tc := range samples {
+			outcomes[i] = testOutcome{result: &Result{TestCaseID: tc.ID, Status: "construct_violation", Stderr: constructViolation, ExitCode: -1}, weight: tc.Weight}
+		}
+	} else {
+		var budget time.Duration
+		for _, tc := range samples {
+			budget += time.Duration(float64(max(performanceRuns(tc), propertyRuns(tc)))*tc.TimeLimitSec*float64(time.Second)) + vmExtraTimeout + 30*time.Second
+		}
+		if prog.rt.compiled() {
+			budget += compileTimeout
+		}
+		sess, sessErr := startVMSession(tmpDir, budget)
+		if sessErr != nil {
+			fmt.Printf("[sample] shared VM session unavailable: %v\n", sessErr)
+			sess = nil
+		}
+		defer sess.Close()
+		ref := newReferenceSandbox(assignment, samples)
+		defer ref.Close()
+
+		var ce *compileError
+		if err := prog.rt.build(sess, tmpDir, assignment); err != nil && !errors.As(err, &ce) {
+			// the student is not charged for our failure
+			if rerr := refundSampleRun(aid, uid); rerr != nil {
+				fmt.Printf("[sample] refund run of %s: %v\n", uid, rerr)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if ce != nil {
+			for i, tc := range samples {
+				outcomes[i] = testOutcome{result: &Result{TestCaseID: tc.ID, Status: "compile_error", Stderr: ce.Diagnostics, ExitCode: -1}, weight: tc.Weight}
+			}
+		} else {
+			sem := make(chan struct{}, max(maxParallelVMs, 1))
+			var wg sync.WaitGroup
+			for i := range samples {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					sem <- struct{}{}
+					defer func() { <-sem }()
+					outcomes[i] = runTestCase(sess, uuid.Nil, samples[i], tmpDir, prog, ref)
+				}(i)
+			}
+			wg.Wait()
+		}
+	}
+
+	public := publicSampleTests(samples)
+	results := make([]gin.H, 0, len(outcomes))
+	passed := 0
+	for i, o := range outcomes {
+		r := o.result
+		if r == nil {
+			continue
+		}
+		if !assignment.ShowTraceback && r.Status != "compile_error" && r.Status != "construct_violation" && r.Status != "illegal_tool_use" {
+			r.Stderr = ""
+		}
+		if o.passed {
+			passed++
+		}
+		results = append(results, gin.H{"test": public[i], "result": r})
+	}
+	resp := gin.H{
+		"results": results,
+		"summary": gin.H{"total": len(samples), "passed": passed},
+		"quota":   sampleRunStatus(aid, uid),
+	}
+	if len(constructFindings) > 0 {
+		resp["construct_findings"] = constructFindings
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestPublicSampleTestsHidesCheckerAndPrivateTests(t *testing.T) {
+	checker := "def check(i, o, r):\n    return True\n"
+	tests := []TestCase{
+		{Stdin: "1", ExpectedStdout: "1", IsSample: true},
+		{Stdin: "2", ExpectedStdout: "2"},
+		{Stdin: "3", ExecutionMode: "checker", CheckerCode: &checker, IsSample: true},
+	}
+	got := publicSampleTests(tests)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 sample tests, got %d", len(got))
+	}
+	if got[0].Stdin != "1" || got[1].Stdin != "3" {
+		t.Fatalf("unexpected samples %+v", got)
+	}
+	if got[1].CheckerCode != nil {
+		t.Fatalf("checker code must not be exposed to students")
+	}
+	if tests[2].CheckerCode == nil {
+		t.Fatalf("original test case must not be modified")
+	}
+}
+
+func TestPublicSampleTestsHidesReferenceMaterial(t *testing.T) {
+	query := "SELECT name FROM users ORDER BY name"
+	perf := `{"generator":"print(10**6)","series":[1000]}`
+	prop := `{"generator":"import random"}`
+	files := `[{"name":"out.csv","content":"YSxiCg==","comparator":"csv"}]`
+	tests := []TestCase{{ExecutionMode: "sql_query", SQLQuery: &query, PerformanceConfig: &perf, PropertyConfig: &prop, OutputFiles: &files, IsSample: true}}
+	got := publicSampleTests(tests)[0]
+	if got.SQLQuery != nil || got.PerformanceConfig != nil || got.PropertyConfig != nil {
+		t.Fatalf("reference material exposed: %+v", got)
+	}
+	if got.OutputFiles == nil || !strings.Contains(*got.OutputFiles, `"name":"out.csv"`) || strings.Contains(*got.OutputFiles, "YSxiCg==") {
+		t.Fatalf("unexpected output files %v", got.OutputFiles)
+	}
+	if *tests[0].OutputFiles != files {
+		t.Fatalf("original test case must not be modified")
+	}
+}
+
+func TestClaimSampleRunCountsAndInsertsUnderLock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	defer db.Close()
+	DB = sqlx.NewDb(db, "sqlmock")
+	aid, uid := uuid.New(), uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM users WHERE id=\$1 FOR UPDATE`).WithArgs(uid).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) AS count`).WithArgs(aid, uid, sampleRunWindowSeconds).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "oldest"}).AddRow(1, nil))
+	mock.ExpectExec(`INSERT INTO sample_runs`).WithArgs(aid, uid).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`DELETE FROM sample_runs`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, ok, err := claimSampleRun(aid, uid, 2); err != nil || !ok {
+		t.Fatalf("claim under the limit: ok=%v err=%v", ok, err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM users WHERE id=\$1 FOR UPDATE`).WithArgs(uid).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) AS count`).WithArgs(aid, uid, sampleRunWindowSeconds).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "oldest"}).AddRow(2, nil))
+	mock.ExpectRollback()
+	if w, ok, err := claimSampleRun(aid, uid, 2); err != nil || ok || w.Count != 2 {
+		t.Fatalf("claim over the limit: ok=%v count=%d err=%v", ok, w.Count, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRefundSampleRunDropsOneRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	defer db.Close()
+	DB = sqlx.NewDb(db, "sqlmock")
+	aid, uid := uuid.New(), uuid.New()
+
+	mock.ExpectExec(`(?s)DELETE FROM sample_runs\s+WHERE id = \(SELECT id FROM sample_runs.*LIMIT 1\)`).
+		WithArgs(aid, uid).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := refundSampleRun(aid, uid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
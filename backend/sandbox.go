@@ -0,0 +1,232 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Sandbox is an isolated environment student code runs in. The grader only
+// relies on this interface, so the QEMU VM can be swapped for a container or,
+// during development, a plain local process.
+type Sandbox interface {
+	// syncWorkspace copies dir into the sandbox and returns the path of the
+	// copy as seen by commands running inside it.
+	syncWorkspace(ctx context.Context, dir string) (string, error)
+	// pullFiles copies the named regular files of remoteDir back out of the
+	// sandbox. Files that do not exist are left out of the result.
+	pullFiles(ctx context.Context, remoteDir string, names []string) (map[string][]byte, error)
+	// shellCommand prepares a bash script to run inside the sandbox.
+	shellCommand(ctx context.Context, script string) *exec.Cmd
+	// runCommand executes script in workdir and waits for it to finish.
+	runCommand(ctx context.Context, workdir, script string, stdin *strings.Reader) (string, string, int, error)
+	// startInteractive starts script in workdir and returns pipes for streaming IO.
+	startInteractive(ctx context.Context, workdir, script string) (*exec.Cmd, io.WriteCloser, io.ReadCloser, io.ReadCloser, error)
+	Close()
+}
+
+const (
+	sandboxQEMU   = "qemu"
+	sandboxDocker = "docker"
+	sandboxLocal  = "local"
+)
+
+// SANDBOX_BACKEND selects where submissions run: "qemu" (default), "docker"
+// (also used for Podman, see SANDBOX_DOCKER_BINARY) or "local".
+var sandboxBackend = normalizeSandboxBackend(getenvOr("SANDBOX_BACKEND", sandboxQEMU))
+
+func normalizeSandboxBackend(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "qemu", "vm", "kvm":
+		return sandboxQEMU
+	case "docker", "podman", "container":
+		return sandboxDocker
+	case "local", "unsafe", "unsafe-local", "process":
+		return sandboxLocal
+	default:
+		return strings.ToLower(strings.TrimSpace(raw))
+	}
+}
+
+// initSandbox prepares the configured backend at startup.
+func initSandbox() {
+	switch sandboxBackend {
+	case sandboxQEMU:
+		initVMPool()
+	case sandboxDocker:
+		if err := ensureSandboxImage(); err != nil {
+			fmt.Printf("[sandbox] warn: %v\n", err)
+		}
+	case sandboxLocal:
+		fmt.Println("[sandbox] WARNING: SANDBOX_BACKEND=local runs student code directly on this host without any isolation. Use it for development and CI only.")
+	default:
+		fmt.Printf("[sandbox] unknown SANDBOX_BACKEND %q; submissions will fail to run\n", sandboxBackend)
+	}
+}
+
+// startSandbox provides a fresh sandbox from the configured backend.
+func startSandbox(ctx context.Context) (Sandbox, error) {
+	switch sandboxBackend {
+	case sandboxQEMU:
+		return startVM(ctx, nil)
+	case sandboxDocker:
+		return startContainerSandbox(ctx)
+	case sandboxLocal:
+		return startLocalSandbox(ctx)
+	default:
+		return nil, fmt.Errorf("unknown sandbox backend %q", sandboxBackend)
+	}
+}
+
+// startSandboxWithWorkspace starts a sandbox and copies the workspace into it.
+func startSandboxWithWorkspace(ctx context.Context, dir string) (Sandbox, string, error) {
+	sb, err := startSandbox(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	remoteDir, err := sb.syncWorkspace(ctx, dir)
+	if err != nil {
+		sb.Close()
+		return nil, "", err
+	}
+	return sb, remoteDir, nil
+}
+
+// runSandboxCommand runs a prepared sandbox command to completion.
+func runSandboxCommand(cmd *exec.Cmd, stdin *strings.Reader) (string, string, int, error) {
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+	err := cmd.Run()
+	exitCode := 0
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			exitCode = ee.ExitCode()
+		} else {
+			exitCode = -1
+		}
+	}
+	return stdoutBuf.String(), stderrBuf.String(), exitCode, err
+}
+
+// startSandboxCommand starts a prepared sandbox command with all three pipes.
+func startSandboxCommand(cmd *exec.Cmd) (*exec.Cmd, io.WriteCloser, io.ReadCloser, io.ReadCloser, error) {
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	stdinPipe, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return cmd, stdinPipe, stdoutPipe, stderrPipe, nil
+}
+
+// pullFilesWithTar implements pullFiles for sandboxes reached through a
+// shell: the files are packed with tar inside and unpacked here. Only regular
+// files are taken, so a symlink planted by the program cannot leak anything
+// from outside the workspace.
+func pullFilesWithTar(ctx context.Context, sb Sandbox, remoteDir string, names []string) (map[string][]byte, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = shellQuote(name)
+	}
+	script := fmt.Sprintf("cd %s && for f in %s; do [ -f \"$f\" ] && [ ! -L \"$f\" ] && printf '%%s\\n' \"$f\"; done | tar -cf - -T -", shellQuote(remoteDir), strings.Join(quoted, " "))
+	cmd := sb.shellCommand(ctx, script)
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pull files from %s: %w (%s)", remoteDir, err, strings.TrimSpace(stderrBuf.String()))
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(&stdoutBuf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pull files from %s: %w", remoteDir, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > maxPulledFileBytes {
+			return nil, fmt.Errorf("%s is larger than %d bytes", hdr.Name, maxPulledFileBytes)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = data
+	}
+	return files, nil
+}
+
+// maxPulledFileBytes bounds every file pulled out of a sandbox.
+const maxPulledFileBytes = 8 << 20
+
+// tarWorkspace packs the regular files and directories of dir, keeping their
+// permission bits so compiled binaries stay executable.
+func tarWorkspace(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pack workspace: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Container sandbox configuration. Works with Docker and with rootless Podman
+// (SANDBOX_DOCKER_BINARY=podman); the image needs bash, coreutils and the
+// language toolchains the course uses, like the QEMU base image.
+var (
+	sandboxDockerBinary = getenvOr("SANDBOX_DOCKER_BINARY", "docker")
+	sandboxDockerImage  = getenvOr("SANDBOX_DOCKER_IMAGE", pythonImage)
+	sandboxDockerCPUs   = getenvOr("SANDBOX_DOCKER_CPUS", qemuCPUs)
+	sandboxDockerMemory = getenvOr("SANDBOX_DOCKER_MEMORY", strings.ToLower(qemuMemory))
+	sandboxDockerPids   = getenvOr("SANDBOX_DOCKER_PIDS_LIMIT", "256")
+	sandboxDockerTmpfs  = getenvOr("SANDBOX_DOCKER_TMPFS_SIZE", "512m")
+)
+
+// containerSandbox is a long-lived container without network access that
+// commands are exec'd into. Workspaces live on a tmpfs at /tmp.
+type containerSandbox struct {
+	name     string
+	slotHeld bool
+}
+
+func ensureSandboxImage() error {
+	if err := exec.Command(sandboxDockerBinary, "image", "inspect", sandboxDockerImage).Run(); err == nil {
+		return nil
+	}
+	if out, err := exec.Command(sandboxDockerBinary, "pull", sandboxDockerImage).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s pull %s failed: %w (%s)", sandboxDockerBinary, sandboxDockerImage, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func containerRunArgs(name string) []string {
+	return []string{
+		"run", "-d", "--rm",
+		"--name", name,
+		"--network", "none",
+		"--user", dockerUser,
+		"--cpus", sandboxDockerCPUs,
+		"--memory", sandboxDockerMemory,
+		"--memory-swap", sandboxDockerMemory,
+		"--pids-limit", sandboxDockerPids,
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--tmpfs", fmt.Sprintf("/tmp:rw,exec,nosuid,size=%s", sandboxDockerTmpfs),
+		"--workdir", "/tmp",
+		sandboxDockerImage,
+		"sleep", "infinity",
+	}
+}
+
+func startContainerSandbox(ctx context.Context) (*containerSandbox, error) {
+	if err := acquireVMSlot(ctx); err != nil {
+		return nil, fmt.Errorf("waiting for sandbox slot: %w", err)
+	}
+	c := &containerSandbox{name: fmt.Sprintf("codedu-sandbox-%d", time.Now().UnixNano()), slotHeld: true}
+	startCtx, cancel := context.WithTimeout(ctx, vmBootTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(startCtx, sandboxDockerBinary, containerRunArgs(c.name)...).CombinedOutput()
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("%s run: %w (%s)", sandboxDockerBinary, err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("[sandbox] started container %s image=%s\n", c.name, sandboxDockerImage)
+	return c, nil
+}
+
+// syncWorkspace streams dir as a tar into a fresh directory on the tmpfs.
+// Extracting as the container user keeps the files writable for tests.
+func (c *containerSandbox) syncWorkspace(ctx context.Context, dir string) (string, error) {
+	data, err := tarWorkspace(dir)
+	if err != nil {
+		return "", err
+	}
+	dest := fmt.Sprintf("/tmp/code-%d", time.Now().UnixNano())
+	copyCtx, cancel := context.WithTimeout(ctx, vmBootTimeout)
+	defer cancel()
+	cmd := c.shellCommand(copyCtx, fmt.Sprintf("mkdir -p -- %[1]s && tar -xf - -C %[1]s", shellQuote(dest)))
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("copy workspace to %s: %w (%s)", dest, err, strings.TrimSpace(string(out)))
+	}
+	return dest, nil
+}
+
+func (c *containerSandbox) pullFiles(ctx context.Context, remoteDir string, names []string) (map[string][]byte, error) {
+	return pullFilesWithTar(ctx, c, remoteDir, names)
+}
+
+func (c *containerSandbox) shellCommand(ctx context.Context, script string) *exec.Cmd {
+	return exec.CommandContext(ctx, sandboxDockerBinary, "exec", "-i", c.name, "bash", "-lc", script)
+}
+
+func (c *containerSandbox) runCommand(ctx context.Context, workdir, script string, stdin *strings.Reader) (string, string, int, error) {
+	fmt.Printf("[sandbox] runCommand container=%s workdir=%s script=%s\n", c.name, workdir, script)
+	return runSandboxCommand(c.shellCommand(ctx, fmt.Sprintf("cd %s && %s", workdir, script)), stdin)
+}
+
+func (c *containerSandbox) startInteractive(ctx context.Context, workdir, script string) (*exec.Cmd, io.WriteCloser, io.ReadCloser, io.ReadCloser, error) {
+	fmt.Printf("[sandbox] startInteractive container=%s workdir=%s script=%s\n", c.name, workdir, script)
+	return startSandboxCommand(c.shellCommand(ctx, fmt.Sprintf("cd %s && %s", workdir, script)))
+}
+
+func (c *containerSandbox) Close() {
+	if c.name != "" {
+		_ = exec.Command(sandboxDockerBinary, "rm", "-f", c.name).Run()
+	}
+	if c.slotHeld {
+		releaseVMSlot()
+		c.slotHeld = false
+	}
+}
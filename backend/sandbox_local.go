@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// localSandbox runs commands as plain processes on the backend host.
+//
+// UNSAFE: there is no isolation of any kind. Student code can read and modify
+// everything the backend user can. It exists so the grader can be developed
+// and tested on machines without KVM or a container runtime, and must never
+// be enabled on a server that accepts real submissions.
+type localSandbox struct {
+	root     string
+	seq      atomic.Int64
+	slotHeld bool
+}
+
+func startLocalSandbox(ctx context.Context) (*localSandbox, error) {
+	if err := acquireVMSlot(ctx); err != nil {
+		return nil, fmt.Errorf("waiting for sandbox slot: %w", err)
+	}
+	root, err := os.MkdirTemp(execRoot, "local-")
+	if err != nil {
+		releaseVMSlot()
+		return nil, err
+	}
+	return &localSandbox{root: root, slotHeld: true}, nil
+}
+
+// syncWorkspace copies dir into a fresh directory under the sandbox root.
+func (l *localSandbox) syncWorkspace(ctx context.Context, dir string) (string, error) {
+	dest := filepath.Join(l.root, fmt.Sprintf("code-%d", l.seq.Add(1)))
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFileMode(path, target, info.Mode().Perm())
+	})
+	if err != nil {
+		_ = os.RemoveAll(dest)
+		return "", fmt.Errorf("copy workspace to %s: %w", dest, err)
+	}
+	return dest, nil
+}
+
+func (l *localSandbox) pullFiles(ctx context.Context, remoteDir string, names []string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, name := range names {
+		path := filepath.Join(remoteDir, name)
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Size() > maxPulledFileBytes {
+			return nil, fmt.Errorf("%s is larger than %d bytes", name, maxPulledFileBytes)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+func copyFileMode(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// shellCommand runs the script in its own process group so that a timeout
+// kills everything it started, not just the shell.
+func (l *localSandbox) shellCommand(ctx context.Context, script string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "bash", "-c", script)
+	cmd.Dir = l.root
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+func (l *localSandbox) runCommand(ctx context.Context, workdir, script string, stdin *strings.Reader) (string, string, int, error) {
+	return runSandboxCommand(l.shellCommand(ctx, fmt.Sprintf("cd %s && %s", shellQuote(workdir), script)), stdin)
+}
+
+func (l *localSandbox) startInteractive(ctx context.Context, workdir, script string) (*exec.Cmd, io.WriteCloser, io.ReadCloser, io.ReadCloser, error) {
+	return startSandboxCommand(l.shellCommand(ctx, fmt.Sprintf("cd %s && %s", shellQuote(workdir), script)))
+}
+
+func (l *localSandbox) Close() {
+	if l.root != "" {
+		_ = os.RemoveAll(l.root)
+	}
+	if l.slotHeld {
+		releaseVMSlot()
+		l.slotHeld = false
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sandboxBackendsUnderTest lists the backends the sandbox tests run against:
+// always the local one, plus any named in SANDBOX_TEST_BACKENDS (e.g.
+// "docker,qemu") on machines that have them.
+func sandboxBackendsUnderTest() []string {
+	backends := []string{sandboxLocal}
+	for _, raw := range strings.Split(os.Getenv("SANDBOX_TEST_BACKENDS"), ",") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		if b := normalizeSandboxBackend(raw); b != sandboxLocal {
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+func forEachSandboxBackend(t *testing.T, fn func(t *testing.T)) {
+	for _, backend := range sandboxBackendsUnderTest() {
+		t.Run(backend, func(t *testing.T) {
+			origBackend, origRoot := sandboxBackend, execRoot
+			sandboxBackend, execRoot = backend, t.TempDir()
+			defer func() { sandboxBackend, execRoot = origBackend, origRoot }()
+			fn(t)
+		})
+	}
+}
+
+func TestNormalizeSandboxBackend(t *testing.T) {
+	for raw, want := range map[string]string{"": "qemu", "KVM": "qemu", "podman": "docker", "unsafe": "local", "firecracker": "firecracker"} {
+		if got := normalizeSandboxBackend(raw); got != want {
+			t.Errorf("normalizeSandboxBackend(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestSandboxWorkspaceAndCommands(t *testing.T) {
+	forEachSandboxBackend(t, func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"data.txt": "hello", "pkg/inner.txt": "inner"})
+		if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\necho ran\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		sb, remoteDir, err := startSandboxWithWorkspace(ctx, dir)
+		if err != nil {
+			t.Fatalf("start sandbox: %v", err)
+		}
+		defer sb.Close()
+
+		out, _, code, err := sb.runCommand(ctx, remoteDir, "cat data.txt pkg/inner.txt && ./run.sh && cat", strings.NewReader("-stdin"))
+		if err != nil || code != 0 {
+			t.Fatalf("runCommand: code=%d err=%v", code, err)
+		}
+		if out != "helloinnerran\n-stdin" {
+			t.Fatalf("unexpected output %q", out)
+		}
+		_, errOut, code, _ := sb.runCommand(ctx, remoteDir, "echo oops >&2; exit 3", nil)
+		if code != 3 || strings.TrimSpace(errOut) != "oops" {
+			t.Fatalf("exit code %d stderr %q", code, errOut)
+		}
+
+		cmd, stdin, stdout, _, err := sb.startInteractive(ctx, remoteDir, "read name; echo \"hi $name\"")
+		if err != nil {
+			t.Fatalf("startInteractive: %v", err)
+		}
+		if _, err := stdin.Write([]byte("bob\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if err != nil || line != "hi bob\n" {
+			t.Fatalf("interactive output %q: %v", line, err)
+		}
+		_ = stdin.Close()
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("interactive exit: %v", err)
+		}
+	})
+}
+
+// TestSandboxGrading runs real Python submissions through the grading path
+// on every backend under test.
+func TestSandboxGrading(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"main.py":  "n = int(input('n? '))\nprint(n * 2)\n",
+			"sleep.py": "import time\ntime.sleep(30)\n",
+		})
+		out, errOut, code, timedOut, _, _ := executePythonDir(nil, dir, "main.py", "21\n", 20*time.Second, 0)
+		if code != 0 || timedOut || out != "42" {
+			t.Fatalf("stdout=%q stderr=%q code=%d timedOut=%v", out, errOut, code, timedOut)
+		}
+
+		start := time.Now()
+		_, _, _, timedOut, _, _ = executePythonDir(nil, dir, "sleep.py", "", time.Second, 0)
+		if !timedOut {
+			t.Fatalf("expected a timeout")
+		}
+		if elapsed := time.Since(start); elapsed > 15*time.Second {
+			t.Fatalf("timed out run took %v", elapsed)
+		}
+
+		sess, err := startVMSession(dir, time.Minute)
+		if err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+		defer sess.Close()
+		// each test starts from a pristine copy of the workspace
+		for i := 0; i < 2; i++ {
+			work := t.TempDir()
+			writeFiles(t, work, map[string]string{
+				"main.py":  "n = int(input('n? '))\nprint(n * 2)\n",
+				"sleep.py": "import time\ntime.sleep(30)\n",
+				"check.py": "import os\nprint('dirty' if os.path.exists('marker') else 'fresh')\nopen('marker', 'w').close()\n",
+			})
+			out, errOut, code, _, _, _ := executePythonDir(sess, work, "check.py", "", 20*time.Second, 0)
+			if code != 0 || out != "fresh" {
+				t.Fatalf("session run %d: stdout=%q stderr=%q code=%d", i, out, errOut, code)
+			}
+		}
+	})
+}
@@ -60,7 +60,7 @@ CREATE TABLE IF NOT EXISTS assignments (
   published BOOLEAN NOT NULL DEFAULT FALSE,
   show_traceback BOOLEAN NOT NULL DEFAULT FALSE,
   show_test_details BOOLEAN NOT NULL DEFAULT FALSE,
-  programming_language TEXT NOT NULL DEFAULT 'python' CHECK (programming_language IN ('python','scratch')),
+  programming_language TEXT NOT NULL DEFAULT 'python' CHECK (programming_language IN ('python','scratch','c','cpp','java','javascript','sql','jupyter')),
   manual_review BOOLEAN NOT NULL DEFAULT FALSE,
   scratch_evaluation_mode TEXT NOT NULL DEFAULT 'manual' CHECK (scratch_evaluation_mode IN ('manual','semi_automatic','automatic')),
   banned_functions TEXT[] NOT NULL DEFAULT '{}',
@@ -75,7 +75,15 @@ CREATE TABLE IF NOT EXISTS assignments (
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS template_path TEXT;
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS show_traceback BOOLEAN NOT NULL DEFAULT FALSE;
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS show_test_details BOOLEAN NOT NULL DEFAULT FALSE;
-ALTER TABLE assignments ADD COLUMN IF NOT EXISTS programming_language TEXT NOT NULL DEFAULT 'python' CHECK (programming_language IN ('python','scratch'));
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS programming_language TEXT NOT NULL DEFAULT 'python' CHECK (programming_language IN ('python','scratch','c','cpp','java','javascript','sql','jupyter'));
+-- widen the language check for databases created before C/C++ support
+ALTER TABLE assignments DROP CONSTRAINT IF EXISTS assignments_programming_language_check;
+ALTER TABLE assignments ADD CONSTRAINT assignments_programming_language_check CHECK (programming_language IN ('python','scratch','c','cpp','java','javascript','sql','jupyter'));
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS compile_flags TEXT; -- extra gcc/g++ flags for C/C++ assignments
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS sql_schema TEXT; -- SQLite schema for SQL assignments
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS sql_seed TEXT; -- SQLite seed data for SQL assignments
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS construct_rules TEXT; -- JSON list of required/forbidden construct rules
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS quality_config TEXT; -- JSON static code-quality analysis settings
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS manual_review BOOLEAN NOT NULL DEFAULT FALSE;
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS scratch_evaluation_mode TEXT NOT NULL DEFAULT 'manual';
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS banned_functions TEXT[] NOT NULL DEFAULT '{}';
@@ -99,6 +107,8 @@ ALTER TABLE assignments ADD COLUMN IF NOT EXISTS second_deadline TIMESTAMPTZ; --
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS late_penalty_ratio NUMERIC NOT NULL DEFAULT 0.5 CHECK (late_penalty_ratio >= 0 AND late_penalty_ratio <= 1); -- points multiplier for second deadline submissions
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS max_attempts INTEGER; -- NULL or 0 means unlimited
 ALTER TABLE assignments ADD COLUMN IF NOT EXISTS scratch_semantic_criteria TEXT;
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS reference_solution TEXT; -- base64 zip, same format as submissions.code_content
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS reference_solution_updated_at TIMESTAMPTZ;
 
 -- Track cloned assignments (e.g., Teachers' group versions)
 CREATE TABLE IF NOT EXISTS assignment_clones (
@@ -158,6 +168,35 @@ ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS expected_return TEXT;
 ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS file_name TEXT;
 ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS file_base64 TEXT;
 ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS files_json TEXT;
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS comparator TEXT NOT NULL DEFAULT 'exact';
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS comparator_options TEXT; -- JSON: abs_tol, rel_tol, ignore_case
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS checker_code TEXT;
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS dialogue_script TEXT; -- JSON list of expect/send steps
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS is_sample BOOLEAN NOT NULL DEFAULT FALSE; -- public tests students can run before submitting
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS sql_query TEXT; -- reference query of sql_query tests
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS sql_file TEXT; -- student query file (NULL = main file)
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS sql_ordered BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS sql_column_match TEXT NOT NULL DEFAULT 'exact'; -- exact, case_insensitive, ignore
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS notebook_target TEXT; -- variable name or cell tag of notebook tests
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS performance_config TEXT; -- JSON: factor, min_limit_ms, repeats, sizes, generator
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS property_config TEXT; -- JSON: generator, runs, seed, vary_seed, max_shrinks
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS output_files TEXT; -- JSON: files the program must write, each with a comparator
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS turtle_config TEXT; -- JSON: tolerance, ignore_order, ignore_colors, ignore_fills
+
+-- Named groups of tests (subtasks) scored as a unit
+CREATE TABLE IF NOT EXISTS test_groups (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  assignment_id UUID NOT NULL REFERENCES assignments(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  points DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (points >= 0),
+  policy TEXT NOT NULL DEFAULT 'all' CHECK (policy IN ('all','min','proportional')),
+  depends_on UUID[] NOT NULL DEFAULT '{}',   -- groups that must fully pass first
+  position INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (assignment_id, name)
+);
+ALTER TABLE test_cases ADD COLUMN IF NOT EXISTS group_id UUID REFERENCES test_groups(id) ON DELETE SET NULL;
 
 DO $$ BEGIN
     CREATE TYPE submission_status AS ENUM ('pending','running','completed','failed');
@@ -188,6 +227,9 @@ ALTER TABLE submissions ADD COLUMN IF NOT EXISTS manually_accepted BOOLEAN NOT N
 ALTER TABLE submissions ADD COLUMN IF NOT EXISTS all_tests_failure_explanation TEXT;
 ALTER TABLE submissions ADD COLUMN IF NOT EXISTS scratch_analysis TEXT;
 ALTER TABLE submissions ADD COLUMN IF NOT EXISTS scratch_semantic_analysis TEXT;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS executed_notebook TEXT; -- JSON list of executed notebook cells
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS construct_findings TEXT; -- JSON list of violated construct rules
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS quality_report TEXT; -- JSON code-quality score and findings
 
 DO $$ BEGIN
     CREATE TYPE result_status AS ENUM ('passed','time_limit_exceeded','memory_limit_exceeded','wrong_output','runtime_error');
@@ -196,6 +238,11 @@ EXCEPTION
 END $$;
 
 ALTER TYPE result_status ADD VALUE IF NOT EXISTS 'illegal_tool_use';
+ALTER TYPE result_status ADD VALUE IF NOT EXISTS 'partially_passed';
+ALTER TYPE result_status ADD VALUE IF NOT EXISTS 'checker_error';
+ALTER TYPE result_status ADD VALUE IF NOT EXISTS 'skipped';
+ALTER TYPE result_status ADD VALUE IF NOT EXISTS 'compile_error';
+ALTER TYPE result_status ADD VALUE IF NOT EXISTS 'construct_violation';
 
 CREATE TABLE IF NOT EXISTS results (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -213,6 +260,103 @@ ALTER TABLE results ADD COLUMN IF NOT EXISTS stderr TEXT;
 ALTER TABLE results ADD COLUMN IF NOT EXISTS exit_code INTEGER;
 ALTER TABLE results ADD COLUMN IF NOT EXISTS actual_return TEXT;
 ALTER TABLE results ADD COLUMN IF NOT EXISTS failure_explanation TEXT;
+ALTER TABLE results ADD COLUMN IF NOT EXISTS peak_memory_kb INTEGER;
+ALTER TABLE results ADD COLUMN IF NOT EXISTS score DOUBLE PRECISION; -- fraction of the test weight earned (checker tests)
+ALTER TABLE results ADD COLUMN IF NOT EXISTS checker_message TEXT;
+ALTER TABLE results ADD COLUMN IF NOT EXISTS file_diffs TEXT; -- JSON per expected output file: comparator, passed, message, diff
+ALTER TABLE results ADD COLUMN IF NOT EXISTS artifacts TEXT; -- JSON: files made while grading (rendered drawings), base64
+
+-- Durable grading queue; workers lease rows with FOR UPDATE SKIP LOCKED
+DO $$ BEGIN
+    CREATE TYPE grading_job_status AS ENUM ('queued','leased','done','failed');
+EXCEPTION
+    WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE TABLE IF NOT EXISTS grading_jobs (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  submission_id UUID NOT NULL REFERENCES submissions(id) ON DELETE CASCADE,
+  status grading_job_status NOT NULL DEFAULT 'queued',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  max_attempts INTEGER NOT NULL DEFAULT 3,
+  run_after TIMESTAMPTZ NOT NULL DEFAULT now(),
+  leased_by TEXT,
+  lease_expires_at TIMESTAMPTZ,
+  last_error TEXT,
+  finished_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_grading_jobs_queued_submission ON grading_jobs(submission_id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_grading_jobs_runnable ON grading_jobs(status, run_after);
+CREATE INDEX IF NOT EXISTS idx_grading_jobs_submission ON grading_jobs(submission_id);
+
+-- Sample test runs by students; only used for the hourly quota
+CREATE TABLE IF NOT EXISTS sample_runs (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  assignment_id UUID NOT NULL REFERENCES assignments(id) ON DELETE CASCADE,
+  student_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_sample_runs_student ON sample_runs(assignment_id, student_id, created_at);
+
+-- Bulk regrades of an assignment and the per-submission score audit
+CREATE TABLE IF NOT EXISTS regrade_runs (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  assignment_id UUID NOT NULL REFERENCES assignments(id) ON DELETE CASCADE,
+  requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  scope TEXT NOT NULL,                       -- all | latest | failed
+  skip_manually_accepted BOOLEAN NOT NULL,
+  keep_override_points BOOLEAN NOT NULL,
+  total INTEGER NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'running',    -- running | completed
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_regrade_runs_assignment ON regrade_runs(assignment_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS regrade_score_changes (
+  regrade_id UUID NOT NULL REFERENCES regrade_runs(id) ON DELETE CASCADE,
+  submission_id UUID NOT NULL REFERENCES submissions(id) ON DELETE CASCADE,
+  student_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  old_status TEXT NOT NULL,
+  old_points NUMERIC,
+  old_override_points NUMERIC,
+  old_manually_accepted BOOLEAN NOT NULL DEFAULT FALSE,
+  new_status TEXT,
+  new_points NUMERIC,
+  new_override_points NUMERIC,
+  finished_at TIMESTAMPTZ,
+  PRIMARY KEY (regrade_id, submission_id)
+);
+
+ALTER TABLE grading_jobs ADD COLUMN IF NOT EXISTS regrade_id UUID REFERENCES regrade_runs(id) ON DELETE SET NULL;
+
+-- Source similarity analyses of an assignment and the ranked submission pairs
+CREATE TABLE IF NOT EXISTS similarity_runs (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  assignment_id UUID NOT NULL REFERENCES assignments(id) ON DELETE CASCADE,
+  requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  status TEXT NOT NULL DEFAULT 'running',    -- running | completed | failed
+  error TEXT,
+  submissions INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_similarity_runs_assignment ON similarity_runs(assignment_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS similarity_pairs (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  run_id UUID NOT NULL REFERENCES similarity_runs(id) ON DELETE CASCADE,
+  submission_a UUID NOT NULL REFERENCES submissions(id) ON DELETE CASCADE,
+  submission_b UUID NOT NULL REFERENCES submissions(id) ON DELETE CASCADE,
+  student_a UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  student_b UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  score DOUBLE PRECISION NOT NULL,
+  matched_tokens INTEGER NOT NULL,
+  regions TEXT NOT NULL                      -- JSON list of matching regions
+);
+CREATE INDEX IF NOT EXISTS idx_similarity_pairs_run ON similarity_pairs(run_id, score DESC);
 
 -- LLM run artifacts per submission attempt
 CREATE TABLE IF NOT EXISTS llm_runs (
@@ -100,7 +100,11 @@ func loadScratchProject(sb3Path string) (*ScratchProject, error) {
 		return nil, err
 	}
 	defer zipReader.Close()
+	return scratchProjectFromZip(&zipReader.Reader, sb3Path)
+}
 
+// scratchProjectFromZip reads project.json from an opened .sb3 archive.
+func scratchProjectFromZip(zipReader *zip.Reader, sb3Path string) (*ScratchProject, error) {
 	for _, file := range zipReader.File {
 		if filepath.Base(file.Name) != "project.json" {
 			continue
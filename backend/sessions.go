@@ -933,10 +933,5 @@ func sortedKeys(m map[string]string) []string {
 	return keys
 }
 
-// ensure reference to docker flags from worker.go to prevent dead code elimination if unused here
-func _refDockerFlags() string {
-	return fmt.Sprintf("%s-%s-%s-%s-%d", pythonImage, dockerUser, dockerCPUs, dockerMemory, dockerExtraTime/time.Second)
-}
-
 // prevent unused import warnings when tests are excluded
 var _ = []any{runtime.NumCPU}
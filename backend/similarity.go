@@ -0,0 +1,493 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Similarity analysis compares the latest submissions of an assignment using
+// winnowing (Schleimer, Wilkerson, Aiken: "Winnowing: Local Algorithms for
+// Document Fingerprinting"). Sources are reduced to normalized tokens, where
+// every identifier that is not a keyword or builtin becomes "V", so renaming
+// variables does not change the token stream. Hashes of k consecutive tokens
+// are winnowed into fingerprints and pairs are scored on the fingerprints
+// they share. Fingerprints are compared as sets, so reordering functions
+// hides nothing either.
+
+const (
+	// similarityK is the k-gram length in tokens; shorter shared runs are
+	// ignored as noise.
+	similarityK = 10
+	// similarityWindow is the winnowing window: any run of
+	// similarityK+similarityWindow-1 shared tokens is guaranteed to match.
+	similarityWindow = 6
+	// pairs scoring below similarityMinScore are not reported
+	similarityMinScore = 0.1
+	similarityMaxPairs = 500
+)
+
+type simToken struct {
+	Text string
+	File string
+	Line int
+}
+
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"async": true, "await": true, "break": true, "class": true, "continue": true, "def": true,
+	"del": true, "elif": true, "else": true, "except": true, "finally": true, "for": true,
+	"from": true, "global": true, "if": true, "import": true, "in": true, "is": true,
+	"lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true, "raise": true,
+	"return": true, "try": true, "while": true, "with": true, "yield": true,
+	"match": true, "case": true, "self": true,
+}
+
+// pythonBuiltins are kept verbatim: renaming them changes what the program
+// does, so they carry structure rather than naming choices.
+var pythonBuiltins = map[string]bool{
+	"print": true, "input": true, "range": true, "len": true, "int": true, "float": true,
+	"str": true, "bool": true, "list": true, "dict": true, "set": true, "tuple": true,
+	"sorted": true, "reversed": true, "sum": true, "min": true, "max": true, "abs": true,
+	"enumerate": true, "zip": true, "map": true, "filter": true, "open": true, "round": true,
+	"isinstance": true, "any": true, "all": true, "ord": true, "chr": true, "super": true,
+}
+
+var pythonOperators = []string{
+	"**=", "//=", ">>=", "<<=", "...",
+	"->", ":=", "==", "!=", "<=", ">=", "**", "//", "<<", ">>",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "@=",
+}
+
+// tokenizePython returns the normalized tokens of a Python source. Comments
+// and layout are dropped, literals collapse to "N" and "S".
+func tokenizePython(file, src string) []simToken {
+	var toks []simToken
+	line := 1
+	emit := func(text string) {
+		toks = append(toks, simToken{Text: text, File: file, Line: line})
+	}
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\\':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			emit("S")
+			i, line = skipPythonString(src, i, line)
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			emit("N")
+			i = skipPythonNumber(src, i)
+		default:
+			r, size := utf8.DecodeRuneInString(src[i:])
+			if r == '_' || unicode.IsLetter(r) {
+				j := i + size
+				for j < len(src) {
+					r, size := utf8.DecodeRuneInString(src[j:])
+					if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+						break
+					}
+					j += size
+				}
+				word := src[i:j]
+				if j < len(src) && (src[j] == '"' || src[j] == '\'') && len(word) <= 2 && strings.Trim(strings.ToLower(word), "rbuf") == "" {
+					emit("S")
+					i, line = skipPythonString(src, j, line)
+					continue
+				}
+				switch {
+				case pythonKeywords[word] || pythonBuiltins[word]:
+					emit(word)
+				case len(toks) > 0 && toks[len(toks)-1].Text == ".":
+					// attribute and method names usually come from libraries
+					emit(word)
+				default:
+					emit("V")
+				}
+				i = j
+				continue
+			}
+			if unicode.IsSpace(r) {
+				i += size
+				continue
+			}
+			op := string(r)
+			for _, candidate := range pythonOperators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			emit(op)
+			i += len(op)
+		}
+	}
+	return toks
+}
+
+func skipPythonString(src string, i, line int) (int, int) {
+	q := src[i]
+	triple := strings.HasPrefix(src[i:], strings.Repeat(string(q), 3))
+	if triple {
+		i += 3
+	} else {
+		i++
+	}
+	for i < len(src) {
+		switch c := src[i]; {
+		case c == '\\':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				line++
+			}
+			i += 2
+			continue
+		case c == '\n':
+			line++
+			if !triple {
+				return i, line - 1
+			}
+		case c == q:
+			if !triple {
+				return i + 1, line
+			}
+			if strings.HasPrefix(src[i:], strings.Repeat(string(q), 3)) {
+				return i + 3, line
+			}
+		}
+		i++
+	}
+	return i, line
+}
+
+func skipPythonNumber(src string, i int) int {
+	start := i
+	for i < len(src) {
+		c := src[i]
+		if c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			i++
+			continue
+		}
+		if (c == '+' || c == '-') && (src[i-1] == 'e' || src[i-1] == 'E') && !strings.HasPrefix(strings.ToLower(src[start:]), "0x") {
+			i++
+			continue
+		}
+		break
+	}
+	return i
+}
+
+// tokenizeScratch tokenizes the pseudo-code from SerializeScratchProject.
+// Block text and structure are kept; literals collapse like in Python.
+func tokenizeScratch(file, src string) []simToken {
+	var toks []simToken
+	for n, text := range strings.Split(src, "\n") {
+		line := n + 1
+		i := 0
+		for i < len(text) {
+			r, size := utf8.DecodeRuneInString(text[i:])
+			switch {
+			case unicode.IsSpace(r):
+				i += size
+			case r == '"':
+				j := strings.IndexByte(text[i+1:], '"')
+				if j == -1 {
+					i = len(text)
+				} else {
+					i += j + 2
+				}
+				toks = append(toks, simToken{Text: "S", File: file, Line: line})
+			case unicode.IsDigit(r):
+				for i < len(text) && (text[i] >= '0' && text[i] <= '9' || text[i] == '.') {
+					i++
+				}
+				toks = append(toks, simToken{Text: "N", File: file, Line: line})
+			case r == '_' || unicode.IsLetter(r):
+				j := i
+				for j < len(text) {
+					r, size := utf8.DecodeRuneInString(text[j:])
+					if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+						break
+					}
+					j += size
+				}
+				toks = append(toks, simToken{Text: strings.ToLower(text[i:j]), File: file, Line: line})
+				i = j
+			default:
+				toks = append(toks, simToken{Text: string(r), File: file, Line: line})
+				i += size
+			}
+		}
+	}
+	return toks
+}
+
+// similarityDoc is one submission (or baseline) reduced to fingerprints.
+type similarityDoc struct {
+	tokens []simToken
+	// prints maps each selected k-gram hash to the index of the first token
+	// of its first occurrence
+	prints map[uint64]int
+}
+
+func newSimilarityDoc(sources map[string]string, scratch bool) *similarityDoc {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	doc := &similarityDoc{prints: map[uint64]int{}}
+	for _, name := range names {
+		if scratch {
+			doc.tokens = append(doc.tokens, tokenizeScratch(name, sources[name])...)
+		} else {
+			doc.tokens = append(doc.tokens, tokenizePython(name, sources[name])...)
+		}
+	}
+	for _, fp := range winnow(doc.tokens, similarityK, similarityWindow) {
+		if _, ok := doc.prints[fp.hash]; !ok {
+			doc.prints[fp.hash] = fp.pos
+		}
+	}
+	return doc
+}
+
+type fingerprint struct {
+	hash uint64
+	pos  int
+}
+
+// winnow hashes every k-gram of tokens and keeps the minimum hash of each
+// window of w consecutive hashes (the rightmost one on ties), recording a
+// position only once.
+func winnow(tokens []simToken, k, w int) []fingerprint {
+	if len(tokens) < k {
+		return nil
+	}
+	hashes := make([]uint64, len(tokens)-k+1)
+	for i := range hashes {
+		h := fnv.New64a()
+		for _, t := range tokens[i : i+k] {
+			_, _ = io.WriteString(h, t.Text)
+			_, _ = h.Write([]byte{0})
+		}
+		hashes[i] = h.Sum64()
+	}
+	if len(hashes) < w {
+		w = len(hashes)
+	}
+	var out []fingerprint
+	last := -1
+	for start := 0; start+w <= len(hashes); start++ {
+		minPos := start
+		for i := start + 1; i < start+w; i++ {
+			if hashes[i] <= hashes[minPos] {
+				minPos = i
+			}
+		}
+		if minPos != last {
+			out = append(out, fingerprint{hash: hashes[minPos], pos: minPos})
+			last = minPos
+		}
+	}
+	return out
+}
+
+// SimilarityRegion is a stretch of code that matches between two
+// submissions, as line ranges on both sides.
+type SimilarityRegion struct {
+	FileA      string `json:"file_a"`
+	StartLineA int    `json:"start_line_a"`
+	EndLineA   int    `json:"end_line_a"`
+	FileB      string `json:"file_b"`
+	StartLineB int    `json:"start_line_b"`
+	EndLineB   int    `json:"end_line_b"`
+	Tokens     int    `json:"tokens"`
+}
+
+type similarityMatch struct {
+	Score   float64
+	Matched int
+	Regions []SimilarityRegion
+}
+
+// compareSimilarityDocs scores two documents by the share of fingerprints
+// they have in common, relative to the smaller one, and aligns the shared
+// fingerprints into regions. Fingerprints in baseline are ignored.
+func compareSimilarityDocs(a, b *similarityDoc, baseline map[uint64]struct{}) similarityMatch {
+	usable := func(d *similarityDoc) int {
+		n := 0
+		for h := range d.prints {
+			if _, ok := baseline[h]; !ok {
+				n++
+			}
+		}
+		return n
+	}
+	var shared [][2]int
+	for h, posA := range a.prints {
+		if _, ok := baseline[h]; ok {
+			continue
+		}
+		if posB, ok := b.prints[h]; ok {
+			shared = append(shared, [2]int{posA, posB})
+		}
+	}
+	denom := min(usable(a), usable(b))
+	if len(shared) == 0 || denom == 0 {
+		return similarityMatch{}
+	}
+	sort.Slice(shared, func(i, j int) bool { return shared[i][0] < shared[j][0] })
+
+	// Consecutive shared k-grams that advance together on both sides and
+	// lie within one file form a region.
+	gap := similarityK + similarityWindow
+	var match similarityMatch
+	match.Score = float64(len(shared)) / float64(denom)
+	flush := func(startA, endA, startB, endB int) {
+		endA = clampTokenEnd(a.tokens, startA, endA+similarityK-1)
+		endB = clampTokenEnd(b.tokens, startB, endB+similarityK-1)
+		r := SimilarityRegion{
+			FileA: a.tokens[startA].File, StartLineA: a.tokens[startA].Line, EndLineA: a.tokens[endA].Line,
+			FileB: b.tokens[startB].File, StartLineB: b.tokens[startB].Line, EndLineB: b.tokens[endB].Line,
+			Tokens: min(endA-startA, endB-startB) + 1,
+		}
+		match.Matched += r.Tokens
+		match.Regions = append(match.Regions, r)
+	}
+	startA, startB := shared[0][0], shared[0][1]
+	lastA, lastB := startA, startB
+	for _, p := range shared[1:] {
+		pa, pb := p[0], p[1]
+		contiguous := pa > lastA && pb > lastB && pa-lastA <= gap && pb-lastB <= gap &&
+			a.tokens[pa].File == a.tokens[startA].File && b.tokens[pb].File == b.tokens[startB].File
+		if !contiguous {
+			flush(startA, lastA, startB, lastB)
+			startA, startB = pa, pb
+		}
+		lastA, lastB = pa, pb
+	}
+	flush(startA, lastA, startB, lastB)
+	return match
+}
+
+// clampTokenEnd keeps a region end within the file its start token is in.
+func clampTokenEnd(tokens []simToken, start, end int) int {
+	end = min(end, len(tokens)-1)
+	for end > start && tokens[end].File != tokens[start].File {
+		end--
+	}
+	return end
+}
+
+type similarityInput struct {
+	SubmissionID uuid.UUID
+	StudentID    uuid.UUID
+	Sources      map[string]string
+}
+
+type similarityPairResult struct {
+	A, B  similarityInput
+	Match similarityMatch
+}
+
+// analyzeSimilarity compares every pair of inputs and returns those above
+// similarityMinScore, most similar first. Code also found in one of the
+// baseline sources (template, teacher runs) does not count as a match.
+func analyzeSimilarity(inputs []similarityInput, baseline []map[string]string, scratch bool) []similarityPairResult {
+	common := map[uint64]struct{}{}
+	for _, sources := range baseline {
+		for h := range newSimilarityDoc(sources, scratch).prints {
+			common[h] = struct{}{}
+		}
+	}
+	docs := make([]*similarityDoc, len(inputs))
+	for i, in := range inputs {
+		docs[i] = newSimilarityDoc(in.Sources, scratch)
+	}
+	var pairs []similarityPairResult
+	for i := range inputs {
+		for j := i + 1; j < len(inputs); j++ {
+			m := compareSimilarityDocs(docs[i], docs[j], common)
+			if m.Score < similarityMinScore {
+				continue
+			}
+			pairs = append(pairs, similarityPairResult{A: inputs[i], B: inputs[j], Match: m})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Match.Score != pairs[j].Match.Score {
+			return pairs[i].Match.Score > pairs[j].Match.Score
+		}
+		return pairs[i].Match.Matched > pairs[j].Match.Matched
+	})
+	if len(pairs) > similarityMaxPairs {
+		pairs = pairs[:similarityMaxPairs]
+	}
+	return pairs
+}
+
+// similaritySources extracts the comparable sources from an uploaded file:
+// Python files as they are and Scratch projects as serialized blocks. Zip
+// archives (the stored submissions) are unpacked one level deep.
+func similaritySources(name string, data []byte, scratch bool, out map[string]string) error {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case ext == ".zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return fmt.Errorf("open %s: %w", name, err)
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || strings.HasPrefix(path.Base(f.Name), ".") || strings.Contains(f.Name, "__pycache__") {
+				continue
+			}
+			fext := strings.ToLower(path.Ext(f.Name))
+			if (scratch && fext != ".sb3") || (!scratch && fext != ".py") {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			content, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := similaritySources(f.Name, content, scratch, out); err != nil {
+				return err
+			}
+		}
+	case scratch && ext == ".sb3":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return fmt.Errorf("open %s: %w", name, err)
+		}
+		project, err := scratchProjectFromZip(zr, name)
+		if err != nil {
+			return err
+		}
+		pseudo, _ := SerializeScratchProject(project, ScratchSerializerOptions{MaxChars: 1 << 20, MaxScriptsPerTarget: 1000})
+		out[name] = pseudo
+	case !scratch && ext == ".py":
+		out[name] = string(data)
+	}
+	return nil
+}
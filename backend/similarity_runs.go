@@ -0,0 +1,364 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// A similarity run compares the latest submission of every student of an
+// assignment (see similarity.go) in the background and stores the ranked
+// pairs. Teacher runs and the assignment template are the common baseline:
+// they are not compared themselves and code they contain is not a match.
+
+type SimilarityRun struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	AssignmentID uuid.UUID  `db:"assignment_id" json:"assignment_id"`
+	RequestedBy  *uuid.UUID `db:"requested_by" json:"requested_by,omitempty"`
+	Status       string     `db:"status" json:"status"`
+	Error        *string    `db:"error" json:"error,omitempty"`
+	Submissions  int        `db:"submissions" json:"submissions"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	FinishedAt   *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+type SimilarityPair struct {
+	ID            uuid.UUID          `db:"id" json:"id"`
+	SubmissionA   uuid.UUID          `db:"submission_a" json:"submission_a"`
+	SubmissionB   uuid.UUID          `db:"submission_b" json:"submission_b"`
+	StudentA      uuid.UUID          `db:"student_a" json:"student_a"`
+	StudentB      uuid.UUID          `db:"student_b" json:"student_b"`
+	StudentAName  *string            `db:"student_a_name" json:"student_a_name,omitempty"`
+	StudentBName  *string            `db:"student_b_name" json:"student_b_name,omitempty"`
+	Score         float64            `db:"score" json:"score"`
+	MatchedTokens int                `db:"matched_tokens" json:"matched_tokens"`
+	RegionsJSON   string             `db:"regions" json:"-"`
+	Regions       []SimilarityRegion `db:"-" json:"regions"`
+}
+
+type similaritySubmission struct {
+	ID          uuid.UUID `db:"id"`
+	StudentID   uuid.UUID `db:"student_id"`
+	CodeContent string    `db:"code_content"`
+}
+
+func similaritySupported(a *Assignment) bool {
+	return a.ProgrammingLanguage == "python" || a.ProgrammingLanguage == "scratch"
+}
+
+// StartSimilarityRun records a run and starts the analysis in the background.
+func StartSimilarityRun(a *Assignment, requestedBy *uuid.UUID) (*SimilarityRun, error) {
+	var run SimilarityRun
+	err := DB.Get(&run, `
+        INSERT INTO similarity_runs (assignment_id, requested_by)
+        VALUES ($1,$2)
+        RETURNING id, assignment_id, requested_by, status, error, submissions, created_at, finished_at`,
+		a.ID, requestedBy)
+	if err != nil {
+		return nil, err
+	}
+	go runSimilarityAnalysis(run.ID, a)
+	return &run, nil
+}
+
+func runSimilarityAnalysis(runID uuid.UUID, a *Assignment) {
+	n, err := computeSimilarityRun(runID, a)
+	if err != nil {
+		fmt.Printf("[similarity] run %s for assignment %s failed: %v\n", runID, a.ID, err)
+		msg := err.Error()
+		_, _ = DB.Exec(`UPDATE similarity_runs SET status='failed', error=$2, finished_at=now() WHERE id=$1`, runID, msg)
+		return
+	}
+	_, _ = DB.Exec(`UPDATE similarity_runs SET status='completed', submissions=$2, finished_at=now() WHERE id=$1`, runID, n)
+}
+
+func computeSimilarityRun(runID uuid.UUID, a *Assignment) (int, error) {
+	scratch := a.ProgrammingLanguage == "scratch"
+	latest, err := listSimilaritySubmissions(a.ID, false)
+	if err != nil {
+		return 0, err
+	}
+	teacherRuns, err := listSimilaritySubmissions(a.ID, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var baseline []map[string]string
+	for _, sub := range teacherRuns {
+		if sources, err := submissionSimilaritySources(sub.CodeContent, scratch); err == nil {
+			baseline = append(baseline, sources)
+		}
+	}
+	if a.TemplatePath != nil {
+		if data, err := os.ReadFile(*a.TemplatePath); err != nil {
+			fmt.Printf("[similarity] template of assignment %s: %v\n", a.ID, err)
+		} else {
+			sources := map[string]string{}
+			if err := similaritySources(filepath.Base(*a.TemplatePath), data, scratch, sources); err == nil {
+				baseline = append(baseline, sources)
+			}
+		}
+	}
+
+	inputs := make([]similarityInput, 0, len(latest))
+	for _, sub := range latest {
+		sources, err := submissionSimilaritySources(sub.CodeContent, scratch)
+		if err != nil {
+			fmt.Printf("[similarity] skipping submission %s: %v\n", sub.ID, err)
+			continue
+		}
+		inputs = append(inputs, similarityInput{SubmissionID: sub.ID, StudentID: sub.StudentID, Sources: sources})
+	}
+
+	pairs := analyzeSimilarity(inputs, baseline, scratch)
+	tx, err := DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, p := range pairs {
+		regions, err := json.Marshal(p.Match.Regions)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`
+            INSERT INTO similarity_pairs (run_id, submission_a, submission_b, student_a, student_b, score, matched_tokens, regions)
+            VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+			runID, p.A.SubmissionID, p.B.SubmissionID, p.A.StudentID, p.B.StudentID, p.Match.Score, p.Match.Matched, string(regions)); err != nil {
+			return 0, err
+		}
+	}
+	return len(inputs), tx.Commit()
+}
+
+// listSimilaritySubmissions returns the latest submission of every student,
+// or all teacher runs when teacherRuns is set.
+func listSimilaritySubmissions(aid uuid.UUID, teacherRuns bool) ([]similaritySubmission, error) {
+	list := []similaritySubmission{}
+	if teacherRuns {
+		err := DB.Select(&list, `
+            SELECT id, student_id, code_content
+              FROM submissions
+             WHERE assignment_id = $1 AND is_teacher_run = TRUE`, aid)
+		return list, err
+	}
+	err := DB.Select(&list, `
+        SELECT DISTINCT ON (student_id) id, student_id, code_content
+          FROM submissions
+         WHERE assignment_id = $1 AND is_teacher_run = FALSE
+         ORDER BY student_id, created_at DESC, id DESC`, aid)
+	return list, err
+}
+
+func submissionSimilaritySources(codeContent string, scratch bool) (map[string]string, error) {
+	data, err := base64.StdEncoding.DecodeString(codeContent)
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string]string{}
+	if err := similaritySources("submission.zip", data, scratch, sources); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// failInterruptedSimilarityRuns marks runs that were still running when the
+// server stopped; their goroutine is gone.
+func failInterruptedSimilarityRuns() {
+	if _, err := DB.Exec(`UPDATE similarity_runs SET status='failed', error='interrupted by server restart', finished_at=now() WHERE status='running'`); err != nil {
+		fmt.Printf("[similarity] could not clean up interrupted runs: %v\n", err)
+	}
+}
+
+func GetSimilarityRun(id uuid.UUID) (*SimilarityRun, error) {
+	var run SimilarityRun
+	err := DB.Get(&run, `
+        SELECT id, assignment_id, requested_by, status, error, submissions, created_at, finished_at
+          FROM similarity_runs
+         WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func ListSimilarityRuns(aid uuid.UUID) ([]SimilarityRun, error) {
+	list := []SimilarityRun{}
+	err := DB.Select(&list, `
+        SELECT id, assignment_id, requested_by, status, error, submissions, created_at, finished_at
+          FROM similarity_runs
+         WHERE assignment_id = $1
+         ORDER BY created_at DESC`, aid)
+	return list, err
+}
+
+const similarityPairColumns = `
+        SELECT p.id, p.submission_a, p.submission_b, p.student_a, p.student_b,
+               ua.name AS student_a_name, ub.name AS student_b_name,
+               p.score, p.matched_tokens, p.regions
+          FROM similarity_pairs p
+          LEFT JOIN users ua ON ua.id = p.student_a
+          LEFT JOIN users ub ON ub.id = p.student_b`
+
+// ListSimilarityPairs returns the pairs of a run, most similar first.
+func ListSimilarityPairs(runID uuid.UUID) ([]SimilarityPair, error) {
+	list := []SimilarityPair{}
+	if err := DB.Select(&list, similarityPairColumns+`
+         WHERE p.run_id = $1
+         ORDER BY p.score DESC, p.matched_tokens DESC`, runID); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		_ = json.Unmarshal([]byte(list[i].RegionsJSON), &list[i].Regions)
+	}
+	return list, nil
+}
+
+func GetSimilarityPair(runID, pairID uuid.UUID) (*SimilarityPair, error) {
+	var p SimilarityPair
+	if err := DB.Get(&p, similarityPairColumns+`
+         WHERE p.run_id = $1 AND p.id = $2`, runID, pairID); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(p.RegionsJSON), &p.Regions)
+	return &p, nil
+}
+
+// startSimilarity: POST /api/assignments/:id/similarity
+func startSimilarity(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	assignment, err := GetAssignment(aid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if !similaritySupported(assignment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "similarity analysis supports Python and Scratch assignments"})
+		return
+	}
+	uid := getUserID(c)
+	run, err := StartSimilarityRun(assignment, &uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusAccepted, run)
+}
+
+// listSimilarityRuns: GET /api/assignments/:id/similarity
+func listSimilarityRuns(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	list, err := ListSimilarityRuns(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func loadSimilarityRunForRequest(c *gin.Context) (*SimilarityRun, bool) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return nil, false
+	}
+	run, err := GetSimilarityRun(rid)
+	if err != nil || run.AssignmentID != aid {
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+			return nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return nil, false
+	}
+	return run, true
+}
+
+// getSimilarityRun: GET /api/assignments/:id/similarity/:rid
+// Returns the run with its ranked pairs and their matching regions.
+func getSimilarityRun(c *gin.Context) {
+	run, ok := loadSimilarityRunForRequest(c)
+	if !ok {
+		return
+	}
+	pairs, err := ListSimilarityPairs(run.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"run": run, "pairs": pairs})
+}
+
+// getSimilarityPair: GET /api/assignments/:id/similarity/:rid/pairs/:pid
+// Returns one pair together with the compared sources of both submissions,
+// so the regions can be shown side by side.
+func getSimilarityPair(c *gin.Context) {
+	run, ok := loadSimilarityRunForRequest(c)
+	if !ok {
+		return
+	}
+	pid, err := uuid.Parse(c.Param("pid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	pair, err := GetSimilarityPair(run.ID, pid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		}
+		return
+	}
+	assignment, err := GetAssignment(run.AssignmentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	scratch := assignment.ProgrammingLanguage == "scratch"
+	sources := make([]map[string]string, 2)
+	for i, sid := range []uuid.UUID{pair.SubmissionA, pair.SubmissionB} {
+		sub, err := GetSubmission(sid)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "submission not found"})
+			return
+		}
+		if sources[i], err = submissionSimilaritySources(sub.CodeContent, scratch); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read submission"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"pair": pair, "sources_a": sources[0], "sources_b": sources[1]})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const similarityOriginal = `def read_numbers():
+    values = []
+    for line in open("data.txt"):
+        values.append(int(line))
+    return values
+
+
+def average(values):
+    total = 0
+    for v in values:
+        total += v
+    return total / len(values)
+
+
+def report(values):
+    # print the summary
+    print("count", len(values))
+    print("avg", round(average(values), 2))
+    print("max", max(values))
+
+
+report(read_numbers())
+`
+
+// same program with renamed identifiers, other literals and comments, and
+// the functions in a different order
+const similarityDisguised = `def summary(nums):
+    print('n =', len(nums))
+    print('mean =', round(mean(nums), 3))
+    print('largest =', max(nums))
+
+
+def load():
+    """Load the input."""
+    result = []
+    for row in open('input.txt'):
+        result.append(int(row))
+    return result
+
+
+def mean(nums):
+    acc = 0
+    for x in nums:
+        acc += x
+    return acc / len(nums)
+
+
+summary(load())
+`
+
+const similarityUnrelated = `import sys
+
+words = sys.stdin.read().split()
+counts = {}
+for w in words:
+    counts[w.lower()] = counts.get(w.lower(), 0) + 1
+best = sorted(counts.items(), key=lambda kv: (-kv[1], kv[0]))
+while best and best[0][1] > 1:
+    word, n = best.pop(0)
+    print(f"{word}: {n}")
+`
+
+func TestTokenizePythonNormalizes(t *testing.T) {
+	a := tokenizePython("a.py", "x = foo(1, 'a')  # c\nprint(x.real)\n")
+	b := tokenizePython("b.py", "value = bar(2.5e-3, \"\"\"b\nc\"\"\")\nprint(value.real)\n")
+	text := func(toks []simToken) string {
+		parts := make([]string, len(toks))
+		for i, tok := range toks {
+			parts[i] = tok.Text
+		}
+		return strings.Join(parts, " ")
+	}
+	if text(a) != "V = V ( N , S ) print ( V . real )" || text(a) != text(b) {
+		t.Fatalf("tokens differ: %q vs %q", text(a), text(b))
+	}
+	if last := b[len(b)-1]; last.Line != 3 {
+		t.Fatalf("triple-quoted string should advance lines, last token on line %d", last.Line)
+	}
+}
+
+func TestWinnowGuaranteesLongMatches(t *testing.T) {
+	toks := tokenizePython("m.py", similarityOriginal)
+	prints := winnow(toks, similarityK, similarityWindow)
+	if len(prints) == 0 || len(prints) >= len(toks) {
+		t.Fatalf("unexpected fingerprint count %d for %d tokens", len(prints), len(toks))
+	}
+	// every window of hashes must contain a selected position
+	for start := 0; start+similarityWindow <= len(toks)-similarityK+1; start++ {
+		found := false
+		for _, fp := range prints {
+			if fp.pos >= start && fp.pos < start+similarityWindow {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("window at %d has no fingerprint", start)
+		}
+	}
+}
+
+func TestCompareSimilarityDocs(t *testing.T) {
+	orig := newSimilarityDoc(map[string]string{"main.py": similarityOriginal}, false)
+	disguised := newSimilarityDoc(map[string]string{"solution.py": similarityDisguised}, false)
+	unrelated := newSimilarityDoc(map[string]string{"main.py": similarityUnrelated}, false)
+
+	m := compareSimilarityDocs(orig, disguised, nil)
+	if m.Score < 0.6 {
+		t.Fatalf("disguised copy scored %.2f", m.Score)
+	}
+	if len(m.Regions) < 2 {
+		t.Fatalf("reordered functions should give separate regions: %+v", m.Regions)
+	}
+	for _, r := range m.Regions {
+		if r.FileA != "main.py" || r.FileB != "solution.py" || r.StartLineA > r.EndLineA || r.StartLineB > r.EndLineB {
+			t.Fatalf("bad region %+v", r)
+		}
+	}
+	if m := compareSimilarityDocs(orig, unrelated, nil); m.Score > 0.1 {
+		t.Fatalf("unrelated programs scored %.2f", m.Score)
+	}
+
+	// with the original as baseline nothing the disguised copy shares with
+	// it counts any more
+	baseline := map[uint64]struct{}{}
+	for h := range orig.prints {
+		baseline[h] = struct{}{}
+	}
+	if m := compareSimilarityDocs(orig, disguised, baseline); m.Score != 0 || len(m.Regions) != 0 {
+		t.Fatalf("baseline code matched: %+v", m)
+	}
+}
+
+func TestAnalyzeSimilarityRanksPairs(t *testing.T) {
+	template := "def main():\n    n = int(input())\n    data = [int(input()) for _ in range(n)]\n    print(solve(data))\n\n\nmain()\n"
+	inputs := []similarityInput{
+		{SubmissionID: uuid.New(), Sources: map[string]string{"main.py": template + similarityOriginal}},
+		{SubmissionID: uuid.New(), Sources: map[string]string{"main.py": template + similarityUnrelated}},
+		{SubmissionID: uuid.New(), Sources: map[string]string{"main.py": template + similarityDisguised}},
+	}
+	pairs := analyzeSimilarity(inputs, []map[string]string{{"template.py": template}}, false)
+	if len(pairs) != 1 {
+		t.Fatalf("expected only the copied pair, got %d", len(pairs))
+	}
+	if pairs[0].A.SubmissionID != inputs[0].SubmissionID || pairs[0].B.SubmissionID != inputs[2].SubmissionID {
+		t.Fatalf("wrong pair ranked first")
+	}
+}
+
+func TestSimilaritySourcesFromSubmissionZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{"main.py": "print(1)\n", "notes.txt": "x", "__pycache__/main.py": "", "pkg/util.py": "pass\n"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]string{}
+	if err := similaritySources("submission.zip", buf.Bytes(), false, sources); err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 || sources["main.py"] != "print(1)\n" || sources["pkg/util.py"] != "pass\n" {
+		t.Fatalf("unexpected sources %v", sources)
+	}
+}
+
+func TestSimilaritySourcesSerializeScratch(t *testing.T) {
+	project := `{"targets":[{"name":"Cat","isStage":false,"blocks":{
+		"a":{"opcode":"event_whenflagclicked","next":"b","topLevel":true,"x":0,"y":0},
+		"b":{"opcode":"motion_movesteps","parent":"a","inputs":{"STEPS":[1,[4,"10"]]}}}}]}`
+	var sb3 bytes.Buffer
+	zw := zip.NewWriter(&sb3)
+	w, err := zw.Create("project.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte(project))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]string{}
+	if err := similaritySources("game.sb3", sb3.Bytes(), true, sources); err != nil {
+		t.Fatal(err)
+	}
+	pseudo := sources["game.sb3"]
+	if !strings.Contains(pseudo, "Sprite: Cat") {
+		t.Fatalf("unexpected pseudo-code %q", pseudo)
+	}
+	if toks := tokenizeScratch("game.sb3", pseudo); len(toks) == 0 || toks[0].Text != "sprite" {
+		t.Fatalf("unexpected tokens %+v", toks)
+	}
+}
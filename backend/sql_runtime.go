@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL assignments run on SQLite inside the sandbox VM through Python's
+// sqlite3 module, so no database server is involved. The teacher supplies a
+// schema and a seed script per assignment; each sql_query test builds a
+// fresh in-memory database from them, runs the student's query file and the
+// test's reference query, and compares the two result sets.
+
+const (
+	sqlSchemaFile    = "__schema__.sql"
+	sqlSeedFile      = "__seed__.sql"
+	sqlReferenceFile = "__sqlref__.sql"
+	sqlRunnerFile    = "__sqlgrade__.py"
+	sqlConfigFile    = "__sqlgrade__.json"
+	sqlMarker        = "===SQL_JSON==="
+	sqlMaxShownRows  = 200
+)
+
+// Column name handling of sql_query tests.
+const (
+	sqlColumnsExact           = "exact"
+	sqlColumnsCaseInsensitive = "case_insensitive"
+	sqlColumnsIgnore          = "ignore"
+)
+
+const sqlRunnerScript = `import json
+import pathlib
+import sqlite3
+import sys
+import traceback
+
+MARKER = "===SQL_JSON==="
+HERE = pathlib.Path(__file__).resolve().parent
+CFG = json.loads((HERE / "__sqlgrade__.json").read_text(encoding="utf-8"))
+
+
+def read(path):
+    p = HERE / path
+    return p.read_text(encoding="utf-8") if p.exists() else ""
+
+
+def fresh_db():
+    db = sqlite3.connect(":memory:")
+    for name in ("__schema__.sql", "__seed__.sql"):
+        db.executescript(read(name))
+    db.commit()
+    return db
+
+
+def statements(sql):
+    out, start = [], 0
+    for i, ch in enumerate(sql):
+        if ch == ";" and sqlite3.complete_statement(sql[start:i + 1]):
+            out.append(sql[start:i + 1])
+            start = i + 1
+    if sql[start:].strip():
+        out.append(sql[start:])
+    return out
+
+
+def value(v):
+    if isinstance(v, bytes):
+        return "x'" + v.hex() + "'"
+    return v
+
+
+def run(sql):
+    db = fresh_db()
+    last = None
+    for stmt in statements(sql):
+        cur = db.execute(stmt)
+        if cur.description is not None:
+            last = {
+                "columns": [d[0] for d in cur.description],
+                "rows": [[value(v) for v in row] for row in cur.fetchall()],
+            }
+    if last is None:
+        raise ValueError("the query did not return a result set")
+    return last
+
+
+def main():
+    result = {}
+    try:
+        result["reference"] = run(read("__sqlref__.sql"))
+    except Exception as exc:  # noqa: BLE001
+        result["reference_error"] = str(exc)
+    try:
+        result["student"] = run(read(CFG["query_file"]))
+    except sqlite3.Error as exc:
+        result["error"] = "%s: %s" % (type(exc).__name__, exc)
+    except Exception:  # noqa: BLE001
+        result["error"] = traceback.format_exc()
+    print(MARKER + json.dumps(result))
+    sys.exit(1 if "error" in result else 0)
+
+
+if __name__ == "__main__":
+    main()
+`
+
+// sqlResultSet is one query result as reported by the runner.
+type sqlResultSet struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// sqlRun is the outcome of a sql_query test.
+type sqlRun struct {
+	Student        *sqlResultSet `json:"student"`
+	Reference      *sqlResultSet `json:"reference"`
+	Error          string        `json:"error"`
+	ReferenceError string        `json:"reference_error"`
+}
+
+// normalizeSQLColumnMatch validates the column name handling of a test.
+func normalizeSQLColumnMatch(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", sqlColumnsExact:
+		return sqlColumnsExact, nil
+	case sqlColumnsCaseInsensitive, "ignore_case":
+		return sqlColumnsCaseInsensitive, nil
+	case sqlColumnsIgnore:
+		return sqlColumnsIgnore, nil
+	default:
+		return "", fmt.Errorf("invalid sql_column_match")
+	}
+}
+
+// validateSQLTest checks the authoring-time fields of a sql_query test.
+func validateSQLTest(query, file *string) error {
+	if query == nil || strings.TrimSpace(*query) == "" {
+		return errors.New("sql_query is required")
+	}
+	if file != nil && *file != "" {
+		clean := filepath.ToSlash(filepath.Clean(*file))
+		if filepath.IsAbs(*file) || strings.HasPrefix(clean, "../") || clean == ".." || !strings.HasSuffix(strings.ToLower(clean), ".sql") {
+			return errors.New("sql_file must be a relative path to a .sql file")
+		}
+	}
+	return nil
+}
+
+type sqlRuntime struct {
+	noUnitTests
+	noFunctionCalls
+}
+
+func (sqlRuntime) modes() []string { return []string{"sql_query"} }
+
+// compiled is true so the schema and seed of the assignment get staged by
+// build before the tests run.
+func (sqlRuntime) compiled() bool { return true }
+
+func (sqlRuntime) entryFile(dir string) (string, error) {
+	sources, err := findSources(dir, ".sql")
+	if err != nil {
+		return "", err
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("no .sql files found")
+	}
+	for _, preferred := range []string{"query.sql", "main.sql"} {
+		for _, src := range sources {
+			if src == preferred {
+				return src, nil
+			}
+		}
+	}
+	return sources[0], nil
+}
+
+// build stages the schema and seed scripts of the assignment next to the
+// submission. Nothing runs in the VM at this point.
+func (sqlRuntime) build(_ *vmSession, dir string, a *Assignment) error {
+	var schema, seed string
+	if a != nil {
+		schema, seed = stringOrEmpty(a.SQLSchema), stringOrEmpty(a.SQLSeed)
+	}
+	if err := os.WriteFile(filepath.Join(dir, sqlSchemaFile), []byte(schema), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, sqlSeedFile), []byte(seed), 0644)
+}
+
+func (sqlRuntime) command(remoteDir, _ string, memoryLimitKB int) string {
+	return "PYTHONDONTWRITEBYTECODE=1 HOME=/tmp LANG=C.UTF-8 " + memoryGuardedPython(remoteDir, filepath.Join(remoteDir, sqlRunnerFile), memoryLimitKB)
+}
+
+func (sqlRuntime) runStdin(*vmSession, string, string, string, time.Duration, int) (string, string, int, bool, time.Duration, memoryUsage) {
+	return "", "stdin tests are not supported for this programming language", -1, false, 0, memoryUsage{}
+}
+
+// sqlTestRun is the outcome of a sql_query test.
+type sqlTestRun struct {
+	Result   sqlRun
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Runtime  time.Duration
+	Memory   memoryUsage
+	Err      error
+}
+
+// runSQLTest runs the student's query file and the reference query of tc on
+// fresh copies of the assignment database.
+func runSQLTest(sess *vmSession, dir string, prog program, tc TestCase, timeout time.Duration) sqlTestRun {
+	queryFile := prog.entry
+	if f := strings.TrimSpace(stringOrEmpty(tc.SQLFile)); f != "" {
+		queryFile = filepath.ToSlash(filepath.Clean(f))
+	}
+	if _, err := os.Stat(filepath.Join(dir, queryFile)); err != nil {
+		return sqlTestRun{Stderr: fmt.Sprintf("%s not found in the submission", queryFile), ExitCode: 1}
+	}
+	cfg, err := json.Marshal(map[string]string{"query_file": queryFile})
+	if err != nil {
+		return sqlTestRun{ExitCode: -1, Err: err}
+	}
+	files := map[string][]byte{
+		sqlRunnerFile:    []byte(sqlRunnerScript),
+		sqlConfigFile:    cfg,
+		sqlReferenceFile: []byte(stringOrEmpty(tc.SQLQuery)),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return sqlTestRun{ExitCode: -1, Err: fmt.Errorf("write %s: %w", name, err)}
+		}
+	}
+	if err := writeMemoryGuard(dir); err != nil {
+		return sqlTestRun{ExitCode: -1, Err: err}
+	}
+	_ = ensureSandboxPerms(dir)
+
+	run := sqlTestRun{}
+	var out string
+	out, run.Stderr, run.ExitCode, run.TimedOut, run.Runtime, run.Memory = runTimedInVM(sess, dir, "", timeout, func(remoteDir string) string {
+		return prog.rt.command(remoteDir, prog.entry, tc.MemoryLimitKB)
+	})
+	if idx := strings.LastIndex(out, sqlMarker); idx != -1 {
+		if err := json.Unmarshal([]byte(strings.TrimSpace(out[idx+len(sqlMarker):])), &run.Result); err != nil {
+			run.Err = fmt.Errorf("invalid SQL runner output: %w", err)
+		}
+	}
+	if run.Result.Error != "" {
+		run.Stderr = strings.TrimSpace(run.Result.Error + "\n" + run.Stderr)
+	}
+	run.Stdout = formatSQLResult(run.Result.Student)
+	return run
+}
+
+// sqlStatus grades a sql_query run. The message explains a mismatch or a
+// broken reference query.
+func sqlStatus(run sqlTestRun, tc TestCase) (string, string) {
+	switch {
+	case run.TimedOut:
+		return "time_limit_exceeded", ""
+	case run.Memory.LimitExceeded:
+		return "memory_limit_exceeded", ""
+	case run.Err != nil:
+		return "runtime_error", run.Err.Error()
+	case run.Result.Error != "" || run.ExitCode != 0 || run.Result.Student == nil:
+		return "runtime_error", ""
+	case run.Result.ReferenceError != "" || run.Result.Reference == nil:
+		return "checker_error", "reference query failed: " + run.Result.ReferenceError
+	}
+	if ok, msg := compareSQLResults(run.Result.Student, run.Result.Reference, tc.SQLOrdered, tc.SQLColumnMatch); !ok {
+		return "wrong_output", msg
+	}
+	return "passed", ""
+}
+
+// compareSQLResults reports whether the student's result set matches the
+// reference and, if not, explains the first difference.
+func compareSQLResults(student, reference *sqlResultSet, ordered bool, columnMatch string) (bool, string) {
+	if len(student.Columns) != len(reference.Columns) {
+		return false, fmt.Sprintf("expected %d columns, got %d", len(reference.Columns), len(student.Columns))
+	}
+	for i := range reference.Columns {
+		got, want := strings.TrimSpace(student.Columns[i]), strings.TrimSpace(reference.Columns[i])
+		switch columnMatch {
+		case sqlColumnsIgnore:
+			continue
+		case sqlColumnsCaseInsensitive:
+			if strings.EqualFold(got, want) {
+				continue
+			}
+		default:
+			if got == want {
+				continue
+			}
+		}
+		return false, fmt.Sprintf("column %d should be named %q, got %q", i+1, want, got)
+	}
+	if len(student.Rows) != len(reference.Rows) {
+		return false, fmt.Sprintf("expected %d rows, got %d", len(reference.Rows), len(student.Rows))
+	}
+	got, want := sqlRowKeys(student.Rows), sqlRowKeys(reference.Rows)
+	if !ordered {
+		sort.Strings(got)
+		sort.Strings(want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			if ordered {
+				return false, fmt.Sprintf("row %d differs: expected %s, got %s", i+1, want[i], got[i])
+			}
+			return false, fmt.Sprintf("rows differ: expected %s, got %s", want[i], got[i])
+		}
+	}
+	return true, ""
+}
+
+// sqlRowKeys renders rows in a canonical form. Numbers are compared with
+// twelve significant digits so 2 and 2.0 or rounding noise in averages match.
+func sqlRowKeys(rows [][]any) []string {
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		parts := make([]string, len(row))
+		for j, v := range row {
+			parts[j] = sqlValueKey(v)
+		}
+		keys[i] = "(" + strings.Join(parts, ", ") + ")"
+	}
+	return keys
+}
+
+func sqlValueKey(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case float64:
+		return strconv.FormatFloat(x, 'g', 12, 64)
+	case string:
+		return strconv.Quote(x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// formatSQLResult renders a result set as a plain text table for feedback.
+func formatSQLResult(rs *sqlResultSet) string {
+	if rs == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(strings.Join(rs.Columns, " | "))
+	b.WriteByte('\n')
+	for i, row := range rs.Rows {
+		if i == sqlMaxShownRows {
+			fmt.Fprintf(&b, "... (%d more rows)\n", len(rs.Rows)-i)
+			break
+		}
+		parts := make([]string, len(row))
+		for j, v := range row {
+			if v == nil {
+				parts[j] = "NULL"
+			} else if f, ok := v.(float64); ok {
+				parts[j] = strconv.FormatFloat(f, 'g', -1, 64)
+			} else {
+				parts[j] = fmt.Sprint(v)
+			}
+		}
+		b.WriteString(strings.Join(parts, " | "))
+		b.WriteByte('\n')
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareSQLResults(t *testing.T) {
+	ref := &sqlResultSet{Columns: []string{"name", "total"}, Rows: [][]any{{"ann", 2.0}, {"bob", 1.5}, {nil, 0.0}}}
+	cases := []struct {
+		name     string
+		student  *sqlResultSet
+		ordered  bool
+		match    string
+		ok       bool
+		contains string
+	}{
+		{"same", &sqlResultSet{Columns: []string{"name", "total"}, Rows: [][]any{{"ann", 2.0}, {"bob", 1.5}, {nil, 0.0}}}, true, sqlColumnsExact, true, ""},
+		{"unordered", &sqlResultSet{Columns: []string{"name", "total"}, Rows: [][]any{{nil, 0.0}, {"bob", 1.5}, {"ann", 2.0}}}, false, sqlColumnsExact, true, ""},
+		{"order matters", &sqlResultSet{Columns: []string{"name", "total"}, Rows: [][]any{{"bob", 1.5}, {"ann", 2.0}, {nil, 0.0}}}, true, sqlColumnsExact, false, "row 1 differs"},
+		{"rounding", &sqlResultSet{Columns: []string{"name", "total"}, Rows: [][]any{{"ann", 2.0000000000001}, {"bob", 1.5}, {nil, 0.0}}}, true, sqlColumnsExact, true, ""},
+		{"column case", &sqlResultSet{Columns: []string{"Name", "TOTAL"}, Rows: ref.Rows}, true, sqlColumnsCaseInsensitive, true, ""},
+		{"column name", &sqlResultSet{Columns: []string{"Name", "total"}, Rows: ref.Rows}, true, sqlColumnsExact, false, `should be named "name"`},
+		{"ignored names", &sqlResultSet{Columns: []string{"a", "b"}, Rows: ref.Rows}, true, sqlColumnsIgnore, true, ""},
+		{"column count", &sqlResultSet{Columns: []string{"name"}, Rows: [][]any{{"ann"}}}, true, sqlColumnsIgnore, false, "expected 2 columns"},
+		{"row count", &sqlResultSet{Columns: []string{"name", "total"}, Rows: ref.Rows[:2]}, false, sqlColumnsExact, false, "expected 3 rows, got 2"},
+		{"null vs string", &sqlResultSet{Columns: []string{"name", "total"}, Rows: [][]any{{"ann", 2.0}, {"bob", 1.5}, {"NULL", 0.0}}}, false, sqlColumnsExact, false, "rows differ"},
+	}
+	for _, tc := range cases {
+		ok, msg := compareSQLResults(tc.student, ref, tc.ordered, tc.match)
+		if ok != tc.ok || !strings.Contains(msg, tc.contains) {
+			t.Errorf("%s: got %v %q", tc.name, ok, msg)
+		}
+	}
+}
+
+func TestNormalizeSQLColumnMatch(t *testing.T) {
+	for raw, want := range map[string]string{"": "exact", "IGNORE_CASE": "case_insensitive", "ignore": "ignore"} {
+		if got, err := normalizeSQLColumnMatch(raw); err != nil || got != want {
+			t.Errorf("normalizeSQLColumnMatch(%q) = %q, %v", raw, got, err)
+		}
+	}
+	if _, err := normalizeSQLColumnMatch("fuzzy"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestValidateSQLTest(t *testing.T) {
+	q := "SELECT 1"
+	for _, f := range []string{"", "q1.sql", "tasks/q2.sql"} {
+		if err := validateSQLTest(&q, &f); err != nil {
+			t.Errorf("sql_file %q: %v", f, err)
+		}
+	}
+	for _, f := range []string{"../x.sql", "/etc/x.sql", "q1.txt"} {
+		if err := validateSQLTest(&q, &f); err == nil {
+			t.Errorf("sql_file %q should be rejected", f)
+		}
+	}
+	blank := " "
+	if err := validateSQLTest(&blank, nil); err == nil {
+		t.Fatalf("blank query should be rejected")
+	}
+}
+
+func TestFormatSQLResult(t *testing.T) {
+	got := formatSQLResult(&sqlResultSet{Columns: []string{"id", "name"}, Rows: [][]any{{1.0, "ann"}, {2.0, nil}}})
+	if want := "id | name\n1 | ann\n2 | NULL"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// TestSQLRunnerScript runs the runner with the local Python, which ships
+// sqlite3 just like the sandbox image.
+func TestSQLRunnerScript(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not available")
+	}
+	dir := t.TempDir()
+	a := &Assignment{
+		SQLSchema: strPtr("CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);"),
+		SQLSeed:   strPtr("INSERT INTO people (name, age) VALUES ('ann', 31), ('bob', 25), ('cid', 40);"),
+	}
+	writeFiles(t, dir, map[string]string{
+		"query.sql": "-- adults over 30\nUPDATE people SET age = age + 1 WHERE name = 'bob'; SELECT name AS Name FROM people\n WHERE age > 30 ORDER BY name;",
+	})
+	if err := (sqlRuntime{}).build(nil, dir, a); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ := json.Marshal(map[string]string{"query_file": "query.sql"})
+	writeFiles(t, dir, map[string]string{
+		sqlRunnerFile:    sqlRunnerScript,
+		sqlConfigFile:    string(cfg),
+		sqlReferenceFile: "SELECT name FROM people WHERE age > 30;",
+	})
+	out, err := exec.Command(python, filepath.Join(dir, sqlRunnerFile)).Output()
+	if err != nil {
+		t.Fatalf("runner failed: %v\n%s", err, out)
+	}
+	idx := strings.LastIndex(string(out), sqlMarker)
+	if idx == -1 {
+		t.Fatalf("no marker in %q", out)
+	}
+	var run sqlRun
+	if err := json.Unmarshal(out[idx+len(sqlMarker):], &run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Student == nil || run.Reference == nil {
+		t.Fatalf("missing result sets: %+v", run)
+	}
+	if ok, msg := compareSQLResults(run.Student, run.Reference, false, sqlColumnsCaseInsensitive); !ok {
+		t.Fatalf("results differ: %s", msg)
+	}
+	if ok, _ := compareSQLResults(run.Student, run.Reference, false, sqlColumnsExact); ok {
+		t.Fatalf("column names should differ in exact mode")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "query.sql"), []byte("SELECT nope FROM people;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, _ = exec.Command(python, filepath.Join(dir, sqlRunnerFile)).Output()
+	idx = strings.LastIndex(string(out), sqlMarker)
+	run = sqlRun{}
+	if idx == -1 || json.Unmarshal(out[idx+len(sqlMarker):], &run) != nil || !strings.Contains(run.Error, "no such column") {
+		t.Fatalf("expected a student SQL error, got %q", out)
+	}
+}
@@ -74,6 +74,14 @@ func normalizeTestFilesPayload(files []TestFilePayload) (*string, error) {
 }
 
 func stageTestFile(dir, mainFile string, tc TestCase) error {
+	if err := stageOutputManifest(dir, mainFile, tc); err != nil {
+		return err
+	}
+	if tc.ExecutionMode == "turtle" {
+		if err := stageTurtleShim(dir, mainFile); err != nil {
+			return err
+		}
+	}
 	if tc.FilesJSON != nil && *tc.FilesJSON != "" {
 		var files []TestFilePayload
 		if err := json.Unmarshal([]byte(*tc.FilesJSON), &files); err != nil {
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Test groups (subtasks) bundle test cases under a name with their own points
+// and a scoring policy:
+//
+//	all           the group earns its points only when every test passes
+//	min           points scaled by the lowest test credit
+//	proportional  points scaled by the weighted share of earned test credit
+//
+// A group may depend on other groups of the same assignment. When one of them
+// does not fully pass, the dependent group is skipped: its tests are not run
+// and it earns nothing. Once an assignment has groups, group points take the
+// place of test weights in scoring; tests outside any group still count with
+// their own weight.
+
+const (
+	groupPolicyAll          = "all"
+	groupPolicyMin          = "min"
+	groupPolicyProportional = "proportional"
+)
+
+type TestGroup struct {
+	ID           uuid.UUID      `db:"id" json:"id"`
+	AssignmentID uuid.UUID      `db:"assignment_id" json:"assignment_id"`
+	Name         string         `db:"name" json:"name"`
+	Points       float64        `db:"points" json:"points"`
+	Policy       string         `db:"policy" json:"policy"`
+	DependsOn    pq.StringArray `db:"depends_on" json:"depends_on"`
+	Position     int            `db:"position" json:"position"`
+	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+// groupResult is the outcome of one group for a submission.
+type groupResult struct {
+	GroupID     uuid.UUID   `json:"group_id"`
+	Name        string      `json:"name"`
+	Policy      string      `json:"policy"`
+	Points      float64     `json:"points"`
+	Earned      float64     `json:"earned"`
+	Passed      bool        `json:"passed"`
+	Skipped     bool        `json:"skipped"`
+	BlockedBy   []string    `json:"blocked_by,omitempty"`
+	Tests       int         `json:"tests"`
+	PassedTests int         `json:"passed_tests"`
+	TestIDs     []uuid.UUID `json:"test_ids"`
+}
+
+func ListTestGroups(aid uuid.UUID) ([]TestGroup, error) {
+	list := []TestGroup{}
+	err := DB.Select(&list, `
+                SELECT id, assignment_id, name, points, policy, depends_on, position, created_at, updated_at
+                  FROM test_groups
+                 WHERE assignment_id=$1
+                 ORDER BY position, name`, aid)
+	return list, err
+}
+
+func GetTestGroup(id uuid.UUID) (*TestGroup, error) {
+	var g TestGroup
+	err := DB.Get(&g, `
+                SELECT id, assignment_id, name, points, policy, depends_on, position, created_at, updated_at
+                  FROM test_groups
+                 WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func CreateTestGroup(g *TestGroup) error {
+	if g.DependsOn == nil {
+		g.DependsOn = pq.StringArray{}
+	}
+	return DB.QueryRow(`
+                INSERT INTO test_groups (assignment_id, name, points, policy, depends_on, position)
+                VALUES ($1,$2,$3,$4,$5,$6)
+                RETURNING id, created_at, updated_at`,
+		g.AssignmentID, g.Name, g.Points, g.Policy, g.DependsOn, g.Position).
+		Scan(&g.ID, &g.CreatedAt, &g.UpdatedAt)
+}
+
+func UpdateTestGroup(g *TestGroup) error {
+	if g.DependsOn == nil {
+		g.DependsOn = pq.StringArray{}
+	}
+	return DB.QueryRow(`
+                UPDATE test_groups
+                   SET name=$1, points=$2, policy=$3, depends_on=$4, position=$5, updated_at=now()
+                 WHERE id=$6
+             RETURNING updated_at`,
+		g.Name, g.Points, g.Policy, g.DependsOn, g.Position, g.ID).Scan(&g.UpdatedAt)
+}
+
+// DeleteTestGroup removes a group and drops it from the dependencies of the
+// other groups. Its tests become ungrouped.
+func DeleteTestGroup(g *TestGroup) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE test_groups SET depends_on=array_remove(depends_on, $1::uuid) WHERE assignment_id=$2`, g.ID, g.AssignmentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM test_groups WHERE id=$1`, g.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteAllTestGroupsForAssignment removes every test group of an assignment.
+func DeleteAllTestGroupsForAssignment(aid uuid.UUID) error {
+	_, err := DB.Exec(`DELETE FROM test_groups WHERE assignment_id=$1`, aid)
+	return err
+}
+
+// copyTestGroups duplicates the groups of an assignment and returns the
+// mapping from source to new group IDs.
+func copyTestGroups(srcID, dstID uuid.UUID) (map[uuid.UUID]uuid.UUID, error) {
+	groups, err := ListTestGroups(srcID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[uuid.UUID]uuid.UUID, len(groups))
+	for _, g := range groups {
+		cp := &TestGroup{AssignmentID: dstID, Name: g.Name, Points: g.Points, Policy: g.Policy, Position: g.Position}
+		if err := CreateTestGroup(cp); err != nil {
+			return nil, err
+		}
+		ids[g.ID] = cp.ID
+	}
+	for _, g := range groups {
+		if len(g.DependsOn) == 0 {
+			continue
+		}
+		deps := pq.StringArray{}
+		for _, d := range g.DependsOn {
+			if old, err := uuid.Parse(d); err == nil {
+				if nid, ok := ids[old]; ok {
+					deps = append(deps, nid.String())
+				}
+			}
+		}
+		if _, err := DB.Exec(`UPDATE test_groups SET depends_on=$1 WHERE id=$2`, deps, ids[g.ID]); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// testGroupsDiffer compares two assignments' groups by their settings, with
+// dependencies compared by group name since the IDs differ between copies.
+func testGroupsDiffer(a, b []TestGroup) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	key := func(list []TestGroup) []string {
+		names := make(map[string]string, len(list))
+		for _, g := range list {
+			names[g.ID.String()] = g.Name
+		}
+		keys := make([]string, 0, len(list))
+		for _, g := range list {
+			deps := make([]string, 0, len(g.DependsOn))
+			for _, d := range g.DependsOn {
+				deps = append(deps, names[d])
+			}
+			sort.Strings(deps)
+			keys = append(keys, fmt.Sprintf("%d|%s|%g|%s|%s", g.Position, g.Name, g.Points, g.Policy, strings.Join(deps, ",")))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	ka, kb := key(a), key(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// testGroupBelongsTo reports whether the group is part of the assignment.
+func testGroupBelongsTo(groupID, aid uuid.UUID) (bool, error) {
+	var n int
+	err := DB.Get(&n, `SELECT COUNT(*) FROM test_groups WHERE id=$1 AND assignment_id=$2`, groupID, aid)
+	return n > 0, err
+}
+
+func normalizeGroupPolicy(policy string) string {
+	p := strings.ToLower(strings.TrimSpace(policy))
+	if p == "" {
+		return groupPolicyAll
+	}
+	return p
+}
+
+// validateTestGroups checks the full set of groups of one assignment.
+func validateTestGroups(groups []TestGroup) error {
+	names := make(map[string]bool, len(groups))
+	ids := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		ids[g.ID.String()] = true
+	}
+	for _, g := range groups {
+		name := strings.TrimSpace(g.Name)
+		if name == "" {
+			return errors.New("group name is required")
+		}
+		if names[strings.ToLower(name)] {
+			return fmt.Errorf("duplicate group name %q", name)
+		}
+		names[strings.ToLower(name)] = true
+		switch g.Policy {
+		case groupPolicyAll, groupPolicyMin, groupPolicyProportional:
+		default:
+			return fmt.Errorf("group %q: policy must be all, min or proportional", name)
+		}
+		if g.Points < 0 || math.IsNaN(g.Points) || math.IsInf(g.Points, 0) {
+			return fmt.Errorf("group %q: points must not be negative", name)
+		}
+		for _, d := range g.DependsOn {
+			if d == g.ID.String() {
+				return fmt.Errorf("group %q cannot depend on itself", name)
+			}
+			if !ids[d] {
+				return fmt.Errorf("group %q depends on an unknown group", name)
+			}
+		}
+	}
+	_, err := orderTestGroups(groups)
+	return err
+}
+
+// orderTestGroups sorts groups so that every group comes after the groups it
+// depends on, keeping the given order otherwise.
+func orderTestGroups(groups []TestGroup) ([]TestGroup, error) {
+	known := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		known[g.ID.String()] = true
+	}
+	placed := make(map[string]bool, len(groups))
+	ordered := make([]TestGroup, 0, len(groups))
+	for len(ordered) < len(groups) {
+		progress := false
+		for _, g := range groups {
+			id := g.ID.String()
+			if placed[id] {
+				continue
+			}
+			ready := true
+			for _, d := range g.DependsOn {
+				if known[d] && !placed[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[id] = true
+				ordered = append(ordered, g)
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, errors.New("group dependencies contain a cycle")
+		}
+	}
+	return ordered, nil
+}
+
+// groupLevels gives each group the length of its longest dependency chain.
+func groupLevels(ordered []TestGroup) map[uuid.UUID]int {
+	byID := make(map[string]uuid.UUID, len(ordered))
+	for _, g := range ordered {
+		byID[g.ID.String()] = g.ID
+	}
+	levels := make(map[uuid.UUID]int, len(ordered))
+	for _, g := range ordered {
+		lvl := 0
+		for _, d := range g.DependsOn {
+			if id, ok := byID[d]; ok && levels[id]+1 > lvl {
+				lvl = levels[id] + 1
+			}
+		}
+		levels[g.ID] = lvl
+	}
+	return levels
+}
+
+// splitTestsByGroup returns the tests of every group and the ungrouped tests.
+func splitTestsByGroup(groups []TestGroup, tests []TestCase) (map[uuid.UUID][]TestCase, []TestCase) {
+	members := make(map[uuid.UUID][]TestCase, len(groups))
+	for _, g := range groups {
+		members[g.ID] = nil
+	}
+	var ungrouped []TestCase
+	for _, tc := range tests {
+		if tc.GroupID != nil {
+			if _, ok := members[*tc.GroupID]; ok {
+				members[*tc.GroupID] = append(members[*tc.GroupID], tc)
+				continue
+			}
+		}
+		ungrouped = append(ungrouped, tc)
+	}
+	return members, ungrouped
+}
+
+// blockedBy lists the names of the dependencies of g that did not pass.
+func blockedBy(g TestGroup, passed map[uuid.UUID]bool, names map[uuid.UUID]string) []string {
+	var blocked []string
+	for _, d := range g.DependsOn {
+		id, err := uuid.Parse(d)
+		if err != nil {
+			continue
+		}
+		name, ok := names[id]
+		if ok && !passed[id] {
+			blocked = append(blocked, name)
+		}
+	}
+	return blocked
+}
+
+// groupEarned applies the group policy to the credits of its tests. A group
+// passes when every one of its tests passed fully.
+func groupEarned(g TestGroup, members []TestCase, credit map[uuid.UUID]float64) (float64, bool, int) {
+	if len(members) == 0 {
+		return 0, true, 0
+	}
+	passedTests := 0
+	minCredit := 1.0
+	var weightSum, earnedSum float64
+	for _, tc := range members {
+		c := credit[tc.ID]
+		if c >= 1 {
+			passedTests++
+		}
+		minCredit = math.Min(minCredit, c)
+		weightSum += tc.Weight
+		earnedSum += tc.Weight * c
+	}
+	passed := passedTests == len(members)
+	var earned float64
+	switch g.Policy {
+	case groupPolicyMin:
+		earned = g.Points * minCredit
+	case groupPolicyProportional:
+		if weightSum > 0 {
+			earned = g.Points * earnedSum / weightSum
+		} else {
+			earned = g.Points * float64(passedTests) / float64(len(members))
+		}
+	default:
+		if passed {
+			earned = g.Points
+		}
+	}
+	return earned, passed, passedTests
+}
+
+// scoreTestGroups evaluates the groups against per-test credits in dependency
+// order. Besides the group results it returns the earned and total points of
+// the whole suite, ungrouped tests included, and whether everything passed.
+// Groups without tests are reported but carry no points.
+func scoreTestGroups(groups []TestGroup, tests []TestCase, credit map[uuid.UUID]float64) ([]groupResult, float64, float64, bool) {
+	ordered, err := orderTestGroups(groups)
+	if err != nil {
+		ordered = groups
+	}
+	members, ungrouped := splitTestsByGroup(groups, tests)
+	names := make(map[uuid.UUID]string, len(groups))
+	for _, g := range groups {
+		names[g.ID] = g.Name
+	}
+
+	var earned, total float64
+	allPass := true
+	for _, tc := range ungrouped {
+		c := credit[tc.ID]
+		total += tc.Weight
+		earned += tc.Weight * c
+		if c < 1 {
+			allPass = false
+		}
+	}
+
+	passed := make(map[uuid.UUID]bool, len(groups))
+	results := make([]groupResult, 0, len(ordered))
+	for _, g := range ordered {
+		res := groupResult{GroupID: g.ID, Name: g.Name, Policy: g.Policy, Points: g.Points, Tests: len(members[g.ID]), TestIDs: []uuid.UUID{}}
+		for _, tc := range members[g.ID] {
+			res.TestIDs = append(res.TestIDs, tc.ID)
+		}
+		if len(members[g.ID]) > 0 {
+			total += g.Points
+		}
+		if blocked := blockedBy(g, passed, names); len(blocked) > 0 {
+			res.Skipped = true
+			res.BlockedBy = blocked
+			allPass = false
+			results = append(results, res)
+			continue
+		}
+		res.Earned, res.Passed, res.PassedTests = groupEarned(g, members[g.ID], credit)
+		passed[g.ID] = res.Passed
+		earned += res.Earned
+		if !res.Passed {
+			allPass = false
+		}
+		results = append(results, res)
+	}
+	return results, earned, total, allPass
+}
+
+func skippedTestOutcome(subID uuid.UUID, tc TestCase, blocked []string) testOutcome {
+	return testOutcome{
+		result: &Result{
+			SubmissionID: subID,
+			TestCaseID:   tc.ID,
+			Status:       "skipped",
+			Stderr:       "skipped: depends on failed group " + strings.Join(blocked, ", "),
+			ExitCode:     -1,
+		},
+		weight: tc.Weight,
+	}
+}
+
+// runTestsByGroup runs ungrouped tests and groups without dependencies first,
+// then each further level of groups once everything they depend on finished.
+// Tests of groups blocked by a failed dependency are not run and get a
+// "skipped" result instead.
+func runTestsByGroup(groups []TestGroup, tests []TestCase, subID uuid.UUID, run func([]TestCase) []testOutcome) []testOutcome {
+	if len(groups) == 0 {
+		return run(tests)
+	}
+	ordered, err := orderTestGroups(groups)
+	if err != nil {
+		// cycles are rejected when groups are saved; just run everything
+		return run(tests)
+	}
+	levels := groupLevels(ordered)
+	maxLevel := 0
+	for _, lvl := range levels {
+		maxLevel = max(maxLevel, lvl)
+	}
+	members, ungrouped := splitTestsByGroup(groups, tests)
+	names := make(map[uuid.UUID]string, len(groups))
+	for _, g := range groups {
+		names[g.ID] = g.Name
+	}
+
+	credit := make(map[uuid.UUID]float64, len(tests))
+	passed := make(map[uuid.UUID]bool, len(groups))
+	var outcomes []testOutcome
+	for lvl := 0; lvl <= maxLevel; lvl++ {
+		var batch, runnable []TestCase
+		var stage []TestGroup
+		if lvl == 0 {
+			batch = append(batch, ungrouped...)
+		}
+		for _, g := range ordered {
+			if levels[g.ID] != lvl {
+				continue
+			}
+			if blocked := blockedBy(g, passed, names); len(blocked) > 0 {
+				for _, tc := range members[g.ID] {
+					outcomes = append(outcomes, skippedTestOutcome(subID, tc, blocked))
+				}
+				continue
+			}
+			stage = append(stage, g)
+			runnable = append(runnable, members[g.ID]...)
+		}
+		batch = append(batch, runnable...)
+		if len(batch) > 0 {
+			for _, o := range run(batch) {
+				if o.result != nil {
+					credit[o.result.TestCaseID] = o.credit
+				}
+				outcomes = append(outcomes, o)
+			}
+		}
+		for _, g := range stage {
+			_, ok, _ := groupEarned(g, members[g.ID], credit)
+			passed[g.ID] = ok
+		}
+	}
+	return outcomes
+}
+
+// resultCredit is the fraction of a test's weight a stored result earned.
+func resultCredit(r Result) float64 {
+	switch r.Status {
+	case "passed":
+		return 1
+	case "partially_passed":
+		if r.Score != nil {
+			return *r.Score
+		}
+	}
+	return 0
+}
+
+// submissionGroupResults reports the groups of an assignment for a set of
+// stored results, or nil when the assignment has no groups.
+func submissionGroupResults(aid uuid.UUID, results []Result) ([]groupResult, error) {
+	groups, err := ListTestGroups(aid)
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	tests, err := ListTestCases(aid)
+	if err != nil {
+		return nil, err
+	}
+	credit := make(map[uuid.UUID]float64, len(results))
+	for _, r := range results {
+		credit[r.TestCaseID] = resultCredit(r)
+	}
+	summary, _, _, _ := scoreTestGroups(groups, tests, credit)
+	return summary, nil
+}
+
+type testGroupRequest struct {
+	Name      string   `json:"name"`
+	Points    *float64 `json:"points"`
+	Policy    string   `json:"policy"`
+	DependsOn []string `json:"depends_on"`
+	Position  *int     `json:"position"`
+}
+
+func (req testGroupRequest) apply(g *TestGroup) {
+	g.Name = strings.TrimSpace(req.Name)
+	if req.Points != nil {
+		g.Points = *req.Points
+	}
+	g.Policy = normalizeGroupPolicy(req.Policy)
+	g.DependsOn = pq.StringArray{}
+	for _, d := range req.DependsOn {
+		if d = strings.TrimSpace(d); d != "" {
+			g.DependsOn = append(g.DependsOn, strings.ToLower(d))
+		}
+	}
+	if req.Position != nil {
+		g.Position = *req.Position
+	}
+}
+
+// listTestGroups: GET /api/assignments/:id/test-groups
+func listTestGroups(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	groups, err := ListTestGroups(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// createTestGroup: POST /api/assignments/:id/test-groups
+func createTestGroup(c *gin.Context) {
+	aid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if !requireAssignmentTeacher(c, aid) {
+		return
+	}
+	var req testGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := ListTestGroups(aid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	// A fresh ID lets the new group take part in validation; the database
+	// assigns the real one.
+	g := TestGroup{ID: uuid.New(), AssignmentID: aid, Points: 1, Position: len(existing)}
+	req.apply(&g)
+	if err := validateTestGroups(append(existing, g)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := CreateTestGroup(&g); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusCreated, g)
+}
+
+// updateTestGroup: PUT /api/test-groups/:id
+func updateTestGroup(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	g, err := GetTestGroup(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if !requireAssignmentTeacher(c, g.AssignmentID) {
+		return
+	}
+	var req testGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.apply(g)
+	groups, err := ListTestGroups(g.AssignmentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	for i := range groups {
+		if groups[i].ID == g.ID {
+			groups[i] = *g
+		}
+	}
+	if err := validateTestGroups(groups); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := UpdateTestGroup(g); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.JSON(http.StatusOK, g)
+}
+
+// deleteTestGroup: DELETE /api/test-groups/:id
+func deleteTestGroup(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	g, err := GetTestGroup(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if !requireAssignmentTeacher(c, g.AssignmentID) {
+		return
+	}
+	if err := DeleteTestGroup(g); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db fail"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
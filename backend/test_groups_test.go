@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func groupedTest(g *TestGroup, weight float64) TestCase {
+	tc := TestCase{ID: uuid.New(), Weight: weight}
+	if g != nil {
+		tc.GroupID = &g.ID
+	}
+	return tc
+}
+
+func TestValidateTestGroupsRejectsCycles(t *testing.T) {
+	a := TestGroup{ID: uuid.New(), Name: "A", Policy: groupPolicyAll, Points: 10}
+	b := TestGroup{ID: uuid.New(), Name: "B", Policy: groupPolicyAll, Points: 10}
+	a.DependsOn = pq.StringArray{b.ID.String()}
+	b.DependsOn = pq.StringArray{a.ID.String()}
+	if err := validateTestGroups([]TestGroup{a, b}); err == nil {
+		t.Fatalf("expected cycle error")
+	}
+	b.DependsOn = nil
+	if err := validateTestGroups([]TestGroup{a, b}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.DependsOn = pq.StringArray{uuid.NewString()}
+	if err := validateTestGroups([]TestGroup{a, b}); err == nil {
+		t.Fatalf("expected unknown dependency error")
+	}
+	b.DependsOn = nil
+	b.Name = "a"
+	if err := validateTestGroups([]TestGroup{a, b}); err == nil {
+		t.Fatalf("expected duplicate name error")
+	}
+}
+
+func TestScoreTestGroupsPolicies(t *testing.T) {
+	all := TestGroup{ID: uuid.New(), Name: "all", Policy: groupPolicyAll, Points: 10}
+	lowest := TestGroup{ID: uuid.New(), Name: "min", Policy: groupPolicyMin, Points: 10}
+	prop := TestGroup{ID: uuid.New(), Name: "prop", Policy: groupPolicyProportional, Points: 10}
+	t1, t2 := groupedTest(&all, 1), groupedTest(&all, 1)
+	t3, t4 := groupedTest(&lowest, 1), groupedTest(&lowest, 1)
+	t5, t6 := groupedTest(&prop, 1), groupedTest(&prop, 3)
+	loose := groupedTest(nil, 2)
+	tests := []TestCase{t1, t2, t3, t4, t5, t6, loose}
+	credit := map[uuid.UUID]float64{t1.ID: 1, t2.ID: 0, t3.ID: 1, t4.ID: 0.5, t5.ID: 0, t6.ID: 1, loose.ID: 1}
+
+	res, earned, total, allPass := scoreTestGroups([]TestGroup{all, lowest, prop}, tests, credit)
+	if allPass {
+		t.Fatalf("expected failure")
+	}
+	if total != 32 {
+		t.Fatalf("total = %v, want 32", total)
+	}
+	want := []float64{0, 5, 7.5}
+	for i, r := range res {
+		if math.Abs(r.Earned-want[i]) > 1e-9 {
+			t.Fatalf("group %s earned %v, want %v", r.Name, r.Earned, want[i])
+		}
+	}
+	if math.Abs(earned-14.5) > 1e-9 {
+		t.Fatalf("earned = %v, want 14.5", earned)
+	}
+}
+
+func TestRunTestsByGroupSkipsBlockedGroups(t *testing.T) {
+	basic := TestGroup{ID: uuid.New(), Name: "basic", Policy: groupPolicyAll, Points: 20}
+	full := TestGroup{ID: uuid.New(), Name: "full", Policy: groupPolicyAll, Points: 80, DependsOn: pq.StringArray{basic.ID.String()}}
+	// listed before its dependency on purpose
+	groups := []TestGroup{full, basic}
+	b1, b2 := groupedTest(&basic, 1), groupedTest(&basic, 1)
+	f1 := groupedTest(&full, 1)
+	tests := []TestCase{f1, b1, b2}
+
+	var batches [][]TestCase
+	run := func(batch []TestCase) []testOutcome {
+		batches = append(batches, batch)
+		out := make([]testOutcome, len(batch))
+		for i, tc := range batch {
+			passed := tc.ID != b2.ID
+			credit := 0.0
+			if passed {
+				credit = 1
+			}
+			status := "wrong_output"
+			if passed {
+				status = "passed"
+			}
+			out[i] = testOutcome{result: &Result{TestCaseID: tc.ID, Status: status}, weight: tc.Weight, passed: passed, credit: credit}
+		}
+		return out
+	}
+	outcomes := runTestsByGroup(groups, tests, uuid.New(), run)
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("expected only the basic group to run, got %v batches", len(batches))
+	}
+	if len(outcomes) != 3 {
+		t.Fatalf("expected 3 outcomes, got %d", len(outcomes))
+	}
+	var skipped *Result
+	for _, o := range outcomes {
+		if o.result.TestCaseID == f1.ID {
+			skipped = o.result
+		}
+	}
+	if skipped == nil || skipped.Status != "skipped" {
+		t.Fatalf("expected dependent test to be skipped, got %+v", skipped)
+	}
+
+	res, earned, total, _ := scoreTestGroups(groups, tests, map[uuid.UUID]float64{b1.ID: 1})
+	if earned != 0 || total != 100 {
+		t.Fatalf("earned/total = %v/%v, want 0/100", earned, total)
+	}
+	if res[0].Name != "basic" || !res[1].Skipped || res[1].BlockedBy[0] != "basic" {
+		t.Fatalf("unexpected group results %+v", res)
+	}
+}
+
+func TestTestGroupsDifferIgnoresIDs(t *testing.T) {
+	a := TestGroup{ID: uuid.New(), Name: "basics", Policy: groupPolicyAll, Points: 4}
+	b := TestGroup{ID: uuid.New(), Name: "advanced", Policy: groupPolicyMin, Points: 6, Position: 1, DependsOn: pq.StringArray{a.ID.String()}}
+	ca := a
+	ca.ID = uuid.New()
+	cb := b
+	cb.ID = uuid.New()
+	cb.DependsOn = pq.StringArray{ca.ID.String()}
+	if testGroupsDiffer([]TestGroup{a, b}, []TestGroup{ca, cb}) {
+		t.Fatalf("copies with new IDs should match")
+	}
+	cb.Points = 5
+	if !testGroupsDiffer([]TestGroup{a, b}, []TestGroup{ca, cb}) {
+		t.Fatalf("changed points should differ")
+	}
+	cb.Points = 6
+	cb.DependsOn = nil
+	if !testGroupsDiffer([]TestGroup{a, b}, []TestGroup{ca, cb}) {
+		t.Fatalf("dropped dependency should differ")
+	}
+	if !testGroupsDiffer([]TestGroup{a, b}, []TestGroup{ca}) {
+		t.Fatalf("missing group should differ")
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The static analyzer in tools_guard.go cannot see through __import__('os'),
+// importlib, getattr(builtins, ...) or eval/exec of strings. When an
+// assignment enables the runtime guard, __toolguard__.py is staged next to the
+// submission and every Python runner loads it first (toolGuardPrelude). It
+// watches imports, audit events and calls made from the student's files and
+// stops the program at the first banned one, reporting the call site on
+// stderr after toolGuardMarker. Banned functions are caught by a profiler,
+// so student code that tries to remove it (sys.setprofile, sys.settrace and
+// their threading counterparts) is stopped as well.
+
+const (
+	toolGuardFile       = "__toolguard__.py"
+	toolGuardConfigFile = "__toolguard__.json"
+	toolGuardMarker     = "===TOOLGUARD==="
+)
+
+// toolGuardPrelude is prepended to the Python runner scripts. It is a no-op
+// unless the guard has been staged in the workspace.
+const toolGuardPrelude = `try:
+    import __toolguard__
+except ImportError:
+    pass
+else:
+    __toolguard__.install()
+`
+
+// toolGuardReport is the finding printed by the guard together with the
+// teacher's note for the rule that was hit.
+type toolGuardReport struct {
+	illegalToolFinding
+	Note string `json:"note"`
+}
+
+// runtimeToolGuardEnabled reports whether the assignment asks for the guard
+// and has anything for it to enforce.
+func runtimeToolGuardEnabled(a *Assignment) bool {
+	if a == nil || (len(a.BannedFunctions) == 0 && len(a.BannedModules) == 0) {
+		return false
+	}
+	cfg, err := parseBannedToolsConfig(a.BannedToolRules)
+	return err == nil && cfg != nil && cfg.RuntimeGuard
+}
+
+// writeToolGuard stages the guard and its rules in dir. Every Python file
+// present at this point is treated as student code.
+func writeToolGuard(dir string, bannedFunctions, bannedModules []string, notes map[string]string) error {
+	var student []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && strings.HasSuffix(strings.ToLower(info.Name()), ".py") {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			student = append(student, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("list student files: %w", err)
+	}
+	sort.Strings(student)
+	cfg, err := json.Marshal(map[string]any{
+		"functions":     sanitizeList(bannedFunctions),
+		"modules":       sanitizeList(bannedModules),
+		"notes":         notes,
+		"student_files": student,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, toolGuardConfigFile), cfg, 0644); err != nil {
+		return fmt.Errorf("write tool guard config: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, toolGuardFile), []byte(toolGuardScript), 0644); err != nil {
+		return fmt.Errorf("write tool guard: %w", err)
+	}
+	return nil
+}
+
+// splitToolGuardOutput extracts the guard's report from stderr, returning
+// the remaining stderr and the report (nil when nothing was blocked).
+func splitToolGuardOutput(stderr string) (string, *toolGuardReport) {
+	idx := strings.LastIndex(stderr, toolGuardMarker)
+	if idx == -1 {
+		return stderr, nil
+	}
+	line := stderr[idx+len(toolGuardMarker):]
+	rest := ""
+	if nl := strings.IndexByte(line, '\n'); nl != -1 {
+		line, rest = line[:nl], line[nl+1:]
+	}
+	var report toolGuardReport
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &report); err != nil {
+		return stderr, nil
+	}
+	return strings.TrimSpace(stderr[:idx] + rest), &report
+}
+
+// toolGuardMessage formats a blocked call like the static findings.
+func toolGuardMessage(report *toolGuardReport) string {
+	notes := map[string]string{}
+	if report.Note != "" {
+		notes[strings.ToLower(report.Name)] = report.Note
+	}
+	return formatIllegalToolMessage([]illegalToolFinding{report.illegalToolFinding}, notes) + " (blocked at runtime)"
+}
+
+const toolGuardScript = `"""Runtime guard for banned functions and modules, loaded by the runners."""
+import builtins
+import importlib
+import json
+import os
+import sys
+import threading
+
+MARKER = '===TOOLGUARD==='
+EXIT_CODE = 97
+ROOT = os.path.dirname(os.path.abspath(__file__))
+SELF = os.path.abspath(__file__)
+# C modules that back the public ones, so posix.system is reported as os.system
+ALIASES = {'posix': 'os', 'nt': 'os', '_io': 'io', '_random': 'random', '_socket': 'socket',
+           '_thread': 'threading', '_operator': 'operator', '_functools': 'functools',
+           '_collections': 'collections', '_heapq': 'heapq', '_bisect': 'bisect', '_json': 'json'}
+# function bans rely on the profiler, so student code may not replace it
+TAMPER = {'sys.setprofile', 'sys.settrace', 'threading.setprofile', 'threading.settrace',
+          'threading.setprofile_all_threads', 'threading.settrace_all_threads'}
+TAMPER_NOTE = 'the grader watches function calls with a profiler that must stay installed'
+
+func_exact = {}
+func_simple = {}
+func_prefixes = []
+func_suffixes = []
+module_map = {}
+notes = {}
+student = set()
+tripped = False
+profiling = False
+cache = {}
+orig_import = builtins.__import__
+orig_import_module = importlib.import_module
+
+
+def load_rules():
+    with open(os.path.join(ROOT, '__toolguard__.json'), encoding='utf-8') as fh:
+        cfg = json.load(fh)
+    for item in cfg.get('functions') or []:
+        name = item.strip()
+        lower = name.lower()
+        if lower.startswith('*.'):
+            func_suffixes.append((lower[1:], name))
+        elif lower.startswith('.'):
+            func_suffixes.append((lower, name))
+        elif lower.endswith('.*'):
+            func_prefixes.append((lower[:-1], name))
+        else:
+            func_exact[lower] = name
+            if '.' not in lower:
+                func_simple.setdefault(lower, name)
+            elif lower.startswith('builtins.'):
+                func_simple.setdefault(lower.rsplit('.', 1)[-1], name)
+    for item in cfg.get('modules') or []:
+        name = item.strip()
+        lower = name.lower()
+        if lower.endswith('.*'):
+            lower = lower[:-2]
+        module_map.setdefault(lower, name)
+    for key, value in (cfg.get('notes') or {}).items():
+        notes[key.lower()] = value
+    for rel in cfg.get('student_files') or []:
+        student.add(os.path.normpath(os.path.join(ROOT, rel)))
+
+
+def match_function(full, simple):
+    if full in func_exact:
+        return func_exact[full]
+    if simple is not None and simple in func_simple:
+        return func_simple[simple]
+    for prefix, rule in func_prefixes:
+        if full.startswith(prefix):
+            return rule
+    for suffix, rule in func_suffixes:
+        if full.endswith(suffix):
+            return rule
+    return None
+
+
+def match_module(name):
+    current = name.lower()
+    while current:
+        if current in module_map:
+            return module_map[current]
+        current = current.rsplit('.', 1)[0] if '.' in current else ''
+    return None
+
+
+def origin(frame, skip_importlib=False):
+    # Dynamic code (eval/exec of strings) is attributed to whoever ran it.
+    while frame is not None:
+        name = frame.f_code.co_filename
+        if name == SELF or (name.startswith('<') and (skip_importlib or not name.startswith('<frozen'))):
+            frame = frame.f_back
+            continue
+        return frame
+    return None
+
+
+def student_path(frame):
+    if frame is None:
+        return None
+    name = frame.f_code.co_filename
+    if not os.path.isabs(name):
+        name = os.path.join(ROOT, name)
+    name = os.path.normpath(name)
+    return name if name in student else None
+
+
+def report(kind, rule, symbol, frame):
+    global tripped
+    tripped = True
+    try:
+        sys.setprofile(None)
+        threading.setprofile(None)
+    except RuntimeError:
+        # reported from inside sys.setprofile; tripped silences the profiler
+        pass
+    path = student_path(frame)
+    result = {
+        'kind': kind,
+        'name': rule,
+        'symbol': symbol,
+        'file': os.path.relpath(path, ROOT) if path else '',
+        'line': frame.f_lineno if frame is not None else 0,
+        'column': 0,
+        'note': notes.get(rule.lower()) or (TAMPER_NOTE if rule in TAMPER else ''),
+    }
+    try:
+        sys.stdout.flush()
+    except Exception:
+        pass
+    err = sys.__stderr__
+    err.write('\n' + MARKER + json.dumps(result) + '\n')
+    err.flush()
+    os._exit(EXIT_CODE)
+
+
+def check_import(name, fromlist, frame):
+    caller = origin(frame)
+    if student_path(caller) is None:
+        return
+    rule = match_module(name)
+    if rule:
+        report('module_import', rule, name, caller)
+    for item in fromlist or ():
+        if not isinstance(item, str) or item == '*':
+            continue
+        rule = match_function((name + '.' + item).lower(), item.lower())
+        if rule:
+            report('function_import', rule, name + '.' + item, caller)
+
+
+def guarded_import(name, globals=None, locals=None, fromlist=(), level=0):
+    if not tripped and level == 0:
+        check_import(name, fromlist, sys._getframe(1))
+    return orig_import(name, globals, locals, fromlist, level)
+
+
+def guarded_import_module(name, package=None):
+    if not tripped and not name.startswith('.'):
+        check_import(name, (), sys._getframe(1))
+    return orig_import_module(name, package)
+
+
+def audit(event, args):
+    # the guard itself calls sys._getframe, which is audited too
+    if tripped or event == 'sys._getframe':
+        return
+    if event == 'import':
+        rule = match_module(str(args[0]))
+        if rule:
+            caller = origin(sys._getframe(1), skip_importlib=True)
+            if student_path(caller):
+                report('module_import', rule, str(args[0]), caller)
+        return
+    if profiling and event in TAMPER:
+        caller = origin(sys._getframe(1))
+        if student_path(caller):
+            report('function_call', event, event, caller)
+        return
+    lower = event.lower()
+    rule = match_function(lower, lower if '.' not in lower else None)
+    if rule:
+        caller = origin(sys._getframe(1))
+        if student_path(caller):
+            report('function_call', rule, event, caller)
+
+
+def describe_builtin(fn):
+    owner = getattr(fn, '__self__', None)
+    name = getattr(fn, '__name__', '')
+    if owner is None or type(owner).__name__ == 'module':
+        module = getattr(fn, '__module__', None) or getattr(owner, '__name__', None) or 'builtins'
+    else:
+        module = type(owner).__name__ if not isinstance(owner, type) else owner.__name__
+    module = ALIASES.get(module, module)
+    return module + '.' + name, name if module == 'builtins' else None
+
+
+def profile(frame, event, arg):
+    if tripped:
+        return
+    if event == 'c_call':
+        key = (getattr(arg, '__module__', None), type(getattr(arg, '__self__', None)), getattr(arg, '__name__', ''))
+        if key not in cache:
+            full, simple = describe_builtin(arg)
+            cache[key] = (match_function(full.lower(), simple and simple.lower()), full)
+        caller = frame
+    elif event == 'call':
+        code = frame.f_code
+        if code not in cache:
+            rule, full = None, ''
+            if student_path(frame) is None and code.co_filename != SELF:
+                module = ALIASES.get(frame.f_globals.get('__name__', ''), frame.f_globals.get('__name__', ''))
+                qual = getattr(code, 'co_qualname', code.co_name)
+                for suffix in ('', '.__init__', '.__new__'):
+                    if suffix and not qual.endswith(suffix):
+                        continue
+                    full = module + '.' + (qual[:-len(suffix)] if suffix else qual)
+                    rule = match_function(full.lower(), None)
+                    if rule:
+                        break
+                if rule is None and module + '.' + qual in TAMPER:
+                    rule = full = module + '.' + qual
+            cache[code] = (rule, full)
+        caller = frame.f_back
+    else:
+        return
+    rule, full = cache[key if event == 'c_call' else code]
+    if rule:
+        caller = origin(caller)
+        if student_path(caller):
+            report('function_call', rule, full, caller)
+
+
+def install():
+    load_rules()
+    builtins.__import__ = guarded_import
+    importlib.import_module = guarded_import_module
+    sys.addaudithook(audit)
+    if func_exact or func_prefixes or func_suffixes:
+        global profiling
+        sys.setprofile(profile)
+        threading.setprofile(profile)
+        profiling = True
+`
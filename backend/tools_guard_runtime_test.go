@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitToolGuardOutput(t *testing.T) {
+	stderr := "warning\n" + toolGuardMarker + `{"kind":"function_call","name":"sorted","symbol":"builtins.sorted","file":"main.py","line":3,"column":0,"note":"write your own sort"}` + "\n"
+	rest, report := splitToolGuardOutput(stderr)
+	if report == nil || report.Name != "sorted" || report.Line != 3 || rest != "warning" {
+		t.Fatalf("got %q %+v", rest, report)
+	}
+	msg := toolGuardMessage(report)
+	if !strings.Contains(msg, `called banned function "builtins.sorted" (rule "sorted") at main.py:3`) || !strings.Contains(msg, "write your own sort") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	if rest, report := splitToolGuardOutput("Traceback ...\n"); report != nil || rest != "Traceback ...\n" {
+		t.Fatalf("unexpected report for plain stderr")
+	}
+}
+
+func TestRuntimeToolGuardEnabled(t *testing.T) {
+	rules := `{"mode":"advanced","advanced":["sorted"],"runtime_guard":true}`
+	a := &Assignment{BannedFunctions: []string{"sorted"}, BannedToolRules: &rules}
+	if !runtimeToolGuardEnabled(a) {
+		t.Fatalf("guard should be enabled")
+	}
+	a.BannedFunctions = nil
+	if runtimeToolGuardEnabled(a) {
+		t.Fatalf("guard without rules should stay off")
+	}
+	cfg := cloneConfig(&BannedToolsConfig{Advanced: []string{"os.*"}, RuntimeGuard: true})
+	if raw, _ := serializeBannedToolsConfig(cfg); raw == nil || !strings.Contains(*raw, `"runtime_guard":true`) {
+		t.Fatalf("runtime_guard not kept: %v", raw)
+	}
+}
+
+// TestToolGuardBlocksDynamicUse runs submissions that dodge the static
+// analysis through every Python runner with the guard staged.
+func TestToolGuardBlocksDynamicUse(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		stage := func(t *testing.T, code string) string {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.py": code})
+			if err := writeToolGuard(dir, []string{"sorted", "os.system"}, []string{"subprocess"}, map[string]string{"sorted": "no built-in sorting"}); err != nil {
+				t.Fatal(err)
+			}
+			return dir
+		}
+		cases := []struct {
+			code string
+			rule string
+			line int
+		}{
+			{"import builtins\nprint(getattr(builtins, 'sor' + 'ted')([2, 1]))\n", "sorted", 2},
+			{"x = 1\nm = __import__('subprocess')\n", "subprocess", 2},
+			{"import importlib\nos = importlib.import_module('os')\nos.system('true')\n", "os.system", 3},
+			{"print(eval('sorted([3, 1])'))\n", "sorted", 1},
+			{"try:\n    exec('import subprocess')\nexcept BaseException:\n    print('caught')\n", "subprocess", 2},
+			{"import sys; sys.setprofile(None); print(sorted([3,1,2]))\n", "sys.setprofile", 1},
+			{"import threading\nthreading.setprofile(None)\n", "threading.setprofile", 2},
+			{"import sys\nsys.settrace(lambda *a: None)\n", "sys.settrace", 2},
+		}
+		for _, tc := range cases {
+			dir := stage(t, tc.code)
+			out, stderr, _, _, _, _ := executePythonDir(nil, dir, "main.py", "", 20*time.Second, 0)
+			_, report := splitToolGuardOutput(stderr)
+			if report == nil || report.Name != tc.rule || report.File != "main.py" || report.Line != tc.line {
+				t.Errorf("%q: stdout=%q stderr=%q report=%+v", tc.code, out, stderr, report)
+			}
+		}
+
+		dir := stage(t, "import json\nprint(json.dumps({'a': 1}, sort_keys=True))\n")
+		out, stderr, code, _, _, _ := executePythonDir(nil, dir, "main.py", "", 20*time.Second, 0)
+		if code != 0 || out != `{"a": 1}` {
+			t.Fatalf("allowed program: stdout=%q stderr=%q code=%d", out, stderr, code)
+		}
+
+		dir = stage(t, "name = input('Name? ')\nprint(sorted(name))\n")
+		script := `[{"expect":"Name\\? $"},{"send":"bca"},{"expect":"abc"}]`
+		run := runDialogueTest(nil, dir, program{rt: pythonRuntime{}, entry: "main.py"}, TestCase{DialogueScript: &script}, 20*time.Second)
+		if _, report := splitToolGuardOutput(run.Stderr); run.Passed || report == nil || report.Name != "sorted" || report.Line != 2 {
+			t.Fatalf("dialogue runner: passed=%v stderr=%q", run.Passed, run.Stderr)
+		}
+
+		dir = stage(t, "import builtins\n\ndef solve(xs):\n    return getattr(builtins, 'sorted')(xs)\n")
+		_, stderr, _, _, _, _, _, err := runFunctionCall(nil, dir, "main.py", functionCallConfig{FunctionName: "solve", ArgsJSON: strPtr("[[2, 1]]")}, 20*time.Second, 0)
+		if _, report := splitToolGuardOutput(stderr); err != nil || report == nil || report.Line != 4 {
+			t.Fatalf("function runner: err=%v stderr=%q", err, stderr)
+		}
+
+		dir = stage(t, "def solve(xs):\n    return sorted(xs)\n")
+		test := "class T(unittest.TestCase):\n    def test_solve(self):\n        self.assertEqual(student_function('solve', [2, 1]), [1, 2])\n"
+		_, stderr, _, _, _, _ = executePythonUnit(nil, dir, "main.py", test, "T.test_solve", "", 20*time.Second, 0)
+		if _, report := splitToolGuardOutput(stderr); report == nil || report.Name != "sorted" || report.Line != 2 {
+			t.Fatalf("unit runner: stderr=%q", stderr)
+		}
+	})
+}
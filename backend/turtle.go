@@ -0,0 +1,1165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Turtle tests grade drawings made with Python's turtle module. A headless
+// turtle.py is staged in front of the standard library one; it keeps the
+// turtle's position and heading like the real module, records the lines,
+// filled shapes and dots drawn, and prints the trace after the program ends.
+// The submission's trace is compared with the reference solution's on the
+// same stdin, within a distance tolerance and optionally in any order, and
+// both drawings are rendered to PNGs attached to the result.
+
+const (
+	turtleShimFile = "turtle.py"
+	turtleMarker   = "===TURTLE_TRACE==="
+	// turtleMaxItems bounds the lines, fills and dots the shim records.
+	turtleMaxItems = 20000
+
+	turtleMaxTolerance = 50
+	turtleImageSize    = 400
+	turtleImageMargin  = 20
+)
+
+// TurtleConfig is stored as JSON in test_cases.turtle_config.
+type TurtleConfig struct {
+	// Tolerance is how far (in turtle units) points may be from the
+	// reference drawing.
+	Tolerance float64 `json:"tolerance"`
+	// IgnoreOrder accepts the lines, fills and dots drawn in any order and
+	// direction, as long as the picture is the same.
+	IgnoreOrder  bool `json:"ignore_order"`
+	IgnoreColors bool `json:"ignore_colors"`
+	IgnoreFills  bool `json:"ignore_fills"`
+}
+
+func parseTurtleConfig(raw *string) (*TurtleConfig, error) {
+	cfg := &TurtleConfig{}
+	if raw != nil && strings.TrimSpace(*raw) != "" {
+		dec := json.NewDecoder(strings.NewReader(*raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("turtle_config must be a JSON object: %v", err)
+		}
+	}
+	if cfg.Tolerance == 0 {
+		cfg.Tolerance = 1
+	}
+	if cfg.Tolerance < 0 || cfg.Tolerance > turtleMaxTolerance {
+		return nil, fmt.Errorf("tolerance must be between 0 and %d", turtleMaxTolerance)
+	}
+	return cfg, nil
+}
+
+// validateTurtleTest checks a turtle test at authoring time and returns the
+// normalized config to store.
+func validateTurtleTest(raw *string) (*string, error) {
+	cfg, err := parseTurtleConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
+// stageTurtleShim puts the headless turtle module where the Python runner
+// imports from first: the workspace root, which the runner runs in.
+func stageTurtleShim(dir, mainFile string) error {
+	if filepath.Clean(mainFile) == turtleShimFile {
+		return errors.New("the main file must not be called turtle.py: it would hide the turtle module")
+	}
+	if err := os.WriteFile(filepath.Join(dir, turtleShimFile), []byte(turtleShim), 0644); err != nil {
+		return fmt.Errorf("write turtle shim: %w", err)
+	}
+	return nil
+}
+
+type turtlePoint [2]float64
+
+type turtleSegment struct {
+	From  turtlePoint `json:"from"`
+	To    turtlePoint `json:"to"`
+	Color string      `json:"color"`
+	Width float64     `json:"width"`
+}
+
+type turtleFill struct {
+	Points []turtlePoint `json:"points"`
+	Color  string        `json:"color"`
+}
+
+type turtleDot struct {
+	At    turtlePoint `json:"at"`
+	Size  float64     `json:"size"`
+	Color string      `json:"color"`
+}
+
+type turtleTrace struct {
+	Segments   []turtleSegment `json:"segments"`
+	Fills      []turtleFill    `json:"fills"`
+	Dots       []turtleDot     `json:"dots"`
+	Background string          `json:"background"`
+	Truncated  bool            `json:"truncated"`
+}
+
+// splitTurtleTrace separates the program's own output from the trace the
+// shim printed. A program that never imported turtle drew nothing.
+func splitTurtleTrace(stdout string) (string, *turtleTrace, error) {
+	idx := strings.LastIndex(stdout, turtleMarker)
+	if idx == -1 {
+		return stdout, &turtleTrace{}, nil
+	}
+	trace := &turtleTrace{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stdout[idx+len(turtleMarker):])), trace); err != nil {
+		return stdout[:idx], nil, fmt.Errorf("invalid turtle trace: %w", err)
+	}
+	return strings.TrimRight(stdout[:idx], "\n"), trace, nil
+}
+
+type turtleRun struct {
+	Status   string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Runtime  time.Duration
+	Memory   memoryUsage
+	// Problem reports a failure of the reference solution.
+	Problem   string
+	Message   string
+	Artifacts []ResultArtifact
+}
+
+func runTurtleTest(sess *vmSession, workDir string, prog program, tc TestCase, timeout time.Duration, ref *referenceSandbox) turtleRun {
+	cfg, err := parseTurtleConfig(tc.TurtleConfig)
+	if err != nil {
+		return turtleRun{ExitCode: -1, Problem: err.Error()}
+	}
+	var run turtleRun
+	var stdout string
+	var actual *turtleTrace
+	stdout, run.Stderr, run.ExitCode, run.TimedOut, run.Runtime, run.Memory = prog.rt.runStdin(sess, workDir, prog.entry, tc.Stdin, timeout, tc.MemoryLimitKB)
+	run.Stdout, actual, err = splitTurtleTrace(stdout)
+	switch {
+	case run.TimedOut:
+		run.Status = "time_limit_exceeded"
+		return run
+	case run.Memory.LimitExceeded:
+		run.Status = "memory_limit_exceeded"
+		return run
+	case run.ExitCode != 0:
+		run.Status = "runtime_error"
+		return run
+	case err != nil:
+		run.Status = "runtime_error"
+		run.Stderr = strings.TrimSpace(run.Stderr + "\n" + err.Error())
+		return run
+	}
+
+	if ref == nil {
+		run.Problem = "turtle tests are not available here"
+		return run
+	}
+	if err := ref.load(); err != nil {
+		run.Problem = err.Error()
+		return run
+	}
+	if !ref.present {
+		run.Problem = "turtle tests need a reference solution"
+		return run
+	}
+	refOut, _, err := ref.runStdin(tc, tc.Stdin, timeout)
+	if err != nil {
+		run.Problem = err.Error()
+		return run
+	}
+	_, expected, err := splitTurtleTrace(refOut)
+	if err != nil {
+		run.Problem = "reference solution: " + err.Error()
+		return run
+	}
+
+	run.Artifacts = renderTurtleArtifacts(expected, actual)
+	if msg := compareTurtleTraces(expected, actual, cfg); msg != "" {
+		run.Status = "wrong_output"
+		run.Message = "The drawing differs from the reference: " + msg
+	} else {
+		run.Status = "passed"
+		run.Message = "The drawing matches the reference."
+	}
+	if expected.Truncated || actual.Truncated {
+		run.Message += fmt.Sprintf("\nOnly the first %d items of each kind were recorded.", turtleMaxItems)
+	}
+	return run
+}
+
+func turtleStatus(run turtleRun) string {
+	if run.Problem != "" {
+		return "checker_error"
+	}
+	return run.Status
+}
+
+// compareTurtleTraces returns a description of the first difference, or ""
+// when the drawings match.
+func compareTurtleTraces(expected, actual *turtleTrace, cfg *TurtleConfig) string {
+	want, got := mergeTurtleSegments(expected.Segments), mergeTurtleSegments(actual.Segments)
+	var msg string
+	if cfg.IgnoreOrder {
+		msg = compareTurtleCoverage(want, got, cfg)
+	} else {
+		msg = compareTurtleSequence(want, got, cfg)
+	}
+	if msg != "" {
+		return msg
+	}
+	if !cfg.IgnoreFills {
+		if msg := compareTurtleShapes(expected.Fills, actual.Fills, cfg, "filled shape", fillsMatch, func(f turtleFill) string {
+			return fmt.Sprintf("%s near %s", f.Color, formatTurtlePoint(polygonCentroid(f.Points)))
+		}); msg != "" {
+			return msg
+		}
+	}
+	return compareTurtleShapes(expected.Dots, actual.Dots, cfg, "dot", dotsMatch, func(d turtleDot) string {
+		return fmt.Sprintf("%s of size %s at %s", d.Color, formatTurtleNumber(d.Size), formatTurtlePoint(d.At))
+	})
+}
+
+func formatTurtleNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+func formatTurtlePoint(p turtlePoint) string {
+	return "(" + formatTurtleNumber(p[0]) + ", " + formatTurtleNumber(p[1]) + ")"
+}
+
+func turtleDistance(a, b turtlePoint) float64 {
+	return math.Hypot(a[0]-b[0], a[1]-b[1])
+}
+
+// mergeTurtleSegments drops empty segments and joins consecutive collinear
+// ones of the same pen, so that forward(50) twice draws the same line as
+// forward(100).
+func mergeTurtleSegments(segs []turtleSegment) []turtleSegment {
+	var out []turtleSegment
+	for _, s := range segs {
+		if turtleDistance(s.From, s.To) < 1e-9 {
+			continue
+		}
+		if n := len(out); n > 0 {
+			last := &out[n-1]
+			if last.To == s.From && last.Color == s.Color && last.Width == s.Width {
+				d1 := turtlePoint{last.To[0] - last.From[0], last.To[1] - last.From[1]}
+				d2 := turtlePoint{s.To[0] - s.From[0], s.To[1] - s.From[1]}
+				l1, l2 := math.Hypot(d1[0], d1[1]), math.Hypot(d2[0], d2[1])
+				cross := d1[0]*d2[1] - d1[1]*d2[0]
+				if d1[0]*d2[0]+d1[1]*d2[1] > 0 && math.Abs(cross) <= 1e-3*l1*l2 {
+					last.To = s.To
+					continue
+				}
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func describeTurtleSegment(s turtleSegment) string {
+	return fmt.Sprintf("%s → %s in %s", formatTurtlePoint(s.From), formatTurtlePoint(s.To), s.Color)
+}
+
+func compareTurtleSequence(want, got []turtleSegment, cfg *TurtleConfig) string {
+	for i := 0; i < len(want) && i < len(got); i++ {
+		w, g := want[i], got[i]
+		if turtleDistance(w.From, g.From) > cfg.Tolerance || turtleDistance(w.To, g.To) > cfg.Tolerance ||
+			(!cfg.IgnoreColors && w.Color != g.Color) {
+			return fmt.Sprintf("line %d should go %s, it goes %s.", i+1, describeTurtleSegment(w), describeTurtleSegment(g))
+		}
+	}
+	switch {
+	case len(got) < len(want):
+		return fmt.Sprintf("the reference draws %d lines, the drawing only %d; the next one goes %s.", len(want), len(got), describeTurtleSegment(want[len(got)]))
+	case len(got) > len(want):
+		return fmt.Sprintf("the reference draws %d lines, the drawing %d; the first extra one goes %s.", len(want), len(got), describeTurtleSegment(got[len(want)]))
+	}
+	return ""
+}
+
+// segmentIndex buckets segments into a grid so that coverage checks only
+// look at the segments near a point.
+type segmentIndex struct {
+	cell  float64
+	cells map[[2]int][]int
+	segs  []turtleSegment
+}
+
+func newSegmentIndex(segs []turtleSegment, tol float64) *segmentIndex {
+	idx := &segmentIndex{cell: math.Max(4*tol, 10), cells: map[[2]int][]int{}, segs: segs}
+	for i, s := range segs {
+		x0, x1 := math.Min(s.From[0], s.To[0])-tol, math.Max(s.From[0], s.To[0])+tol
+		y0, y1 := math.Min(s.From[1], s.To[1])-tol, math.Max(s.From[1], s.To[1])+tol
+		for cx := idx.key(x0); cx <= idx.key(x1); cx++ {
+			for cy := idx.key(y0); cy <= idx.key(y1); cy++ {
+				idx.cells[[2]int{cx, cy}] = append(idx.cells[[2]int{cx, cy}], i)
+			}
+		}
+	}
+	return idx
+}
+
+func (idx *segmentIndex) key(v float64) int {
+	return int(math.Floor(v / idx.cell))
+}
+
+// near returns the segments within tol of p, closest first.
+func (idx *segmentIndex) near(p turtlePoint, tol float64) []turtleSegment {
+	var out []turtleSegment
+	var dists []float64
+	for _, i := range idx.cells[[2]int{idx.key(p[0]), idx.key(p[1])}] {
+		if d := pointSegmentDistance(p, idx.segs[i]); d <= tol {
+			out = append(out, idx.segs[i])
+			dists = append(dists, d)
+		}
+	}
+	sort.Sort(byDistance{out, dists})
+	return out
+}
+
+type byDistance struct {
+	segs  []turtleSegment
+	dists []float64
+}
+
+func (b byDistance) Len() int           { return len(b.segs) }
+func (b byDistance) Less(i, j int) bool { return b.dists[i] < b.dists[j] }
+func (b byDistance) Swap(i, j int) {
+	b.segs[i], b.segs[j] = b.segs[j], b.segs[i]
+	b.dists[i], b.dists[j] = b.dists[j], b.dists[i]
+}
+
+func pointSegmentDistance(p turtlePoint, s turtleSegment) float64 {
+	dx, dy := s.To[0]-s.From[0], s.To[1]-s.From[1]
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return turtleDistance(p, s.From)
+	}
+	t := math.Max(0, math.Min(1, ((p[0]-s.From[0])*dx+(p[1]-s.From[1])*dy)/l2))
+	return turtleDistance(p, turtlePoint{s.From[0] + t*dx, s.From[1] + t*dy})
+}
+
+// uncoveredPoint walks along segs and returns the first point with no line
+// of other within the tolerance; with colors checked a nearby line of
+// another color is returned as well.
+func uncoveredPoint(segs []turtleSegment, other *segmentIndex, cfg *TurtleConfig) (turtlePoint, *turtleSegment, *turtleSegment, bool) {
+	step := math.Max(cfg.Tolerance/2, 0.5)
+	for i := range segs {
+		s := segs[i]
+		length := turtleDistance(s.From, s.To)
+		n := int(math.Min(math.Ceil(length/step), 1000))
+		for k := 0; k <= n; k++ {
+			t := float64(k) / float64(max(n, 1))
+			p := turtlePoint{s.From[0] + t*(s.To[0]-s.From[0]), s.From[1] + t*(s.To[1]-s.From[1])}
+			near := other.near(p, cfg.Tolerance)
+			if len(near) == 0 {
+				return p, &segs[i], nil, true
+			}
+			if cfg.IgnoreColors {
+				continue
+			}
+			matched := false
+			for _, o := range near {
+				if o.Color == s.Color {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return p, &segs[i], &near[0], true
+			}
+		}
+	}
+	return turtlePoint{}, nil, nil, false
+}
+
+// compareTurtleCoverage checks that every line of each drawing lies on a
+// line of the other, whatever order and direction they were drawn in.
+func compareTurtleCoverage(want, got []turtleSegment, cfg *TurtleConfig) string {
+	if p, s, other, ok := uncoveredPoint(want, newSegmentIndex(got, cfg.Tolerance), cfg); ok {
+		if other != nil {
+			return fmt.Sprintf("the line at %s should be %s, it is %s.", formatTurtlePoint(p), s.Color, other.Color)
+		}
+		return fmt.Sprintf("the line %s of the reference is missing near %s.", describeTurtleSegment(*s), formatTurtlePoint(p))
+	}
+	if p, s, _, ok := uncoveredPoint(got, newSegmentIndex(want, cfg.Tolerance), cfg); ok {
+		return fmt.Sprintf("the drawing has an extra line %s (near %s).", describeTurtleSegment(*s), formatTurtlePoint(p))
+	}
+	return ""
+}
+
+// compareTurtleShapes compares fills or dots: pairwise in order, or as
+// multisets with ignore_order.
+func compareTurtleShapes[T any](want, got []T, cfg *TurtleConfig, noun string, match func(a, b T, cfg *TurtleConfig) bool, describe func(T) string) string {
+	if !cfg.IgnoreOrder {
+		for i := 0; i < len(want) && i < len(got); i++ {
+			if !match(want[i], got[i], cfg) {
+				return fmt.Sprintf("%s %d should be %s, it is %s.", noun, i+1, describe(want[i]), describe(got[i]))
+			}
+		}
+		switch {
+		case len(got) < len(want):
+			return fmt.Sprintf("the %s %s is missing.", noun, describe(want[len(got)]))
+		case len(got) > len(want):
+			return fmt.Sprintf("the drawing has an extra %s %s.", noun, describe(got[len(want)]))
+		}
+		return ""
+	}
+	used := make([]bool, len(got))
+	for _, w := range want {
+		found := false
+		for j, g := range got {
+			if !used[j] && match(w, g, cfg) {
+				used[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("the %s %s is missing.", noun, describe(w))
+		}
+	}
+	for j, g := range got {
+		if !used[j] {
+			return fmt.Sprintf("the drawing has an extra %s %s.", noun, describe(g))
+		}
+	}
+	return ""
+}
+
+func polygonArea(pts []turtlePoint) float64 {
+	var a float64
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		a += pts[i][0]*pts[j][1] - pts[j][0]*pts[i][1]
+	}
+	return math.Abs(a) / 2
+}
+
+func polygonPerimeter(pts []turtlePoint) float64 {
+	var p float64
+	for i := range pts {
+		p += turtleDistance(pts[i], pts[(i+1)%len(pts)])
+	}
+	return p
+}
+
+// polygonCentroid is the mean of the vertices, which is stable enough to
+// tell shapes apart and to point at them.
+func polygonCentroid(pts []turtlePoint) turtlePoint {
+	var c turtlePoint
+	for _, p := range pts {
+		c[0] += p[0]
+		c[1] += p[1]
+	}
+	if len(pts) > 0 {
+		c[0] /= float64(len(pts))
+		c[1] /= float64(len(pts))
+	}
+	return c
+}
+
+func polygonBounds(pts []turtlePoint) (turtlePoint, turtlePoint) {
+	lo, hi := turtlePoint{math.Inf(1), math.Inf(1)}, turtlePoint{math.Inf(-1), math.Inf(-1)}
+	for _, p := range pts {
+		lo = turtlePoint{math.Min(lo[0], p[0]), math.Min(lo[1], p[1])}
+		hi = turtlePoint{math.Max(hi[0], p[0]), math.Max(hi[1], p[1])}
+	}
+	return lo, hi
+}
+
+// fillsMatch compares filled shapes by color, extent and area rather than
+// vertex by vertex, so the starting corner and direction do not matter.
+func fillsMatch(a, b turtleFill, cfg *TurtleConfig) bool {
+	if !cfg.IgnoreColors && a.Color != b.Color {
+		return false
+	}
+	alo, ahi := polygonBounds(a.Points)
+	blo, bhi := polygonBounds(b.Points)
+	if turtleDistance(alo, blo) > cfg.Tolerance || turtleDistance(ahi, bhi) > cfg.Tolerance {
+		return false
+	}
+	slack := cfg.Tolerance * math.Max(polygonPerimeter(a.Points), polygonPerimeter(b.Points))
+	return math.Abs(polygonArea(a.Points)-polygonArea(b.Points)) <= slack+1e-9
+}
+
+func dotsMatch(a, b turtleDot, cfg *TurtleConfig) bool {
+	return turtleDistance(a.At, b.At) <= cfg.Tolerance && math.Abs(a.Size-b.Size) <= cfg.Tolerance &&
+		(cfg.IgnoreColors || a.Color == b.Color)
+}
+
+// renderTurtleArtifacts draws both traces at the same scale.
+func renderTurtleArtifacts(expected, actual *turtleTrace) []ResultArtifact {
+	v := newTurtleViewport(expected, actual)
+	var out []ResultArtifact
+	for _, item := range []struct {
+		name, label string
+		trace       *turtleTrace
+	}{
+		{"turtle_expected.png", "Expected drawing", expected},
+		{"turtle_actual.png", "Your drawing", actual},
+	} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, v.render(item.trace)); err != nil {
+			continue
+		}
+		out = append(out, ResultArtifact{Name: item.name, Label: item.label, ContentType: "image/png", Data: base64.StdEncoding.EncodeToString(buf.Bytes())})
+	}
+	return out
+}
+
+// turtleViewport maps turtle coordinates (y up) to image pixels (y down).
+type turtleViewport struct {
+	minX, minY, scale, offX, offY float64
+}
+
+func newTurtleViewport(traces ...*turtleTrace) turtleViewport {
+	lo, hi := turtlePoint{math.Inf(1), math.Inf(1)}, turtlePoint{math.Inf(-1), math.Inf(-1)}
+	extend := func(p turtlePoint, r float64) {
+		lo = turtlePoint{math.Min(lo[0], p[0]-r), math.Min(lo[1], p[1]-r)}
+		hi = turtlePoint{math.Max(hi[0], p[0]+r), math.Max(hi[1], p[1]+r)}
+	}
+	for _, t := range traces {
+		for _, s := range t.Segments {
+			extend(s.From, 0)
+			extend(s.To, 0)
+		}
+		for _, f := range t.Fills {
+			for _, p := range f.Points {
+				extend(p, 0)
+			}
+		}
+		for _, d := range t.Dots {
+			extend(d.At, d.Size/2)
+		}
+	}
+	if math.IsInf(lo[0], 1) {
+		lo, hi = turtlePoint{-100, -100}, turtlePoint{100, 100}
+	}
+	span := float64(turtleImageSize - 2*turtleImageMargin)
+	w, h := math.Max(hi[0]-lo[0], 1), math.Max(hi[1]-lo[1], 1)
+	scale := math.Min(4, math.Min(span/w, span/h))
+	return turtleViewport{
+		minX: lo[0], minY: lo[1], scale: scale,
+		offX: turtleImageMargin + (span-w*scale)/2,
+		offY: turtleImageMargin + (span-h*scale)/2,
+	}
+}
+
+func (v turtleViewport) pixel(p turtlePoint) (float64, float64) {
+	return v.offX + (p[0]-v.minX)*v.scale, turtleImageSize - (v.offY + (p[1]-v.minY)*v.scale)
+}
+
+func (v turtleViewport) render(t *turtleTrace) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, turtleImageSize, turtleImageSize))
+	bg := parseTurtleColor(t.Background, color.RGBA{255, 255, 255, 255})
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, 255
+	}
+	black := color.RGBA{0, 0, 0, 255}
+	for _, f := range t.Fills {
+		pts := make([][2]float64, len(f.Points))
+		for i, p := range f.Points {
+			pts[i][0], pts[i][1] = v.pixel(p)
+		}
+		fillPolygon(img, pts, parseTurtleColor(f.Color, black))
+	}
+	for _, s := range t.Segments {
+		x0, y0 := v.pixel(s.From)
+		x1, y1 := v.pixel(s.To)
+		r := math.Max(0.5, s.Width*v.scale/2)
+		c := parseTurtleColor(s.Color, black)
+		n := int(math.Ceil(math.Hypot(x1-x0, y1-y0)))
+		for k := 0; k <= n; k++ {
+			t := float64(k) / float64(max(n, 1))
+			fillDisc(img, x0+t*(x1-x0), y0+t*(y1-y0), r, c)
+		}
+	}
+	for _, d := range t.Dots {
+		x, y := v.pixel(d.At)
+		fillDisc(img, x, y, math.Max(0.5, d.Size*v.scale/2), parseTurtleColor(d.Color, black))
+	}
+	return img
+}
+
+// parseTurtleColor reads the #rrggbb colors the shim records; color names
+// it does not know are drawn in fallback.
+func parseTurtleColor(s string, fallback color.RGBA) color.RGBA {
+	if len(s) != 7 || s[0] != '#' {
+		return fallback
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return fallback
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}
+}
+
+func fillDisc(img *image.RGBA, cx, cy, r float64, c color.RGBA) {
+	for y := int(math.Floor(cy - r)); y <= int(math.Ceil(cy+r)); y++ {
+		for x := int(math.Floor(cx - r)); x <= int(math.Ceil(cx+r)); x++ {
+			if (float64(x)+0.5-cx)*(float64(x)+0.5-cx)+(float64(y)+0.5-cy)*(float64(y)+0.5-cy) <= r*r+0.25 {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// fillPolygon fills pts (in pixels) with the even-odd rule, like Tk does.
+func fillPolygon(img *image.RGBA, pts [][2]float64, c color.RGBA) {
+	if len(pts) < 3 {
+		return
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		sy := float64(y) + 0.5
+		var xs []float64
+		for i := range pts {
+			a, e := pts[i], pts[(i+1)%len(pts)]
+			if (a[1] <= sy) != (e[1] <= sy) {
+				xs = append(xs, a[0]+(sy-a[1])*(e[0]-a[0])/(e[1]-a[1]))
+			}
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			for x := max(b.Min.X, int(math.Ceil(xs[i]-0.5))); x < min(b.Max.X, int(math.Ceil(xs[i+1]-0.5))); x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// turtleShim replaces the turtle module. It covers the drawing part of the
+// API; window and event functions do nothing, and textinput/numinput read a
+// line from stdin instead of opening a dialog.
+var turtleShim = `"""Headless stand-in for the turtle module, used when grading."""
+import atexit as _atexit
+import json as _json
+import math as _math
+import sys as _sys
+
+_MARKER = '` + turtleMarker + `'
+_LIMIT = ` + strconv.Itoa(turtleMaxItems) + `
+_COLORS = {
+    'black': '#000000', 'white': '#ffffff', 'red': '#ff0000', 'green': '#00ff00',
+    'blue': '#0000ff', 'yellow': '#ffff00', 'cyan': '#00ffff', 'magenta': '#ff00ff',
+    'orange': '#ffa500', 'purple': '#a020f0', 'pink': '#ffc0cb', 'brown': '#a52a2a',
+    'gray': '#bebebe', 'grey': '#bebebe', 'lightgray': '#d3d3d3', 'lightgrey': '#d3d3d3',
+    'darkgray': '#a9a9a9', 'darkgrey': '#a9a9a9', 'darkgreen': '#006400', 'darkblue': '#00008b',
+    'darkred': '#8b0000', 'darkorange': '#ff8c00', 'lightblue': '#add8e6', 'lightgreen': '#90ee90',
+    'gold': '#ffd700', 'navy': '#000080', 'violet': '#ee82ee', 'maroon': '#b03060',
+    'skyblue': '#87ceeb', 'turquoise': '#40e0d0', 'indigo': '#4b0082', 'salmon': '#fa8072',
+    'tomato': '#ff6347', 'crimson': '#dc143c', 'olive': '#808000', 'teal': '#008080',
+    'silver': '#c0c0c0', 'beige': '#f5f5dc', 'chocolate': '#d2691e', 'coral': '#ff7f50',
+    'khaki': '#f0e68c', 'lavender': '#e6e6fa', 'orchid': '#da70d6', 'tan': '#d2b48c',
+    'forestgreen': '#228b22', 'royalblue': '#4169e1', 'hotpink': '#ff69b4', 'deeppink': '#ff1493',
+    'steelblue': '#4682b4', 'lime': '#00ff00',
+}
+
+_trace = {'segments': [], 'fills': [], 'dots': [], 'background': '#ffffff', 'truncated': False}
+_turtles = []
+
+
+class Terminator(Exception):
+    pass
+
+
+class TurtleGraphicsError(Exception):
+    pass
+
+
+class Vec2D(tuple):
+    def __new__(cls, x, y):
+        return tuple.__new__(cls, (x, y))
+
+    def __add__(self, other):
+        return Vec2D(self[0] + other[0], self[1] + other[1])
+
+    def __sub__(self, other):
+        return Vec2D(self[0] - other[0], self[1] - other[1])
+
+    def __mul__(self, other):
+        if isinstance(other, Vec2D):
+            return self[0] * other[0] + self[1] * other[1]
+        return Vec2D(self[0] * other, self[1] * other)
+
+    __rmul__ = __mul__
+
+    def __neg__(self):
+        return Vec2D(-self[0], -self[1])
+
+    def __abs__(self):
+        return _math.hypot(*self)
+
+    def __repr__(self):
+        return '(%.2f,%.2f)' % self
+
+
+def _noop(*args, **kwargs):
+    return None
+
+
+def _record(kind, item):
+    items = _trace[kind]
+    if len(items) >= _LIMIT:
+        _trace['truncated'] = True
+        return
+    items.append(item)
+
+
+def _point(x, y):
+    return [round(x, 3), round(y, 3)]
+
+
+def _color(args):
+    if len(args) == 1:
+        args = args[0]
+    if isinstance(args, str):
+        name = args.strip().lower().replace(' ', '')
+        if name.startswith('#'):
+            if len(name) == 4:
+                name = '#' + ''.join(c * 2 for c in name[1:])
+            if len(name) != 7:
+                raise TurtleGraphicsError('bad color string: %s' % args)
+            return name
+        return _COLORS.get(name, name)
+    try:
+        r, g, b = args
+    except (TypeError, ValueError):
+        raise TurtleGraphicsError('bad color arguments: %s' % (args,))
+    if _screen._colormode == 1.0:
+        r, g, b = r * 255, g * 255, b * 255
+    if not all(0 <= v <= 255 for v in (r, g, b)):
+        raise TurtleGraphicsError('bad color sequence: %s' % (args,))
+    return '#%02x%02x%02x' % (int(round(r)), int(round(g)), int(round(b)))
+
+
+class _Screen(object):
+    def __init__(self):
+        self._colormode = 1.0
+        self._mode = 'standard'
+
+    def bgcolor(self, *args):
+        if args:
+            _trace['background'] = _color(args)
+        return _trace['background']
+
+    def colormode(self, cmode=None):
+        if cmode is None:
+            return self._colormode
+        if cmode not in (1.0, 255):
+            raise TurtleGraphicsError('colormode must be 1.0 or 255')
+        self._colormode = float(cmode) if cmode == 1.0 else 255
+
+    def mode(self, mode=None):
+        if mode is None:
+            return self._mode
+        self._mode = mode.lower()
+        for t in _turtles:
+            t.reset()
+
+    def turtles(self):
+        return list(_turtles)
+
+    def clearscreen(self):
+        for kind in ('segments', 'fills', 'dots'):
+            del _trace[kind][:]
+        for t in _turtles:
+            t._reset_state()
+
+    clear = clearscreen
+
+    def resetscreen(self):
+        for t in _turtles:
+            t.reset()
+
+    reset = resetscreen
+
+    def window_width(self):
+        return 800
+
+    def window_height(self):
+        return 600
+
+    def screensize(self, canvwidth=None, canvheight=None, bg=None):
+        if bg is not None:
+            self.bgcolor(bg)
+        return (400, 300)
+
+    def textinput(self, title, prompt):
+        line = _sys.stdin.readline()
+        return line.rstrip('\n') if line else None
+
+    def numinput(self, title, prompt, default=None, minval=None, maxval=None):
+        line = _sys.stdin.readline()
+        return float(line) if line.strip() else default
+
+    def getshapes(self):
+        return ['arrow', 'blank', 'circle', 'classic', 'square', 'triangle', 'turtle']
+
+    def __getattr__(self, name):
+        if name.startswith('_'):
+            raise AttributeError(name)
+        return _noop
+
+
+_screen = _Screen()
+
+
+def Screen():
+    return _screen
+
+
+class Turtle(object):
+    def __init__(self, shape='classic', undobuffersize=1000, visible=True, **kwargs):
+        self._id = len(_turtles)
+        self._visible = visible
+        self._fullcircle = 360.0
+        self._reset_state()
+        _turtles.append(self)
+
+    def _reset_state(self):
+        self._x = 0.0
+        self._y = 0.0
+        self._angle = 90.0 if _screen._mode == 'logo' else 0.0
+        self._down = True
+        self._pencolor = '#000000'
+        self._fillcolor = '#000000'
+        self._width = 1.0
+        self._fill = None
+
+    def _unit(self):
+        return 360.0 / self._fullcircle
+
+    def _move(self, x, y, draw=True):
+        x, y = float(x), float(y)
+        if draw and self._down and (x, y) != (self._x, self._y):
+            _record('segments', {'from': _point(self._x, self._y), 'to': _point(x, y),
+                                 'color': self._pencolor, 'width': self._width, 'turtle': self._id})
+        self._x, self._y = x, y
+        if self._fill is not None:
+            self._fill.append(_point(x, y))
+
+    def forward(self, distance):
+        a = _math.radians(self._angle)
+        self._move(self._x + distance * _math.cos(a), self._y + distance * _math.sin(a))
+
+    fd = forward
+
+    def back(self, distance):
+        self.forward(-distance)
+
+    bk = backward = back
+
+    def left(self, angle):
+        self._angle += angle * self._unit()
+
+    lt = left
+
+    def right(self, angle):
+        self._angle -= angle * self._unit()
+
+    rt = right
+
+    def goto(self, x, y=None):
+        if y is None:
+            x, y = x
+        self._move(x, y)
+
+    setpos = setposition = goto
+
+    def teleport(self, x=None, y=None, fill_gap=False):
+        self._move(self._x if x is None else x, self._y if y is None else y, draw=False)
+
+    def setx(self, x):
+        self._move(x, self._y)
+
+    def sety(self, y):
+        self._move(self._x, y)
+
+    def setheading(self, to_angle):
+        a = to_angle * self._unit()
+        self._angle = 90.0 - a if _screen._mode == 'logo' else a
+
+    seth = setheading
+
+    def heading(self):
+        a = self._angle % 360.0
+        if _screen._mode == 'logo':
+            a = (90.0 - a) % 360.0
+        return round(a / self._unit(), 10)
+
+    def home(self):
+        self._move(0, 0)
+        self.setheading(0)
+
+    def circle(self, radius, extent=None, steps=None):
+        # the same polygon as the real turtle draws
+        if extent is None:
+            extent = self._fullcircle
+        if steps is None:
+            frac = abs(extent) / self._fullcircle
+            steps = 1 + int(min(11 + abs(radius) / 6.0, 59.0) * frac)
+        w = 1.0 * extent / steps
+        w2 = 0.5 * w
+        l = 2.0 * radius * _math.sin(_math.radians(w2 * self._unit()))
+        if radius < 0:
+            l, w, w2 = -l, -w, -w2
+        self.left(w2)
+        for _ in range(steps):
+            self.forward(l)
+            self.left(w)
+        self.left(-w2)
+
+    def dot(self, size=None, *color):
+        if not color and isinstance(size, (str, tuple)):
+            color, size = (size,), None
+        if size is None:
+            size = max(self._width + 4, self._width * 2)
+        _record('dots', {'at': _point(self._x, self._y), 'size': float(size),
+                         'color': _color(color) if color else self._pencolor, 'turtle': self._id})
+
+    def penup(self):
+        self._down = False
+
+    pu = up = penup
+
+    def pendown(self):
+        self._down = True
+
+    pd = down = pendown
+
+    def isdown(self):
+        return self._down
+
+    def pensize(self, width=None):
+        if width is None:
+            return self._width
+        self._width = float(width)
+
+    width = pensize
+
+    def pencolor(self, *args):
+        if not args:
+            return self._pencolor
+        self._pencolor = _color(args)
+
+    def fillcolor(self, *args):
+        if not args:
+            return self._fillcolor
+        self._fillcolor = _color(args)
+
+    def color(self, *args):
+        if not args:
+            return self._pencolor, self._fillcolor
+        if len(args) == 2 and not all(isinstance(a, (int, float)) for a in args):
+            self._pencolor, self._fillcolor = _color(args[:1]), _color(args[1:])
+        else:
+            self._pencolor = self._fillcolor = _color(args)
+
+    def pen(self, pen=None, **pendict):
+        if pen is None and not pendict:
+            return {'pendown': self._down, 'pencolor': self._pencolor, 'fillcolor': self._fillcolor,
+                    'pensize': self._width, 'shown': self._visible}
+        settings = dict(pen or {}, **pendict)
+        if 'pendown' in settings:
+            self._down = bool(settings['pendown'])
+        if 'pencolor' in settings:
+            self.pencolor(settings['pencolor'])
+        if 'fillcolor' in settings:
+            self.fillcolor(settings['fillcolor'])
+        if 'pensize' in settings:
+            self.pensize(settings['pensize'])
+
+    def begin_fill(self):
+        self._fill = [_point(self._x, self._y)]
+
+    def end_fill(self):
+        if self._fill is not None and len(self._fill) >= 3:
+            _record('fills', {'points': self._fill, 'color': self._fillcolor, 'turtle': self._id})
+        self._fill = None
+
+    def filling(self):
+        return self._fill is not None
+
+    def position(self):
+        return Vec2D(self._x, self._y)
+
+    pos = position
+
+    def xcor(self):
+        return self._x
+
+    def ycor(self):
+        return self._y
+
+    def distance(self, x, y=None):
+        if y is None:
+            x, y = x
+        return _math.hypot(x - self._x, y - self._y)
+
+    def towards(self, x, y=None):
+        if y is None:
+            x, y = x
+        a = _math.degrees(_math.atan2(y - self._y, x - self._x))
+        if _screen._mode == 'logo':
+            a = 90.0 - a
+        return round((a % 360.0) / self._unit(), 10)
+
+    def degrees(self, fullcircle=360.0):
+        self._fullcircle = float(fullcircle)
+
+    def radians(self):
+        self._fullcircle = 2 * _math.pi
+
+    def clear(self):
+        for kind in ('segments', 'fills', 'dots'):
+            _trace[kind][:] = [item for item in _trace[kind] if item['turtle'] != self._id]
+
+    def reset(self):
+        self.clear()
+        self._reset_state()
+
+    def speed(self, speed=None):
+        return 0 if speed is None else None
+
+    def hideturtle(self):
+        self._visible = False
+
+    ht = hideturtle
+
+    def showturtle(self):
+        self._visible = True
+
+    st = showturtle
+
+    def isvisible(self):
+        return self._visible
+
+    def stamp(self):
+        return 0
+
+    def getscreen(self):
+        return _screen
+
+    def getturtle(self):
+        return self
+
+    getpen = getturtle
+
+    def clone(self):
+        t = Turtle(visible=self._visible)
+        t.__dict__.update({k: v for k, v in self.__dict__.items() if k != '_id'})
+        t._fill = list(self._fill) if self._fill is not None else None
+        return t
+
+    def __getattr__(self, name):
+        if name.startswith('_'):
+            raise AttributeError(name)
+        return _noop
+
+
+Pen = RawTurtle = RawPen = Turtle
+
+_pen = []
+
+
+def _getpen():
+    if not _pen:
+        _pen.append(Turtle())
+    return _pen[0]
+
+
+def getturtle():
+    return _getpen()
+
+
+getpen = getturtle
+
+
+def _turtle_function(name):
+    def call(*args, **kwargs):
+        return getattr(_getpen(), name)(*args, **kwargs)
+    call.__name__ = name
+    return call
+
+
+def _screen_function(name):
+    def call(*args, **kwargs):
+        return getattr(_screen, name)(*args, **kwargs)
+    call.__name__ = name
+    return call
+
+
+for _name in ('forward', 'fd', 'back', 'bk', 'backward', 'left', 'lt', 'right', 'rt', 'goto',
+              'setpos', 'setposition', 'teleport', 'setx', 'sety', 'setheading', 'seth', 'heading',
+              'home', 'circle', 'dot', 'penup', 'pu', 'up', 'pendown', 'pd', 'down', 'isdown',
+              'pensize', 'width', 'pencolor', 'fillcolor', 'color', 'pen', 'begin_fill', 'end_fill',
+              'filling', 'position', 'pos', 'xcor', 'ycor', 'distance', 'towards', 'degrees',
+              'radians', 'speed', 'hideturtle', 'ht', 'showturtle', 'st', 'isvisible', 'stamp',
+              'clearstamp', 'clearstamps', 'write', 'shape', 'shapesize', 'turtlesize', 'tilt',
+              'settiltangle', 'tiltangle', 'undo', 'onclick', 'onrelease', 'ondrag', 'getscreen',
+              'clone', 'resizemode', 'fillingstatus'):
+    globals()[_name] = _turtle_function(_name)
+
+for _name in ('bgcolor', 'colormode', 'mode', 'turtles', 'clearscreen', 'resetscreen',
+              'window_width', 'window_height', 'screensize', 'textinput', 'numinput', 'getshapes',
+              'setup', 'title', 'tracer', 'update', 'delay', 'listen', 'onkey', 'onkeypress',
+              'onkeyrelease', 'onscreenclick', 'ontimer', 'mainloop', 'done', 'exitonclick', 'bye',
+              'register_shape', 'addshape', 'bgpic', 'setworldcoordinates', 'getcanvas'):
+    globals()[_name] = _screen_function(_name)
+
+
+def clear():
+    _getpen().clear()
+
+
+def reset():
+    _getpen().reset()
+
+
+def __getattr__(name):
+    if name.startswith('_'):
+        raise AttributeError(name)
+    return _noop
+
+
+def _dump():
+    try:
+        _sys.stdout.flush()
+    except Exception:
+        pass
+    out = _sys.__stdout__
+    out.write('\n' + _MARKER + _json.dumps(_trace) + '\n')
+    out.flush()
+
+
+_atexit.register(_dump)
+`
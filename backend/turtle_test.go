@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTurtleConfigNormalize(t *testing.T) {
+	stored, err := validateTurtleTest(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseTurtleConfig(stored)
+	if err != nil || cfg.Tolerance != 1 || cfg.IgnoreOrder {
+		t.Fatalf("unexpected defaults %+v (%v)", cfg, err)
+	}
+	for _, bad := range []string{`{"tolerance": -1}`, `{"tolerance": 500}`, `{"order": true}`, `[]`} {
+		if _, err := validateTurtleTest(&bad); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+	if err := stageTurtleShim(t.TempDir(), "turtle.py"); err == nil {
+		t.Fatalf("a main file called turtle.py should be rejected")
+	}
+}
+
+func seg(x0, y0, x1, y1 float64, color string) turtleSegment {
+	return turtleSegment{From: turtlePoint{x0, y0}, To: turtlePoint{x1, y1}, Color: color, Width: 1}
+}
+
+func TestCompareTurtleTraces(t *testing.T) {
+	square := &turtleTrace{Segments: []turtleSegment{
+		seg(0, 0, 100, 0, "#000000"), seg(100, 0, 100, 100, "#000000"),
+		seg(100, 100, 0, 100, "#000000"), seg(0, 100, 0, 0, "#000000"),
+	}}
+	// the same square in short steps, backwards and from another corner
+	var steps []turtleSegment
+	for _, s := range []turtleSegment{seg(100, 100, 100, 0, "#000000"), seg(100, 0, 0, 0, "#000000"), seg(0, 0, 0, 100, "#000000"), seg(0, 100, 100, 100, "#000000")} {
+		for k := 0; k < 4; k++ {
+			f, g := float64(k)/4, float64(k+1)/4
+			steps = append(steps, seg(s.From[0]+f*(s.To[0]-s.From[0]), s.From[1]+f*(s.To[1]-s.From[1]), s.From[0]+g*(s.To[0]-s.From[0]), s.From[1]+g*(s.To[1]-s.From[1]), s.Color))
+		}
+	}
+	reordered := &turtleTrace{Segments: steps}
+	ordered := &TurtleConfig{Tolerance: 1}
+	anyOrder := &TurtleConfig{Tolerance: 1, IgnoreOrder: true}
+
+	if msg := compareTurtleTraces(square, reordered, ordered); !strings.Contains(msg, "line 1 should go (0, 0) → (100, 0)") {
+		t.Errorf("ordered comparison: %q", msg)
+	}
+	if msg := compareTurtleTraces(square, reordered, anyOrder); msg != "" {
+		t.Errorf("unordered comparison: %q", msg)
+	}
+	// splitting a line into collinear steps does not matter even in order
+	split := &turtleTrace{Segments: append([]turtleSegment{seg(0, 0, 40, 0, "#000000"), seg(40, 0, 100.5, 0, "#000000")}, square.Segments[1:]...)}
+	if msg := compareTurtleTraces(square, split, ordered); msg != "" {
+		t.Errorf("split line: %q", msg)
+	}
+
+	open := &turtleTrace{Segments: square.Segments[:3]}
+	if msg := compareTurtleTraces(square, open, anyOrder); !strings.Contains(msg, "of the reference is missing near") {
+		t.Errorf("missing side: %q", msg)
+	}
+	if msg := compareTurtleTraces(square, open, ordered); !strings.Contains(msg, "the reference draws 4 lines, the drawing only 3") {
+		t.Errorf("missing side in order: %q", msg)
+	}
+	red := &turtleTrace{Segments: append([]turtleSegment{seg(0, 0, 100, 0, "#ff0000")}, square.Segments[1:]...)}
+	if msg := compareTurtleTraces(square, red, anyOrder); !strings.Contains(msg, "should be #000000, it is #ff0000") {
+		t.Errorf("wrong color: %q", msg)
+	}
+	if msg := compareTurtleTraces(square, red, &TurtleConfig{Tolerance: 1, IgnoreOrder: true, IgnoreColors: true}); msg != "" {
+		t.Errorf("ignored color: %q", msg)
+	}
+	extra := &turtleTrace{Segments: append(append([]turtleSegment{}, square.Segments...), seg(0, 0, 100, 100, "#000000"))}
+	if msg := compareTurtleTraces(square, extra, anyOrder); !strings.Contains(msg, "extra line (0, 0) → (100, 100)") {
+		t.Errorf("extra diagonal: %q", msg)
+	}
+
+	fill := turtleFill{Color: "#ff0000", Points: []turtlePoint{{0, 0}, {100, 0}, {100, 100}, {0, 100}}}
+	rotated := turtleFill{Color: "#ff0000", Points: []turtlePoint{{100, 100}, {100, 0}, {0, 0}, {0, 100}}}
+	withFill := &turtleTrace{Segments: square.Segments, Fills: []turtleFill{fill}}
+	if msg := compareTurtleTraces(withFill, &turtleTrace{Segments: square.Segments, Fills: []turtleFill{rotated}}, ordered); msg != "" {
+		t.Errorf("same fill from another corner: %q", msg)
+	}
+	if msg := compareTurtleTraces(withFill, square, ordered); !strings.Contains(msg, "filled shape #ff0000 near (50, 50) is missing") {
+		t.Errorf("missing fill: %q", msg)
+	}
+	if msg := compareTurtleTraces(withFill, square, &TurtleConfig{Tolerance: 1, IgnoreFills: true}); msg != "" {
+		t.Errorf("ignored fill: %q", msg)
+	}
+}
+
+// TestTurtleAgainstReference runs turtle programs through the shim in the
+// sandbox and compares them with a reference drawing.
+func TestTurtleAgainstReference(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		refDir := t.TempDir()
+		writeFiles(t, refDir, map[string]string{
+			"main.py": "import turtle\n\nn = int(input())\nt = turtle.Turtle()\nt.color('red', 'yellow')\nt.begin_fill()\nfor _ in range(n):\n    t.forward(100)\n    t.left(360 / n)\nt.end_fill()\nt.penup()\nt.goto(50, -40)\nt.dot(10, 'blue')\nturtle.done()\n",
+		})
+		ref := &referenceSandbox{dir: refDir, present: true, prog: program{rt: pythonRuntime{}, entry: "main.py"}}
+		ref.once.Do(func() {})
+		prog := program{rt: pythonRuntime{}, entry: "main.py"}
+		tc := TestCase{ExecutionMode: "turtle", Stdin: "5\n", TimeLimitSec: 10}
+
+		run := func(t *testing.T, src string, cfg string) turtleRun {
+			t.Helper()
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.py": src})
+			tc := tc
+			tc.TurtleConfig = &cfg
+			if err := stageTestFile(dir, prog.entry, tc); err != nil {
+				t.Fatal(err)
+			}
+			return runTurtleTest(nil, dir, prog, tc, 10*time.Second, ref)
+		}
+
+		// a pentagon drawn clockwise from the other end, with the module functions
+		good := "from turtle import *\n\nn = int(input())\nprint('drawing', n)\ncolor('red')\nfillcolor('yellow')\nspeed(0)\nbegin_fill()\nright(360 / n)\nfor _ in range(n):\n    backward(100)\n    right(360 / n)\nend_fill()\npenup()\nsetheading(0)\ngoto(50, -40)\ndot(10, (0, 0, 1))\nexitonclick()\n"
+		res := run(t, good, `{"ignore_order": true}`)
+		if turtleStatus(res) != "passed" {
+			t.Fatalf("status %s: %s %s", turtleStatus(res), res.Message, res.Stderr)
+		}
+		if res.Stdout != "drawing 5" {
+			t.Errorf("trace not split from stdout: %q", res.Stdout)
+		}
+		if len(res.Artifacts) != 2 {
+			t.Fatalf("expected two rendered drawings, got %d", len(res.Artifacts))
+		}
+		for _, a := range res.Artifacts {
+			data, _ := base64.StdEncoding.DecodeString(a.Data)
+			if img, err := png.Decode(bytes.NewReader(data)); err != nil || img.Bounds().Dx() != turtleImageSize {
+				t.Errorf("%s is not a rendered PNG: %v", a.Name, err)
+			}
+		}
+		if res := run(t, good, `{}`); turtleStatus(res) != "wrong_output" || !strings.Contains(res.Message, "line 1 should go") {
+			t.Errorf("drawing order should matter by default: %s", res.Message)
+		}
+
+		square := "import turtle\n\nt = turtle.Turtle()\nt.color('red', 'yellow')\nfor _ in range(4):\n    t.forward(100)\n    t.left(90)\n"
+		if res := run(t, square, `{"ignore_order": true}`); turtleStatus(res) != "wrong_output" || !strings.Contains(res.Message, "The drawing differs") {
+			t.Errorf("square accepted as a pentagon: %s", res.Message)
+		}
+		if res := run(t, "import turtle\nturtle.forward(\n", `{}`); turtleStatus(res) != "runtime_error" {
+			t.Errorf("syntax error graded as %s", turtleStatus(res))
+		}
+	})
+}
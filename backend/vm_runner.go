@@ -894,62 +894,26 @@ func (v *vmInstance) syncWorkspace(ctx context.Context, dir string) (string, err
 	return "", fmt.Errorf("copy workspace failed")
 }
 
-// startVMWithWorkspace boots a VM and copies the workspace into it.
-func startVMWithWorkspace(ctx context.Context, dir string, forwards map[int]int) (*vmInstance, string, error) {
-	vm, err := startVM(ctx, forwards)
-	if err != nil {
-		return nil, "", err
-	}
-	remoteDir, err := vm.syncWorkspace(ctx, dir)
-	if err != nil {
-		vm.Close()
-		return nil, "", err
-	}
-	return vm, remoteDir, nil
+// pullFiles copies files of a workspace back out of the VM over ssh.
+func (v *vmInstance) pullFiles(ctx context.Context, remoteDir string, names []string) (map[string][]byte, error) {
+	return pullFilesWithTar(ctx, v, remoteDir, names)
+}
+
+// shellCommand implements Sandbox by running the script over ssh.
+func (v *vmInstance) shellCommand(ctx context.Context, script string) *exec.Cmd {
+	return v.sshCommand(ctx, script)
 }
 
 // runCommand executes a command inside the VM in the provided workdir.
 func (v *vmInstance) runCommand(ctx context.Context, workdir, script string, stdin *strings.Reader) (string, string, int, error) {
 	fmt.Printf("[vm] runCommand workdir=%s script=%s\n", workdir, script)
-	cmd := v.sshCommand(ctx, fmt.Sprintf("cd %s && %s", workdir, script))
-	if stdin != nil {
-		cmd.Stdin = stdin
-	}
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
-	err := cmd.Run()
-	exitCode := 0
-	if err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
-			exitCode = ee.ExitCode()
-		} else {
-			exitCode = -1
-		}
-	}
-	return stdoutBuf.String(), stderrBuf.String(), exitCode, err
+	return runSandboxCommand(v.sshCommand(ctx, fmt.Sprintf("cd %s && %s", workdir, script)), stdin)
 }
 
 // startInteractive starts a long-running command and returns pipes for streaming IO.
 func (v *vmInstance) startInteractive(ctx context.Context, workdir, script string) (*exec.Cmd, io.WriteCloser, io.ReadCloser, io.ReadCloser, error) {
 	fmt.Printf("[vm] startInteractive workdir=%s script=%s\n", workdir, script)
-	cmd := v.sshCommand(ctx, fmt.Sprintf("cd %s && %s", workdir, script))
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	stdinPipe, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	if err := cmd.Start(); err != nil {
-		return nil, nil, nil, nil, err
-	}
-	return cmd, stdinPipe, stdoutPipe, stderrPipe, nil
+	return startSandboxCommand(v.sshCommand(ctx, fmt.Sprintf("cd %s && %s", workdir, script)))
 }
 
 func (v *vmInstance) Close() {
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tests of one submission run concurrently inside the shared VM up to this
+// limit. The default matches the default QEMU_CPUS, so each test still has a
+// CPU of its own; more than that makes the tests compete for CPU and memory
+// and their timings noisier. Setting it to 1 runs the tests one at a time,
+// which also lets cleanupCase kill every leftover process of the sandbox user.
+var vmSessionParallelism = getenvIntOr("VM_SESSION_PARALLEL_TESTS", 2)
+
+const (
+	sessionManifestName = ".__base_manifest__"
+	// exit code used by the case preparation script when the base copy in the
+	// guest no longer matches what was synced
+	sessionBaseTamperedExit = 86
+)
+
+// vmSession runs every test of a submission inside a single sandbox (a VM
+// unless SANDBOX_BACKEND says otherwise). The pristine
+// submission workspace is synced once; each test then gets a fresh copy of it
+// in the guest plus its own staged files, and is cleaned up afterwards.
+type vmSession struct {
+	vm         Sandbox
+	ctx        context.Context
+	cancel     context.CancelFunc
+	baseDir    string
+	remoteBase string
+	manifest   map[string]string // rel path -> sha256 of the pristine workspace
+	manifestTx []byte            // sha256sum -c compatible listing of manifest
+	baseMu     sync.RWMutex
+	slots      chan struct{}
+	seq        atomic.Int64
+}
+
+// startVMSession starts a sandbox and syncs baseDir into it. budget bounds
+// the lifetime of the sandbox once started.
+func startVMSession(baseDir string, budget time.Duration) (*vmSession, error) {
+	manifest, err := hashWorkspace(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("hash workspace: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vmBootTimeout+vmQueueTimeout+budget)
+	bootCtx, bootCancel := context.WithTimeout(ctx, vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
+	defer bootCancel()
+
+	vm, err := startSandbox(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	remoteBase, err := vm.syncWorkspace(bootCtx, baseDir)
+	if err != nil {
+		vm.Close()
+		cancel()
+		return nil, err
+	}
+	parallel := vmSessionParallelism
+	if parallel < 1 {
+		parallel = 1
+	}
+	return &vmSession{
+		vm:         vm,
+		ctx:        ctx,
+		cancel:     cancel,
+		baseDir:    baseDir,
+		remoteBase: remoteBase,
+		manifest:   manifest,
+		manifestTx: formatManifest(manifest),
+		slots:      make(chan struct{}, parallel),
+	}, nil
+}
+
+// Close shuts the VM down.
+func (s *vmSession) Close() {
+	if s == nil {
+		return
+	}
+	s.vm.Close()
+	s.cancel()
+}
+
+// acquireRunWorkspace provides a sandbox that holds the contents of dir.
+// Without a session one is started just for this run; with one, a fresh
+// per-test copy is created inside the shared sandbox. The returned release
+// func must always be called.
+func acquireRunWorkspace(ctx context.Context, sess *vmSession, dir string) (Sandbox, string, func(), error) {
+	if sess == nil {
+		vm, remoteDir, err := startSandboxWithWorkspace(ctx, dir)
+		if err != nil {
+			return nil, "", func() {}, err
+		}
+		return vm, remoteDir, vm.Close, nil
+	}
+	return sess.prepareCase(ctx, dir)
+}
+
+func (s *vmSession) prepareCase(ctx context.Context, dir string) (Sandbox, string, func(), error) {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, "", func() {}, ctx.Err()
+	}
+	s.baseMu.RLock()
+	dest := fmt.Sprintf("%s-case-%d", s.remoteBase, s.seq.Add(1))
+	s.baseMu.RUnlock()
+	release := func() {
+		s.cleanupCase(dest)
+		<-s.slots
+	}
+
+	overlay, err := s.buildOverlay(dir)
+	if err != nil {
+		release()
+		return nil, "", func() {}, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		s.baseMu.RLock()
+		code, stderr, err := s.copyCase(ctx, dest, overlay)
+		s.baseMu.RUnlock()
+		if err == nil {
+			return caseSandbox{Sandbox: s.vm, dest: dest}, dest, release, nil
+		}
+		if code != sessionBaseTamperedExit || attempt > 0 {
+			release()
+			return nil, "", func() {}, fmt.Errorf("prepare test workspace: %w (stderr=%q)", err, stderr)
+		}
+		// A previous test modified the shared base copy; restore it.
+		fmt.Printf("[vm] base workspace %s changed inside the guest; resyncing\n", s.remoteBase)
+		if err := s.resyncBase(ctx); err != nil {
+			release()
+			return nil, "", func() {}, err
+		}
+	}
+	release()
+	return nil, "", func() {}, fmt.Errorf("prepare test workspace failed")
+}
+
+// copyCase creates dest from the base copy and unpacks the overlay on top.
+// The manifest travels inside the overlay and is verified against the copy
+// before any test-specific files are added.
+func (s *vmSession) copyCase(ctx context.Context, dest string, overlay []byte) (int, string, error) {
+	script := fmt.Sprintf(`set -e
+base=%q
+dest=%q
+rm -rf -- "$dest" "$dest.ovl"
+mkdir -p -- "$dest" "$dest.ovl"
+tar -xf - -C "$dest.ovl"
+cp -R -- "$base"/. "$dest"/
+cd "$dest"
+want=$(sed 's/^[0-9a-f]*  //' "$dest.ovl/%[3]s" | LC_ALL=C sort)
+have=$(find . -type f | LC_ALL=C sort)
+if [ "$want" != "$have" ] || ! sha256sum --quiet --strict -c "$dest.ovl/%[3]s" >/dev/null 2>&1; then
+  rm -rf -- "$dest" "$dest.ovl"
+  exit %[4]d
+fi
+rm -f -- "$dest.ovl/%[3]s"
+cp -R -- "$dest.ovl"/. "$dest"/
+rm -rf -- "$dest.ovl"
+`, s.remoteBase, dest, sessionManifestName, sessionBaseTamperedExit)
+	cmd := s.vm.shellCommand(ctx, script)
+	cmd.Stdin = bytes.NewReader(overlay)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	err := cmd.Run()
+	code := 0
+	if err != nil {
+		code = -1
+		if ee, ok := err.(*exec.ExitError); ok {
+			code = ee.ExitCode()
+		}
+	}
+	return code, strings.TrimSpace(stderrBuf.String()), err
+}
+
+func (s *vmSession) resyncBase(ctx context.Context) error {
+	s.baseMu.Lock()
+	defer s.baseMu.Unlock()
+	remoteBase, err := s.vm.syncWorkspace(ctx, s.baseDir)
+	if err != nil {
+		return fmt.Errorf("resync workspace: %w", err)
+	}
+	s.remoteBase = remoteBase
+	return nil
+}
+
+// sessionCaseEnv marks the processes a test starts with its directory, so
+// that cleanupCase finds them after they chdir elsewhere or detach.
+const sessionCaseEnv = "CODEDU_CASE"
+
+// caseSandbox runs the commands of one test with sessionCaseEnv set.
+type caseSandbox struct {
+	Sandbox
+	dest string
+}
+
+func (c caseSandbox) runCommand(ctx context.Context, workdir, script string, stdin *strings.Reader) (string, string, int, error) {
+	return c.Sandbox.runCommand(ctx, workdir, c.mark(script), stdin)
+}
+
+func (c caseSandbox) startInteractive(ctx context.Context, workdir, script string) (*exec.Cmd, io.WriteCloser, io.ReadCloser, io.ReadCloser, error) {
+	return c.Sandbox.startInteractive(ctx, workdir, c.mark(script))
+}
+
+func (c caseSandbox) mark(script string) string {
+	return fmt.Sprintf("export %s=%s && %s", sessionCaseEnv, shellQuote(c.dest), script)
+}
+
+// cleanupCase kills anything still running from the test (e.g. after a
+// timeout closed the ssh session, or a daemon the program forked) and
+// removes the directory. Processes are found by their working directory and
+// by sessionCaseEnv; when tests run one at a time, every other process of
+// the sandbox user is killed as well, which also catches programs that
+// cleared their environment. The local backend shares its user with the
+// backend itself and is never swept.
+func (s *vmSession) cleanupCase(dest string) {
+	_, local := s.vm.(*localSandbox)
+	sweep := 0
+	if cap(s.slots) == 1 && !local {
+		sweep = 1
+	}
+	script := fmt.Sprintf(`dest=%q
+sweep=%d
+mark=%s="$dest"
+keep=" $$ "
+p=$$
+while [ "$p" -gt 1 ] 2>/dev/null; do
+  p=$(sed 's/.*) [A-Za-z] \([0-9]*\).*/\1/' "/proc/$p/stat" 2>/dev/null) || break
+  keep="$keep$p "
+done
+for round in 1 2; do
+  for p in /proc/[0-9]*; do
+    pid=${p#/proc/}
+    case "$keep" in *" $pid "*) continue ;; esac
+    [ "$pid" = 1 ] && continue
+    if [ "$sweep" = 1 ] && [ -O "$p" ]; then
+      kill -KILL "$pid" 2>/dev/null || true
+      continue
+    fi
+    c=$(readlink "$p/cwd" 2>/dev/null) || continue
+    case "$c" in
+      "$dest"|"$dest"/*) kill -KILL "$pid" 2>/dev/null || true; continue ;;
+    esac
+    if tr '\0' '\n' < "$p/environ" 2>/dev/null | grep -qxF -- "$mark"; then
+      kill -KILL "$pid" 2>/dev/null || true
+    fi
+  done
+done
+rm -rf -- "$dest" "$dest.ovl"
+`, dest, sweep, sessionCaseEnv)
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+	if out, err := s.vm.shellCommand(ctx, script).CombinedOutput(); err != nil {
+		fmt.Printf("[vm] cleanup %s failed: %v output=%q\n", dest, err, string(out))
+	}
+}
+
+// buildOverlay tars the files of dir that are not identical to the pristine
+// workspace (staged test files, runner scripts) together with the manifest.
+func (s *vmSession) buildOverlay(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(sessionManifestName, s.manifestTx); err != nil {
+		return nil, err
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		if s.manifest[rel] == hex.EncodeToString(sum[:]) {
+			return nil
+		}
+		return add(rel, data)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("build test overlay: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func hashWorkspace(dir string) (map[string]string, error) {
+	out := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		out[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return out, err
+}
+
+// formatManifest renders the hashes in `sha256sum` format relative to the
+// workspace root, sorted for stable output.
+func formatManifest(m map[string]string) []byte {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  ./%s\n", m[name], name)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionOverlayOnlyCarriesChangedFiles(t *testing.T) {
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(base, "main.py"), []byte("print(1)\n"), 0644)
+	os.WriteFile(filepath.Join(base, "pkg", "util.py"), []byte("X = 1\n"), 0644)
+
+	manifest, err := hashWorkspace(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &vmSession{manifest: manifest, manifestTx: formatManifest(manifest)}
+
+	work := t.TempDir()
+	os.MkdirAll(filepath.Join(work, "pkg"), 0755)
+	os.WriteFile(filepath.Join(work, "main.py"), []byte("print(1)\n"), 0644)
+	os.WriteFile(filepath.Join(work, "input.txt"), []byte("data"), 0644)
+	os.WriteFile(filepath.Join(work, "pkg", "util.py"), []byte("X = 2\n"), 0644)
+
+	overlay, err := sess.buildOverlay(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var manifestBody string
+	tr := tar.NewReader(bytes.NewReader(overlay))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == sessionManifestName {
+			data, _ := io.ReadAll(tr)
+			manifestBody = string(data)
+		}
+	}
+	sort.Strings(names)
+	want := []string{sessionManifestName, "input.txt", "pkg/util.py"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("overlay entries = %v, want %v", names, want)
+	}
+	if !strings.Contains(manifestBody, "  ./main.py\n") || !strings.Contains(manifestBody, "  ./pkg/util.py\n") {
+		t.Fatalf("manifest missing base files:\n%s", manifestBody)
+	}
+}
+
+// TestSessionCleanupKillsDaemons checks that a process a test leaves behind
+// is gone before the next test starts, even after leaving the test directory
+// and its session.
+func TestSessionCleanupKillsDaemons(t *testing.T) {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		t.Skip("python3 not available")
+	}
+	forEachSandboxBackend(t, func(t *testing.T) {
+		base := t.TempDir()
+		writeFiles(t, base, map[string]string{"daemon.py": `import os, sys, time
+pid = os.fork()
+if pid:
+    print(pid)
+    sys.exit(0)
+os.setsid()
+os.chdir('/')
+null = os.open(os.devnull, os.O_RDWR)
+for fd in (0, 1, 2):
+    os.dup2(null, fd)
+time.sleep(120)
+`})
+		sess, err := startVMSession(base, time.Minute)
+		if err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+		defer sess.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		sb, dest, release, err := sess.prepareCase(ctx, base)
+		if err != nil {
+			t.Fatalf("prepare case: %v", err)
+		}
+		stdout, stderr, exitCode, err := sb.runCommand(ctx, dest, pythonBinary+" daemon.py", nil)
+		if err != nil || exitCode != 0 {
+			release()
+			t.Fatalf("daemon.py: exit %d, err %v, stderr %q", exitCode, err, stderr)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(stdout))
+		if err != nil {
+			release()
+			t.Fatalf("unexpected output %q", stdout)
+		}
+		release()
+
+		sb, dest, release, err = sess.prepareCase(ctx, base)
+		if err != nil {
+			t.Fatalf("prepare second case: %v", err)
+		}
+		defer release()
+		check := "s=$(cat /proc/" + strconv.Itoa(pid) + "/stat 2>/dev/null) || exit 0; case \"$s\" in *') Z '*) exit 0 ;; esac; echo alive"
+		if out, _, _, err := sb.runCommand(ctx, dest, check, nil); err != nil || strings.TrimSpace(out) != "" {
+			t.Errorf("daemon %d survived the first test: %q %v", pid, out, err)
+		}
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -29,8 +30,6 @@ import (
 // Job represents a grading task for one submission.
 type Job struct{ SubmissionID uuid.UUID }
 
-var taskQueue chan Job
-
 var strictnessMessages = []struct {
 	threshold int
 	message   string
@@ -337,7 +336,7 @@ var mainGuard = regexp.MustCompile(`(?m)^\s*if\s+__name__\s*==\s*["']__main__["'
 
 // StartWorker starts n workers processing the grading queue.
 func StartWorker(n int) {
-	taskQueue = make(chan Job, 100)
+	jobWake = make(chan struct{}, n)
 	if err := ensureDockerImage(pythonImage); err != nil {
 		fmt.Println("[worker] warn: pre-pull failed; will retry in background:", err)
 		go func() {
@@ -350,20 +349,47 @@ func StartWorker(n int) {
 			}
 		}()
 	}
+	if recovered, err := recoverOrphanedSubmissions(); err != nil {
+		fmt.Println("[worker] warn: recovering orphaned submissions failed:", err)
+	} else if recovered > 0 {
+		fmt.Printf("[worker] re-queued %d orphaned submissions\n", recovered)
+	}
 	for i := 0; i < n; i++ {
-		go workerLoop()
+		go workerLoop(newWorkerID(i))
 	}
+	go gradingQueueMaintenance()
 
 	// Start presence cleanup task
 	go presenceCleanupTask()
 }
 
-// EnqueueJob enqueues a submission for grading.
-func EnqueueJob(j Job) { taskQueue <- j }
+// EnqueueJob persists a grading job for the submission and wakes a worker.
+func EnqueueJob(j Job) {
+	if err := insertGradingJob(j.SubmissionID); err != nil {
+		// The submission stays pending and is picked up by startup recovery.
+		fmt.Println("[worker] enqueue failed:", err)
+		return
+	}
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+}
 
-func workerLoop() {
-	for j := range taskQueue {
-		runSubmission(j.SubmissionID)
+func workerLoop(workerID string) {
+	for {
+		j, err := claimGradingJob(workerID)
+		if err != nil {
+			fmt.Println("[worker] claim failed:", err)
+		}
+		if j != nil {
+			processGradingJob(j, workerID)
+			continue
+		}
+		select {
+		case <-jobWake:
+		case <-time.After(jobPollInterval):
+		}
 	}
 }
 
@@ -377,7 +403,9 @@ func ensureDockerImage(img string) error {
 	return nil
 }
 
-func runSubmission(id uuid.UUID) {
+// runSubmission grades a submission. The lease, if any, is checked before
+// every write of an outcome so a run that lost its job records nothing.
+func runSubmission(id uuid.UUID, lease *gradingLease) {
 	sub, err := GetSubmission(id)
 	if err != nil {
 		return
@@ -464,33 +492,12 @@ func runSubmission(id uuid.UUID) {
 		runScratchAnalysis(sub, assignment, tmpDir)
 		return
 	}
-	var mainFile string
-	var firstPy string
-	filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil
-		}
-		if strings.HasSuffix(info.Name(), ".py") {
-			rel, _ := filepath.Rel(tmpDir, path)
-			if firstPy == "" {
-				firstPy = rel
-			}
-			content, _ := os.ReadFile(path)
-			if mainGuard.Match(content) {
-				mainFile = rel
-				return io.EOF
-			}
-		}
-		return nil
-	})
-	if mainFile == "" {
-		if _, err := os.Stat(filepath.Join(tmpDir, "main.py")); err == nil {
-			mainFile = "main.py"
-		} else {
-			mainFile = firstPy
-		}
+	lang := "python"
+	if assignment != nil {
+		lang = assignment.ProgrammingLanguage
 	}
-	if mainFile == "" {
+	prog, err := loadProgram(tmpDir, lang)
+	if err != nil {
 		UpdateSubmissionStatus(id, "failed")
 		return
 	}
@@ -500,6 +507,11 @@ func runSubmission(id uuid.UUID) {
 		UpdateSubmissionStatus(id, "failed")
 		return
 	}
+	groups, err := ListTestGroups(sub.AssignmentID)
+	if err != nil {
+		UpdateSubmissionStatus(id, "failed")
+		return
+	}
 
 	if assignment != nil {
 		noteMap := notesFromAssignment(assignment)
@@ -510,6 +522,9 @@ func runSubmission(id uuid.UUID) {
 			if detErr != nil {
 				fmt.Printf("[worker] illegal tool detection failed for submission %s: %v\n", id, detErr)
 			} else if len(findings) > 0 {
+				if !lease.held() {
+					return
+				}
 				message := formatIllegalToolMessage(findings, noteMap)
 				totalWeight := 0.0
 				for _, tc := range tests {
@@ -525,12 +540,64 @@ func runSubmission(id uuid.UUID) {
 					_ = CreateResult(res)
 					totalWeight += tc.Weight
 				}
-				finalizeSubmissionOutcome(sub, assignment, false, totalWeight, 0)
+				finalizeSubmissionOutcome(sub, assignment, false, totalWeight, 0, scoreAdjustments{})
 				return
 			}
 		}
 	}
 
+	var adj scoreAdjustments
+	if _, ok := prog.rt.(pythonRuntime); ok && assignment != nil {
+		rules, err := assignmentConstructRules(assignment)
+		if err != nil {
+			fmt.Printf("[worker] invalid construct rules for assignment %s: %v\n", assignment.ID, err)
+		} else if findings, err := checkConstructRules(tmpDir, rules); err != nil {
+			fmt.Printf("[worker] construct analysis failed for submission %s: %v\n", id, err)
+		} else if !lease.held() {
+			return
+		} else if len(findings) == 0 {
+			// clear the findings of an earlier grading run
+			_ = SetSubmissionConstructFindings(id, nil)
+		} else {
+			if raw, err := json.Marshal(findings); err == nil {
+				stored := string(raw)
+				_ = SetSubmissionConstructFindings(id, &stored)
+			}
+			var failed bool
+			failed, adj.deduction = constructOutcome(findings)
+			if failed {
+				message := formatConstructMessage(findings)
+				totalWeight := 0.0
+				for _, tc := range tests {
+					_ = CreateResult(&Result{
+						SubmissionID: sub.ID,
+						TestCaseID:   tc.ID,
+						Status:       "construct_violation",
+						Stderr:       message,
+						ExitCode:     -1,
+					})
+					totalWeight += tc.Weight
+				}
+				finalizeSubmissionOutcome(sub, assignment, false, totalWeight, 0, scoreAdjustments{})
+				return
+			}
+		}
+	}
+
+	if _, ok := prog.rt.(pythonRuntime); ok && assignment != nil {
+		adj.quality = runQualityAnalysis(id, assignment, tmpDir)
+	}
+
+	// Stage the runtime guard after the static scan so it is not analysed as
+	// student code; every test workspace is cloned from tmpDir.
+	if runtimeToolGuardEnabled(assignment) {
+		if _, ok := prog.rt.(pythonRuntime); ok {
+			if err := writeToolGuard(tmpDir, copyStringArray(assignment.BannedFunctions), copyStringArray(assignment.BannedModules), notesFromAssignment(assignment)); err != nil {
+				fmt.Printf("[worker] runtime tool guard for submission %s: %v\n", id, err)
+			}
+		}
+	}
+
 	allPass := true
 	totalWeight := 0.0
 	earnedWeight := 0.0
@@ -539,37 +606,108 @@ func runSubmission(id uuid.UUID) {
 		parallelism = 1
 	}
 
-	sem := make(chan struct{}, parallelism)
-	outcomes := make(chan testOutcome, len(tests))
-	var wg sync.WaitGroup
+	// One VM serves every test of the submission; each test still runs in
+	// its own fresh copy of the workspace. If the session cannot be started
+	// the tests fall back to booting their own VMs.
+	var budget time.Duration
+	for _, tc := range tests {
+		budget += time.Duration(float64(max(performanceRuns(tc), propertyRuns(tc)))*tc.TimeLimitSec*float64(time.Second)) + vmExtraTimeout + 30*time.Second
+	}
+	if prog.rt.compiled() {
+		budget += compileTimeout
+	}
+	sess, sessErr := startVMSession(tmpDir, budget)
+	if sessErr != nil {
+		fmt.Printf("[worker] shared VM session for submission %s unavailable: %v\n", id, sessErr)
+		sess = nil
+	}
+	defer sess.Close()
+	if sess != nil && lease != nil {
+		// tear the VM down as soon as the job is lost
+		defer context.AfterFunc(lease.ctx, sess.cancel)()
+	}
+	ref := newReferenceSandbox(assignment, tests)
+	defer ref.Close()
 
-	for i := range tests {
-		tc := tests[i]
-		wg.Add(1)
-		go func(tc TestCase) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-			outcomes <- runTestCase(sub.ID, tc, tmpDir, mainFile)
-		}(tc)
+	if prog.rt.compiled() {
+		if err := prog.rt.build(sess, tmpDir, assignment); err != nil {
+			if !lease.held() {
+				return
+			}
+			var ce *compileError
+			if !errors.As(err, &ce) {
+				fmt.Printf("[worker] compile submission %s: %v\n", id, err)
+				UpdateSubmissionStatus(id, "failed")
+				return
+			}
+			for _, tc := range tests {
+				_ = CreateResult(&Result{
+					SubmissionID: sub.ID,
+					TestCaseID:   tc.ID,
+					Status:       "compile_error",
+					Stderr:       ce.Diagnostics,
+					ExitCode:     -1,
+				})
+				totalWeight += tc.Weight
+			}
+			if len(groups) > 0 {
+				_, _, totalWeight, _ = scoreTestGroups(groups, tests, nil)
+			}
+			finalizeSubmissionOutcome(sub, assignment, false, totalWeight, 0, scoreAdjustments{})
+			return
+		}
+	}
+	if _, ok := prog.rt.(notebookRuntime); ok {
+		memoryLimitKB := 0
+		for _, tc := range tests {
+			memoryLimitKB = max(memoryLimitKB, tc.MemoryLimitKB)
+		}
+		recordExecutedNotebook(sess, tmpDir, sub.ID, memoryLimitKB)
 	}
 
-	wg.Wait()
-	close(outcomes)
+	sem := make(chan struct{}, parallelism)
+	runBatch := func(batch []TestCase) []testOutcome {
+		outcomes := make([]testOutcome, len(batch))
+		var wg sync.WaitGroup
+		for i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				if lease.lost() {
+					return
+				}
+				outcomes[i] = runTestCase(sess, sub.ID, batch[i], tmpDir, prog, ref)
+			}(i)
+		}
+		wg.Wait()
+		return outcomes
+	}
 
-	for outcome := range outcomes {
+	// Without groups this is a single batch of all tests.
+	outcomes := runTestsByGroup(groups, tests, sub.ID, runBatch)
+	if !lease.held() {
+		return
+	}
+	credit := make(map[uuid.UUID]float64, len(tests))
+	for _, outcome := range outcomes {
 		if outcome.result != nil {
 			CreateResult(outcome.result)
+			credit[outcome.result.TestCaseID] = outcome.credit
 		}
 		totalWeight += outcome.weight
-		if outcome.passed {
-			earnedWeight += outcome.weight
-		} else {
+		earnedWeight += outcome.weight * outcome.credit
+		if !outcome.passed {
 			allPass = false
 		}
 	}
+	if len(groups) > 0 {
+		// group points replace the test weights
+		_, earnedWeight, totalWeight, allPass = scoreTestGroups(groups, tests, credit)
+	}
 
-	finalizeSubmissionOutcome(sub, assignment, allPass, totalWeight, earnedWeight)
+	finalizeSubmissionOutcome(sub, assignment, allPass, totalWeight, earnedWeight, adj)
 }
 
 func scratchEvaluationMode(raw string) string {
@@ -824,7 +962,7 @@ func executeScratchAnalysis(dir, sb3Name string, timeout time.Duration) (string,
 	bootCtx, bootCancel := context.WithTimeout(context.Background(), vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
 	defer bootCancel()
 
-	vm, remoteDir, err := startVMWithWorkspace(bootCtx, dir, nil)
+	vm, remoteDir, err := startSandboxWithWorkspace(bootCtx, dir)
 	if err != nil {
 		timedOut := bootCtx.Err() == context.DeadlineExceeded
 		return "", fmt.Sprintf("vm start failed: %v", err), -1, timedOut, 0
@@ -863,7 +1001,46 @@ func executeScratchAnalysis(dir, sb3Name string, timeout time.Duration) (string,
 	return strings.TrimSpace(outRaw), strings.TrimSpace(errRaw), exitCode, timedOut, duration
 }
 
-func finalizeSubmissionOutcome(sub *Submission, assignment *Assignment, allPass bool, totalWeight, earnedWeight float64) {
+// scoreAdjustments are applied to the test score before the late penalty.
+type scoreAdjustments struct {
+	// deduction is subtracted for violated construct rules
+	deduction float64
+	// quality is the code-quality report when it counts towards the grade
+	quality *qualityReport
+}
+
+// runQualityAnalysis stores the code-quality report of a submission and
+// returns it when the assignment blends it into the grade. Without a report
+// the one of an earlier grading run is cleared.
+func runQualityAnalysis(id uuid.UUID, assignment *Assignment, dir string) *qualityReport {
+	cfg, err := assignmentQualityConfig(assignment)
+	if err != nil {
+		fmt.Printf("[worker] invalid quality config for assignment %s: %v\n", assignment.ID, err)
+		_ = SetSubmissionQualityReport(id, nil)
+		return nil
+	}
+	if cfg == nil {
+		_ = SetSubmissionQualityReport(id, nil)
+		return nil
+	}
+	report, err := analyzeCodeQuality(dir, cfg)
+	if err != nil {
+		fmt.Printf("[worker] code quality analysis failed for submission %s: %v\n", id, err)
+		_ = SetSubmissionQualityReport(id, nil)
+		return nil
+	}
+	if raw, err := json.Marshal(report); err == nil {
+		stored := string(raw)
+		_ = SetSubmissionQualityReport(id, &stored)
+	}
+	if report.Weight <= 0 {
+		return nil
+	}
+	return report
+}
+
+// finalizeSubmissionOutcome scores the submission and sets its final status.
+func finalizeSubmissionOutcome(sub *Submission, assignment *Assignment, allPass bool, totalWeight, earnedWeight float64, adj scoreAdjustments) {
 	if assignment == nil {
 		var err error
 		assignment, err = GetAssignment(sub.AssignmentID)
@@ -892,6 +1069,16 @@ func finalizeSubmissionOutcome(sub *Submission, assignment *Assignment, allPass
 			score = float64(assignment.MaxPoints)
 		}
 	}
+	if adj.quality != nil {
+		score = blendQualityScore(score, assignment.MaxPoints, adj.quality.Score, adj.quality.Weight)
+		if adj.quality.Score < 1 {
+			allPass = false
+		}
+	}
+	if adj.deduction > 0 {
+		score = math.Max(score-adj.deduction, 0)
+		allPass = false
+	}
 
 	effDeadline := assignment.Deadline
 	effSecond := assignment.SecondDeadline
@@ -925,6 +1112,9 @@ type testOutcome struct {
 	result *Result
 	weight float64
 	passed bool
+	// credit is the fraction of weight earned; 1 for passed tests, the
+	// checker score for partially passed ones.
+	credit float64
 }
 
 func cloneWorkspace(baseDir string) (string, func(), error) {
@@ -973,7 +1163,7 @@ func cloneWorkspace(baseDir string) (string, func(), error) {
 	return dest, cleanup, nil
 }
 
-func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOutcome {
+func runTestCase(sess *vmSession, subID uuid.UUID, tc TestCase, baseDir string, prog program, ref *referenceSandbox) testOutcome {
 	timeout := time.Duration(tc.TimeLimitSec * float64(time.Second))
 	var stdout, stderr string
 	var exitCode int
@@ -991,6 +1181,20 @@ func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOut
 		}
 	}
 
+	if err := checkModeSupported(prog.rt, mode); err != nil {
+		return testOutcome{
+			result: &Result{
+				SubmissionID: subID,
+				TestCaseID:   tc.ID,
+				Status:       "runtime_error",
+				Stderr:       err.Error(),
+				ExitCode:     -1,
+			},
+			weight: tc.Weight,
+			passed: false,
+		}
+	}
+
 	workDir, cleanup, err := cloneWorkspace(baseDir)
 	if err != nil {
 		return testOutcome{
@@ -1006,7 +1210,7 @@ func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOut
 		}
 	}
 	defer cleanup()
-	if err := stageTestFile(workDir, mainFile, tc); err != nil {
+	if err := stageTestFile(workDir, prog.entry, tc); err != nil {
 		return testOutcome{
 			result: &Result{
 				SubmissionID: subID,
@@ -1022,14 +1226,48 @@ func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOut
 
 	var funcMeta *functionCallResult
 	var funcErr error
+	var mem memoryUsage
+	var verdict *checkerVerdict
+	var checkErr error
+	var dialogue dialogueRun
+	var sqlResult sqlTestRun
+	var nbResult notebookTestRun
+	var perfResult performanceRun
+	var propResult propertyRun
+	var turtleResult turtleRun
+	var plotFigures []ResultArtifact
 
 	switch mode {
-	case "unittest":
-		stdout, stderr, exitCode, timedOut, runtime = executePythonUnit(workDir, mainFile, stringOrEmpty(tc.UnittestCode), stringOrEmpty(tc.UnittestName), timeout)
+	case "checker":
+		stdout, stderr, exitCode, timedOut, runtime, mem = prog.rt.runStdin(sess, workDir, prog.entry, tc.Stdin, timeout, tc.MemoryLimitKB)
+		if !timedOut && !mem.LimitExceeded && exitCode == 0 {
+			verdict, checkErr = runChecker(sess, workDir, tc, stdout)
+		}
+	case "performance":
+		perfResult = runPerformanceTest(sess, workDir, prog, tc, timeout, ref)
+		stdout, stderr, exitCode, timedOut, runtime, mem = perfResult.Stdout, perfResult.Stderr, perfResult.ExitCode, perfResult.TimedOut, perfResult.Runtime, perfResult.Memory
+	case "property":
+		propResult = runPropertyTest(sess, workDir, prog, tc, timeout, ref, subID)
+		stdout, stderr, exitCode, timedOut, runtime, mem = propResult.Stdout, propResult.Stderr, propResult.ExitCode, propResult.TimedOut, propResult.Runtime, propResult.Memory
+	case "turtle":
+		turtleResult = runTurtleTest(sess, workDir, prog, tc, timeout, ref)
+		stdout, stderr, exitCode, timedOut, runtime, mem = turtleResult.Stdout, turtleResult.Stderr, turtleResult.ExitCode, turtleResult.TimedOut, turtleResult.Runtime, turtleResult.Memory
+	case "dialogue":
+		dialogue = runDialogueTest(sess, workDir, prog, tc, timeout)
+		stdout, stderr, exitCode, timedOut, runtime, mem = dialogue.Transcript, dialogue.Stderr, dialogue.ExitCode, dialogue.TimedOut, dialogue.Runtime, dialogue.Memory
+	case "sql_query":
+		sqlResult = runSQLTest(sess, workDir, prog, tc, timeout)
+		stdout, stderr, exitCode, timedOut, runtime, mem = sqlResult.Stdout, sqlResult.Stderr, sqlResult.ExitCode, sqlResult.TimedOut, sqlResult.Runtime, sqlResult.Memory
+	case "notebook_variable", "notebook_cell":
+		nbResult = runNotebookTest(sess, workDir, tc, timeout)
+		stdout, stderr, exitCode, timedOut, runtime, mem = nbResult.Stdout, nbResult.Stderr, nbResult.ExitCode, nbResult.TimedOut, nbResult.Runtime, nbResult.Memory
+	case "unittest", "pytest", "doctest", "plot":
+		stdout, stderr, exitCode, timedOut, runtime, mem = prog.rt.runUnit(sess, workDir, prog.entry, tc, timeout)
+		stdout, plotFigures = splitPlotFigures(stdout)
 	case "function":
 		fn := strings.TrimSpace(stringOrEmpty(tc.FunctionName))
 		cfg := functionCallConfig{FunctionName: fn, ArgsJSON: tc.FunctionArgs, KwargsJSON: tc.FunctionKwargs, ExpectedJSON: tc.ExpectedReturn}
-		stdout, stderr, exitCode, timedOut, runtime, funcMeta, funcErr = runFunctionCall(workDir, mainFile, cfg, timeout)
+		stdout, stderr, exitCode, timedOut, runtime, mem, funcMeta, funcErr = prog.rt.runFunction(sess, workDir, prog.entry, cfg, timeout, tc.MemoryLimitKB)
 		if funcErr != nil {
 			stderr = funcErr.Error()
 			exitCode = -1
@@ -1056,7 +1294,7 @@ func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOut
 			}
 		}
 	default:
-		stdout, stderr, exitCode, timedOut, runtime = executePythonDir(workDir, mainFile, tc.Stdin, timeout)
+		stdout, stderr, exitCode, timedOut, runtime, mem = prog.rt.runStdin(sess, workDir, prog.entry, tc.Stdin, timeout, tc.MemoryLimitKB)
 		stdout = normalizeActualStdout(trimTrailingNewline(stdout))
 	}
 
@@ -1066,10 +1304,64 @@ func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOut
 	}
 
 	status := "passed"
+	credit := 0.0
+	var score *float64
+	var checkerMessage *string
 	switch mode {
-	case "unittest":
+	case "checker":
+		switch {
+		case timedOut:
+			status = "time_limit_exceeded"
+		case mem.LimitExceeded:
+			status = "memory_limit_exceeded"
+		case exitCode != 0:
+			status = "runtime_error"
+		case checkErr != nil:
+			status = "checker_error"
+			checkerMessage = strPtr(checkErr.Error())
+		default:
+			status, credit = checkerStatus(verdict)
+			score = &credit
+			if verdict.Message != "" {
+				checkerMessage = strPtr(verdict.Message)
+			}
+		}
+	case "dialogue":
+		status = dialogueStatus(dialogue)
+	case "performance":
+		status = performanceStatus(perfResult, tc)
+		if perfResult.Problem != "" {
+			checkerMessage = strPtr(perfResult.Problem)
+		} else if perfResult.Message != "" {
+			checkerMessage = strPtr(perfResult.Message)
+		}
+	case "property":
+		status = propertyStatus(propResult)
+		if propResult.Problem != "" {
+			checkerMessage = strPtr(propResult.Problem)
+		} else if propResult.Message != "" {
+			checkerMessage = strPtr(propResult.Message)
+		}
+	case "turtle":
+		status = turtleStatus(turtleResult)
+		if turtleResult.Problem != "" {
+			checkerMessage = strPtr(turtleResult.Problem)
+		} else if turtleResult.Message != "" {
+			checkerMessage = strPtr(turtleResult.Message)
+		}
+	case "sql_query":
+		var msg string
+		status, msg = sqlStatus(sqlResult, tc)
+		if msg != "" {
+			checkerMessage = strPtr(msg)
+		}
+	case "notebook_variable", "notebook_cell":
+		status = notebookStatus(nbResult, tc)
+	case "unittest", "pytest", "doctest", "plot":
 		if timedOut {
 			status = "time_limit_exceeded"
+		} else if mem.LimitExceeded {
+			status = "memory_limit_exceeded"
 		} else if exitCode != 0 {
 			if strings.Contains(stdout, "===JUDGE:ASSERT_FAIL===") {
 				status = "wrong_output"
@@ -1082,6 +1374,8 @@ func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOut
 			status = "runtime_error"
 		} else if timedOut {
 			status = "time_limit_exceeded"
+		} else if mem.LimitExceeded {
+			status = "memory_limit_exceeded"
 		} else if funcMeta != nil {
 			if funcMeta.Status == "exception" {
 				status = "runtime_error"
@@ -1095,26 +1389,56 @@ func runTestCase(subID uuid.UUID, tc TestCase, baseDir, mainFile string) testOut
 		switch {
 		case timedOut:
 			status = "time_limit_exceeded"
+		case mem.LimitExceeded:
+			status = "memory_limit_exceeded"
 		case exitCode != 0:
 			status = "runtime_error"
-		case stdout != expectedStdout:
+		case !stdoutMatches(tc, stdout, expectedStdout):
 			status = "wrong_output"
 		}
 	}
+	var fileDiffs *string
+	if outputFilesApply(mode) && (status == "passed" || status == "partially_passed" || status == "wrong_output") {
+		var filesOK bool
+		if fileDiffs, filesOK = gradeOutputFiles(workDir, tc); !filesOK {
+			status = "wrong_output"
+			credit = 0
+			if score != nil {
+				score = &credit
+			}
+		}
+	}
+	if rest, report := splitToolGuardOutput(stderr); report != nil {
+		status = "illegal_tool_use"
+		credit, score = 0, nil
+		stderr = toolGuardMessage(report)
+		if rest != "" {
+			stderr += "\n\n" + rest
+		}
+	}
+	if status == "passed" {
+		credit = 1
+	}
 
 	return testOutcome{
 		result: &Result{
-			SubmissionID: subID,
-			TestCaseID:   tc.ID,
-			Status:       status,
-			ActualStdout: stdout,
-			Stderr:       stderr,
-			ExitCode:     exitCode,
-			RuntimeMS:    int(runtime.Milliseconds()),
-			ActualReturn: actualReturn,
+			SubmissionID:   subID,
+			TestCaseID:     tc.ID,
+			Status:         status,
+			ActualStdout:   stdout,
+			Stderr:         stderr,
+			ExitCode:       exitCode,
+			RuntimeMS:      int(runtime.Milliseconds()),
+			PeakMemoryKB:   mem.peakPtr(),
+			ActualReturn:   actualReturn,
+			Score:          score,
+			CheckerMessage: checkerMessage,
+			FileDiffs:      fileDiffs,
+			Artifacts:      artifactsJSON(append(turtleResult.Artifacts, plotFigures...)),
 		},
 		weight: tc.Weight,
 		passed: status == "passed",
+		credit: credit,
 	}
 }
 
@@ -1378,6 +1702,15 @@ func runLLMInteractive(sub *Submission, a *Assignment) {
 
 func strPtr(s string) *string { return &s }
 
+// nonEmptyStringPtr copies s, mapping a missing or blank value to nil.
+func nonEmptyStringPtr(s *string) *string {
+	if s == nil || strings.TrimSpace(*s) == "" {
+		return nil
+	}
+	v := *s
+	return &v
+}
+
 // Helper to deref optional string pointer
 func stringOrEmpty(p *string) string {
 	if p == nil {
@@ -1432,7 +1765,7 @@ func smokePythonProgram(dir, file string) (bool, string) {
 	bootCtx, bootCancel := context.WithTimeout(context.Background(), vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
 	defer bootCancel()
 
-	vm, remoteDir, err := startVMWithWorkspace(bootCtx, dir, nil)
+	vm, remoteDir, err := startSandboxWithWorkspace(bootCtx, dir)
 	if err != nil {
 		return false, fmt.Sprintf("vm start failed: %v", err)
 	}
@@ -1818,7 +2151,7 @@ func runInteractiveScenarios(dir, mainFile string, scenarios []interactiveScenar
 		// Start fresh VM for this scenario
 		ctx, cancel := context.WithTimeout(context.Background(), remaining+vmBootTimeout+vmExtraTimeout)
 
-		vm, remoteDir, err := startVMWithWorkspace(ctx, dir, nil)
+		vm, remoteDir, err := startSandboxWithWorkspace(ctx, dir)
 		if err != nil {
 			verdict = "RUNTIME_ERROR"
 			reason = fmt.Sprintf("vm start failed: %v", err)
@@ -2312,16 +2645,14 @@ func parseAgentEvalOutput(out []byte) (*agentEvalResult, error) {
 
 // lastN helper removed (unused)
 
-func executePythonDir(dir, file, stdin string, timeout time.Duration) (string, string, int, bool, time.Duration) {
-	_ = ensureSandboxPerms(dir)
-	abs, _ := filepath.Abs(dir)
-	fmt.Printf("[worker] Running in VM: %s/%s with timeout %v\n", abs, file, timeout)
-
-	// Create a runner script that overrides input() to suppress prompts
-	runnerName := "__runner__.py"
-	runnerPath := filepath.Join(dir, runnerName)
-	runnerContent := fmt.Sprintf(`import sys, builtins, os
+// pythonRunnerFile executes the student's file as __main__ from its own
+// directory, with the tool guard loaded first.
+const pythonRunnerFile = "__runner__.py"
 
+// writePythonRunner stages pythonRunnerFile for file. Unless prompts is set,
+// input() does not print its prompt, so stdin tests only see the output.
+func writePythonRunner(dir, file string, prompts bool) error {
+	inputOverride := `
 # Override input to not print prompt
 def _input(prompt=None):
     s = sys.stdin.readline()
@@ -2330,7 +2661,12 @@ def _input(prompt=None):
     return s.rstrip('\n')
 
 builtins.input = _input
-
+`
+	if prompts {
+		inputOverride = ""
+	}
+	runnerContent := fmt.Sprintf(`import sys, builtins, os
+%s
 # Ensure we are in the correct directory
 script_dir = os.path.dirname(os.path.abspath(__file__))
 os.chdir(script_dir)
@@ -2343,36 +2679,72 @@ if target:
         code = compile(f.read(), target, 'exec')
     globs = {'__name__': '__main__', '__file__': target, '__doc__': None}
     exec(code, globs)
-`, file)
+`, inputOverride, file)
 
-	if err := os.WriteFile(runnerPath, []byte(runnerContent), 0644); err != nil {
-		return "", fmt.Sprintf("failed to write runner: %v", err), -1, false, 0
+	runnerPath := filepath.Join(dir, pythonRunnerFile)
+	if err := os.WriteFile(runnerPath, []byte(toolGuardPrelude+runnerContent), 0644); err != nil {
+		return fmt.Errorf("failed to write runner: %v", err)
 	}
 	// Ensure runner is readable
 	_ = os.Chmod(runnerPath, 0644)
+	return nil
+}
 
+func executePythonDir(sess *vmSession, dir, file, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	_ = ensureSandboxPerms(dir)
+	abs, _ := filepath.Abs(dir)
+	fmt.Printf("[worker] Running in VM: %s/%s with timeout %v\n", abs, file, timeout)
+
+	if err := writePythonRunner(dir, file, false); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+	if err := writeMemoryGuard(dir); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
+
+	// We run the runner script (under the memory guard), which internally runs the student file
+	return runTimedInVM(sess, dir, stdin, timeout, func(remoteDir string) string {
+		return pythonRuntime{}.command(remoteDir, pythonRunnerFile, memoryLimitKB)
+	})
+}
+
+// runTimedInVM runs the shell command built by command inside a VM holding
+// dir, feeding it stdin and measuring its runtime inside the guest. The
+// command is expected to run under the memory guard.
+func runTimedInVM(sess *vmSession, dir, stdin string, timeout time.Duration, command func(remoteDir string) string) (string, string, int, bool, time.Duration, memoryUsage) {
+	return runPreparedInVM(sess, dir, stdin, timeout, nil, command)
+}
+
+// runPreparedInVM is runTimedInVM with an untimed preparation step (e.g.
+// compiling test classes) that runs first in the same workspace; the command
+// is skipped when it fails.
+func runPreparedInVM(sess *vmSession, dir, stdin string, timeout time.Duration, prepare, command func(remoteDir string) string) (string, string, int, bool, time.Duration, memoryUsage) {
 	// Boot context: generous timeout for VM acquisition and boot
 	bootCtx, bootCancel := context.WithTimeout(context.Background(), vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
 	defer bootCancel()
 
-	vm, remoteDir, err := startVMWithWorkspace(bootCtx, dir, nil)
+	vm, remoteDir, release, err := acquireRunWorkspace(bootCtx, sess, dir)
 	if err != nil {
 		timedOut := bootCtx.Err() == context.DeadlineExceeded
-		return "", fmt.Sprintf("vm start failed: %v", err), -1, timedOut, 0
+		return "", fmt.Sprintf("vm start failed: %v", err), -1, timedOut, 0, memoryUsage{}
 	}
-	defer vm.Close()
+	defer release()
 
-	remoteRunner := filepath.Join(remoteDir, runnerName)
-	// We run the runner script, which internally runs the student file
-	script := fmt.Sprintf("start=$(date +%%s%%N); PYTHONDONTWRITEBYTECODE=1 PYTHONUNBUFFERED=1 HOME=/tmp LANG=C.UTF-8 %s -u '%s'; status=$?; end=$(date +%%s%%N); echo '===RUNTIME_MS===' $(((end-start)/1000000)); exit $status", pythonBinary, strings.ReplaceAll(remoteRunner, "'", "'\\''"))
+	script := fmt.Sprintf("start=$(date +%%s%%N); %s; status=$?; end=$(date +%%s%%N); echo '===RUNTIME_MS===' $(((end-start)/1000000)); exit $status", command(remoteDir))
+	limit := timeout
+	if prepare != nil {
+		script = fmt.Sprintf("{ %s; } || exit $?; %s", prepare(remoteDir), script)
+		limit += compileTimeout
+	}
 
 	// Execution context: strict timeout for the actual test
-	execCtx, execCancel := context.WithTimeout(context.Background(), timeout)
+	execCtx, execCancel := context.WithTimeout(context.Background(), limit)
 	defer execCancel()
 
 	startWall := time.Now()
 	outRaw, errRaw, exitCode, runErr := vm.runCommand(execCtx, remoteDir, script, strings.NewReader(stdin))
 	duration := time.Since(startWall)
+	collectOutputFiles(vm, remoteDir, dir)
 
 	ctxTimedOut := execCtx.Err() == context.DeadlineExceeded
 
@@ -2396,11 +2768,11 @@ if target:
 		exitCode = -1
 	}
 
-	return out, strings.TrimSpace(errRaw), exitCode, timedOut, runtime
+	errOut, usage := splitMemoryGuardOutput(errRaw)
+	return out, strings.TrimSpace(errOut), exitCode, timedOut, runtime, usage
 }
 
-func executePythonUnit(dir, mainFile, testCode, testName string, timeout time.Duration) (string, string, int, bool, time.Duration) {
-	testPath := filepath.Join(dir, "run_test.py")
+func executePythonUnit(sess *vmSession, dir, mainFile, testCode, testName, stdin string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
 	content := fmt.Sprintf(`import sys, unittest, builtins, io, types, pathlib, os
 
 # Ensure we are in the script directory (robustness)
@@ -2416,7 +2788,10 @@ def __grader_noop__(*args, **kwargs):
 unittest.main = __grader_noop__
 
 ROOT = pathlib.Path(__file__).parent
-student_source = (ROOT / '%s').read_text()
+student_file = ROOT / '%s'
+student_source = student_file.read_text()
+# lines fed to input() when student_code/student_plot get no arguments
+TEST_INPUT = %s
 
 def _normalize_line_endings(text):
     if isinstance(text, str):
@@ -2486,7 +2861,7 @@ unittest.TestCase.assertNotIn = _patched_assert_not_in
 
 def _load_student_module():
     module = types.ModuleType('__student__')
-    exec(student_source, module.__dict__)
+    exec(compile(student_source, str(student_file), 'exec'), module.__dict__)
     return module
 
 def _resolve_attr(root, dotted):
@@ -2496,6 +2871,8 @@ def _resolve_attr(root, dotted):
     return target
 
 def student_code(*args):
+    if not args:
+        args = TEST_INPUT
     it = iter(str(a) for a in args)
     def _input(prompt=None):
         try:
@@ -2507,7 +2884,7 @@ def student_code(*args):
     old = sys.stdout
     sys.stdout = out
     glb = {'__name__':'__main__'}
-    exec(student_source, glb)
+    exec(compile(student_source, str(student_file), 'exec'), glb)
     sys.stdout = old
     return _StudentOutput(_normalize_line_endings(out.getvalue()).strip())
 
@@ -2520,16 +2897,29 @@ def student_function(function_path, *args, **kwargs):
 
 %s
 
+%s
+
 if __name__ == '__main__':
     suite = unittest.defaultTestLoader.loadTestsFromName('__main__.%s')
     result = unittest.TextTestRunner().run(suite)
     ok = result.wasSuccessful()
     if not ok:
         print("===JUDGE:ASSERT_FAIL===")
+    _emit_plot_figures()
     sys.exit(0 if ok else 1)
-`, mainFile, testCode, testName)
-	content = normalizeLeadingTabsToSpaces(content)
+`, mainFile, plotInputs(stdin), plotHarness, testCode, testName)
+	return runPythonHarness(sess, dir, "run_test.py", content, timeout, memoryLimitKB)
+}
+
+// runPythonHarness writes a generated runner script to the workspace root
+// and runs it in the sandbox the way executePythonUnit runs its tests.
+func runPythonHarness(sess *vmSession, dir, runner, content string, timeout time.Duration, memoryLimitKB int) (string, string, int, bool, time.Duration, memoryUsage) {
+	testPath := filepath.Join(dir, runner)
+	content = toolGuardPrelude + normalizeLeadingTabsToSpaces(content)
 	os.WriteFile(testPath, []byte(content), 0644)
+	if err := writeMemoryGuard(dir); err != nil {
+		return "", err.Error(), -1, false, 0, memoryUsage{}
+	}
 	// Ensure permissions are readable by container user (nobody)
 	_ = os.Chmod(dir, 0755)
 	_ = os.Chmod(testPath, 0644)
@@ -2539,15 +2929,15 @@ if __name__ == '__main__':
 	bootCtx, bootCancel := context.WithTimeout(context.Background(), vmBootTimeout+vmExtraTimeout+vmQueueTimeout)
 	defer bootCancel()
 
-	vm, remoteDir, err := startVMWithWorkspace(bootCtx, dir, nil)
+	vm, remoteDir, release, err := acquireRunWorkspace(bootCtx, sess, dir)
 	if err != nil {
 		timedOut := bootCtx.Err() == context.DeadlineExceeded
-		return "", fmt.Sprintf("vm start failed: %v", err), -1, timedOut, 0
+		return "", fmt.Sprintf("vm start failed: %v", err), -1, timedOut, 0, memoryUsage{}
 	}
-	defer vm.Close()
+	defer release()
 
-	remoteTest := filepath.Join(remoteDir, "run_test.py")
-	script := fmt.Sprintf("start=$(date +%%s%%N); PYTHONDONTWRITEBYTECODE=1 PYTHONUNBUFFERED=1 HOME=/tmp LANG=C.UTF-8 %s -u '%s'; status=$?; end=$(date +%%s%%N); echo '===RUNTIME_MS===' $(((end-start)/1000000)); exit $status", pythonBinary, strings.ReplaceAll(remoteTest, "'", "'\\''"))
+	remoteTest := filepath.Join(remoteDir, runner)
+	script := fmt.Sprintf("start=$(date +%%s%%N); PYTHONDONTWRITEBYTECODE=1 PYTHONUNBUFFERED=1 HOME=/tmp LANG=C.UTF-8 %s; status=$?; end=$(date +%%s%%N); echo '===RUNTIME_MS===' $(((end-start)/1000000)); exit $status", memoryGuardedPython(remoteDir, remoteTest, memoryLimitKB))
 
 	// Execution context: strict timeout for the actual test
 	execCtx, execCancel := context.WithTimeout(context.Background(), timeout)
@@ -2556,6 +2946,7 @@ if __name__ == '__main__':
 	startWall := time.Now()
 	outRaw, errRaw, exitCode, runErr := vm.runCommand(execCtx, remoteDir, script, nil)
 	duration := time.Since(startWall)
+	collectOutputFiles(vm, remoteDir, dir)
 
 	ctxTimedOut := execCtx.Err() == context.DeadlineExceeded
 
@@ -2579,7 +2970,8 @@ if __name__ == '__main__':
 		exitCode = -1
 	}
 
-	return out, strings.TrimSpace(errRaw), exitCode, timedOut, runtime
+	errOut, usage := splitMemoryGuardOutput(errRaw)
+	return out, strings.TrimSpace(errOut), exitCode, timedOut, runtime, usage
 }
 
 // presenceCleanupTask periodically cleans up inactive users
@@ -42,7 +42,7 @@ except EOFError:
 	timeout := 5 * time.Second
 
 	// Call executePythonDir
-	stdout, stderr, exitCode, timedOut, _ := executePythonDir(dir, mainFile, stdin, timeout)
+	stdout, stderr, exitCode, timedOut, _, _ := executePythonDir(nil, dir, mainFile, stdin, timeout, 0)
 
 	if timedOut {
 		t.Fatalf("execution timed out")
@@ -54,7 +54,7 @@ print("Done")
 
 	t.Logf("Starting execution with timeout %v...", timeout)
 	start := time.Now()
-	stdout, stderr, exitCode, timedOut, runtime := executePythonDir(dir, mainFile, "", timeout)
+	stdout, stderr, exitCode, timedOut, runtime, _ := executePythonDir(nil, dir, mainFile, "", timeout, 0)
 	totalDuration := time.Since(start)
 
 	t.Logf("Execution finished. TimedOut: %v, Runtime: %v, TotalDuration: %v", timedOut, runtime, totalDuration)